@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reasons a trade signal is flagged by /signals/anomalies
+const (
+	anomalyRewardRiskHigh = "reward_risk_high"  // target is more than max_rr times as far from buy as the stop
+	anomalyRewardRiskLow  = "reward_risk_low"   // target is less than min_rr times as far from buy as the stop
+	anomalyNoRisk         = "no_risk_or_reward" // stop at or above buy, or target at or below it
+	anomalyFlatPrices     = "buy_stop_target_equal"
+	anomalyRepeatedPrices = "repeated_round_prices" // whole-number prices shared by min_repeat or more signals
+	anomalyUnknownTicker  = "unknown_ticker"        // no exchange-formatted mention and no price data
+)
+
+// Default /signals/anomalies thresholds
+const (
+	defaultMaxRewardRisk   = 10.0
+	defaultMinRewardRisk   = 0.2
+	defaultMinRepeatedRows = 3
+)
+
+// AnomalyParams are the thresholds for /signals/anomalies
+type AnomalyParams struct {
+	MaxRewardRisk float64 `json:"max_rr"`
+	MinRewardRisk float64 `json:"min_rr"`
+	MinRepeat     int     `json:"min_repeat"`
+}
+
+// SignalAnomaly is a trade signal that failed one or more sanity checks
+type SignalAnomaly struct {
+	EmailID     string   `json:"email_id"`
+	Ticker      string   `json:"ticker"`
+	SignalDate  string   `json:"signal_date"` // YYYY-MM-DD
+	BuyPrice    float64  `json:"buy_price"`
+	StopPrice   float64  `json:"stop_price"`
+	TargetPrice float64  `json:"target_price"`
+	RewardRisk  *float64 `json:"reward_risk,omitempty"` // (target - buy) / (buy - stop), when both are positive
+	Reasons     []string `json:"reasons"`
+}
+
+// AnomalyReport is the /signals/anomalies response
+type AnomalyReport struct {
+	AnomalyParams
+	Signals   int             `json:"signals"` // trade signals checked
+	Flagged   int             `json:"flagged"`
+	ByReason  map[string]int  `json:"by_reason"`
+	Anomalies []SignalAnomaly `json:"anomalies"`
+}
+
+// anomalySignal is a trade_signals row with what the checks need to know about its ticker
+type anomalySignal struct {
+	SignalAnomaly
+	exchangeTicker bool // the ticker came from a (NASDAQ: X) style mention on a TICKER_EXCHANGES exchange
+	hasPriceData   bool
+}
+
+// isExchangeSource reports whether a ticker source is one of the exchange-formatted patterns
+// generated from tickerExchanges
+func isExchangeSource(source string) bool {
+	for _, exchange := range tickerExchanges {
+		name := strings.ToLower(exchange)
+		if source == "sql:"+name || source == "go:"+name || source == "go:"+name+"_paren" {
+			return true
+		}
+	}
+	return false
+}
+
+// getAnomalySignals loads every trade signal, including partial ones, in signal date order
+func (db *DB) getAnomalySignals() ([]anomalySignal, error) {
+	rows, err := db.Query(`
+		SELECT ts.email_id, ts.ticker, ts.signal_date, ts.buy_price,
+			COALESCE(ts.stop_price, 0), COALESCE(ts.target_price, 0), COALESCE(ts.ticker_source, ''),
+			EXISTS (SELECT 1 FROM price_bars pb WHERE pb.ticker = ts.ticker)
+		FROM trade_signals ts
+		ORDER BY ts.signal_date, ts.email_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trade signals: %w", err)
+	}
+	defer rows.Close()
+
+	var signals []anomalySignal
+	for rows.Next() {
+		var signal anomalySignal
+		var signalDate int64
+		var source string
+		if err := rows.Scan(&signal.EmailID, &signal.Ticker, &signalDate, &signal.BuyPrice,
+			&signal.StopPrice, &signal.TargetPrice, &source, &signal.hasPriceData); err != nil {
+			return nil, fmt.Errorf("failed to scan trade signal: %w", err)
+		}
+		signal.SignalDate = formatDate(time.UnixMilli(signalDate).UTC())
+		signal.exchangeTicker = isExchangeSource(source)
+		signals = append(signals, signal)
+	}
+	return signals, rows.Err()
+}
+
+// isWholeNumber reports whether a price has no cents
+func isWholeNumber(price float64) bool {
+	return price > 0 && price == math.Trunc(price)
+}
+
+// findSignalAnomalies runs the sanity checks over signals and returns the ones that failed any.
+// Missing stop or target prices skip the checks that need them.
+func findSignalAnomalies(signals []anomalySignal, params AnomalyParams) []SignalAnomaly {
+	// Whole-number price triples repeated across many alerts suggest a template or parser default
+	type priceKey struct{ buy, stop, target float64 }
+	repeats := make(map[priceKey]int)
+	for _, s := range signals {
+		if isWholeNumber(s.BuyPrice) && isWholeNumber(s.StopPrice) && isWholeNumber(s.TargetPrice) {
+			repeats[priceKey{s.BuyPrice, s.StopPrice, s.TargetPrice}]++
+		}
+	}
+
+	anomalies := []SignalAnomaly{}
+	for _, s := range signals {
+		anomaly := s.SignalAnomaly
+		complete := s.StopPrice > 0 && s.TargetPrice > 0
+
+		switch {
+		case !complete:
+		case s.BuyPrice == s.StopPrice && s.BuyPrice == s.TargetPrice:
+			anomaly.Reasons = append(anomaly.Reasons, anomalyFlatPrices)
+		case s.BuyPrice <= s.StopPrice || s.TargetPrice <= s.BuyPrice:
+			anomaly.Reasons = append(anomaly.Reasons, anomalyNoRisk)
+		default:
+			rr := (s.TargetPrice - s.BuyPrice) / (s.BuyPrice - s.StopPrice)
+			anomaly.RewardRisk = &rr
+			if rr > params.MaxRewardRisk {
+				anomaly.Reasons = append(anomaly.Reasons, anomalyRewardRiskHigh)
+			} else if rr < params.MinRewardRisk {
+				anomaly.Reasons = append(anomaly.Reasons, anomalyRewardRiskLow)
+			}
+		}
+
+		if complete && repeats[priceKey{s.BuyPrice, s.StopPrice, s.TargetPrice}] >= params.MinRepeat {
+			anomaly.Reasons = append(anomaly.Reasons, anomalyRepeatedPrices)
+		}
+
+		if !s.exchangeTicker && !s.hasPriceData {
+			anomaly.Reasons = append(anomaly.Reasons, anomalyUnknownTicker)
+		}
+
+		if len(anomaly.Reasons) > 0 {
+			roundPrices(&anomaly.BuyPrice, &anomaly.StopPrice, &anomaly.TargetPrice)
+			anomalies = append(anomalies, anomaly)
+		}
+	}
+	return anomalies
+}
+
+// parseAnomalyParams reads max_rr, min_rr and min_repeat, defaulting each when absent
+func parseAnomalyParams(r *http.Request) (AnomalyParams, error) {
+	query := r.URL.Query()
+	params := AnomalyParams{
+		MaxRewardRisk: defaultMaxRewardRisk,
+		MinRewardRisk: defaultMinRewardRisk,
+		MinRepeat:     defaultMinRepeatedRows,
+	}
+
+	for name, target := range map[string]*float64{
+		"max_rr": &params.MaxRewardRisk,
+		"min_rr": &params.MinRewardRisk,
+	} {
+		raw := query.Get(name)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil || value <= 0 {
+			return AnomalyParams{}, fmt.Errorf("%s must be a positive number: %q", name, raw)
+		}
+		*target = value
+	}
+	if params.MinRewardRisk >= params.MaxRewardRisk {
+		return AnomalyParams{}, fmt.Errorf("min_rr %g must be below max_rr %g", params.MinRewardRisk, params.MaxRewardRisk)
+	}
+
+	if raw := query.Get("min_repeat"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 2 {
+			return AnomalyParams{}, fmt.Errorf("min_repeat must be an integer of at least 2: %q", raw)
+		}
+		params.MinRepeat = n
+	}
+
+	return params, nil
+}
+
+// signalAnomaliesHandler serves GET /signals/anomalies, a read-only sanity check of trade_signals.
+// A ticker counts as known when it came from an exchange-formatted mention or has rows in
+// price_bars, so load bars for the other tickers before trusting unknown_ticker.
+func signalAnomaliesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	params, err := parseAnomalyParams(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	db, err := setupDatabase()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer db.Close()
+
+	signals, err := db.getAnomalySignals()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	report := AnomalyReport{
+		AnomalyParams: params,
+		Signals:       len(signals),
+		ByReason:      make(map[string]int),
+		Anomalies:     findSignalAnomalies(signals, params),
+	}
+	report.Flagged = len(report.Anomalies)
+	for _, anomaly := range report.Anomalies {
+		for _, reason := range anomaly.Reasons {
+			report.ByReason[reason]++
+		}
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
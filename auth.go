@@ -2,11 +2,18 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"sync"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -18,15 +25,21 @@ import (
 func printCredentialInfo(credBytes []byte) (*CredentialInfo, error) {
 	var credInfo CredentialInfo
 	if err := json.Unmarshal(credBytes, &credInfo); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal credentials: %v", err)
+		return nil, fmt.Errorf("failed to unmarshal credentials: %w", err)
+	}
+
+	client, kind, err := credInfo.client()
+	if err != nil {
+		return nil, err
 	}
 
 	fmt.Printf("\n=== Credential Information ===\n")
-	fmt.Printf("Project ID: %s\n", credInfo.Web.ProjectID)
-	fmt.Printf("Client ID: %s\n", credInfo.Web.ClientID)
-	fmt.Printf("Auth URI: %s\n", credInfo.Web.AuthURI)
-	fmt.Printf("Token URI: %s\n", credInfo.Web.TokenURI)
-	fmt.Printf("Redirect URIs: %v\n", credInfo.Web.RedirectURIs)
+	fmt.Printf("Client Type: %s\n", kind)
+	fmt.Printf("Project ID: %s\n", client.ProjectID)
+	fmt.Printf("Client ID: %s\n", client.ClientID)
+	fmt.Printf("Auth URI: %s\n", client.AuthURI)
+	fmt.Printf("Token URI: %s\n", client.TokenURI)
+	fmt.Printf("Redirect URIs: %v\n", client.RedirectURIs)
 
 	return &credInfo, nil
 }
@@ -35,7 +48,7 @@ func printCredentialInfo(credBytes []byte) (*CredentialInfo, error) {
 func loadCredentials(credentialsFile string) (*oauth2.Config, error) {
 	credBytes, err := os.ReadFile(credentialsFile)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read client secret file: %v", err)
+		return nil, fmt.Errorf("unable to read client secret file: %w", err)
 	}
 
 	// Print detailed credential information
@@ -46,12 +59,60 @@ func loadCredentials(credentialsFile string) (*oauth2.Config, error) {
 	// Load OAuth configuration
 	config, err := getConfigFromFile(credBytes)
 	if err != nil {
-		return nil, fmt.Errorf("unable to parse client secret file to config: %v", err)
+		return nil, fmt.Errorf("unable to parse client secret file to config: %w", err)
 	}
 
 	return config, nil
 }
 
+// client returns whichever of the web or installed client sections is present, and its kind
+func (c *CredentialInfo) client() (*ClientCredentials, string, error) {
+	switch {
+	case c.Web != nil:
+		return c.Web, "web", nil
+	case c.Installed != nil:
+		return c.Installed, "installed", nil
+	default:
+		return nil, "", fmt.Errorf("credentials file has neither a \"web\" nor an \"installed\" client section")
+	}
+}
+
+// resolveRedirectURI picks the OAuth redirect URI. An override (OAUTH_REDIRECT_URI) wins but is
+// only warned about, not rejected, when the credentials do not register it, since Google's console
+// may be ahead of the downloaded file. Without one, web clients use their first registered URI.
+// Installed (desktop) clients list a bare loopback or the retired OOB URI; Google accepts any
+// loopback port and path for them, so they default to the local callback server.
+func resolveRedirectURI(client *ClientCredentials, kind, override string) (string, error) {
+	if override == "" {
+		if kind == "web" && len(client.RedirectURIs) > 0 {
+			return client.RedirectURIs[0], nil
+		}
+		return defaultRedirectURI, nil
+	}
+
+	parsed, err := url.Parse(override)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return "", fmt.Errorf("OAUTH_REDIRECT_URI must be an absolute URL, got %q", override)
+	}
+	if parsed.Path != "/oauth/callback" {
+		log.Printf("Warning: OAUTH_REDIRECT_URI %s does not point at /oauth/callback; a proxy must route it there", override)
+	}
+
+	if kind == "web" {
+		registered := false
+		for _, uri := range client.RedirectURIs {
+			registered = registered || uri == override
+		}
+		if !registered {
+			log.Printf("Warning: OAUTH_REDIRECT_URI %s is not among the credential's redirect_uris %v", override, client.RedirectURIs)
+		}
+	} else if host := parsed.Hostname(); host != "localhost" && host != "127.0.0.1" && host != "::1" {
+		log.Printf("Warning: OAUTH_REDIRECT_URI %s is not a loopback address, which installed clients require", override)
+	}
+
+	return override, nil
+}
+
 // getConfigFromFile creates OAuth config from credentials file bytes
 func getConfigFromFile(credBytes []byte) (*oauth2.Config, error) {
 	var cred CredentialInfo
@@ -59,28 +120,32 @@ func getConfigFromFile(credBytes []byte) (*oauth2.Config, error) {
 		return nil, err
 	}
 
-	// Determine redirect URI based on environment
-	redirectURI := "http://localhost:8080/oauth/callback"
-	if len(cred.Web.RedirectURIs) > 0 {
-		// Use the first redirect URI from the credentials file
-		redirectURI = cred.Web.RedirectURIs[0]
+	client, kind, err := cred.client()
+	if err != nil {
+		return nil, err
+	}
+
+	redirectURI, err := resolveRedirectURI(client, kind, os.Getenv("OAUTH_REDIRECT_URI"))
+	if err != nil {
+		return nil, err
 	}
 
 	return &oauth2.Config{
-		ClientID:     cred.Web.ClientID,
-		ClientSecret: cred.Web.ClientSecret,
+		ClientID:     client.ClientID,
+		ClientSecret: client.ClientSecret,
 		RedirectURL:  redirectURI,
-		Scopes:       []string{gmail.GmailReadonlyScope},
+		Scopes:       gmailScopes,
 		Endpoint: oauth2.Endpoint{
-			AuthURL:  cred.Web.AuthURI,
-			TokenURL: cred.Web.TokenURI,
+			AuthURL:  client.AuthURI,
+			TokenURL: client.TokenURI,
 		},
 	}, nil
 }
 
 // getTokenFromWeb opens browser for OAuth flow
 func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
+	verifier := oauth2.GenerateVerifier()
+	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline, oauth2.ApprovalForce, oauth2.S256ChallengeOption(verifier))
 	log.Printf("Go to the following link in your browser: \n%v\n", authURL)
 
 	fmt.Print("Enter the authorization code: ")
@@ -89,7 +154,7 @@ func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
 		log.Fatalf("Unable to read authorization code: %v", err)
 	}
 
-	tok, err := config.Exchange(context.TODO(), authCode)
+	tok, err := config.Exchange(context.TODO(), authCode, oauth2.VerifierOption(verifier))
 	if err != nil {
 		log.Fatalf("Unable to retrieve token from web: %v", err)
 	}
@@ -113,18 +178,39 @@ func saveToken(path string, token *oauth2.Token) error {
 	log.Printf("Saving credential file to: %s\n", path)
 	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
-		return fmt.Errorf("unable to cache oauth token: %v", err)
+		return fmt.Errorf("unable to cache oauth token: %w", err)
 	}
 	defer f.Close()
 	json.NewEncoder(f).Encode(token)
 	return nil
 }
 
-// getGmailClient creates an authenticated Gmail client
-func getGmailClient(ctx context.Context) (*http.Client, error) {
-	token, err := tokenFromFile(tokenFile)
+// errNoToken means no Gmail login has been saved yet
+var errNoToken = errors.New("no saved Gmail token")
+
+// errNoRefreshToken means the saved token cannot be renewed once its access token expires.
+// Google omits the refresh token when the account had already granted access, unless consent is forced.
+var errNoRefreshToken = errors.New("saved Gmail token has no refresh token; log in again at /login, which asks Google for consent (prompt=consent) so a refresh token is issued")
+
+// refreshSavedToken loads the saved token, refreshes it through the TokenSource when needed
+// (or always, when force is set) and re-saves it if it changed. A token without a refresh token
+// is rejected up front, even while its access token is still valid, so a long pipeline run does
+// not fail partway through when it expires.
+func refreshSavedToken(ctx context.Context, force bool) (*oauth2.Token, error) {
+	token, accountEmail, err := loadToken()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get token: %v", err)
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+
+	if token.RefreshToken == "" {
+		return nil, errNoRefreshToken
+	}
+
+	if force {
+		// An expired copy makes the TokenSource fetch a new access token
+		expired := *token
+		expired.Expiry = time.Now().Add(-time.Minute)
+		token = &expired
 	}
 
 	// Create a token source that will automatically refresh the token
@@ -133,18 +219,179 @@ func getGmailClient(ctx context.Context) (*http.Client, error) {
 	// Get a fresh token (this will refresh if needed)
 	freshToken, err := tokenSource.Token()
 	if err != nil {
-		return nil, fmt.Errorf("failed to refresh token: %v", err)
+		return nil, fmt.Errorf("failed to refresh token: %w", err)
 	}
 
 	// Save the refreshed token if it was updated
 	if freshToken.AccessToken != token.AccessToken {
 		log.Printf("Token was refreshed, saving new token")
-		if err := saveToken(tokenFile, freshToken); err != nil {
+		if err := storeToken(freshToken, accountEmail); err != nil {
 			log.Printf("Warning: failed to save refreshed token: %v", err)
 		}
 	}
 
-	return config.Client(ctx, freshToken), nil
+	return freshToken, nil
+}
+
+// loadToken reads the saved OAuth token from the configured token store.
+// It also returns the account email the token belongs to, when known.
+func loadToken() (*oauth2.Token, string, error) {
+	info, err := loadTokenRecord()
+	if err != nil {
+		return nil, "", err
+	}
+
+	token := &oauth2.Token{
+		TokenType:    info.TokenType,
+		AccessToken:  info.AccessToken,
+		RefreshToken: info.RefreshToken,
+		Expiry:       info.Expiry,
+	}
+	return token, info.UserEmail, nil
+}
+
+// loadTokenRecord reads the saved token as stored, without refreshing it. A token file holds only
+// the token, so its scopes are the ones this server requests at login.
+func loadTokenRecord() (*OAuthClientInfo, error) {
+	if tokenStore != "db" {
+		token, err := tokenFromFile(tokenFile)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w in %s", errNoToken, tokenStoreLocation())
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &OAuthClientInfo{
+			Scopes:       gmailScopes,
+			TokenType:    token.TokenType,
+			AccessToken:  token.AccessToken,
+			RefreshToken: token.RefreshToken,
+			Expiry:       token.Expiry,
+		}, nil
+	}
+
+	db, err := setupDatabase()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	info, err := db.getOAuthToken(os.Getenv("TOKEN_ACCOUNT"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w in %s", errNoToken, tokenStoreLocation())
+	}
+	return info, err
+}
+
+// storeToken saves an OAuth token to the configured token store
+func storeToken(token *oauth2.Token, accountEmail string) error {
+	if tokenStore != "db" {
+		return saveToken(tokenFile, token)
+	}
+
+	db, err := setupDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	info := OAuthClientInfo{
+		ClientID:        config.ClientID,
+		RedirectURI:     config.RedirectURL,
+		AuthURL:         config.Endpoint.AuthURL,
+		TokenURL:        config.Endpoint.TokenURL,
+		Scopes:          config.Scopes,
+		TokenType:       token.TokenType,
+		AccessToken:     token.AccessToken,
+		RefreshToken:    token.RefreshToken,
+		Expiry:          token.Expiry,
+		UserEmail:       accountEmail,
+		LastRefreshTime: time.Now(),
+		TokenSource:     "db",
+	}
+	if profile, err := db.getUserProfile(accountEmail); err == nil {
+		info.UserID = profile.UserID
+		info.VerifiedEmail = profile.VerifiedEmail
+		info.Picture = profile.Picture
+		info.Locale = profile.Locale
+	}
+	return db.saveOAuthToken(info)
+}
+
+// storeUserProfile saves the authenticated account's profile, whichever token store is in use
+func storeUserProfile(profile UserProfile) error {
+	db, err := setupDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.saveUserProfile(profile)
+}
+
+// googleUserinfoURL serves the signed-in account's Google profile. It needs the userinfo.email or
+// userinfo.profile scope, which GMAIL_SCOPES may add as a scope URL; Gmail scopes alone are refused.
+const googleUserinfoURL = "https://www.googleapis.com/oauth2/v2/userinfo"
+
+// fetchUserinfo fills profile with the account ID, verification, name, picture and locale from Google's userinfo endpoint
+func fetchUserinfo(ctx context.Context, token *oauth2.Token, profile *UserProfile) error {
+	client := config.Client(ctx, token)
+	client.Timeout = gmailTimeout
+
+	resp, err := client.Get(googleUserinfoURL)
+	if err != nil {
+		return fmt.Errorf("userinfo request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("userinfo returned %s", resp.Status)
+	}
+
+	var userinfo struct {
+		ID            string `json:"id"`
+		VerifiedEmail bool   `json:"verified_email"`
+		Name          string `json:"name"`
+		Picture       string `json:"picture"`
+		Locale        string `json:"locale"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&userinfo); err != nil {
+		return fmt.Errorf("failed to decode userinfo: %w", err)
+	}
+
+	profile.UserID = userinfo.ID
+	profile.VerifiedEmail = userinfo.VerifiedEmail
+	profile.Name = userinfo.Name
+	profile.Picture = userinfo.Picture
+	profile.Locale = userinfo.Locale
+	return nil
+}
+
+// tokenStoreLocation describes where tokens are persisted, for display
+func tokenStoreLocation() string {
+	if tokenStore == "db" {
+		return dbFile + " (oauth_tokens table)"
+	}
+	return tokenFile
+}
+
+// getGmailClient creates an authenticated Gmail client whose requests time out after gmailTimeout.
+// It returns errNoRefreshToken when the saved token could not be renewed once it expires.
+func getGmailClient(ctx context.Context) (*http.Client, error) {
+	freshToken, err := refreshSavedToken(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return newGmailHTTPClient(ctx, freshToken), nil
+}
+
+// newGmailHTTPClient returns an OAuth client for token with the request timeout, rate limit, metrics and
+// tracing applied; each request is a span of the ctx it is made with
+func newGmailHTTPClient(ctx context.Context, token *oauth2.Token) *http.Client {
+	client := config.Client(ctx, token)
+	client.Timeout = gmailTimeout
+	return traceClient(instrumentClient(rateLimitClient(client)))
 }
 
 // getGmailService creates an authenticated Gmail service
@@ -156,18 +403,70 @@ func getGmailService(ctx context.Context) (*gmail.Service, error) {
 
 	service, err := gmail.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
-		return nil, fmt.Errorf("unable to create Gmail service: %v", err)
+		return nil, fmt.Errorf("unable to create Gmail service: %w", err)
 	}
 
 	return service, nil
 }
 
+// loginStateTTL is how long a login started at /login may take to come back to /oauth/callback
+const loginStateTTL = 10 * time.Minute
+
+// pendingLogin is the PKCE code verifier of one login that has not reached the callback yet
+type pendingLogin struct {
+	verifier string
+	expires  time.Time
+}
+
+// pendingLogins holds each started login's verifier by its state token, in memory only
+var pendingLogins = struct {
+	sync.Mutex
+	entries map[string]pendingLogin
+}{entries: make(map[string]pendingLogin)}
+
+// startLogin creates a random state token and the PKCE code verifier tied to it
+func startLogin(now time.Time) (state, verifier string, err error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", fmt.Errorf("failed to generate state token: %w", err)
+	}
+	state, verifier = hex.EncodeToString(raw), oauth2.GenerateVerifier()
+
+	pendingLogins.Lock()
+	defer pendingLogins.Unlock()
+	for key, login := range pendingLogins.entries {
+		if now.After(login.expires) {
+			delete(pendingLogins.entries, key)
+		}
+	}
+	pendingLogins.entries[state] = pendingLogin{verifier: verifier, expires: now.Add(loginStateTTL)}
+	return state, verifier, nil
+}
+
+// finishLogin returns the code verifier for a state token, which can be used only once.
+// ok is false for a state that login never issued or that has expired.
+func finishLogin(state string, now time.Time) (verifier string, ok bool) {
+	pendingLogins.Lock()
+	defer pendingLogins.Unlock()
+	login, ok := pendingLogins.entries[state]
+	delete(pendingLogins.entries, state)
+	if !ok || now.After(login.expires) {
+		return "", false
+	}
+	return login.verifier, true
+}
+
 // OAuth handlers for web-based authentication
 func handleLogin(w http.ResponseWriter, r *http.Request) {
-	// Generate state token for security
-	state := fmt.Sprintf("state-%d", time.Now().Unix())
-	
-	authURL := config.AuthCodeURL(state, oauth2.AccessTypeOffline)
+	// A random state token, tied to the PKCE verifier the callback must send with the code
+	state, verifier, err := startLogin(time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Forcing consent makes Google issue a refresh token even when access was granted before
+	authURL := config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce, oauth2.S256ChallengeOption(verifier))
 	
 	http.Redirect(w, r, authURL, http.StatusTemporaryRedirect)
 }
@@ -180,26 +479,24 @@ func handleOAuthCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The state must be one /login issued, and brings back the PKCE verifier for its code
+	verifier, ok := finishLogin(r.URL.Query().Get("state"), time.Now())
+	if !ok {
+		http.Error(w, "Unknown or expired login state; start again at /login", http.StatusBadRequest)
+		return
+	}
+
 	// Exchange the authorization code for an access token
-	token, err := config.Exchange(context.Background(), code)
+	token, err := config.Exchange(context.Background(), code, oauth2.VerifierOption(verifier))
 	if err != nil {
 		log.Printf("Token exchange error: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to exchange token: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// Save the token
-	if err := saveToken(tokenFile, token); err != nil {
-		log.Printf("Failed to save token: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to save token: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	log.Printf("OAuth token saved successfully to %s", tokenFile)
-
-	// Test the authentication by creating a Gmail service
+	// Test the authentication by creating a Gmail service with the new token
 	ctx := context.Background()
-	service, err := getGmailService(ctx)
+	service, err := gmail.NewService(ctx, option.WithHTTPClient(newGmailHTTPClient(ctx, token)))
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to create Gmail service: %v", err), http.StatusInternalServerError)
 		return
@@ -214,12 +511,33 @@ func handleOAuthCallback(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("Successfully authenticated user: %s", profile.EmailAddress)
 
-	// Get the redirect URI for display
-	redirectURI := config.RedirectURL
-	if redirectURI == "" {
-		redirectURI = "http://localhost:8080/oauth/callback"
+	// Record who logged in; a failure here leaves the token usable, so it is only logged
+	userProfile := UserProfile{
+		Email:         profile.EmailAddress,
+		MessagesTotal: profile.MessagesTotal,
+		ThreadsTotal:  profile.ThreadsTotal,
+		HistoryID:     profile.HistoryId,
+		UpdatedAt:     time.Now(),
+	}
+	if err := fetchUserinfo(ctx, token, &userProfile); err != nil {
+		log.Printf("Userinfo unavailable, storing the Gmail profile only: %v", err)
+	}
+	if err := storeUserProfile(userProfile); err != nil {
+		log.Printf("Warning: failed to save user profile: %v", err)
+	}
+
+	// Save the token under the authenticated account
+	if err := storeToken(token, profile.EmailAddress); err != nil {
+		log.Printf("Failed to save token: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to save token: %v", err), http.StatusInternalServerError)
+		return
 	}
 
+	log.Printf("OAuth token saved successfully to %s", tokenStoreLocation())
+
+	// The same resolved redirect URI that login sent to Google, for display
+	redirectURI := config.RedirectURL
+
 	// Send success response
 	html := fmt.Sprintf(`
 	<!DOCTYPE html>
@@ -262,7 +580,165 @@ func handleOAuthCallback(w http.ResponseWriter, r *http.Request) {
 			</div>
 		</body>
 	</html>
-	`, profile.EmailAddress, tokenFile, credentialsFile, redirectURI)
+	`, profile.EmailAddress, tokenStoreLocation(), credentialsFile, redirectURI)
 
 	fmt.Fprint(w, html)
-}
\ No newline at end of file
+}
+
+// TokenStatus describes the saved OAuth token
+type TokenStatus struct {
+	Authenticated   bool      `json:"authenticated"`
+	Email           string    `json:"email,omitempty"`
+	Expiry          time.Time `json:"expiry"`
+	Expired         bool      `json:"expired"`
+	HasRefreshToken bool      `json:"has_refresh_token"`
+	Scopes          []string  `json:"scopes,omitempty"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// UserProfile is the authenticated account, from its Gmail profile and, when the granted scopes
+// allow, Google's userinfo endpoint
+type UserProfile struct {
+	Email         string    `json:"email"`
+	UserID        string    `json:"user_id,omitempty"`
+	VerifiedEmail bool      `json:"verified_email"`
+	Name          string    `json:"name,omitempty"`
+	Picture       string    `json:"picture,omitempty"`
+	Locale        string    `json:"locale,omitempty"`
+	MessagesTotal int64     `json:"messages_total"`
+	ThreadsTotal  int64     `json:"threads_total"`
+	HistoryID     uint64    `json:"history_id"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
+
+// writeJSONError writes a JSON error response
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// writeAuthError responds 401 with the error and a link to /login, for requests that need a Gmail login
+func writeAuthError(w http.ResponseWriter, err error) {
+	writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error(), "login": "/login"})
+}
+
+// tokenStatusFor builds a TokenStatus for a token, looking up the authenticated email
+func tokenStatusFor(ctx context.Context, token *oauth2.Token) TokenStatus {
+	status := TokenStatus{
+		Expiry:          token.Expiry,
+		Expired:         !token.Expiry.IsZero() && token.Expiry.Before(time.Now()),
+		HasRefreshToken: token.RefreshToken != "",
+	}
+
+	service, err := getGmailService(ctx)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+
+	profile, err := service.Users.GetProfile("me").Do()
+	if err != nil {
+		status.Error = fmt.Sprintf("failed to get user profile: %v", err)
+		return status
+	}
+
+	status.Authenticated = true
+	status.Email = profile.EmailAddress
+	return status
+}
+
+// storedTokenStatus describes a saved token from what was stored with it. It calls neither Google
+// nor the token store, so a status check never refreshes or rewrites credentials; authenticated
+// means the access token is still valid or can be renewed.
+func storedTokenStatus(info *OAuthClientInfo) TokenStatus {
+	expired := !info.Expiry.IsZero() && info.Expiry.Before(time.Now())
+	return TokenStatus{
+		Authenticated:   info.AccessToken != "" && (!expired || info.RefreshToken != ""),
+		Email:           info.UserEmail,
+		Expiry:          info.Expiry,
+		Expired:         expired,
+		HasRefreshToken: info.RefreshToken != "",
+		Scopes:          info.Scopes,
+	}
+}
+
+// handleAuthStatus reports whether the saved OAuth token is usable, without refreshing it
+func handleAuthStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	info, err := loadTokenRecord()
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("no saved token in %s, visit /login first", tokenStoreLocation()))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, storedTokenStatus(info))
+}
+
+// handleAuthRefresh forces a token refresh and re-saves the token
+func handleAuthRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if _, _, err := loadToken(); err != nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("no saved token in %s, visit /login first", tokenStoreLocation()))
+		return
+	}
+
+	token, err := refreshSavedToken(r.Context(), true)
+	if errors.Is(err, errNoRefreshToken) {
+		writeAuthError(w, err)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokenStatusFor(r.Context(), token))
+}
+
+// handleAuthProfile returns the stored profile for ?account=, or TOKEN_ACCOUNT, or else the most recent login
+func handleAuthProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	account := r.URL.Query().Get("account")
+	if account == "" {
+		account = os.Getenv("TOKEN_ACCOUNT")
+	}
+
+	db, err := setupDatabase()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer db.Close()
+
+	profile, err := db.getUserProfile(account)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, http.StatusNotFound, "no stored profile, visit /login first")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, profile)
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// installedCredentials is a desktop client file as Google's console downloads it
+const installedCredentials = `{
+  "installed": {
+    "client_id": "123-desktop.apps.googleusercontent.com",
+    "project_id": "backteststoxx",
+    "auth_uri": "https://accounts.google.com/o/oauth2/auth",
+    "token_uri": "https://oauth2.googleapis.com/token",
+    "client_secret": "desktop-secret",
+    "redirect_uris": ["http://localhost"]
+  }
+}`
+
+// webCredentials is a web client file registering a deployed callback
+const webCredentials = `{
+  "web": {
+    "client_id": "123-web.apps.googleusercontent.com",
+    "auth_uri": "https://accounts.google.com/o/oauth2/auth",
+    "token_uri": "https://oauth2.googleapis.com/token",
+    "client_secret": "web-secret",
+    "redirect_uris": ["https://stoxx.example.com/oauth/callback", "http://localhost:8080/oauth/callback"]
+  }
+}`
+
+func TestGetConfigFromFile(t *testing.T) {
+	tests := []struct {
+		name         string
+		file         string
+		wantClientID string
+		wantRedirect string
+		wantErr      string
+	}{
+		{"installed client", installedCredentials, "123-desktop.apps.googleusercontent.com", defaultRedirectURI, ""},
+		{"web client", webCredentials, "123-web.apps.googleusercontent.com", "https://stoxx.example.com/oauth/callback", ""},
+		{"neither section", `{"service_account": {}}`, "", "", "neither"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OAUTH_REDIRECT_URI", "")
+			config, err := getConfigFromFile([]byte(tt.file))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("getConfigFromFile() error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.ClientID != tt.wantClientID || config.RedirectURL != tt.wantRedirect {
+				t.Errorf("client %s redirecting to %s, want %s redirecting to %s", config.ClientID, config.RedirectURL, tt.wantClientID, tt.wantRedirect)
+			}
+			if config.Endpoint.TokenURL != "https://oauth2.googleapis.com/token" {
+				t.Errorf("token URL = %q", config.Endpoint.TokenURL)
+			}
+		})
+	}
+}
+
+func TestRedirectURIOverride(t *testing.T) {
+	tests := []struct {
+		name         string
+		file         string
+		override     string
+		wantRedirect string
+		wantWarning  string
+		wantErr      string
+	}{
+		{
+			name:         "registered web callback",
+			file:         webCredentials,
+			override:     "http://localhost:8080/oauth/callback",
+			wantRedirect: "http://localhost:8080/oauth/callback",
+		},
+		{
+			name:         "unregistered web callback",
+			file:         webCredentials,
+			override:     "https://other.example.com/oauth/callback",
+			wantRedirect: "https://other.example.com/oauth/callback",
+			wantWarning:  "not among the credential's redirect_uris",
+		},
+		{
+			name:         "installed client on another loopback port",
+			file:         installedCredentials,
+			override:     "http://127.0.0.1:9090/oauth/callback",
+			wantRedirect: "http://127.0.0.1:9090/oauth/callback",
+		},
+		{
+			name:         "installed client on a public host",
+			file:         installedCredentials,
+			override:     "https://stoxx.example.com/oauth/callback",
+			wantRedirect: "https://stoxx.example.com/oauth/callback",
+			wantWarning:  "not a loopback address",
+		},
+		{name: "relative URL", file: webCredentials, override: "/oauth/callback", wantErr: "must be an absolute URL"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OAUTH_REDIRECT_URI", tt.override)
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+			config, err := getConfigFromFile([]byte(tt.file))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("getConfigFromFile() error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.RedirectURL != tt.wantRedirect {
+				t.Errorf("RedirectURL = %q, want %q", config.RedirectURL, tt.wantRedirect)
+			}
+			if warned := strings.Contains(logs.String(), "Warning: OAUTH_REDIRECT_URI"); warned != (tt.wantWarning != "") ||
+				!strings.Contains(logs.String(), tt.wantWarning) {
+				t.Errorf("logged %q, want a warning mentioning %q", logs.String(), tt.wantWarning)
+			}
+
+			// The login URL sends Google the same callback the token exchange will use
+			authURL, err := url.Parse(config.AuthCodeURL("state"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := authURL.Query().Get("redirect_uri"); got != tt.wantRedirect {
+				t.Errorf("login redirect_uri = %q, want %q", got, tt.wantRedirect)
+			}
+		})
+	}
+}
+
+func TestGmailClientTimesOutOnHungServer(t *testing.T) {
+	chdirTemp(t)
+	hung := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done() // never responds; returns once the client gives up
+	})
+	ctx := fakeGmail(t, hung)
+
+	saved := gmailTimeout
+	gmailTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { gmailTimeout = saved })
+
+	service, err := getGmailService(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_, err = service.Users.Messages.Get("me", "m1").Do()
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Messages.Get error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("request gave up after %v, want about %v", elapsed, gmailTimeout)
+	}
+}
+
+func TestAuthStatusDoesNotRefreshToken(t *testing.T) {
+	chdirTemp(t)
+	var calls atomic.Int32
+	ctx := fakeGmail(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "unexpected call", http.StatusInternalServerError)
+	}))
+
+	expired := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", Expiry: time.Now().Add(-time.Hour)}
+	if err := saveToken(tokenFile, expired); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	handleAuthStatus(rec, httptest.NewRequest(http.MethodGet, "/auth/status", nil).WithContext(ctx))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+
+	if n := calls.Load(); n != 0 {
+		t.Errorf("status check made %d calls to Google, want none", n)
+	}
+	if after, _ := os.ReadFile(tokenFile); !bytes.Equal(after, before) {
+		t.Errorf("status check rewrote the token file")
+	}
+
+	var status TokenStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if !status.Expired || !status.HasRefreshToken || !status.Authenticated {
+		t.Errorf("status = %+v, want an expired but renewable token", status)
+	}
+	if !reflect.DeepEqual(status.Scopes, gmailScopes) {
+		t.Errorf("scopes = %v, want %v", status.Scopes, gmailScopes)
+	}
+}
+
+func TestRefreshSavedTokenRequiresRefreshToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   oauth2.Token
+		wantErr error
+	}{
+		{"valid access token without a refresh token", oauth2.Token{AccessToken: "access", Expiry: time.Now().Add(time.Hour)}, errNoRefreshToken},
+		{"expired access token without a refresh token", oauth2.Token{AccessToken: "access", Expiry: time.Now().Add(-time.Hour)}, errNoRefreshToken},
+		{"valid access token with a refresh token", oauth2.Token{AccessToken: "access", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour)}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chdirTemp(t)
+			var calls atomic.Int32
+			ctx := fakeGmail(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				http.Error(w, "unexpected call", http.StatusInternalServerError)
+			}))
+			if err := saveToken(tokenFile, &tt.token); err != nil {
+				t.Fatal(err)
+			}
+
+			token, err := refreshSavedToken(ctx, false)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("refreshSavedToken() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && token.AccessToken != "access" {
+				t.Errorf("access token = %q, want the saved one", token.AccessToken)
+			}
+			if _, err := getGmailService(ctx); !errors.Is(err, tt.wantErr) {
+				t.Errorf("getGmailService() error = %v, want %v", err, tt.wantErr)
+			}
+			if n := calls.Load(); n != 0 {
+				t.Errorf("made %d calls to Google, want none", n)
+			}
+		})
+	}
+}
+
+func TestOAuthCallbackNeedsStoredVerifier(t *testing.T) {
+	chdirTemp(t)
+	var verifiers []string
+	fakeGmail(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verifiers = append(verifiers, r.FormValue("code_verifier"))
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+	}))
+
+	rec := httptest.NewRecorder()
+	handleLogin(rec, httptest.NewRequest(http.MethodGet, "/login", nil))
+	authURL, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, challenge := authURL.Query().Get("state"), authURL.Query().Get("code_challenge")
+	if state == "" || challenge == "" || authURL.Query().Get("code_challenge_method") != "S256" {
+		t.Fatalf("login URL %s, want a state and an S256 code challenge", authURL)
+	}
+
+	callback := func(state string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handleOAuthCallback(rec, httptest.NewRequest(http.MethodGet, "/oauth/callback?code=abc&state="+url.QueryEscape(state), nil))
+		return rec
+	}
+
+	// A state login never issued has no verifier, so the code is never exchanged
+	if rec := callback("forged"); rec.Code != http.StatusBadRequest || len(verifiers) != 0 {
+		t.Fatalf("unknown state: status %d after %d token requests, want 400 and none", rec.Code, len(verifiers))
+	}
+
+	// The issued state sends the verifier behind the login's challenge. The client may retry the
+	// exchange with another auth style, so each request is checked.
+	callback(state)
+	if len(verifiers) == 0 {
+		t.Fatal("issued state made no token request")
+	}
+	for _, verifier := range verifiers {
+		if oauth2.S256ChallengeFromVerifier(verifier) != challenge {
+			t.Fatalf("token request sent verifier %q, want one matching challenge %s", verifier, challenge)
+		}
+	}
+
+	// The verifier is used up, so the callback can't be replayed
+	exchanges := len(verifiers)
+	if rec := callback(state); rec.Code != http.StatusBadRequest || len(verifiers) != exchanges {
+		t.Errorf("replayed state: status %d after %d more token requests, want 400 and none", rec.Code, len(verifiers)-exchanges)
+	}
+
+	// Nor does a verifier outlive loginStateTTL
+	state, _, err = startLogin(time.Now().Add(-loginStateTTL - time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := finishLogin(state, time.Now()); ok {
+		t.Error("finishLogin() of an expired state = ok, want not ok")
+	}
+}
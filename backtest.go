@@ -0,0 +1,958 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ResolutionMode decides the outcome when a single daily bar spans both the stop and the target
+type ResolutionMode int
+
+const (
+	// StopFirst assumes the stop was hit before the target (pessimistic, the default)
+	StopFirst ResolutionMode = iota
+	// TargetFirst assumes the target was hit before the stop (optimistic)
+	TargetFirst
+	// Proportional splits the position evenly between the stop and target outcomes
+	Proportional
+)
+
+// String returns the query-parameter name of the mode
+func (m ResolutionMode) String() string {
+	switch m {
+	case TargetFirst:
+		return "target_first"
+	case Proportional:
+		return "proportional"
+	default:
+		return "stop_first"
+	}
+}
+
+// parseResolutionMode parses a resolution mode name, defaulting to StopFirst
+func parseResolutionMode(name string) (ResolutionMode, error) {
+	switch name {
+	case "", "stop_first":
+		return StopFirst, nil
+	case "target_first":
+		return TargetFirst, nil
+	case "proportional":
+		return Proportional, nil
+	default:
+		return StopFirst, fmt.Errorf("unknown resolution mode %q", name)
+	}
+}
+
+// BacktestParams controls how signals are simulated against daily bars
+type BacktestParams struct {
+	Resolution         ResolutionMode // Same-bar stop/target ambiguity handling, StopFirst by default
+	SlippageBps        float64        // Each fill is this many basis points worse than the modeled price
+	CommissionPerShare float64        // Charged on both entry and exit
+	RiskPct            float64        // Account percent risked per trade unless the signal states its own risk
+
+	// Signal date range; zero values leave that end open. To includes its whole day.
+	From time.Time
+	To   time.Time
+	// Cutoff splits signals into in-sample (before it) and out-of-sample (on or after it); zero disables the split
+	Cutoff time.Time
+	// Provider limits the backtest to signals from one newsletter's emails; empty includes every provider
+	Provider string
+	// Benchmark is a reference ticker, such as SPY, whose buy-and-hold return over the same period is
+	// reported next to the strategy's. It does not change the trades, so it is not part of Hash.
+	Benchmark string
+}
+
+// Hash identifies the parameters a backtest ran with, so ledgers from different configurations
+// can share backtest_trades. Equal parameters always hash the same.
+func (p BacktestParams) Hash() string {
+	sum := sha256.Sum256([]byte(p.describe()))
+	return hex.EncodeToString(sum[:8])
+}
+
+// describe renders the parameters as the JSON stored next to their hash
+func (p BacktestParams) describe() string {
+	described := map[string]interface{}{
+		"resolution":           p.Resolution.String(),
+		"slippage_bps":         p.SlippageBps,
+		"commission_per_share": p.CommissionPerShare,
+		"risk_pct":             p.RiskPct,
+		"from":                 formatDate(p.From),
+		"to":                   formatDate(p.To),
+		"cutoff":               formatDate(p.Cutoff),
+	}
+	// Only when set, so runs over every provider keep the hashes they had before providers existed
+	if p.Provider != "" {
+		described["provider"] = p.Provider
+	}
+	body, _ := json.Marshal(described)
+	return string(body)
+}
+
+// PriceBar is one daily OHLC bar
+type PriceBar struct {
+	Date   time.Time
+	Open   float64
+	High   float64
+	Low    float64
+	Close  float64
+	Volume int64 // stored by /price-bars; the backtest does not read it
+}
+
+// TradeStatus separates resolved trades from ones still waiting on price data
+type TradeStatus string
+
+const (
+	StatusCompleted TradeStatus = "completed" // entered and exited on the stop or target
+	StatusOpen      TradeStatus = "open"      // entered but unresolved; marked to market at the last close
+	StatusNoEntry   TradeStatus = "no_entry"  // price data exists but the close was above the buy price
+	StatusNoData    TradeStatus = "no_data"   // no bars on or after the entry date yet
+)
+
+// TradeResult is the simulated outcome of a single signal
+type TradeResult struct {
+	EmailID    string      `json:"email_id"`
+	Ticker     string      `json:"ticker"`
+	Status     TradeStatus `json:"status"`
+	Entered    bool        `json:"entered"`
+	EntryDate  string      `json:"entry_date,omitempty"` // YYYY-MM-DD of the entry bar
+	EntryPrice float64     `json:"entry_price"`
+	ExitDate   string      `json:"exit_date,omitempty"` // YYYY-MM-DD of the exit bar, or the last bar while open
+	ExitPrice  float64     `json:"exit_price"`
+	ExitReason string      `json:"exit_reason"`
+	Ambiguous  bool        `json:"ambiguous"`
+	PnL        float64     `json:"pnl"` // per share, after slippage and commission
+	ReturnPct  float64     `json:"return_pct"`
+	RMultiple  float64     `json:"r_multiple"` // P&L in units of entry-to-stop risk; 0 when the stop is not below entry
+	RiskPct    float64     `json:"risk_pct"`   // account percent risked: the signal's stated risk, else the run's
+	// AccountReturnPct is the trade's effect on the account when sized to lose RiskPct at the stop
+	AccountReturnPct float64 `json:"account_return_pct"`
+
+	hasRisk    bool // the stop was below entry, so RMultiple is defined
+	signalRisk bool // RiskPct came from the signal
+}
+
+// Trade is one entered trade in the backtest ledger
+type Trade struct {
+	EmailID    string  `json:"email_id"`
+	Ticker     string  `json:"ticker"`
+	EntryDate  string  `json:"entry_date"`
+	EntryPrice float64 `json:"entry_price"`
+	ExitDate   string  `json:"exit_date"`
+	ExitPrice  float64 `json:"exit_price"`
+	ExitReason string  `json:"exit_reason"` // stop, target, split (proportional same-bar exit) or eod (still open at the last bar)
+	RMultiple  float64 `json:"r_multiple"`
+	PnL        float64 `json:"pnl"` // per share, after slippage and commission
+}
+
+// ledgerExitReasons maps simulated exit reasons to their ledger names
+var ledgerExitReasons = map[string]string{
+	"STOP LOSS":  "stop",
+	"TARGET HIT": "target",
+	"SPLIT":      "split",
+	"OPEN":       "eod",
+}
+
+// tradeLedger lists the entered trades among results, skipping signals that never filled
+func tradeLedger(results []TradeResult) []Trade {
+	trades := []Trade{}
+	for _, result := range results {
+		if !result.Entered {
+			continue
+		}
+		trades = append(trades, Trade{
+			EmailID:    result.EmailID,
+			Ticker:     result.Ticker,
+			EntryDate:  result.EntryDate,
+			EntryPrice: result.EntryPrice,
+			ExitDate:   result.ExitDate,
+			ExitPrice:  result.ExitPrice,
+			ExitReason: ledgerExitReasons[result.ExitReason],
+			RMultiple:  result.RMultiple,
+			PnL:        result.PnL,
+		})
+	}
+	return trades
+}
+
+// BacktestSummary aggregates trade results
+type BacktestSummary struct {
+	ParamsHash         string  `json:"params_hash"` // key of this run's trades in backtest_trades
+	Resolution         string  `json:"resolution"`
+	SlippageBps        float64 `json:"slippage_bps"`
+	CommissionPerShare float64 `json:"commission_per_share"`
+	RiskPct            float64 `json:"risk_pct"`
+	From               string  `json:"from,omitempty"` // signal date range, YYYY-MM-DD
+	To                 string  `json:"to,omitempty"`
+	Provider           string  `json:"provider,omitempty"`
+
+	Signals   int `json:"signals"`
+	Trades    int `json:"trades"` // entered trades, completed or open
+	Completed int `json:"completed"`
+	Open      int `json:"open"`
+	NoEntry   int `json:"no_entry"`
+	NoData    int `json:"no_data"`
+
+	// Wins, losses, win rate and average return cover completed trades only, so trades
+	// still waiting on price data do not count as losses
+	Wins             int     `json:"wins"`
+	Losses           int     `json:"losses"`
+	Ambiguous        int     `json:"ambiguous"`
+	WinRate          float64 `json:"win_rate"`
+	AvgReturnPct     float64 `json:"avg_return_pct"`
+	OpenAvgReturnPct float64 `json:"open_avg_return_pct"` // unrealized, at the last available close
+	AccountReturnPct float64 `json:"account_return_pct"`  // sum over completed trades, without compounding
+	SignalRiskTrades int     `json:"signal_risk_trades"`  // completed trades sized by the signal's stated risk
+
+	// Buy-and-hold of params.Benchmark over the same period, omitted when it has no bars covering it.
+	// ExcessReturnPct is the strategy's alpha over it: AccountReturnPct minus BenchmarkReturnPct.
+	Benchmark          string   `json:"benchmark,omitempty"`
+	BenchmarkReturnPct *float64 `json:"benchmark_return_pct,omitempty"`
+	BenchmarkCAGRPct   *float64 `json:"benchmark_cagr_pct,omitempty"`
+	ExcessReturnPct    *float64 `json:"excess_return_pct,omitempty"`
+
+	Results []TradeResult `json:"results"`
+}
+
+// simulateTrade runs one signal against daily bars sorted by date.
+// Like the Python strategy, the trade enters at the close of the first bar on or after
+// the parsed entry date when that close is at or below the buy price, then exits on the stop or target.
+// A bar that opens beyond a level exits at its open, as a stop order would fill after a gap.
+func simulateTrade(signal CleanSignal, bars []PriceBar, params BacktestParams) TradeResult {
+	result := TradeResult{EmailID: signal.EmailID, Ticker: signal.Ticker, Status: StatusNoData, ExitReason: "NO DATA"}
+
+	entryDate := time.UnixMilli(signal.EntryDate).Truncate(24 * time.Hour)
+	entryIdx := -1
+	for i, bar := range bars {
+		if !bar.Date.Before(entryDate) {
+			entryIdx = i
+			break
+		}
+	}
+	if entryIdx < 0 {
+		return result
+	}
+
+	if bars[entryIdx].Close > signal.BuyPrice {
+		result.Status, result.ExitReason = StatusNoEntry, "NO ENTRY"
+		return result
+	}
+
+	result.Entered = true
+	result.EntryDate = formatDate(bars[entryIdx].Date)
+	result.EntryPrice = bars[entryIdx].Close
+	result.Status, result.ExitReason = StatusOpen, "OPEN"
+	result.ExitDate = formatDate(bars[len(bars)-1].Date)
+	result.ExitPrice = bars[len(bars)-1].Close
+
+	for i := entryIdx + 1; i < len(bars); i++ {
+		bar := bars[i]
+		hitStop := bar.Low <= signal.StopPrice
+		hitTarget := bar.High >= signal.TargetPrice
+		if hitStop || hitTarget {
+			result.ExitDate = formatDate(bar.Date)
+		}
+
+		// A bar that opens through a level fills there at the open, which also settles which level came first
+		gapDown := bar.Open <= signal.StopPrice
+		gapUp := bar.Open >= signal.TargetPrice
+
+		if hitStop && hitTarget && !gapDown && !gapUp {
+			result.Ambiguous = true
+			switch params.Resolution {
+			case TargetFirst:
+				result.ExitPrice, result.ExitReason = signal.TargetPrice, "TARGET HIT"
+			case Proportional:
+				result.ExitPrice, result.ExitReason = (signal.StopPrice+signal.TargetPrice)/2, "SPLIT"
+			default:
+				result.ExitPrice, result.ExitReason = signal.StopPrice, "STOP LOSS"
+			}
+			result.Status = StatusCompleted
+			break
+		}
+		if hitStop && !gapUp {
+			result.ExitPrice, result.ExitReason = math.Min(bar.Open, signal.StopPrice), "STOP LOSS"
+			result.Status = StatusCompleted
+			break
+		}
+		if hitTarget {
+			result.ExitPrice, result.ExitReason = math.Max(bar.Open, signal.TargetPrice), "TARGET HIT"
+			result.Status = StatusCompleted
+			break
+		}
+	}
+
+	// Exits trigger on the signal's levels, but both fills pay slippage and commission
+	slippage := params.SlippageBps / 10000
+	result.EntryPrice *= 1 + slippage
+	result.ExitPrice *= 1 - slippage
+	result.PnL = result.ExitPrice - result.EntryPrice - 2*params.CommissionPerShare
+
+	result.ReturnPct = result.PnL / result.EntryPrice * 100
+	if risk := result.EntryPrice - signal.StopPrice; risk > 0 {
+		result.RMultiple = result.PnL / risk
+		result.hasRisk = true
+	}
+
+	// A risk stated in the email overrides the run's sizing
+	result.RiskPct = params.RiskPct
+	if signal.RiskPct > 0 {
+		result.RiskPct, result.signalRisk = signal.RiskPct, true
+	}
+	if result.hasRisk {
+		result.AccountReturnPct = result.RMultiple * result.RiskPct
+	}
+	return result
+}
+
+// runBacktest simulates every signal in the params' date range, aggregates the results,
+// and saves the trade ledger under the params' hash
+func runBacktest(db *DB, params BacktestParams) (*BacktestSummary, error) {
+	signals, err := db.getTradeSignals(params.From, dayAfter(params.To), params.Provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trade signals: %w", err)
+	}
+
+	summary, err := backtestSignals(db, signals, params.From, params.To, params, make(map[string][]PriceBar))
+	if err != nil {
+		return nil, err
+	}
+	if err := db.saveBacktestTrades(params, tradeLedger(summary.Results)); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// runWalkForward backtests the params' date range as two blocks split at params.Cutoff:
+// in-sample signals dated before the cutoff and out-of-sample signals on or after it
+func runWalkForward(db *DB, params BacktestParams) (inSample, outOfSample *BacktestSummary, err error) {
+	signals, err := db.getTradeSignals(params.From, dayAfter(params.To), params.Provider)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get trade signals: %w", err)
+	}
+
+	cutoff := params.Cutoff.UnixMilli()
+	split := sort.Search(len(signals), func(i int) bool { return signals[i].SignalDate >= cutoff })
+
+	barsByTicker := make(map[string][]PriceBar)
+	if inSample, err = backtestSignals(db, signals[:split], params.From, params.Cutoff.AddDate(0, 0, -1), params, barsByTicker); err != nil {
+		return nil, nil, err
+	}
+	if outOfSample, err = backtestSignals(db, signals[split:], params.Cutoff, params.To, params, barsByTicker); err != nil {
+		return nil, nil, err
+	}
+
+	// Both blocks share the params' hash; their signals never overlap
+	trades := append(tradeLedger(inSample.Results), tradeLedger(outOfSample.Results)...)
+	if err := db.saveBacktestTrades(params, trades); err != nil {
+		return nil, nil, err
+	}
+	return inSample, outOfSample, nil
+}
+
+// dayAfter returns midnight after day, so a YYYY-MM-DD upper bound includes the whole day. Zero stays zero.
+func dayAfter(day time.Time) time.Time {
+	if day.IsZero() {
+		return day
+	}
+	return day.AddDate(0, 0, 1)
+}
+
+// backtestSignals simulates signals dated from..to and aggregates the results. barsByTicker caches
+// price bars across calls.
+func backtestSignals(db *DB, signals []CleanSignal, from, to time.Time, params BacktestParams, barsByTicker map[string][]PriceBar) (*BacktestSummary, error) {
+	summary := &BacktestSummary{
+		ParamsHash:         params.Hash(),
+		Resolution:         params.Resolution.String(),
+		SlippageBps:        params.SlippageBps,
+		CommissionPerShare: params.CommissionPerShare,
+		RiskPct:            params.RiskPct,
+		From:               formatDate(from),
+		To:                 formatDate(to),
+		Provider:           params.Provider,
+		Signals:            len(signals),
+	}
+	var totalReturn, openReturn float64
+
+	for _, signal := range signals {
+		bars, ok := barsByTicker[signal.Ticker]
+		if !ok {
+			var err error
+			bars, err = db.getPriceBars(signal.Ticker)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get price bars for %s: %w", signal.Ticker, err)
+			}
+			barsByTicker[signal.Ticker] = bars
+		}
+
+		result := simulateTrade(signal, bars, params)
+		summary.Results = append(summary.Results, result)
+		switch result.Status {
+		case StatusNoData:
+			summary.NoData++
+			continue
+		case StatusNoEntry:
+			summary.NoEntry++
+			continue
+		case StatusOpen:
+			summary.Trades++
+			summary.Open++
+			openReturn += result.ReturnPct
+			continue
+		}
+
+		summary.Trades++
+		summary.Completed++
+		totalReturn += result.ReturnPct
+		summary.AccountReturnPct += result.AccountReturnPct
+		if result.signalRisk {
+			summary.SignalRiskTrades++
+		}
+		if result.Ambiguous {
+			summary.Ambiguous++
+		}
+		if result.ReturnPct > 0 {
+			summary.Wins++
+		} else if result.ReturnPct < 0 {
+			summary.Losses++
+		}
+	}
+
+	if summary.Completed > 0 {
+		summary.WinRate = float64(summary.Wins) / float64(summary.Completed) * 100
+		summary.AvgReturnPct = totalReturn / float64(summary.Completed)
+	}
+	if summary.Open > 0 {
+		summary.OpenAvgReturnPct = openReturn / float64(summary.Open)
+	}
+
+	if params.Benchmark != "" {
+		if err := compareToBenchmark(db, summary, signals, from, to, params.Benchmark, barsByTicker); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("Backtest complete (%s to %s, %s, slippage %g bps, commission %g/share): %d signals, %d completed, %d open, %d no entry, %d no data, %d ambiguous bars, win rate %.1f%%",
+		dateOrOpen(summary.From), dateOrOpen(summary.To), summary.Resolution, summary.SlippageBps, summary.CommissionPerShare, summary.Signals,
+		summary.Completed, summary.Open, summary.NoEntry, summary.NoData, summary.Ambiguous, summary.WinRate)
+
+	return summary, nil
+}
+
+// compareToBenchmark sets the summary's benchmark fields from a buy-and-hold of benchmark between
+// from and to. An open from starts at the first signal's date and an open to ends at the last bar.
+func compareToBenchmark(db *DB, summary *BacktestSummary, signals []CleanSignal, from, to time.Time, benchmark string, barsByTicker map[string][]PriceBar) error {
+	bars, ok := barsByTicker[benchmark]
+	if !ok {
+		var err error
+		bars, err = db.getPriceBars(benchmark)
+		if err != nil {
+			return fmt.Errorf("failed to get price bars for benchmark %s: %w", benchmark, err)
+		}
+		barsByTicker[benchmark] = bars
+	}
+
+	if from.IsZero() && len(signals) > 0 {
+		from = time.UnixMilli(signals[0].SignalDate).UTC().Truncate(24 * time.Hour)
+	}
+	returnPct, cagrPct, ok := buyAndHold(bars, from, dayAfter(to))
+	if !ok {
+		log.Printf("Benchmark %s has no bars covering %s to %s; leaving it out of the summary", benchmark, dateOrOpen(formatDate(from)), dateOrOpen(formatDate(to)))
+		return nil
+	}
+
+	excessPct := summary.AccountReturnPct - returnPct
+	summary.Benchmark = benchmark
+	summary.BenchmarkReturnPct, summary.BenchmarkCAGRPct, summary.ExcessReturnPct = &returnPct, &cagrPct, &excessPct
+	return nil
+}
+
+// buyAndHold returns the percent return and compound annual growth rate of buying at the close of the
+// first bar on or after from and selling at the close of the last bar before end (zero end: the last bar).
+// ok is false when fewer than two bars fall in the range.
+func buyAndHold(bars []PriceBar, from, end time.Time) (returnPct, cagrPct float64, ok bool) {
+	first, last := -1, -1
+	for i, bar := range bars {
+		if bar.Date.Before(from) || (!end.IsZero() && !bar.Date.Before(end)) {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		last = i
+	}
+	if first < 0 || last == first || bars[first].Close <= 0 {
+		return 0, 0, false
+	}
+
+	growth := bars[last].Close / bars[first].Close
+	years := bars[last].Date.Sub(bars[first].Date).Hours() / 24 / 365.25
+	return (growth - 1) * 100, (math.Pow(growth, 1/years) - 1) * 100, true
+}
+
+// formatDate formats a YYYY-MM-DD bound, leaving a zero time empty
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// dateOrOpen labels an empty date bound for logging
+func dateOrOpen(date string) string {
+	if date == "" {
+		return "open"
+	}
+	return date
+}
+
+// TickerStats aggregates the completed trades of one ticker
+type TickerStats struct {
+	Ticker       string  `json:"ticker"`
+	Trades       int     `json:"trades"`
+	Wins         int     `json:"wins"`
+	WinRate      float64 `json:"win_rate"`
+	AvgR         float64 `json:"avg_r"`          // mean R multiple over trades with a defined risk
+	NetReturnPct float64 `json:"net_return_pct"` // sum of per-trade returns
+}
+
+// tickerStats groups completed trades by ticker, drops tickers with fewer than minTrades trades,
+// and sorts the rest by net return, best first
+func tickerStats(results []TradeResult, minTrades int) []TickerStats {
+	byTicker := make(map[string]*TickerStats)
+	rCounts := make(map[string]int)
+	for _, result := range results {
+		if result.Status != StatusCompleted {
+			continue
+		}
+
+		stats, ok := byTicker[result.Ticker]
+		if !ok {
+			stats = &TickerStats{Ticker: result.Ticker}
+			byTicker[result.Ticker] = stats
+		}
+		stats.Trades++
+		stats.NetReturnPct += result.ReturnPct
+		if result.ReturnPct > 0 {
+			stats.Wins++
+		}
+		if result.hasRisk {
+			stats.AvgR += result.RMultiple
+			rCounts[result.Ticker]++
+		}
+	}
+
+	out := make([]TickerStats, 0, len(byTicker))
+	for ticker, stats := range byTicker {
+		if stats.Trades < minTrades {
+			continue
+		}
+		stats.WinRate = float64(stats.Wins) / float64(stats.Trades) * 100
+		if n := rCounts[ticker]; n > 0 {
+			stats.AvgR /= float64(n)
+		}
+		out = append(out, *stats)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].NetReturnPct != out[j].NetReturnPct {
+			return out[i].NetReturnPct > out[j].NetReturnPct
+		}
+		return out[i].Ticker < out[j].Ticker
+	})
+	return out
+}
+
+// defaultRiskPct is the account percent risked per trade when neither the request nor the signal sets one
+const defaultRiskPct = 1
+
+// parseBacktestParams reads backtest parameters from the query string
+func parseBacktestParams(r *http.Request) (BacktestParams, error) {
+	return backtestParamsFromValues(r.URL.Query())
+}
+
+// backtestParamsFromValues reads backtest parameters named as in the /backtest query string
+func backtestParamsFromValues(query url.Values) (BacktestParams, error) {
+	resolution, err := parseResolutionMode(query.Get("resolution"))
+	if err != nil {
+		return BacktestParams{}, err
+	}
+	params := BacktestParams{Resolution: resolution, RiskPct: defaultRiskPct, Benchmark: strings.ToUpper(strings.TrimSpace(query.Get("benchmark")))}
+
+	for name, target := range map[string]*float64{
+		"slippageBps":        &params.SlippageBps,
+		"commissionPerShare": &params.CommissionPerShare,
+		"riskPct":            &params.RiskPct,
+	} {
+		raw := query.Get(name)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil || value < 0 {
+			return BacktestParams{}, fmt.Errorf("%s must be a non-negative number: %q", name, raw)
+		}
+		*target = value
+	}
+
+	for name, target := range map[string]*time.Time{
+		"from":   &params.From,
+		"to":     &params.To,
+		"cutoff": &params.Cutoff,
+	} {
+		raw := query.Get(name)
+		if raw == "" {
+			continue
+		}
+		date, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			return BacktestParams{}, fmt.Errorf("%s must be a YYYY-MM-DD date: %q", name, raw)
+		}
+		*target = date
+	}
+
+	if raw := query.Get("provider"); raw != "" {
+		found, err := findProviders(raw)
+		if err != nil {
+			return BacktestParams{}, err
+		}
+		if len(found) != 1 {
+			return BacktestParams{}, fmt.Errorf("provider must name one provider: %q", raw)
+		}
+		params.Provider = found[0].Name
+	}
+
+	if !params.From.IsZero() && !params.To.IsZero() && params.From.After(params.To) {
+		return BacktestParams{}, fmt.Errorf("from %s is after to %s", formatDate(params.From), formatDate(params.To))
+	}
+	if !params.Cutoff.IsZero() {
+		if !params.From.IsZero() && params.Cutoff.Before(params.From) {
+			return BacktestParams{}, fmt.Errorf("cutoff %s is before from %s", formatDate(params.Cutoff), formatDate(params.From))
+		}
+		if !params.To.IsZero() && params.Cutoff.After(params.To) {
+			return BacktestParams{}, fmt.Errorf("cutoff %s is after to %s", formatDate(params.Cutoff), formatDate(params.To))
+		}
+	}
+
+	return params, nil
+}
+
+// HTTP handler for per-ticker backtest stats, optionally limited to tickers with at least minTrades trades
+func backtestByTickerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	params, err := parseBacktestParams(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !params.Cutoff.IsZero() {
+		writeJSONError(w, http.StatusBadRequest, "cutoff is only supported by /backtest")
+		return
+	}
+
+	minTrades := 1
+	if raw := r.URL.Query().Get("minTrades"); raw != "" {
+		if minTrades, err = strconv.Atoi(raw); err != nil || minTrades < 1 {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("minTrades must be a positive integer: %q", raw))
+			return
+		}
+	}
+
+	db, err := setupDatabase()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
+		return
+	}
+	defer db.Close()
+
+	summary, err := runBacktest(db, params)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Backtest failed: %v", err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"resolution": summary.Resolution,
+		"min_trades": minTrades,
+		"tickers":    tickerStats(summary.Results, minTrades),
+	})
+}
+
+// HTTP handler for running the backtest over trade_signals and price_bars
+func backtestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	params, err := parseBacktestParams(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	db, err := setupDatabase()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
+		return
+	}
+	defer db.Close()
+
+	if !params.Cutoff.IsZero() {
+		inSample, outOfSample, err := runWalkForward(db, params)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Backtest failed: %v", err))
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"params_hash":   params.Hash(),
+			"cutoff":        formatDate(params.Cutoff),
+			"in_sample":     inSample,
+			"out_of_sample": outOfSample,
+		})
+		return
+	}
+
+	summary, err := runBacktest(db, params)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Backtest failed: %v", err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, summary)
+}
+
+// HTTP handler for the saved trade ledger of one backtest configuration, as JSON or with ?format=csv.
+// The configuration is picked by ?hash=, or else by the same query parameters /backtest takes.
+func backtestTradesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("format must be json or csv: %q", format))
+		return
+	}
+
+	hash := query.Get("hash")
+	if hash == "" {
+		params, err := parseBacktestParams(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		hash = params.Hash()
+	}
+
+	db, err := setupDatabase()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
+		return
+	}
+	defer db.Close()
+
+	trades, err := db.getBacktestTrades(hash)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if format != "csv" {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"params_hash": hash,
+			"trades":      trades,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="backtest_trades_%s.csv"`, hash))
+	out := csv.NewWriter(w)
+	out.Write([]string{"params_hash", "email_id", "ticker", "entry_date", "entry_price", "exit_date", "exit_price", "exit_reason", "r_multiple", "pnl"})
+	for _, trade := range trades {
+		out.Write([]string{
+			hash,
+			trade.EmailID,
+			trade.Ticker,
+			trade.EntryDate,
+			strconv.FormatFloat(trade.EntryPrice, 'f', -1, 64),
+			trade.ExitDate,
+			strconv.FormatFloat(trade.ExitPrice, 'f', -1, 64),
+			trade.ExitReason,
+			strconv.FormatFloat(trade.RMultiple, 'f', -1, 64),
+			strconv.FormatFloat(trade.PnL, 'f', -1, 64),
+		})
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		log.Printf("Backtest trades export for %s failed: %v", hash, err)
+	}
+}
+
+// Limits on one /backtest/compare request
+const (
+	maxCompareParamSets = 10
+	maxCompareBodyBytes = 1 << 16
+)
+
+// compareParamNames are the fields a /backtest/compare parameter set may have, named as in the /backtest query
+var compareParamNames = map[string]bool{
+	"resolution": true, "slippageBps": true, "commissionPerShare": true, "riskPct": true, "from": true, "to": true, "benchmark": true, "provider": true,
+}
+
+// MetricComparison is one summary metric across compared runs. Delta is each run's value minus
+// the first run's, so the first parameter set is the baseline.
+type MetricComparison struct {
+	Metric string    `json:"metric"`
+	Values []float64 `json:"values"`
+	Delta  []float64 `json:"delta"`
+}
+
+// BacktestComparison is the /backtest/compare response
+type BacktestComparison struct {
+	Runs []*BacktestSummary `json:"runs"`
+	Diff []MetricComparison `json:"diff"`
+}
+
+// compareMetrics are the summary metrics set side by side in a comparison
+var compareMetrics = []struct {
+	name  string
+	value func(*BacktestSummary) float64
+}{
+	{"trades", func(s *BacktestSummary) float64 { return float64(s.Trades) }},
+	{"completed", func(s *BacktestSummary) float64 { return float64(s.Completed) }},
+	{"wins", func(s *BacktestSummary) float64 { return float64(s.Wins) }},
+	{"losses", func(s *BacktestSummary) float64 { return float64(s.Losses) }},
+	{"ambiguous", func(s *BacktestSummary) float64 { return float64(s.Ambiguous) }},
+	{"win_rate", func(s *BacktestSummary) float64 { return s.WinRate }},
+	{"avg_return_pct", func(s *BacktestSummary) float64 { return s.AvgReturnPct }},
+	{"open_avg_return_pct", func(s *BacktestSummary) float64 { return s.OpenAvgReturnPct }},
+	{"account_return_pct", func(s *BacktestSummary) float64 { return s.AccountReturnPct }},
+}
+
+// parseCompareParams reads the JSON array of parameter sets posted to /backtest/compare, such as
+// [{"resolution": "stop_first"}, {"resolution": "target_first", "slippageBps": 5}]. Every set must cover
+// the same from/to range and provider so the runs see the same signals.
+func parseCompareParams(body []byte) ([]BacktestParams, error) {
+	var sets []map[string]interface{}
+	if err := json.Unmarshal(body, &sets); err != nil {
+		return nil, fmt.Errorf("body must be a JSON array of backtest parameter objects: %v", err)
+	}
+	if len(sets) < 2 || len(sets) > maxCompareParamSets {
+		return nil, fmt.Errorf("compare takes 2 to %d parameter sets, got %d", maxCompareParamSets, len(sets))
+	}
+
+	paramSets := make([]BacktestParams, 0, len(sets))
+	for i, set := range sets {
+		values := url.Values{}
+		for name, raw := range set {
+			if !compareParamNames[name] {
+				return nil, fmt.Errorf("parameter set %d: unknown parameter %q", i, name)
+			}
+			switch value := raw.(type) {
+			case string:
+				values.Set(name, value)
+			case float64:
+				values.Set(name, strconv.FormatFloat(value, 'f', -1, 64))
+			default:
+				return nil, fmt.Errorf("parameter set %d: %s must be a string or number", i, name)
+			}
+		}
+		params, err := backtestParamsFromValues(values)
+		if err != nil {
+			return nil, fmt.Errorf("parameter set %d: %w", i, err)
+		}
+		if i > 0 && (!params.From.Equal(paramSets[0].From) || !params.To.Equal(paramSets[0].To) || params.Provider != paramSets[0].Provider) {
+			return nil, fmt.Errorf("parameter set %d: from, to and provider must match the first parameter set", i)
+		}
+		paramSets = append(paramSets, params)
+	}
+	return paramSets, nil
+}
+
+// runBacktestComparison runs each parameter set over the same signals and price bars, saving each
+// run's ledger under its own hash as /backtest does
+func runBacktestComparison(db *DB, paramSets []BacktestParams) (*BacktestComparison, error) {
+	first := paramSets[0]
+	signals, err := db.getTradeSignals(first.From, dayAfter(first.To), first.Provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trade signals: %w", err)
+	}
+
+	comparison := &BacktestComparison{}
+	barsByTicker := make(map[string][]PriceBar)
+	for _, params := range paramSets {
+		summary, err := backtestSignals(db, signals, params.From, params.To, params, barsByTicker)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.saveBacktestTrades(params, tradeLedger(summary.Results)); err != nil {
+			return nil, err
+		}
+		comparison.Runs = append(comparison.Runs, summary)
+	}
+
+	for _, metric := range compareMetrics {
+		diff := MetricComparison{Metric: metric.name}
+		baseline := metric.value(comparison.Runs[0])
+		for _, run := range comparison.Runs {
+			value := metric.value(run)
+			diff.Values = append(diff.Values, value)
+			diff.Delta = append(diff.Delta, value-baseline)
+		}
+		comparison.Diff = append(comparison.Diff, diff)
+	}
+	return comparison, nil
+}
+
+// HTTP handler for backtesting several parameter sets over the same signals and comparing their key metrics
+func backtestCompareHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCompareBodyBytes))
+	if err != nil {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body must be at most %d bytes", maxCompareBodyBytes))
+		return
+	}
+	paramSets, err := parseCompareParams(body)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	db, err := setupDatabase()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
+		return
+	}
+	defer db.Close()
+
+	comparison, err := runBacktestComparison(db, paramSets)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Backtest failed: %v", err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, comparison)
+}
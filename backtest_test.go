@@ -0,0 +1,303 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// day returns midnight UTC of a June 2024 date, the calendar the backtest tests trade on
+func day(d int) time.Time {
+	return time.Date(2024, 6, d, 0, 0, 0, 0, time.UTC)
+}
+
+// testSignal buys at 10.50 with a stop at 9.50 and a target at 11.50, entering on June 3
+func testSignal() CleanSignal {
+	return CleanSignal{
+		EmailID:     "m1",
+		Ticker:      "ACME",
+		EntryDate:   day(3).UnixMilli(),
+		BuyPrice:    10.50,
+		StopPrice:   9.50,
+		TargetPrice: 11.50,
+	}
+}
+
+func TestSimulateTradeAmbiguousBar(t *testing.T) {
+	bars := []PriceBar{
+		{Date: day(3), Open: 10.20, High: 10.40, Low: 10.00, Close: 10.00},
+		// Low reaches the stop and High the target on the same day
+		{Date: day(4), Open: 10.00, High: 12.00, Low: 9.00, Close: 10.80},
+	}
+
+	tests := []struct {
+		mode       ResolutionMode
+		wantPrice  float64
+		wantReason string
+	}{
+		{StopFirst, 9.50, "STOP LOSS"},
+		{TargetFirst, 11.50, "TARGET HIT"},
+		{Proportional, 10.50, "SPLIT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode.String(), func(t *testing.T) {
+			result := simulateTrade(testSignal(), bars, BacktestParams{Resolution: tt.mode})
+			if !result.Ambiguous {
+				t.Error("Ambiguous = false, want true")
+			}
+			if result.Status != StatusCompleted || result.ExitDate != "2024-06-04" {
+				t.Errorf("status %s exiting %s, want completed on 2024-06-04", result.Status, result.ExitDate)
+			}
+			if result.ExitPrice != tt.wantPrice || result.ExitReason != tt.wantReason {
+				t.Errorf("exit = %v %s, want %v %s", result.ExitPrice, result.ExitReason, tt.wantPrice, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestSimulateTradeGapFills(t *testing.T) {
+	entry := PriceBar{Date: day(3), Open: 10.20, High: 10.40, Low: 10.00, Close: 10.00}
+	tests := []struct {
+		name       string
+		bar        PriceBar
+		wantPrice  float64
+		wantReason string
+	}{
+		{"stop touched intraday", PriceBar{Open: 10.00, High: 10.20, Low: 9.40, Close: 9.60}, 9.50, "STOP LOSS"},
+		{"gap down through the stop", PriceBar{Open: 9.00, High: 9.20, Low: 8.80, Close: 9.10}, 9.00, "STOP LOSS"},
+		{"gap down, then up through the target", PriceBar{Open: 9.00, High: 12.00, Low: 8.80, Close: 11.80}, 9.00, "STOP LOSS"},
+		{"target touched intraday", PriceBar{Open: 10.80, High: 11.60, Low: 10.70, Close: 11.40}, 11.50, "TARGET HIT"},
+		{"gap up through the target", PriceBar{Open: 12.00, High: 12.50, Low: 11.90, Close: 12.40}, 12.00, "TARGET HIT"},
+		{"gap up, then down through the stop", PriceBar{Open: 12.00, High: 12.50, Low: 9.00, Close: 9.20}, 12.00, "TARGET HIT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.bar.Date = day(4)
+			result := simulateTrade(testSignal(), []PriceBar{entry, tt.bar}, BacktestParams{Resolution: StopFirst})
+			if result.Ambiguous {
+				t.Error("Ambiguous = true, but the open settles which level was hit first")
+			}
+			if result.ExitPrice != tt.wantPrice || result.ExitReason != tt.wantReason {
+				t.Errorf("exit = %v %s, want %v %s", result.ExitPrice, result.ExitReason, tt.wantPrice, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestBacktestCostsReduceReturns(t *testing.T) {
+	db := newTestDB(t)
+	entry := PriceBar{Date: day(3), Open: 10.20, High: 10.40, Low: 10.00, Close: 10.00}
+	for ticker, exit := range map[string]PriceBar{
+		"WIN":  {Date: day(4), Open: 10.60, High: 12.00, Low: 10.40, Close: 11.80}, // reaches the 11.50 target
+		"LOSS": {Date: day(4), Open: 9.80, High: 10.10, Low: 9.00, Close: 9.20},    // reaches the 9.50 stop
+		"THIN": {Date: day(4), Open: 10.00, High: 10.10, Low: 9.90, Close: 10.05},  // reaches the 10.05 target
+	} {
+		if err := db.savePriceBars(ticker, []PriceBar{entry, exit}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var signals []CleanSignal
+	for _, ticker := range []string{"WIN", "LOSS", "THIN"} {
+		signal := testSignal()
+		signal.EmailID, signal.Ticker = ticker, ticker
+		if ticker == "THIN" {
+			signal.TargetPrice = 10.05 // a 0.5% gain that costs can turn into a loss
+		}
+		signals = append(signals, signal)
+	}
+
+	// Costs only increase down the table
+	tests := []struct {
+		slippageBps, commission float64
+	}{
+		{0, 0},
+		{10, 0},
+		{10, 0.01},
+		{50, 0.01},
+		{50, 0.05},
+	}
+
+	var previous *BacktestSummary
+	for _, tt := range tests {
+		params := BacktestParams{RiskPct: 1, SlippageBps: tt.slippageBps, CommissionPerShare: tt.commission}
+		summary, err := backtestSignals(db, signals, time.Time{}, time.Time{}, params, make(map[string][]PriceBar))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.Completed != 3 {
+			t.Fatalf("slippage %g bps, commission %g: %d completed trades, want 3", tt.slippageBps, tt.commission, summary.Completed)
+		}
+		if previous != nil {
+			if summary.AvgReturnPct >= previous.AvgReturnPct || summary.AccountReturnPct >= previous.AccountReturnPct {
+				t.Errorf("slippage %g bps, commission %g: average return %.4f%%, account return %.4f%%, want both below %.4f%% and %.4f%%",
+					tt.slippageBps, tt.commission, summary.AvgReturnPct, summary.AccountReturnPct, previous.AvgReturnPct, previous.AccountReturnPct)
+			}
+			if summary.WinRate > previous.WinRate {
+				t.Errorf("slippage %g bps, commission %g: win rate rose to %.1f%% from %.1f%%", tt.slippageBps, tt.commission, summary.WinRate, previous.WinRate)
+			}
+		}
+		previous = summary
+	}
+
+	// The thin winner is a loss once costs exceed its gain
+	if previous.Wins != 1 || previous.Losses != 2 {
+		t.Errorf("at the highest costs %d wins and %d losses, want the thin winner to lose", previous.Wins, previous.Losses)
+	}
+}
+
+func TestTradeLedgerExitReasons(t *testing.T) {
+	entry := PriceBar{Date: day(3), Open: 10.20, High: 10.40, Low: 10.00, Close: 10.00}
+	tests := []struct {
+		name       string
+		next       PriceBar
+		resolution ResolutionMode
+		want       string
+	}{
+		{"stop", PriceBar{Open: 10.00, High: 10.20, Low: 9.40, Close: 9.60}, StopFirst, "stop"},
+		{"target", PriceBar{Open: 10.80, High: 11.60, Low: 10.70, Close: 11.40}, StopFirst, "target"},
+		{"split", PriceBar{Open: 10.00, High: 12.00, Low: 9.00, Close: 10.80}, Proportional, "split"},
+		{"still open at the last bar", PriceBar{Open: 10.10, High: 10.60, Low: 9.90, Close: 10.40}, StopFirst, "eod"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			tt.next.Date = day(4)
+			if err := db.savePriceBars("ACME", []PriceBar{entry, tt.next}); err != nil {
+				t.Fatal(err)
+			}
+			params := BacktestParams{Resolution: tt.resolution}
+			summary, err := backtestSignals(db, []CleanSignal{testSignal()}, time.Time{}, time.Time{}, params, make(map[string][]PriceBar))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ledger := tradeLedger(summary.Results)
+			if len(ledger) != 1 || ledger[0].ExitReason != tt.want {
+				t.Fatalf("tradeLedger() = %+v, want one trade exiting on %s", ledger, tt.want)
+			}
+			if err := db.saveBacktestTrades(params, ledger); err != nil {
+				t.Fatal(err)
+			}
+			saved, err := db.getBacktestTrades(params.Hash())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(saved) != 1 || saved[0].ExitReason != tt.want {
+				t.Errorf("backtest_trades holds %+v, want one trade exiting on %s", saved, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePriceBarCSV(t *testing.T) {
+	csv := "Date,Open,High,Low,Close,Adj Close,Volume\n" +
+		"2024-06-03,10.2,10.4,10.0,10.0,10.0,1200\n" +
+		"2024-06-04 00:00:00-04:00,10.0,12.0,9.0,10.8,10.8,3400\n" +
+		"2024-06-05,n/a,1,1,1,1,0\n"
+
+	bars, rows, rowErrors, err := parsePriceBarCSV([]byte(csv), "ACME")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 3 || len(rowErrors) != 1 {
+		t.Errorf("rows = %d with errors %v, want 3 rows and one error", rows, rowErrors)
+	}
+	got := bars["ACME"]
+	if len(got) != 2 || !got[1].Date.Equal(day(4)) || got[1].Low != 9.0 || got[1].Volume != 3400 {
+		t.Fatalf("bars = %+v, want the two valid June 3 and 4 bars", got)
+	}
+
+	if _, _, _, err := parsePriceBarCSV([]byte("Date,Open,High,Low,Close\n"), ""); err == nil {
+		t.Error("a file without a ticker column or ticker parameter was accepted")
+	}
+}
+
+func TestSavePriceBarsReplacesDates(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.savePriceBars("ACME", []PriceBar{{Date: day(3), Close: 10}, {Date: day(4), Close: 11}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.savePriceBars("ACME", []PriceBar{{Date: day(4), Close: 12}}); err != nil {
+		t.Fatal(err)
+	}
+
+	bars, err := db.getPriceBars("ACME")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bars) != 2 || bars[1].Close != 12 {
+		t.Errorf("bars = %+v, want two bars with June 4 replaced", bars)
+	}
+}
+
+func TestBenchmarkBuyAndHold(t *testing.T) {
+	// A year from 100 to 121 is 21% and, over 365 of 365.25 days, a CAGR just above 21%
+	year := []PriceBar{
+		{Date: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC), Close: 90}, // before the range
+		{Date: time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC), Close: 100},
+		{Date: time.Date(2024, 12, 2, 0, 0, 0, 0, time.UTC), Close: 95},
+		{Date: time.Date(2025, 6, 3, 0, 0, 0, 0, time.UTC), Close: 121},
+	}
+	returnPct, cagrPct, ok := buyAndHold(year, day(3), time.Time{})
+	if !ok || math.Abs(returnPct-21) > 1e-9 || cagrPct < 21 || cagrPct > 21.02 {
+		t.Errorf("buyAndHold() = %g%%, CAGR %g%%, %v, want 21%% and a CAGR just above it", returnPct, cagrPct, ok)
+	}
+	if _, _, ok := buyAndHold(year, day(3), day(4)); ok {
+		t.Error("buyAndHold() of a single bar is ok, want not ok")
+	}
+
+	db := newTestDB(t)
+	if err := db.savePriceBars("ACME", []PriceBar{
+		{Date: day(3), Open: 10.20, High: 10.40, Low: 10.00, Close: 10.00},
+		{Date: day(4), Open: 10.60, High: 12.00, Low: 10.40, Close: 11.80}, // reaches the 11.50 target
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.savePriceBars("SPY", []PriceBar{
+		{Date: day(3), Open: 500, High: 502, Low: 499, Close: 500},
+		{Date: day(4), Open: 501, High: 506, Low: 500, Close: 505},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	signal := testSignal()
+	signal.SignalDate = day(3).UnixMilli()
+
+	tests := []struct {
+		name      string
+		benchmark string
+		included  bool
+		want      float64 // benchmark return
+	}{
+		{"synthetic SPY bars", "SPY", true, 1},
+		{"no bars for the benchmark", "QQQ", false, 0},
+		{"no benchmark", "", false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := BacktestParams{RiskPct: 1, Benchmark: tt.benchmark}
+			summary, err := backtestSignals(db, []CleanSignal{signal}, time.Time{}, time.Time{}, params, make(map[string][]PriceBar))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.included {
+				if summary.Benchmark != "" || summary.BenchmarkReturnPct != nil || summary.BenchmarkCAGRPct != nil || summary.ExcessReturnPct != nil {
+					t.Errorf("benchmark fields = %q %v %v %v, want them left out", summary.Benchmark, summary.BenchmarkReturnPct, summary.BenchmarkCAGRPct, summary.ExcessReturnPct)
+				}
+				return
+			}
+			if summary.Benchmark != tt.benchmark || summary.BenchmarkReturnPct == nil || math.Abs(*summary.BenchmarkReturnPct-tt.want) > 1e-9 {
+				t.Fatalf("benchmark %q returned %v, want %s returning %g%%", summary.Benchmark, summary.BenchmarkReturnPct, tt.benchmark, tt.want)
+			}
+			if summary.BenchmarkCAGRPct == nil || *summary.BenchmarkCAGRPct <= *summary.BenchmarkReturnPct {
+				t.Errorf("benchmark CAGR %v, want a one-day gain to compound above %g%%", summary.BenchmarkCAGRPct, *summary.BenchmarkReturnPct)
+			}
+			if summary.Completed != 1 || summary.ExcessReturnPct == nil || math.Abs(*summary.ExcessReturnPct-(summary.AccountReturnPct-tt.want)) > 1e-9 {
+				t.Errorf("excess return %v with account return %g%%, want the account return minus %g%%", summary.ExcessReturnPct, summary.AccountReturnPct, tt.want)
+			}
+		})
+	}
+}
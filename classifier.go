@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// classifierExchanges are always scored as exchange-formatted tickers, along with any TICKER_EXCHANGES
+var classifierExchanges = []string{"NASDAQ", "NYSE", "AMEX", "NYSEARCA"}
+
+// buildExchangeTickerRe matches an exchange-formatted ticker for classifierExchanges and extra
+func buildExchangeTickerRe(extra []string) *regexp.Regexp {
+	names := append([]string(nil), classifierExchanges...)
+	for _, name := range extra {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return regexp.MustCompile(`(?i)\b(?:` + strings.Join(names, "|") + `)\s*:\s*[A-Z]{1,7}(?:\.[A-Z])?\b`)
+}
+
+var (
+	// exchangeTickerRe finds exchange-formatted tickers like (NASDAQ: LNTH) that real alerts always carry
+	exchangeTickerRe = buildExchangeTickerRe(nil)
+
+	// Subject words typical of alerts and of marketing mail
+	signalSubjectRe = regexp.MustCompile(`(?i)\b(?:pick|alert|trade|signal|buy|breakout|setup)\b`)
+	promoSubjectRe  = regexp.MustCompile(`(?i)\b(?:renew|renewal|subscription|subscribe|discount|sale|webinar|offer|expires?|last chance|upgrade|free trial|coupon)\b|\d+%\s*off`)
+
+	// resendPrefixRe matches one reply, forward or resend marker at the start of a subject
+	resendPrefixRe = regexp.MustCompile(`(?i)^\s*(?:(?:re|fwd?)\s*:|\[resend\])\s*`)
+)
+
+// normalizeSubject strips any run of RE:, FW:, FWD: and [RESEND] prefixes, collapses whitespace
+// and lowercases, so an alert and its resends share one subject
+func normalizeSubject(subject string) string {
+	for {
+		stripped := resendPrefixRe.ReplaceAllString(subject, "")
+		if stripped == subject {
+			break
+		}
+		subject = stripped
+	}
+	return strings.ToLower(strings.Join(strings.Fields(subject), " "))
+}
+
+// SignalScore is the result of classifying an email as a likely signal or likely promotion
+type SignalScore struct {
+	Score   int
+	Reasons []string
+}
+
+// scoreSignalEmail scores an email from simple features: an exchange-formatted ticker,
+// prices following the entry, stop and target keywords, and the subject line.
+// Higher scores are more likely to be real trade alerts.
+func scoreSignalEmail(email EmailSignal) SignalScore {
+	var score SignalScore
+	add := func(points int, reason string) {
+		score.Score += points
+		score.Reasons = append(score.Reasons, fmt.Sprintf("%+d %s", points, reason))
+	}
+
+	plainText := email.Text
+	if plainText == "" {
+		plainText = htmlToPlainText(email.HTML)
+	}
+	textLower := strings.ToLower(plainText)
+
+	if exchangeTickerRe.MatchString(plainText) {
+		add(3, "exchange ticker")
+	}
+
+	priceText := textLower
+	if spelledPrices {
+		priceText = replaceSpelledPrices(textLower)
+	}
+	signal := &TradingSignal{}
+	extractBuyPrice(signal, priceText, nil)
+	extractStopPrice(signal, priceText, nil)
+	extractTargetPrice(signal, priceText, nil)
+	if signal.BuyPrice > 0 {
+		add(2, "entry price")
+	}
+	if signal.StopPrice > 0 {
+		add(1, "stop price")
+	}
+	if signal.TargetPrice > 0 {
+		add(1, "target price")
+	}
+
+	if signalSubjectRe.MatchString(email.Subject) {
+		add(1, "signal subject")
+	}
+	if promoSubjectRe.MatchString(email.Subject) {
+		add(-3, "promotional subject")
+	}
+
+	return score
+}
+
+// filterLikelySignals drops emails scoring below signalScoreThreshold so marketing mail
+// with buy/stop/target boilerplate does not produce junk signals. It also returns the score of each
+// dropped email by ID, which the parse watermark keeps so lowering the threshold requeues them.
+func filterLikelySignals(emails []EmailSignal) ([]EmailSignal, map[string]int) {
+	kept := emails[:0:0]
+	skipped := make(map[string]int)
+	for _, email := range emails {
+		score := scoreSignalEmail(email)
+		if score.Score < signalScoreThreshold {
+			skipped[email.ID] = score.Score
+			log.Printf("Skipping likely promotional email %s (%q): score %d < %d [%s]",
+				email.ID, email.Subject, score.Score, signalScoreThreshold, strings.Join(score.Reasons, ", "))
+			signalsParsedTotal.WithLabelValues("skipped").Inc()
+			continue
+		}
+		kept = append(kept, email)
+	}
+	return kept, skipped
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// newsletterHTML wraps body in the <head> and <style> block that most newsletter HTML opens with
+func newsletterHTML(body string) string {
+	return `<html><head><style>` + strings.Repeat(".stoxx-column { padding: 0 12px; font-family: Arial, sans-serif; }\n", 20) +
+		`</style></head><body>` + body + `</body></html>`
+}
+
+var (
+	realAlert = EmailSignal{
+		ID:      "alert",
+		Subject: "Today's Stock Pick",
+		HTML: newsletterHTML(`<p>Acme Robotics (NASDAQ: ACME) is today's pick.</p>` +
+			`<p>Buy at $12.50<br>Stop at $11.00<br>Target at $15.00</p>`),
+	}
+	promoEmail = EmailSignal{
+		ID:      "promo",
+		Subject: "Last chance: renew your subscription at 50% off",
+		HTML: newsletterHTML(`<p>Members who buy early, set a stop and hit their target get more from every pick.</p>` +
+			`<p>Renew today for $99 a year.</p>`),
+	}
+)
+
+func TestScoreSignalEmail(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    EmailSignal
+		wantKept bool
+	}{
+		{"real alert", realAlert, true},
+		{"promotion", promoEmail, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score := scoreSignalEmail(tt.email)
+			if kept := score.Score >= signalScoreThreshold; kept != tt.wantKept {
+				t.Errorf("score %d [%s], kept = %v, want %v", score.Score, strings.Join(score.Reasons, ", "), kept, tt.wantKept)
+			}
+		})
+	}
+
+	kept, skipped := filterLikelySignals([]EmailSignal{promoEmail, realAlert})
+	if len(kept) != 1 || kept[0].ID != "alert" {
+		t.Errorf("filterLikelySignals() kept %+v, want only the alert", kept)
+	}
+	if score, ok := skipped["promo"]; !ok || score != scoreSignalEmail(promoEmail).Score || len(skipped) != 1 {
+		t.Errorf("filterLikelySignals() skipped %v, want the promotion with its score", skipped)
+	}
+}
+
+func TestDropEmptyEmailsSetsText(t *testing.T) {
+	blank := EmailSignal{ID: "blank", HTML: newsletterHTML(`<p>&nbsp;</p><img src="banner.png">`)}
+	emails, dropped := dropEmptyEmails([]EmailSignal{blank, realAlert})
+	if dropped != 1 || len(emails) != 1 || emails[0].ID != "alert" {
+		t.Fatalf("dropEmptyEmails() = %+v dropping %d, want only the alert", emails, dropped)
+	}
+	if want := htmlToPlainText(realAlert.HTML); emails[0].Text != want {
+		t.Errorf("Text = %q, want %q", emails[0].Text, want)
+	}
+
+	// The parser reads the carried text, past the 1000 characters of <head> the HTML opens with
+	signal, _, rejection, err := extractSignalFieldsTraced(emails[0], nil)
+	if err != nil || rejection != nil {
+		t.Fatalf("extractSignalFieldsTraced() rejected the alert: %v %+v", err, rejection)
+	}
+	if signal.Ticker != "ACME" || signal.BuyPrice != 12.5 || signal.StopPrice != 11 || signal.TargetPrice != 15 {
+		t.Errorf("parsed %s buy %g stop %g target %g", signal.Ticker, signal.BuyPrice, signal.StopPrice, signal.TargetPrice)
+	}
+}
+
+func TestNormalizeSubject(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    string
+	}{
+		{"Buy XYZ", "buy xyz"},
+		{"RE: Buy XYZ", "buy xyz"},
+		{"Fwd: RE:  Buy   XYZ ", "buy xyz"},
+		{"[RESEND] re:Buy XYZ", "buy xyz"},
+		{"Buy XYZ: RE: update", "buy xyz: re: update"},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeSubject(tt.subject); got != tt.want {
+			t.Errorf("normalizeSubject(%q) = %q, want %q", tt.subject, got, tt.want)
+		}
+	}
+}
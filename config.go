@@ -0,0 +1,721 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// Environment-driven settings. Defaults preserve the original hardcoded behavior.
+var (
+	// compressRawPayload controls whether raw Gmail messages are gzip-compressed before storage.
+	// Set RAW_PAYLOAD_COMPRESS=false to store plain JSON instead.
+	compressRawPayload = os.Getenv("RAW_PAYLOAD_COMPRESS") != "false"
+
+	// compressHTMLColumn stores email HTML gzip-compressed in emails.html_gz, leaving emails.html NULL.
+	// Set HTML_COMPRESS=true to enable; existing rows are converted either way when the setting changes.
+	compressHTMLColumn = os.Getenv("HTML_COMPRESS") == "true"
+
+	// tokenStore selects where OAuth tokens are persisted: "file" (default) or "db"
+	tokenStore = envString("TOKEN_STORE", "file")
+
+	// tickerMinLength and tickerMaxLength bound the base ticker symbol, excluding any class-share suffix.
+	// Symbols longer than 5 letters are only accepted from exchange-formatted mentions like (NYSE: TICKER).
+	tickerMinLength = envInt("TICKER_MIN_LENGTH", 2)
+	tickerMaxLength = envInt("TICKER_MAX_LENGTH", 5)
+
+	// Price sanity checks shared by the Go and SQL parsers. Prices must fall strictly between
+	// PRICE_MIN (default 0) and PRICE_MAX (default 10000), and target >= buy * tolerance and
+	// buy >= stop * tolerance must hold, where PRICE_RELATIONSHIP_TOLERANCE defaults to 0.9.
+	priceMin              = envFloat("PRICE_MIN", 0)
+	priceMax              = envFloat("PRICE_MAX", 10000)
+	relationshipTolerance = envFloat("PRICE_RELATIONSHIP_TOLERANCE", 0.9)
+
+	// priceDecimals is how many decimal places prices are shown with in logs and JSON responses
+	// (PRICE_DECIMALS, default 4, so sub-cent quotes such as 0.125 survive). Stored prices keep
+	// full precision; logs drop trailing zeros past the second place, so 50 still reads 50.00.
+	priceDecimals = envInt("PRICE_DECIMALS", 4)
+
+	// signalDateSource picks a signal's effective date: "email" (default) uses when the email was sent,
+	// while "content" prefers a trade date stated in the body, such as "for Monday, June 3, 2024" or
+	// "for Monday 6/3". Entry timing and ENTRY_OFFSET_HOURS count from that date, but never enter before
+	// the email was sent. The email's own date is stored alongside either way.
+	signalDateSource = envString("SIGNAL_DATE_SOURCE", "email")
+
+	// parseTrace controls the parser's per-field "PARSING:" log lines. "failures" (default) keeps them
+	// quiet for valid signals and, when validation rejects one, logs them with the full cleaned text,
+	// cut to PARSE_TRACE_MAX_TEXT characters (default 4000). "all" logs every email's lines as they
+	// happen, and "off" never logs them.
+	parseTraceMode    = envString("PARSE_TRACE", "failures")
+	parseTraceMaxText = envInt("PARSE_TRACE_MAX_TEXT", 4000)
+
+	// The SQL parser reads each price from the SQL_SEGMENT_LENGTH characters (default 100) starting at its
+	// keyword, and looks SQL_LOOKBEHIND_LENGTH characters (default 20) back for the "$50 IS OUR BUY POINT"
+	// form. Raise them for layouts such as whitespace-padded tables that push the number farther away.
+	sqlSegmentLength    = envInt("SQL_SEGMENT_LENGTH", 100)
+	sqlLookbehindLength = envInt("SQL_LOOKBEHIND_LENGTH", 20)
+
+	// spelledPrices rewrites spelled-out prices like "fifty dollars" or "forty eight fifty" as digits
+	// before extraction. It is slower and can misread prose, so it is off unless SPELLED_PRICES=true.
+	spelledPrices = os.Getenv("SPELLED_PRICES") == "true"
+
+	// nonUSDSignals is what the parser does with a signal whose buy price is marked in another currency,
+	// such as "£12.50" or "C$8": "skip" (default) rejects it, and "convert" converts its prices to dollars
+	// at the FX_RATES rate, rejecting currencies without one.
+	nonUSDSignals = envString("NON_USD_SIGNALS", "skip")
+
+	// accessLog logs every HTTP request's method, path, status, bytes and duration; ACCESS_LOG=false turns it off
+	accessLog = os.Getenv("ACCESS_LOG") != "false"
+
+	// signalScoreThreshold is the minimum classifier score for an email to be parsed; lower-scoring
+	// emails are treated as promotions. Set SIGNAL_SCORE_THRESHOLD to tune it; lowering it requeues
+	// the skipped emails that now reach it on the next incremental parse.
+	signalScoreThreshold = envInt("SIGNAL_SCORE_THRESHOLD", 3)
+
+	// enrichFreshness is how long an enriched thread is skipped by later enrichment runs.
+	// Set ENRICH_FRESHNESS_HOURS (default 24); 0 re-enriches every thread.
+	enrichFreshness = time.Duration(envInt("ENRICH_FRESHNESS_HOURS", 24)) * time.Hour
+
+	// enrichMessagesPerThread caps how many of a thread's messages enrichment fetches, oldest first.
+	// A newsletter's signal is in the thread's original message, so ENRICH_MESSAGES_PER_THREAD=1 skips
+	// the replies and the API calls they cost; the default 0 fetches every message.
+	enrichMessagesPerThread = envInt("ENRICH_MESSAGES_PER_THREAD", 0)
+
+	// useBatch fetches messages through Gmail's batch endpoint, up to 100 per request.
+	// Set USE_BATCH=true to enable; otherwise each message is fetched with its own request.
+	useBatch = os.Getenv("USE_BATCH") == "true"
+
+	// entryOffset is how long after the signal email a trade is assumed to enter when the email
+	// gives no timing of its own. Set ENTRY_OFFSET_HOURS (default 24, the next session).
+	entryOffset = time.Duration(envInt("ENTRY_OFFSET_HOURS", 24)) * time.Hour
+
+	// gmailTimeout bounds each Gmail API request so a hung connection cannot stall a worker.
+	// Set GMAIL_TIMEOUT_SECONDS (default 30); 0 disables the timeout.
+	gmailTimeout = time.Duration(envInt("GMAIL_TIMEOUT_SECONDS", 30)) * time.Second
+
+	// itemTimeout bounds the work a download, enrich or parse worker spends on one message, thread or
+	// email, so a pathological item is recorded as a timeout instead of stalling the pool.
+	// Set ITEM_TIMEOUT_SECONDS (default 120); 0 disables the timeout.
+	itemTimeout = time.Duration(envInt("ITEM_TIMEOUT_SECONDS", 120)) * time.Second
+
+	// stageTimeouts bound each pipeline stage as a whole, so a stage stuck retrying rate limits cannot run
+	// for hours: at the deadline it starts no more items, cancels those in flight and reports how far it got.
+	// Set DOWNLOAD_TIMEOUT_SECONDS, ENRICH_TIMEOUT_SECONDS (also used by enrich_v1_2), PARSE_TIMEOUT_SECONDS
+	// and PROCESS_TIMEOUT_SECONDS; 0 (default) lets a stage run to the end.
+	stageTimeouts = map[string]time.Duration{
+		"download":    time.Duration(envInt("DOWNLOAD_TIMEOUT_SECONDS", 0)) * time.Second,
+		"enrich":      time.Duration(envInt("ENRICH_TIMEOUT_SECONDS", 0)) * time.Second,
+		"enrich_v1_2": time.Duration(envInt("ENRICH_TIMEOUT_SECONDS", 0)) * time.Second,
+		"parse":       time.Duration(envInt("PARSE_TIMEOUT_SECONDS", 0)) * time.Second,
+		"process":     time.Duration(envInt("PROCESS_TIMEOUT_SECONDS", 0)) * time.Second,
+	}
+
+	// gmailQPS caps Gmail API requests per second across all stages and workers; each message in a
+	// batch request counts as one. Set GMAIL_QPS (default 0, unlimited); around 40 keeps messages.get
+	// under Gmail's per-user quota of 250 units per second.
+	gmailQPS = envFloat("GMAIL_QPS", 0)
+
+	// resendWindow collapses trade signals whose email repeats the normalized subject of another
+	// signal sent within this window, keeping the earliest. Set RESEND_WINDOW_MINUTES; 0 (default) disables it.
+	resendWindow = time.Duration(envInt("RESEND_WINDOW_MINUTES", 0)) * time.Minute
+
+	// SQLite connection settings. SQLITE_JOURNAL_MODE defaults to WAL so readers do not block the writer,
+	// and SQLITE_BUSY_TIMEOUT_MS (default 30000) is how long a connection waits on a lock before failing
+	// with "database is locked". SQLITE_SYNCHRONOUS is left to SQLite unless set; NORMAL is safe under WAL
+	// and faster than FULL. SQLITE_MAX_OPEN_CONNS (default 1) caps each pool: SQLite serializes writes
+	// anyway, so one connection queues concurrent workers in Go instead of failing on the file lock.
+	sqliteJournalMode  = strings.ToUpper(envString("SQLITE_JOURNAL_MODE", "WAL"))
+	sqliteBusyTimeout  = envInt("SQLITE_BUSY_TIMEOUT_MS", 30000)
+	sqliteSynchronous  = strings.ToUpper(os.Getenv("SQLITE_SYNCHRONOUS"))
+	sqliteMaxOpenConns = envInt("SQLITE_MAX_OPEN_CONNS", 1)
+
+	// channelBufferPerWorker sizes each pipeline stage's jobs and results channels at this many items per
+	// worker (default 2), so memory stays flat however many messages or signals a stage works through.
+	channelBufferPerWorker = envInt("CHANNEL_BUFFER_PER_WORKER", 2)
+
+	// idempotencyTTL is how long a pipeline POST's successful response is replayed for a repeated
+	// Idempotency-Key header. Set IDEMPOTENCY_TTL_MINUTES to change it from 10 minutes.
+	idempotencyTTL = time.Duration(envInt("IDEMPOTENCY_TTL_MINUTES", 10)) * time.Minute
+
+	// completionWebhookURL receives a JSON POST after each pipeline stage finishes.
+	// Leave COMPLETION_WEBHOOK_URL unset to disable notifications.
+	completionWebhookURL = os.Getenv("COMPLETION_WEBHOOK_URL")
+)
+
+// KeywordConfig lists the words that introduce each price, in priority order.
+// Both parsers try the first keyword before falling back to later ones. Synonyms such as
+// "support" for a stop are tried last and only count when a number follows them closely.
+type KeywordConfig struct {
+	EntryKeywords  []string `json:"EntryKeywords"`
+	StopKeywords   []string `json:"StopKeywords"`
+	TargetKeywords []string `json:"TargetKeywords"`
+	StopSynonyms   []string `json:"StopSynonyms"`
+	TargetSynonyms []string `json:"TargetSynonyms"`
+}
+
+// defaultKeywords returns the built-in price keywords
+func defaultKeywords() KeywordConfig {
+	return KeywordConfig{
+		EntryKeywords:  []string{"buy", "entry", "long"},
+		StopKeywords:   []string{"stop", "stop-loss", "sl", "s.l."},
+		TargetKeywords: []string{"target", "targets", "take-profit", "tp", "t.p."},
+		StopSynonyms:   []string{"support"},
+		TargetSynonyms: []string{"resistance"},
+	}
+}
+
+// keywords are the active price keywords; KEYWORDS_FILE replaces them at startup
+var keywords = defaultKeywords()
+
+// loadKeywordConfig reads keyword lists from a JSON file. Lists missing from the file keep their defaults;
+// synonym lists may be set to [] to disable them.
+func loadKeywordConfig(path string) (KeywordConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return KeywordConfig{}, fmt.Errorf("failed to read keyword config: %w", err)
+	}
+
+	config := defaultKeywords()
+	if err := json.Unmarshal(data, &config); err != nil {
+		return KeywordConfig{}, fmt.Errorf("failed to parse keyword config %s: %w", path, err)
+	}
+
+	for name, list := range map[string][]string{
+		"EntryKeywords":  config.EntryKeywords,
+		"StopKeywords":   config.StopKeywords,
+		"TargetKeywords": config.TargetKeywords,
+		"StopSynonyms":   config.StopSynonyms,
+		"TargetSynonyms": config.TargetSynonyms,
+	} {
+		if len(list) == 0 && !strings.HasSuffix(name, "Synonyms") {
+			return KeywordConfig{}, fmt.Errorf("keyword config %s: %s is empty", path, name)
+		}
+		for i, keyword := range list {
+			if strings.TrimSpace(keyword) == "" {
+				return KeywordConfig{}, fmt.Errorf("keyword config %s: %s[%d] is blank", path, name, i)
+			}
+			list[i] = strings.ToLower(strings.TrimSpace(keyword))
+		}
+	}
+
+	return config, nil
+}
+
+// gmailScopeAliases maps the short names accepted in GMAIL_SCOPES to Gmail OAuth scopes
+var gmailScopeAliases = map[string]string{
+	"readonly": gmail.GmailReadonlyScope,
+	"metadata": gmail.GmailMetadataScope,
+	"modify":   gmail.GmailModifyScope,
+}
+
+// bodyScopes are the Gmail scopes that allow reading message bodies
+var bodyScopes = map[string]bool{
+	gmail.GmailReadonlyScope: true,
+	gmail.GmailModifyScope:   true,
+	gmail.MailGoogleComScope: true,
+}
+
+// gmailScopes are requested during login; GMAIL_SCOPES replaces them at startup.
+// Body extraction (download and enrichment) requires readonly; metadata only covers headers and labels.
+var gmailScopes = []string{gmail.GmailReadonlyScope}
+
+// tickerExchanges are the exchange prefixes both ticker extractors recognize in "(NASDAQ: ACME)" and
+// "NYSE: ACME" mentions, tried in order; TICKER_EXCHANGES replaces them at startup
+var tickerExchanges = []string{"NASDAQ", "NYSE"}
+
+// exchangeNameRe limits exchange names to what can go into the extractors' regexes and SQL unescaped
+var exchangeNameRe = regexp.MustCompile(`^[A-Z][A-Z0-9]*$`)
+
+// parseTickerExchanges turns a comma-separated list such as "NASDAQ,NYSE,AMEX" into exchange prefixes
+func parseTickerExchanges(raw string) ([]string, error) {
+	var exchanges []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		if !exchangeNameRe.MatchString(name) {
+			return nil, fmt.Errorf("invalid exchange %q in TICKER_EXCHANGES (use letters and digits, e.g. AMEX)", name)
+		}
+		seen[name] = true
+		exchanges = append(exchanges, name)
+	}
+	if len(exchanges) == 0 {
+		return nil, fmt.Errorf("TICKER_EXCHANGES is empty")
+	}
+	return exchanges, nil
+}
+
+// tickerAllowlist and tickerDenylist constrain the tickers a signal may have. TICKER_ALLOWLIST limits signals to a
+// watchlist and TICKER_DENYLIST drops tickers that bad parses keep producing; both are empty unless set.
+var (
+	tickerAllowlist map[string]bool
+	tickerDenylist  map[string]bool
+)
+
+// tickerListRe limits ticker list entries to symbols the parser can produce, with an optional class suffix
+var tickerListRe = regexp.MustCompile(`^[A-Z]+(?:\.[A-Z])?$`)
+
+// parseTickerList turns a list of tickers separated by commas or whitespace, such as "ACME, BRK.B",
+// into a set, so a watchlist file can be passed as TICKER_ALLOWLIST="$(cat watchlist.txt)"
+func parseTickerList(name, raw string) (map[string]bool, error) {
+	tickers := make(map[string]bool)
+	for _, ticker := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		ticker = strings.ToUpper(ticker)
+		if !tickerListRe.MatchString(ticker) {
+			return nil, fmt.Errorf("invalid ticker %q in %s (use letters with an optional class suffix, e.g. BRK.B)", ticker, name)
+		}
+		tickers[ticker] = true
+	}
+	if len(tickers) == 0 {
+		return nil, fmt.Errorf("%s is empty", name)
+	}
+	return tickers, nil
+}
+
+// Provider is a signal newsletter, tagged on the emails it sends
+type Provider struct {
+	Name   string
+	Sender string // an address, or a domain matching every address at it and its subdomains
+}
+
+// providers are the newsletters /download-emails fetches by default and emails.provider names.
+// PROVIDERS replaces them at startup with name=sender pairs.
+var providers = []Provider{{Name: "drstoxx", Sender: targetSender}}
+
+// providerNameRe limits provider names to what reads well in query strings and the provider column
+var providerNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// parseProviders turns a comma-separated list such as "drstoxx=drstoxx@drstoxx.com,acme=alerts.acme.com"
+// into providers
+func parseProviders(raw string) ([]Provider, error) {
+	var list []Provider
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, sender, ok := strings.Cut(entry, "=")
+		name, sender = strings.ToLower(strings.TrimSpace(name)), strings.ToLower(strings.TrimSpace(sender))
+		if !ok || !providerNameRe.MatchString(name) || sender == "" || strings.ContainsAny(sender, " \t\"()<>,") {
+			return nil, fmt.Errorf("invalid provider %q in PROVIDERS (use name=address or name=domain, e.g. acme=alerts@acme.com)", entry)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("provider %q appears twice in PROVIDERS", name)
+		}
+		seen[name] = true
+		list = append(list, Provider{Name: name, Sender: sender})
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("PROVIDERS is empty")
+	}
+	return list, nil
+}
+
+// findProviders looks up a comma-separated list of provider names, such as a provider query parameter
+func findProviders(raw string) ([]Provider, error) {
+	var found []Provider
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		i := slices.IndexFunc(providers, func(p Provider) bool { return p.Name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown provider %q (configured: %s)", name, strings.Join(providerNames(), ", "))
+		}
+		found = append(found, providers[i])
+	}
+	return found, nil
+}
+
+// providerNames lists the configured provider names in order
+func providerNames() []string {
+	names := make([]string, len(providers))
+	for i, p := range providers {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// providerFor tags a sender address with the provider whose sender matches it. Senders of no
+// configured provider are tagged with their domain, so each newsletter can still be told apart.
+func providerFor(fromAddress string) string {
+	address := strings.ToLower(strings.Trim(strings.TrimSpace(fromAddress), "<>"))
+	if address == "" {
+		return ""
+	}
+	_, domain, _ := strings.Cut(address, "@")
+	for _, p := range providers {
+		if strings.Contains(p.Sender, "@") {
+			if address == p.Sender {
+				return p.Name
+			}
+		} else if domain == p.Sender || strings.HasSuffix(domain, "."+p.Sender) {
+			return p.Name
+		}
+	}
+	if domain == "" {
+		return address
+	}
+	return domain
+}
+
+// signalKeywords are the words an email may be required to mention before it enters the parse stage
+var signalKeywords = map[string]bool{"buy": true, "stop": true, "target": true}
+
+// requiredSignalKeywords must all appear in an email's content for it to be parsed; SIGNAL_REQUIRED_KEYWORDS
+// replaces them at startup. Dropping stop or target admits partial signals, which cleanSignalPolicy then
+// keeps with that price missing; backtests still skip them.
+var requiredSignalKeywords = []string{"buy", "stop", "target"}
+
+// parseRequiredSignalKeywords turns a comma-separated list such as "buy,stop" into required signal keywords
+func parseRequiredSignalKeywords(raw string) ([]string, error) {
+	var required []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		if !signalKeywords[name] {
+			return nil, fmt.Errorf("unknown signal keyword %q in SIGNAL_REQUIRED_KEYWORDS (use buy, stop or target)", name)
+		}
+		seen[name] = true
+		required = append(required, name)
+	}
+	if !seen["buy"] {
+		return nil, fmt.Errorf("SIGNAL_REQUIRED_KEYWORDS must include buy, got %q", raw)
+	}
+	return required, nil
+}
+
+// signalKeywordRequired reports whether name is one of the required signal keywords
+func signalKeywordRequired(name string) bool {
+	for _, keyword := range requiredSignalKeywords {
+		if keyword == name {
+			return true
+		}
+	}
+	return false
+}
+
+// CleanSignalPolicy is which optional prices a parsed signal needs before processing promotes it
+// into trade_signals; a ticker and buy price are always required. Missing prices are stored as NULL.
+type CleanSignalPolicy struct {
+	RequireStop   bool
+	RequireTarget bool
+}
+
+// cleanSignalPolicy requires a price whose keyword SIGNAL_REQUIRED_KEYWORDS requires, so by default
+// all three. CLEAN_REQUIRE_STOP and CLEAN_REQUIRE_TARGET override either one.
+var cleanSignalPolicy = CleanSignalPolicy{RequireStop: true, RequireTarget: true}
+
+// parseGmailScopes turns a comma-separated list of scope aliases or URLs into OAuth scopes
+func parseGmailScopes(raw string) ([]string, error) {
+	var scopes []string
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if scope, ok := gmailScopeAliases[name]; ok {
+			scopes = append(scopes, scope)
+		} else if strings.HasPrefix(name, "https://") {
+			scopes = append(scopes, name)
+		} else {
+			return nil, fmt.Errorf("unknown Gmail scope %q (use readonly, metadata, modify or a scope URL)", name)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("GMAIL_SCOPES is empty")
+	}
+	return scopes, nil
+}
+
+// requireBodyScope fails early when an operation that reads message bodies runs without a scope granting it
+func requireBodyScope(operation string) error {
+	for _, scope := range gmailScopes {
+		if bodyScopes[scope] {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s reads message bodies and requires the gmail.readonly scope, but GMAIL_SCOPES grants only %s",
+		operation, strings.Join(gmailScopes, ", "))
+}
+
+// sqliteJournalModes and sqliteSynchronousModes are the values SQLite accepts for those pragmas
+var (
+	sqliteJournalModes     = map[string]bool{"DELETE": true, "TRUNCATE": true, "PERSIST": true, "MEMORY": true, "WAL": true, "OFF": true}
+	sqliteSynchronousModes = map[string]bool{"OFF": true, "NORMAL": true, "FULL": true, "EXTRA": true}
+)
+
+// maxTickerLength is the longest base symbol TICKER_MAX_LENGTH may allow
+const maxTickerLength = 7
+
+// envString returns an environment variable or a default when unset
+func envString(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// channelCapacity is the buffer size for a stage's jobs and results channels. Feeders block once it
+// fills, which keeps a large backlog in its source slice rather than copied into a channel.
+func channelCapacity(numWorkers int) int {
+	return numWorkers * channelBufferPerWorker
+}
+
+// envInt returns an integer environment variable or a default when unset or invalid
+func envInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Warning: invalid %s=%q, using default %d", name, value, def)
+		return def
+	}
+	return n
+}
+
+// envFloat returns a float environment variable or a default when unset or invalid
+func envFloat(name string, def float64) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Warning: invalid %s=%q, using default %g", name, value, def)
+		return def
+	}
+	return f
+}
+
+// listenAddress resolves the HTTP listen address: LISTEN_ADDR (e.g. "127.0.0.1:8080") if set,
+// otherwise all interfaces on PORT, defaulting to 8080
+func listenAddress() (string, error) {
+	addr := os.Getenv("LISTEN_ADDR")
+	if addr == "" {
+		addr = ":" + envString("PORT", "8080")
+	}
+
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("%q: %w", addr, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return "", fmt.Errorf("%q: invalid port %q", addr, port)
+	}
+	return addr, nil
+}
+
+// validateConfig checks settings that cannot be safely defaulted
+func validateConfig() error {
+	if tokenStore != "file" && tokenStore != "db" {
+		return fmt.Errorf("TOKEN_STORE must be file or db, got %q", tokenStore)
+	}
+
+	if tickerMinLength < 1 || tickerMaxLength < tickerMinLength || tickerMaxLength > maxTickerLength {
+		return fmt.Errorf("ticker length bounds must satisfy 1 <= TICKER_MIN_LENGTH (%d) <= TICKER_MAX_LENGTH (%d) <= %d",
+			tickerMinLength, tickerMaxLength, maxTickerLength)
+	}
+
+	if sqlSegmentLength < 1 {
+		return fmt.Errorf("SQL_SEGMENT_LENGTH must be at least 1, got %d", sqlSegmentLength)
+	}
+
+	if sqlLookbehindLength < 0 {
+		return fmt.Errorf("SQL_LOOKBEHIND_LENGTH must not be negative, got %d", sqlLookbehindLength)
+	}
+
+	if idempotencyTTL <= 0 {
+		return fmt.Errorf("IDEMPOTENCY_TTL_MINUTES must be at least 1, got %d", int(idempotencyTTL/time.Minute))
+	}
+
+	if channelBufferPerWorker < 1 {
+		return fmt.Errorf("CHANNEL_BUFFER_PER_WORKER must be at least 1, got %d", channelBufferPerWorker)
+	}
+
+	if parseTraceMode != "failures" && parseTraceMode != "all" && parseTraceMode != "off" {
+		return fmt.Errorf("PARSE_TRACE must be failures, all or off, got %q", parseTraceMode)
+	}
+
+	if parseTraceMaxText < 1 {
+		return fmt.Errorf("PARSE_TRACE_MAX_TEXT must be at least 1, got %d", parseTraceMaxText)
+	}
+
+	if nonUSDSignals != "skip" && nonUSDSignals != "convert" {
+		return fmt.Errorf("NON_USD_SIGNALS must be skip or convert, got %q", nonUSDSignals)
+	}
+
+	if signalDateSource != "email" && signalDateSource != "content" {
+		return fmt.Errorf("SIGNAL_DATE_SOURCE must be email or content, got %q", signalDateSource)
+	}
+
+	if priceMin < 0 || priceMax <= priceMin {
+		return fmt.Errorf("price range must satisfy 0 <= PRICE_MIN (%g) < PRICE_MAX (%g)", priceMin, priceMax)
+	}
+
+	if priceDecimals < 0 || priceDecimals > 8 {
+		return fmt.Errorf("PRICE_DECIMALS must be between 0 and 8, got %d", priceDecimals)
+	}
+
+	if relationshipTolerance <= 0 {
+		return fmt.Errorf("PRICE_RELATIONSHIP_TOLERANCE must be positive, got %g", relationshipTolerance)
+	}
+
+	if entryOffset < 0 {
+		return fmt.Errorf("ENTRY_OFFSET_HOURS must not be negative, got %d", int(entryOffset/time.Hour))
+	}
+
+	if !sqliteJournalModes[sqliteJournalMode] {
+		return fmt.Errorf("SQLITE_JOURNAL_MODE must be one of DELETE, TRUNCATE, PERSIST, MEMORY, WAL or OFF, got %q", sqliteJournalMode)
+	}
+
+	if sqliteSynchronous != "" && !sqliteSynchronousModes[sqliteSynchronous] {
+		return fmt.Errorf("SQLITE_SYNCHRONOUS must be one of OFF, NORMAL, FULL or EXTRA, got %q", sqliteSynchronous)
+	}
+
+	if sqliteBusyTimeout < 0 {
+		return fmt.Errorf("SQLITE_BUSY_TIMEOUT_MS must not be negative, got %d", sqliteBusyTimeout)
+	}
+
+	if sqliteMaxOpenConns < 1 {
+		return fmt.Errorf("SQLITE_MAX_OPEN_CONNS must be at least 1, got %d", sqliteMaxOpenConns)
+	}
+
+	if gmailQPS < 0 {
+		return fmt.Errorf("GMAIL_QPS must not be negative, got %g", gmailQPS)
+	}
+
+	if resendWindow < 0 {
+		return fmt.Errorf("RESEND_WINDOW_MINUTES must not be negative, got %d", int(resendWindow/time.Minute))
+	}
+
+	if gmailTimeout < 0 {
+		return fmt.Errorf("GMAIL_TIMEOUT_SECONDS must not be negative, got %d", int(gmailTimeout/time.Second))
+	}
+
+	if enrichMessagesPerThread < 0 {
+		return fmt.Errorf("ENRICH_MESSAGES_PER_THREAD must not be negative, got %d", enrichMessagesPerThread)
+	}
+
+	if itemTimeout < 0 {
+		return fmt.Errorf("ITEM_TIMEOUT_SECONDS must not be negative, got %d", int(itemTimeout/time.Second))
+	}
+
+	for stage, timeout := range stageTimeouts {
+		if timeout < 0 {
+			return fmt.Errorf("%s_TIMEOUT_SECONDS must not be negative, got %d", strings.ToUpper(strings.TrimSuffix(stage, "_v1_2")), int(timeout/time.Second))
+		}
+	}
+
+	if completionWebhookURL != "" {
+		u, err := url.Parse(completionWebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("COMPLETION_WEBHOOK_URL must be an http or https URL, got %q", completionWebhookURL)
+		}
+	}
+
+	if raw := os.Getenv("GMAIL_SCOPES"); raw != "" {
+		scopes, err := parseGmailScopes(raw)
+		if err != nil {
+			return err
+		}
+		gmailScopes = scopes
+	}
+
+	if raw := os.Getenv("TICKER_EXCHANGES"); raw != "" {
+		exchanges, err := parseTickerExchanges(raw)
+		if err != nil {
+			return err
+		}
+		tickerExchanges = exchanges
+		exchangeTickerRe = buildExchangeTickerRe(tickerExchanges)
+	}
+
+	for name, list := range map[string]*map[string]bool{
+		"TICKER_ALLOWLIST": &tickerAllowlist,
+		"TICKER_DENYLIST":  &tickerDenylist,
+	} {
+		if raw := os.Getenv(name); raw != "" {
+			tickers, err := parseTickerList(name, raw)
+			if err != nil {
+				return err
+			}
+			*list = tickers
+		}
+	}
+
+	if raw := os.Getenv("PROVIDERS"); raw != "" {
+		list, err := parseProviders(raw)
+		if err != nil {
+			return err
+		}
+		providers = list
+	}
+
+	if raw := os.Getenv("FX_RATES"); raw != "" {
+		rates, err := parseFXRates(raw)
+		if err != nil {
+			return err
+		}
+		fxRates = rates
+	}
+
+	if raw := os.Getenv("SIGNAL_REQUIRED_KEYWORDS"); raw != "" {
+		required, err := parseRequiredSignalKeywords(raw)
+		if err != nil {
+			return err
+		}
+		requiredSignalKeywords = required
+	}
+
+	cleanSignalPolicy = CleanSignalPolicy{
+		RequireStop:   signalKeywordRequired("stop"),
+		RequireTarget: signalKeywordRequired("target"),
+	}
+	for name, require := range map[string]*bool{
+		"CLEAN_REQUIRE_STOP":   &cleanSignalPolicy.RequireStop,
+		"CLEAN_REQUIRE_TARGET": &cleanSignalPolicy.RequireTarget,
+	} {
+		if raw := os.Getenv(name); raw != "" {
+			value, err := strconv.ParseBool(raw)
+			if err != nil {
+				return fmt.Errorf("%s must be true or false, got %q", name, raw)
+			}
+			*require = value
+		}
+	}
+
+	if path := os.Getenv("KEYWORDS_FILE"); path != "" {
+		config, err := loadKeywordConfig(path)
+		if err != nil {
+			return err
+		}
+		keywords = config
+		log.Printf("Loaded price keywords from %s", path)
+	}
+
+	parserPatterns = compileParserPatterns()
+	return nil
+}
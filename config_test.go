@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useKeywords makes config the active keyword set for the rest of the test
+func useKeywords(t *testing.T, config KeywordConfig) {
+	t.Helper()
+	saved := keywords
+	keywords = config
+	parserPatterns = compileParserPatterns()
+	t.Cleanup(func() {
+		keywords = saved
+		parserPatterns = compileParserPatterns()
+	})
+}
+
+func TestLoadKeywordConfig(t *testing.T) {
+	defaults := defaultKeywords()
+	tests := []struct {
+		name    string
+		file    string
+		want    KeywordConfig
+		wantErr string
+	}{
+		{
+			name: "custom entry and target lists",
+			file: `{"EntryKeywords": ["Accumulate", " add "], "TargetKeywords": ["exit", "sell"], "StopSynonyms": []}`,
+			want: KeywordConfig{
+				EntryKeywords:  []string{"accumulate", "add"},
+				StopKeywords:   defaults.StopKeywords,
+				TargetKeywords: []string{"exit", "sell"},
+				StopSynonyms:   []string{},
+				TargetSynonyms: defaults.TargetSynonyms,
+			},
+		},
+		{name: "empty file keeps the defaults", file: `{}`, want: defaults},
+		{name: "empty keyword list", file: `{"StopKeywords": []}`, wantErr: "StopKeywords is empty"},
+		{name: "blank keyword", file: `{"EntryKeywords": ["buy", " "]}`, wantErr: "EntryKeywords[1] is blank"},
+		{name: "not JSON", file: `EntryKeywords: buy`, wantErr: "failed to parse"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keywords.json")
+			if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			config, err := loadKeywordConfig(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadKeywordConfig() error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(config, tt.want) {
+				t.Errorf("loadKeywordConfig() = %+v, want %+v", config, tt.want)
+			}
+		})
+	}
+}
+
+func TestCustomKeywordsReachBothParsers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keywords.json")
+	if err := os.WriteFile(path, []byte(`{"EntryKeywords": ["accumulate"], "TargetKeywords": ["exit"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadKeywordConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useKeywords(t, config)
+
+	text := "acme (nasdaq: acme) accumulate at $50.00, protective stop at $45.00, exit at $60.00"
+
+	signal := &TradingSignal{}
+	extractBuyPrice(signal, text, nil)
+	extractStopPrice(signal, text, nil)
+	extractTargetPrice(signal, text, nil)
+	if signal.BuyPrice != 50 || signal.StopPrice != 45 || signal.TargetPrice != 60 {
+		t.Errorf("Go parser: buy %g, stop %g, target %g, want 50, 45, 60", signal.BuyPrice, signal.StopPrice, signal.TargetPrice)
+	}
+	if signal.BuySource != "go:accumulate" || signal.TargetSource != "go:exit" {
+		t.Errorf("Go parser sources %s and %s, want go:accumulate and go:exit", signal.BuySource, signal.TargetSource)
+	}
+
+	db := newTestDB(t)
+	date := time.Date(2024, 6, 3, 13, 0, 0, 0, time.UTC)
+	if _, err := db.Exec(`INSERT INTO emails (id, thread_id, subject, date, clean_text) VALUES ('m1', 't1', 'Trade alert', ?, ?)`,
+		date.Format(time.RFC3339), text); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO trade_signals (email_id, ticker, signal_date, entry_date, buy_price) VALUES ('m1', 'PARSED', ?, ?, 1)`,
+		date.UnixMilli(), date.UnixMilli()); err != nil {
+		t.Fatal(err)
+	}
+	if err := materializedSQLParse(db); err != nil {
+		t.Fatal(err)
+	}
+	var buy, stop, target float64
+	if err := db.QueryRow(`SELECT buy_price, stop_price, target_price FROM trade_signals WHERE email_id = 'm1'`).Scan(&buy, &stop, &target); err != nil {
+		t.Fatal(err)
+	}
+	if buy != 50 || stop != 45 || target != 60 {
+		t.Errorf("SQL parser: buy %g, stop %g, target %g, want 50, 45, 60", buy, stop, target)
+	}
+}
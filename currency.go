@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// currencyUSD is the currency backtests and price bars are in; prices with no currency marked are taken as it
+const currencyUSD = "USD"
+
+// currencySymbolPattern matches the currency symbol the price patterns allow before a number, in lowercased text
+const currencySymbolPattern = `(?:us\$|ca?\$|a\$|\$|£|€)`
+
+// currencySymbols map a symbol written right before a price to its currency, longest first so "c$" is not read as "$"
+var currencySymbols = []struct{ symbol, code string }{
+	{"us$", "USD"},
+	{"ca$", "CAD"},
+	{"c$", "CAD"},
+	{"a$", "AUD"},
+	{"£", "GBP"},
+	{"€", "EUR"},
+	{"$", "USD"},
+}
+
+var (
+	// currencyCodeBeforeRe and currencyCodeAfterRe find an ISO code written around a price, as in "GBP 12.50" or "12.50 EUR"
+	currencyCodeBeforeRe = regexp.MustCompile(`\b(usd|gbp|eur|cad|aud)\s*$`)
+	currencyCodeAfterRe  = regexp.MustCompile(`^\s*(usd|gbp|eur|cad|aud)\b`)
+	// currencyCodeRe limits FX_RATES entries to three-letter codes
+	currencyCodeRe = regexp.MustCompile(`^[A-Z]{3}$`)
+)
+
+// priceCurrency returns the currency marked around the price at text[start:end], or USD when none is.
+// A symbol must touch the number, while a code may be separated from it by spaces.
+func priceCurrency(text string, start, end int) string {
+	before := text[:start]
+	for _, s := range currencySymbols {
+		if strings.HasSuffix(before, s.symbol) {
+			return s.code
+		}
+	}
+	if m := currencyCodeBeforeRe.FindStringSubmatch(before[max(len(before)-8, 0):]); m != nil {
+		return strings.ToUpper(m[1])
+	}
+	if m := currencyCodeAfterRe.FindStringSubmatch(text[end:min(end+8, len(text))]); m != nil {
+		return strings.ToUpper(m[1])
+	}
+	return currencyUSD
+}
+
+// fxRates are the static USD rates FX_RATES sets, e.g. {"GBP": 1.27} for one pound buying 1.27 dollars
+var fxRates = map[string]float64{}
+
+// usdRate returns how many US dollars one unit of currency buys. It reads FX_RATES; another FX source
+// can be plugged in by replacing it at startup.
+var usdRate = func(currency string) (float64, bool) {
+	rate, ok := fxRates[currency]
+	return rate, ok
+}
+
+// parseFXRates turns a comma-separated list such as "GBP=1.27,EUR=1.08" into USD rates
+func parseFXRates(raw string) (map[string]float64, error) {
+	rates := make(map[string]float64)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		code, value, ok := strings.Cut(entry, "=")
+		code = strings.ToUpper(strings.TrimSpace(code))
+		rate, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if !ok || !currencyCodeRe.MatchString(code) || err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid rate %q in FX_RATES (use CODE=dollars per unit, e.g. GBP=1.27)", entry)
+		}
+		rates[code] = rate
+	}
+	return rates, nil
+}
+
+// convertSignalCurrency handles a signal quoted in another currency: with NON_USD_SIGNALS=convert its
+// prices are converted to dollars at usdRate, and otherwise, or when there is no rate, it is rejected.
+// Currency keeps the quoted currency either way.
+func convertSignalCurrency(signal *TradingSignal, trace *parseTrace) *SignalRejection {
+	if signal.Currency == currencyUSD {
+		return nil
+	}
+	if nonUSDSignals != "convert" {
+		return &SignalRejection{rejectCurrency, fmt.Sprintf("prices quoted in %s; NON_USD_SIGNALS=convert converts them with FX_RATES", signal.Currency)}
+	}
+	rate, ok := usdRate(signal.Currency)
+	if !ok {
+		return &SignalRejection{rejectCurrency, fmt.Sprintf("prices quoted in %s, which FX_RATES has no rate for", signal.Currency)}
+	}
+
+	for _, price := range []*float64{&signal.BuyPrice, &signal.StopPrice, &signal.TargetPrice} {
+		*price *= rate
+	}
+	for i := range signal.Targets {
+		signal.Targets[i] *= rate
+	}
+	trace.Printf("Converted %s prices to USD at %g: buy %s, stop %s, target %s", signal.Currency, rate,
+		formatPrice(signal.BuyPrice), formatPrice(signal.StopPrice), formatPrice(signal.TargetPrice))
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBuyPriceCurrency(t *testing.T) {
+	tests := []struct {
+		text         string
+		wantPrice    float64
+		wantCurrency string
+	}{
+		{"buy at £12.50", 12.5, "GBP"},
+		{"buy at €12.50", 12.5, "EUR"},
+		{"buy at c$12.50", 12.5, "CAD"},
+		{"buy at 12.50 gbp", 12.5, "GBP"},
+		{"buy at $12.50", 12.5, "USD"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			signal := &TradingSignal{}
+			extractBuyPrice(signal, tt.text, nil)
+			if signal.BuyPrice != tt.wantPrice || signal.Currency != tt.wantCurrency {
+				t.Errorf("buy %g in %q, want %g in %q", signal.BuyPrice, signal.Currency, tt.wantPrice, tt.wantCurrency)
+			}
+		})
+	}
+}
+
+func TestNonUSDSignals(t *testing.T) {
+	email := EmailSignal{
+		ID:   "gbp",
+		HTML: `<p>Acme Robotics (NASDAQ: ACME) is today's pick.</p><p>Buy at £12.50<br>Stop at £11.00<br>Target at £15.00</p>`,
+	}
+	tests := []struct {
+		name          string
+		mode          string
+		rates         map[string]float64
+		wantRejection string
+		wantBuy       float64
+	}{
+		{name: "skipped by default", mode: "skip", wantRejection: rejectCurrency},
+		{name: "converted at the FX_RATES rate", mode: "convert", rates: map[string]float64{"GBP": 1.28}, wantBuy: 16},
+		{name: "no rate for the currency", mode: "convert", rates: map[string]float64{"EUR": 1.08}, wantRejection: rejectCurrency},
+	}
+
+	savedMode, savedRates := nonUSDSignals, fxRates
+	t.Cleanup(func() { nonUSDSignals, fxRates = savedMode, savedRates })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nonUSDSignals, fxRates = tt.mode, tt.rates
+			signal, _, rejection, err := extractSignalFields(email)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantRejection != "" {
+				if rejection == nil || rejection.Reason != tt.wantRejection {
+					t.Errorf("rejection = %+v, want %s", rejection, tt.wantRejection)
+				}
+				return
+			}
+			if rejection != nil {
+				t.Fatalf("rejected the signal: %+v", rejection)
+			}
+			if signal.Currency != "GBP" || math.Abs(signal.BuyPrice-tt.wantBuy) > 1e-9 {
+				t.Errorf("buy %g tagged %q, want %g tagged GBP", signal.BuyPrice, signal.Currency, tt.wantBuy)
+			}
+		})
+	}
+}
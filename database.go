@@ -1,10 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"database/sql"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"html"
+	"io"
 	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -25,33 +35,215 @@ func NewDB(db *sql.DB) *DB {
 	return &DB{DB: db}
 }
 
-// setupDatabase initializes the database with required tables
+// sqliteDSN builds the connection string for path from the SQLITE_* settings.
+// The go-sqlite3 driver applies these pragmas to every connection it opens.
+func sqliteDSN(path string) string {
+	params := url.Values{}
+	params.Set("_journal_mode", sqliteJournalMode)
+	params.Set("_busy_timeout", strconv.Itoa(sqliteBusyTimeout))
+	params.Set("_foreign_keys", "1")
+	if sqliteSynchronous != "" {
+		params.Set("_synchronous", sqliteSynchronous)
+	}
+	return path + "?" + params.Encode()
+}
+
+// setupDatabase opens the database and creates any missing tables. Handlers call it on every request,
+// so one-time conversions of an existing database belong in migrateDatabase instead.
 func setupDatabase() (*DB, error) {
-	db, err := sql.Open("sqlite3", dbFile+"?_journal_mode=WAL&_timeout=30000")
+	db, err := sql.Open("sqlite3", sqliteDSN(dbFile))
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %v", err)
+		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	db.SetMaxOpenConns(sqliteMaxOpenConns)
 
 	// Test connection
 	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %v", err)
+		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
 	// Create tables
 	if err := createTables(db); err != nil {
-		return nil, fmt.Errorf("failed to create tables: %v", err)
+		return nil, fmt.Errorf("failed to create tables: %w", err)
 	}
 
 	return NewDB(db), nil
 }
 
+// migrateDatabase brings a database saved by an older version up to date. main runs it once at startup,
+// before any request opens the database.
+func migrateDatabase(db *DB) error {
+	// Add columns introduced after the original schema
+	if err := migrateTables(db.DB); err != nil {
+		return fmt.Errorf("failed to migrate tables: %w", err)
+	}
+
+	if err := migrateHTMLStorage(db.DB); err != nil {
+		return fmt.Errorf("failed to convert stored HTML: %w", err)
+	}
+
+	if err := setupEmailSearch(db.DB); err != nil {
+		return fmt.Errorf("failed to set up email search: %w", err)
+	}
+
+	// Emails saved from now on are tagged as upsertFullEmailToDB stores them
+	if err := tagProviders(db.DB); err != nil {
+		return fmt.Errorf("failed to tag email providers: %w", err)
+	}
+
+	return nil
+}
+
+// migrateTables adds columns that existing databases may be missing
+func migrateTables(db *sql.DB) error {
+	columns := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"emails", "from_address", "TEXT"}, // older tables named the sender columns from_addr and to_addr
+		{"emails", "to_address", "TEXT"},
+		{"emails", "raw_payload", "TEXT"},
+		{"emails", "text_body", "TEXT"},
+		{"emails", "clean_text", "TEXT"},
+		{"emails", "from_name", "TEXT"},
+		{"emails", "reply_to", "TEXT"},
+		{"emails", "labels", "TEXT"},
+		{"emails", "normalized_subject", "TEXT"},
+		{"emails", "parsed_at", "DATETIME"},
+		{"emails", "skipped_score", "INTEGER"},
+		{"emails", "html_gz", "BLOB"},
+		{"emails", "provider", "TEXT"},
+		{"emails", "ignored", "INTEGER NOT NULL DEFAULT 0"},
+		{"parse_runs", "no_content", "INTEGER"},
+		{"parse_runs", "rejections", "TEXT"},
+		{"thread_enrichment", "reported_messages", "INTEGER"},
+		{"thread_enrichment", "saved_messages", "INTEGER"},
+		{"parse_buy_stop_target", "ticker_source", "TEXT"},
+		{"parse_buy_stop_target", "buy_source", "TEXT"},
+		{"parse_buy_stop_target", "stop_source", "TEXT"},
+		{"parse_buy_stop_target", "target_source", "TEXT"},
+		{"parse_buy_stop_target", "risk_pct", "REAL"},
+		{"parse_buy_stop_target", "notes", "TEXT"},
+		{"parse_buy_stop_target", "email_date", "INTEGER"},
+		{"parse_buy_stop_target", "company", "TEXT"},
+		{"parse_buy_stop_target", "targets", "TEXT"},
+		{"parse_buy_stop_target", "currency", "TEXT"},
+		{"trade_signals", "ticker_source", "TEXT"},
+		{"trade_signals", "buy_source", "TEXT"},
+		{"trade_signals", "stop_source", "TEXT"},
+		{"trade_signals", "target_source", "TEXT"},
+		{"trade_signals", "risk_pct", "REAL"},
+		{"trade_signals", "notes", "TEXT"},
+		{"trade_signals", "email_date", "INTEGER"},
+		{"trade_signals", "company", "TEXT"},
+		{"trade_signals", "targets", "TEXT"},
+		{"trade_signals", "currency", "TEXT"},
+		{"signals_archive", "currency", "TEXT"},
+	}
+
+	for _, c := range columns {
+		if err := addColumnIfMissing(db, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	return migrateLandingToMessageKey(db)
+}
+
+// migrateLandingToMessageKey rebuilds an email_landing keyed on threadid so it is keyed on message id.
+// Existing rows only know their thread, so they keep the thread ID as message ID; Gmail uses the
+// first message's ID as the thread ID, so this matches for the message that was kept.
+func migrateLandingToMessageKey(db *sql.DB) error {
+	exists, err := hasColumn(db, "email_landing", "messageid")
+	if err != nil || exists {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Legacy rename skips rewriting views, some of which reference columns that no longer exist
+	statements := []string{
+		`PRAGMA legacy_alter_table = ON`,
+		`CREATE TABLE email_landing_new (
+			messageid TEXT PRIMARY KEY,
+			threadid TEXT NOT NULL,
+			content TEXT
+		)`,
+		`INSERT INTO email_landing_new (messageid, threadid, content)
+			SELECT threadid, threadid, content FROM email_landing`,
+		`DROP TABLE email_landing`,
+		`ALTER TABLE email_landing_new RENAME TO email_landing`,
+		`CREATE INDEX idx_email_landing_threadid ON email_landing(threadid)`,
+		`PRAGMA legacy_alter_table = OFF`,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to migrate email_landing: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit email_landing migration: %w", err)
+	}
+
+	log.Printf("Migrated email_landing to one row per message")
+	return nil
+}
+
+// hasColumn reports whether a table has the named column
+func hasColumn(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, fmt.Errorf("failed to read columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return false, fmt.Errorf("failed to scan column of %s: %w", table, err)
+		}
+		if strings.EqualFold(name, column) {
+			return true, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("failed to read columns of %s: %w", table, err)
+	}
+	return false, nil
+}
+
+// addColumnIfMissing adds a column to a table unless it already exists
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	exists, err := hasColumn(db, table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+
+	log.Printf("Added column %s.%s", table, column)
+	return nil
+}
+
 // createTables creates all required database tables
 func createTables(db *sql.DB) error {
 	tables := []string{
 		`CREATE TABLE IF NOT EXISTS email_landing (
-			threadid TEXT PRIMARY KEY,
+			messageid TEXT PRIMARY KEY,
+			threadid TEXT NOT NULL,
 			content TEXT
 		)`,
+		`CREATE INDEX IF NOT EXISTS idx_email_landing_threadid ON email_landing(threadid)`,
 		`CREATE TABLE IF NOT EXISTS emails (
 			id TEXT PRIMARY KEY,
 			thread_id TEXT,
@@ -60,7 +252,14 @@ func createTables(db *sql.DB) error {
 			snippet TEXT,
 			html TEXT,
 			from_address TEXT,
-			to_address TEXT
+			to_address TEXT,
+			raw_payload TEXT,
+			text_body TEXT,
+			clean_text TEXT,
+			from_name TEXT,
+			reply_to TEXT,
+			labels TEXT,
+			normalized_subject TEXT
 		)`,
 		`CREATE TABLE IF NOT EXISTS emails_v1_2 (
 			id TEXT PRIMARY KEY,
@@ -88,6 +287,10 @@ func createTables(db *sql.DB) error {
 			target_price REAL,
 			raw_html TEXT,
 			parsed_text TEXT,
+			ticker_source TEXT,
+			buy_source TEXT,
+			stop_source TEXT,
+			target_source TEXT,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 		)`,
 		`CREATE TABLE IF NOT EXISTS trade_signals (
@@ -99,13 +302,121 @@ func createTables(db *sql.DB) error {
 			buy_price REAL NOT NULL,
 			stop_price REAL,
 			target_price REAL,
+			ticker_source TEXT,
+			buy_source TEXT,
+			stop_source TEXT,
+			target_source TEXT,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 		)`,
+		`CREATE TABLE IF NOT EXISTS signals_archive (
+			id INTEGER PRIMARY KEY,
+			email_id TEXT,
+			ticker TEXT NOT NULL,
+			company TEXT,
+			email_date INTEGER,
+			signal_date INTEGER NOT NULL,
+			entry_date INTEGER NOT NULL,
+			buy_price REAL NOT NULL,
+			stop_price REAL,
+			target_price REAL,
+			targets TEXT,
+			risk_pct REAL,
+			notes TEXT,
+			currency TEXT,
+			ticker_source TEXT,
+			buy_source TEXT,
+			stop_source TEXT,
+			target_source TEXT,
+			created_at DATETIME,
+			archived_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_signals_archive_email_id ON signals_archive(email_id)`,
+		`CREATE TABLE IF NOT EXISTS price_bars (
+			ticker TEXT NOT NULL,
+			date TEXT NOT NULL,
+			open REAL,
+			high REAL,
+			low REAL,
+			close REAL,
+			volume INTEGER,
+			PRIMARY KEY (ticker, date)
+		)`,
+		`CREATE TABLE IF NOT EXISTS backtest_trades (
+			params_hash TEXT NOT NULL,
+			params TEXT NOT NULL,
+			email_id TEXT NOT NULL,
+			ticker TEXT NOT NULL,
+			entry_date TEXT NOT NULL,
+			entry_price REAL NOT NULL,
+			exit_date TEXT NOT NULL,
+			exit_price REAL NOT NULL,
+			exit_reason TEXT NOT NULL,
+			r_multiple REAL,
+			pnl REAL NOT NULL,
+			run_at DATETIME NOT NULL,
+			PRIMARY KEY (params_hash, email_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS oauth_tokens (
+			account_email TEXT PRIMARY KEY,
+			token_json TEXT NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS user_profiles (
+			email TEXT PRIMARY KEY,
+			user_id TEXT,
+			verified_email BOOLEAN,
+			name TEXT,
+			picture TEXT,
+			locale TEXT,
+			messages_total INTEGER,
+			threads_total INTEGER,
+			history_id INTEGER,
+			updated_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS thread_enrichment (
+			thread_id TEXT PRIMARY KEY,
+			enriched_at DATETIME NOT NULL,
+			reported_messages INTEGER,
+			saved_messages INTEGER
+		)`,
+		`CREATE TABLE IF NOT EXISTS download_worklist (
+			query TEXT NOT NULL,
+			message_id TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			done BOOLEAN NOT NULL DEFAULT 0,
+			PRIMARY KEY (query, message_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS parse_errors (
+			email_id TEXT PRIMARY KEY,
+			error TEXT NOT NULL,
+			run_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS parse_failures (
+			email_id TEXT NOT NULL,
+			field TEXT NOT NULL,
+			keyword TEXT,
+			examined_text TEXT NOT NULL,
+			run_at DATETIME NOT NULL,
+			PRIMARY KEY (email_id, field)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_parse_failures_field ON parse_failures(field)`,
+		`CREATE TABLE IF NOT EXISTS parse_runs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			parser TEXT NOT NULL,
+			started_at DATETIME NOT NULL,
+			duration_ms INTEGER,
+			emails INTEGER,
+			signals INTEGER,
+			errors INTEGER,
+			error TEXT,
+			no_content INTEGER,
+			rejections TEXT
+		)`,
 	}
 
 	for _, table := range tables {
 		if _, err := db.Exec(table); err != nil {
-			return fmt.Errorf("failed to create table: %v", err)
+			return fmt.Errorf("failed to create table: %w", err)
 		}
 	}
 
@@ -115,11 +426,11 @@ func createTables(db *sql.DB) error {
 // saveEmailToLanding saves email to the landing table
 func (db *DB) saveEmailToLanding(message *gmail.Message) error {
 	stmt, err := db.Prepare(`
-		INSERT OR REPLACE INTO email_landing (threadid, content) 
-		VALUES (?, ?)
+		INSERT OR REPLACE INTO email_landing (messageid, threadid, content)
+		VALUES (?, ?, ?)
 	`)
 	if err != nil {
-		return fmt.Errorf("failed to prepare landing statement: %v", err)
+		return fmt.Errorf("failed to prepare landing statement: %w", err)
 	}
 	defer stmt.Close()
 
@@ -128,21 +439,162 @@ func (db *DB) saveEmailToLanding(message *gmail.Message) error {
 		content = "No content"
 	}
 
-	_, err = stmt.Exec(message.ThreadId, content)
+	_, err = stmt.Exec(message.Id, message.ThreadId, content)
+	if err != nil {
+		return fmt.Errorf("failed to insert into landing: %w", err)
+	}
+
+	return nil
+}
+
+// saveDownloadWorklist replaces the worklist saved under key with messageIDs, in listing order
+func (db *DB) saveDownloadWorklist(key string, messageIDs []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM download_worklist WHERE query = ?`, key); err != nil {
+		return fmt.Errorf("failed to clear download worklist: %w", err)
+	}
+
+	stmt, err := tx.Prepare(`INSERT OR IGNORE INTO download_worklist (query, message_id, position) VALUES (?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare download worklist statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for i, messageID := range messageIDs {
+		if _, err := stmt.Exec(key, messageID, i); err != nil {
+			return fmt.Errorf("failed to save %s to download worklist: %w", messageID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit download worklist: %w", err)
+	}
+	return nil
+}
+
+// getDownloadWorklist returns the message IDs under key not yet downloaded, in listing order, and how
+// many IDs the worklist holds in all; 0 means no unfinished run saved one
+func (db *DB) getDownloadWorklist(key string) (pending []string, listed int, err error) {
+	rows, err := db.Query(`
+		SELECT message_id, done FROM download_worklist
+		WHERE query = ?
+		ORDER BY position
+	`, key)
 	if err != nil {
-		return fmt.Errorf("failed to insert into landing: %v", err)
+		return nil, 0, fmt.Errorf("failed to query download worklist: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID string
+		var done bool
+		if err := rows.Scan(&messageID, &done); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan download worklist: %w", err)
+		}
+		listed++
+		if !done {
+			pending = append(pending, messageID)
+		}
+	}
+	return pending, listed, rows.Err()
+}
+
+// markDownloaded checks a saved message off the worklist under key
+func (db *DB) markDownloaded(key, messageID string) error {
+	if _, err := db.Exec(`UPDATE download_worklist SET done = 1 WHERE query = ? AND message_id = ?`, key, messageID); err != nil {
+		return fmt.Errorf("failed to mark %s downloaded: %w", messageID, err)
+	}
+	return nil
+}
+
+// clearDownloadWorklist drops the worklist saved under key
+func (db *DB) clearDownloadWorklist(key string) error {
+	if _, err := db.Exec(`DELETE FROM download_worklist WHERE query = ?`, key); err != nil {
+		return fmt.Errorf("failed to clear download worklist: %w", err)
 	}
+	return nil
+}
 
+// recordThreadEnrichment records how many of a thread's messages Gmail reported and how many were saved to emails
+func (db *DB) recordThreadEnrichment(threadID string, reported, saved int) error {
+	_, err := db.Exec(`
+		INSERT INTO thread_enrichment (thread_id, enriched_at, reported_messages, saved_messages)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(thread_id) DO UPDATE SET
+			enriched_at = excluded.enriched_at,
+			reported_messages = excluded.reported_messages,
+			saved_messages = excluded.saved_messages
+	`, threadID, time.Now().UTC(), reported, saved)
+	if err != nil {
+		return fmt.Errorf("failed to record enrichment of thread %s: %w", threadID, err)
+	}
 	return nil
 }
 
-// getThreadIDsFromLanding retrieves all thread IDs from email_landing
+// getThreadsEnrichedSince returns the threads fully enriched after since. Rows recorded before
+// message counts were kept only exist for complete threads.
+func (db *DB) getThreadsEnrichedSince(since time.Time) (map[string]bool, error) {
+	rows, err := db.Query(`
+		SELECT thread_id FROM thread_enrichment
+		WHERE enriched_at > ?
+		AND (reported_messages IS NULL OR saved_messages >= reported_messages)
+	`, since.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query enriched threads: %w", err)
+	}
+	defer rows.Close()
+
+	threads := make(map[string]bool)
+	for rows.Next() {
+		var threadID string
+		if err := rows.Scan(&threadID); err != nil {
+			return nil, fmt.Errorf("failed to scan enriched thread: %w", err)
+		}
+		threads[threadID] = true
+	}
+	return threads, rows.Err()
+}
+
+// getIncompleteThreads lists threads whose last enrichment saved fewer messages than Gmail reported,
+// most recently enriched first
+func (db *DB) getIncompleteThreads() ([]ThreadEnrichment, error) {
+	rows, err := db.Query(`
+		SELECT thread_id, reported_messages, saved_messages, enriched_at
+		FROM thread_enrichment
+		WHERE saved_messages < reported_messages
+		ORDER BY enriched_at DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query incomplete threads: %w", err)
+	}
+	defer rows.Close()
+
+	threads := []ThreadEnrichment{}
+	for rows.Next() {
+		var thread ThreadEnrichment
+		if err := rows.Scan(&thread.ThreadID, &thread.ReportedMessages, &thread.SavedMessages, &thread.EnrichedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan incomplete thread: %w", err)
+		}
+		threads = append(threads, thread)
+	}
+	return threads, rows.Err()
+}
+
+// getThreadIDsFromLanding retrieves all thread IDs from email_landing, leaving out ignored emails
 func (db *DB) getThreadIDsFromLanding() ([]string, error) {
-	query := `SELECT threadid FROM email_landing ORDER BY threadid`
+	query := `
+		SELECT DISTINCT threadid FROM email_landing
+		WHERE messageid NOT IN (SELECT id FROM emails WHERE ignored = 1)
+		ORDER BY threadid`
 	
 	rows, err := db.Query(query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query thread IDs: %v", err)
+		return nil, fmt.Errorf("failed to query thread IDs: %w", err)
 	}
 	defer rows.Close()
 
@@ -165,7 +617,7 @@ func (db *DB) getThreadIDsFromV1_1() ([]string, error) {
 	
 	rows, err := db.Query(query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query thread IDs from emails_v1_1: %v", err)
+		return nil, fmt.Errorf("failed to query thread IDs from emails_v1_1: %w", err)
 	}
 	defer rows.Close()
 
@@ -185,7 +637,7 @@ func (db *DB) getThreadIDsFromV1_1() ([]string, error) {
 // upsertFullEmailToDB saves complete email data to the emails table
 func (db *DB) upsertFullEmailToDB(msg *gmail.Message) error {
 	// Extract headers
-	var subject, from, to string
+	var subject, from, to, replyTo string
 	for _, header := range msg.Payload.Headers {
 		switch strings.ToLower(header.Name) {
 		case "subject":
@@ -194,8 +646,12 @@ func (db *DB) upsertFullEmailToDB(msg *gmail.Message) error {
 			from = header.Value
 		case "to":
 			to = header.Value
+		case "reply-to":
+			replyTo = header.Value
 		}
 	}
+	fromName, fromAddress := splitAddress(from)
+	_, replyTo = splitAddress(replyTo)
 
 	// Parse date - InternalDate is already an int64 in milliseconds
 	dateInt := msg.InternalDate
@@ -208,20 +664,51 @@ func (db *DB) upsertFullEmailToDB(msg *gmail.Message) error {
 	// Extract HTML content
 	htmlContent := extractHTMLFromMessage(msg)
 
+	// Plain-text body for text-only alerts, falling back to the snippet
+	textBody := extractPlainTextFromMessage(msg)
+	if strings.TrimSpace(textBody) == "" {
+		textBody = msg.Snippet
+	}
+
+	// Keep the full message so it can be re-parsed without another download
+	rawPayload, err := encodeRawPayload(msg, compressRawPayload)
+	if err != nil {
+		return fmt.Errorf("failed to encode raw payload: %w", err)
+	}
+
+	htmlColumn, htmlGz, err := htmlColumns(htmlContent)
+	if err != nil {
+		return fmt.Errorf("failed to compress HTML: %w", err)
+	}
+
 	stmt, err := db.Prepare(`
-		INSERT INTO emails (id, thread_id, subject, date, snippet, html, from_address, to_address)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO emails (id, thread_id, subject, date, snippet, html, html_gz, from_address, to_address, raw_payload, text_body, clean_text, from_name, reply_to, labels,
+		                    normalized_subject, provider)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(id) DO UPDATE SET
 			thread_id = excluded.thread_id,
 			subject = excluded.subject,
 			date = excluded.date,
 			snippet = excluded.snippet,
 			html = excluded.html,
+			html_gz = excluded.html_gz,
 			from_address = excluded.from_address,
-			to_address = excluded.to_address
+			to_address = excluded.to_address,
+			raw_payload = excluded.raw_payload,
+			text_body = excluded.text_body,
+			clean_text = excluded.clean_text,
+			from_name = excluded.from_name,
+			reply_to = excluded.reply_to,
+			labels = excluded.labels,
+			normalized_subject = excluded.normalized_subject,
+			provider = excluded.provider,
+			-- Changed content has to be parsed again
+			parsed_at = CASE WHEN emails.html IS excluded.html AND emails.html_gz IS excluded.html_gz
+				AND emails.text_body IS excluded.text_body
+				THEN emails.parsed_at END
 	`)
 	if err != nil {
-		return fmt.Errorf("failed to prepare email statement: %v", err)
+		return fmt.Errorf("failed to prepare email statement: %w", err)
 	}
 	defer stmt.Close()
 
@@ -231,170 +718,787 @@ func (db *DB) upsertFullEmailToDB(msg *gmail.Message) error {
 		subject,
 		date,
 		msg.Snippet,
-		htmlContent,
-		from,
+		htmlColumn,
+		htmlGz,
+		fromAddress,
 		to,
+		rawPayload,
+		textBody,
+		cleanTextFor(htmlContent, textBody),
+		fromName,
+		replyTo,
+		strings.Join(msg.LabelIds, ","),
+		normalizeSubject(subject),
+		nullIfEmpty(providerFor(fromAddress)),
 	)
 	if err != nil {
-		return fmt.Errorf("failed to upsert email: %v", err)
+		return fmt.Errorf("failed to upsert email: %w", err)
 	}
 
 	return nil
 }
 
-// extractHTMLFromMessage extracts HTML content from Gmail message
-func extractHTMLFromMessage(msg *gmail.Message) string {
-	if msg.Payload == nil {
-		return ""
+// saveOAuthToken upserts an OAuth token keyed by account email
+func (db *DB) saveOAuthToken(info OAuthClientInfo) error {
+	tokenJSON, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("failed to marshal oauth token: %w", err)
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO oauth_tokens (account_email, token_json, updated_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(account_email) DO UPDATE SET
+			token_json = excluded.token_json,
+			updated_at = excluded.updated_at
+	`, info.UserEmail, string(tokenJSON))
+	if err != nil {
+		return fmt.Errorf("failed to save oauth token: %w", err)
 	}
 
-	return extractHTMLFromPart(msg.Payload)
+	return nil
 }
 
-// extractHTMLFromPart recursively extracts HTML from message parts
-func extractHTMLFromPart(part *gmail.MessagePart) string {
-	// Check if this part is HTML
-	if part.MimeType == "text/html" && part.Body != nil && part.Body.Data != "" {
-		decoded, err := decodeBase64URL(part.Body.Data)
-		if err == nil {
-			return string(decoded)
-		}
+// getOAuthToken loads the OAuth token for an account, or the most recently updated one if accountEmail is empty
+func (db *DB) getOAuthToken(accountEmail string) (*OAuthClientInfo, error) {
+	var tokenJSON string
+	var err error
+	if accountEmail != "" {
+		err = db.QueryRow(`SELECT token_json FROM oauth_tokens WHERE account_email = ?`, accountEmail).Scan(&tokenJSON)
+	} else {
+		err = db.QueryRow(`SELECT token_json FROM oauth_tokens ORDER BY updated_at DESC LIMIT 1`).Scan(&tokenJSON)
+	}
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no oauth token stored: %w", err)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query oauth token: %w", err)
 	}
 
-	// Check parts recursively
-	for _, subPart := range part.Parts {
-		htmlContent := extractHTMLFromPart(subPart)
-		if htmlContent != "" {
-			return htmlContent
-		}
+	var info OAuthClientInfo
+	if err := json.Unmarshal([]byte(tokenJSON), &info); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal oauth token: %w", err)
 	}
 
-	return ""
+	return &info, nil
 }
 
-// getSignalEmails retrieves emails that contain trading signal keywords
-func (db *DB) getSignalEmails() ([]EmailSignal, error) {
+// saveUserProfile upserts an authenticated account's profile. Userinfo fields already stored are
+// kept when this login could not fetch them.
+func (db *DB) saveUserProfile(profile UserProfile) error {
+	_, err := db.Exec(`
+		INSERT INTO user_profiles (email, user_id, verified_email, name, picture, locale,
+		                           messages_total, threads_total, history_id, updated_at)
+		VALUES (?, NULLIF(?, ''), ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?, ?)
+		ON CONFLICT(email) DO UPDATE SET
+			user_id = COALESCE(excluded.user_id, user_id),
+			verified_email = CASE WHEN excluded.user_id IS NULL THEN verified_email ELSE excluded.verified_email END,
+			name = COALESCE(excluded.name, name),
+			picture = COALESCE(excluded.picture, picture),
+			locale = COALESCE(excluded.locale, locale),
+			messages_total = excluded.messages_total,
+			threads_total = excluded.threads_total,
+			history_id = excluded.history_id,
+			updated_at = excluded.updated_at
+	`, profile.Email, profile.UserID, profile.VerifiedEmail, profile.Name, profile.Picture, profile.Locale,
+		profile.MessagesTotal, profile.ThreadsTotal, int64(profile.HistoryID), profile.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save user profile: %w", err)
+	}
+	return nil
+}
+
+// getUserProfile loads the profile for an account, or the most recently updated one if email is empty.
+// It returns sql.ErrNoRows when no profile is stored.
+func (db *DB) getUserProfile(email string) (*UserProfile, error) {
 	query := `
-		SELECT id, thread_id, subject, date, html 
-		FROM emails 
-		WHERE html IS NOT NULL 
-		AND LOWER(html) LIKE '%buy%'
-		AND LOWER(html) LIKE '%stop%'
-		AND LOWER(html) LIKE '%target%'
-		ORDER BY date DESC
-	`
+		SELECT email, COALESCE(user_id, ''), COALESCE(verified_email, 0), COALESCE(name, ''),
+			COALESCE(picture, ''), COALESCE(locale, ''), COALESCE(messages_total, 0),
+			COALESCE(threads_total, 0), COALESCE(history_id, 0), updated_at
+		FROM user_profiles`
+	var args []interface{}
+	if email != "" {
+		query += ` WHERE email = ?`
+		args = append(args, email)
+	}
+	query += ` ORDER BY updated_at DESC LIMIT 1`
 
-	rows, err := db.Query(query)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query signal emails: %v", err)
+	var profile UserProfile
+	var historyID int64
+	err := db.QueryRow(query, args...).Scan(&profile.Email, &profile.UserID, &profile.VerifiedEmail, &profile.Name,
+		&profile.Picture, &profile.Locale, &profile.MessagesTotal, &profile.ThreadsTotal, &historyID, &profile.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query user profile: %w", err)
 	}
-	defer rows.Close()
+	profile.HistoryID = uint64(historyID)
 
-	var emails []EmailSignal
-	for rows.Next() {
-		var email EmailSignal
-		var dateStr string
-		
-		if err := rows.Scan(&email.ID, &email.ThreadID, &email.Subject, &dateStr, &email.HTML); err != nil {
-			log.Printf("Failed to scan email: %v", err)
-			continue
-		}
+	return &profile, nil
+}
 
-		// Parse date
-		if parsedDate, err := time.Parse("2006-01-02 15:04:05", dateStr); err == nil {
-			email.Date = parsedDate
-		} else {
-			log.Printf("Failed to parse date %s: %v", dateStr, err)
-			email.Date = time.Now()
-		}
+// encodeRawPayload serializes a Gmail message to JSON, optionally gzip-compressed and base64-encoded
+func encodeRawPayload(msg *gmail.Message, compress bool) (string, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
 
-		emails = append(emails, email)
+	if !compress {
+		return string(data), nil
 	}
 
-	return emails, nil
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
 }
 
-// saveToParseBuyStopTarget saves parsed data to the staging table
-func saveToParseBuyStopTarget(email EmailSignal, signal *TradingSignal, htmlStripped string, db *DB) error {
-	log.Printf("SAVING: Email ID %s, cleaned text length: %d", email.ID, len(htmlStripped))
-	log.Printf("SAVING: Cleaned text preview: %s", htmlStripped[:min(100, len(htmlStripped))])
-	stmt, err := db.Prepare(`
-		INSERT INTO parse_buy_stop_target (email_id, ticker, signal_date, entry_date, buy_price, stop_price, target_price, raw_html, parsed_text)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-		ON CONFLICT(email_id) DO UPDATE SET
-			ticker = excluded.ticker,
-			signal_date = excluded.signal_date,
-			entry_date = excluded.entry_date,
-			buy_price = excluded.buy_price,
-			stop_price = excluded.stop_price,
-			target_price = excluded.target_price,
-			raw_html = excluded.raw_html,
-			parsed_text = excluded.parsed_text
-	`)
+// compressHTML gzips HTML for the html_gz column
+func compressHTML(htmlContent string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(htmlContent)); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressHTML reverses compressHTML
+func decompressHTML(data []byte) (string, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
 	if err != nil {
-		return fmt.Errorf("failed to prepare parse statement: %v", err)
+		return "", fmt.Errorf("failed to open compressed HTML: %w", err)
 	}
-	defer stmt.Close()
+	defer zr.Close()
 
-	_, err = stmt.Exec(
-		email.ID,
-		signal.Ticker,
-		signal.SignalDate,
-		signal.EntryDate,
-		signal.BuyPrice,
-		signal.StopPrice,
-		signal.TargetPrice,
-		htmlStripped,
-		"", // parsed_text field for future use
-	)
+	htmlContent, err := io.ReadAll(zr)
 	if err != nil {
-		return fmt.Errorf("failed to insert parsed signal: %v", err)
+		return "", fmt.Errorf("failed to decompress HTML: %w", err)
 	}
+	return string(htmlContent), nil
+}
 
-	return nil
+// htmlColumns returns the html and html_gz values to store for an email's HTML: the text itself,
+// or with HTML_COMPRESS on, a NULL html and the gzipped bytes. Empty HTML is never compressed.
+func htmlColumns(htmlContent string) (interface{}, interface{}, error) {
+	if !compressHTMLColumn || htmlContent == "" {
+		return htmlContent, nil, nil
+	}
+	compressed, err := compressHTML(htmlContent)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, compressed, nil
 }
 
-// getCleanSignals retrieves clean signals from parse_buy_stop_target
-func (db *DB) getCleanSignals() ([]CleanSignal, error) {
-	query := `
-		SELECT email_id, ticker, signal_date, entry_date, buy_price, stop_price, target_price
-		FROM parse_buy_stop_target 
-		WHERE ticker IS NOT NULL 
-		AND ticker != ''
-		AND buy_price IS NOT NULL 
-		AND buy_price > 0
-		AND stop_price IS NOT NULL 
-		AND stop_price > 0
-		AND target_price IS NOT NULL 
-		AND target_price > 0
-		ORDER BY signal_date DESC
-	`
+// storedHTML returns an emails row's HTML from whichever of html and html_gz holds it
+func storedHTML(htmlContent string, htmlGz []byte) (string, error) {
+	if len(htmlGz) == 0 {
+		return htmlContent, nil
+	}
+	return decompressHTML(htmlGz)
+}
 
-	rows, err := db.Query(query)
+// htmlMigrationBatch is how many emails migrateHTMLStorage converts per transaction
+const htmlMigrationBatch = 500
+
+// tagProviders sets emails.provider on rows saved before the column existed, and re-tags rows tagged
+// only by sender domain whose sender PROVIDERS now names
+func tagProviders(db *sql.DB) error {
+	names, _ := json.Marshal(providerNames())
+	rows, err := db.Query(`
+		SELECT id, from_address, COALESCE(provider, '') FROM emails
+		WHERE from_address IS NOT NULL AND from_address != ''
+		AND (provider IS NULL OR provider NOT IN (SELECT value FROM json_each(?)))
+	`, string(names))
 	if err != nil {
-		return nil, fmt.Errorf("failed to query clean signals: %v", err)
+		return fmt.Errorf("failed to query untagged emails: %w", err)
 	}
-	defer rows.Close()
 
-	var signals []CleanSignal
+	retag := make(map[string]string)
 	for rows.Next() {
-		var signal CleanSignal
-		
-		if err := rows.Scan(
+		var id, fromAddress, current string
+		if err := rows.Scan(&id, &fromAddress, &current); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan email sender: %w", err)
+		}
+		if provider := providerFor(fromAddress); provider != current {
+			retag[id] = provider
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read email senders: %w", err)
+	}
+	if len(retag) == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	for id, provider := range retag {
+		if _, err := tx.Exec(`UPDATE emails SET provider = ? WHERE id = ?`, provider, id); err != nil {
+			return fmt.Errorf("failed to tag email %s: %w", id, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit provider tags: %w", err)
+	}
+	log.Printf("Tagged the provider of %d emails", len(retag))
+	return nil
+}
+
+// migrateHTMLStorage converts stored HTML to match HTML_COMPRESS: compressing plain html into
+// html_gz when it is on, and restoring html_gz to plain html when it is off, so sqlite users
+// querying html directly get their text back. Space freed by compression is reclaimed by VACUUM.
+func migrateHTMLStorage(db *sql.DB) error {
+	pending := `SELECT id, COALESCE(html, ''), html_gz FROM emails WHERE html_gz IS NOT NULL LIMIT ?`
+	if compressHTMLColumn {
+		pending = `SELECT id, html, html_gz FROM emails WHERE html_gz IS NULL AND html IS NOT NULL AND html != '' LIMIT ?`
+	}
+
+	converted := 0
+	for {
+		rows, err := db.Query(pending, htmlMigrationBatch)
+		if err != nil {
+			return fmt.Errorf("failed to query emails to convert: %w", err)
+		}
+
+		type storedRow struct {
+			id     string
+			html   string
+			htmlGz []byte
+		}
+		var batch []storedRow
+		for rows.Next() {
+			var row storedRow
+			if err := rows.Scan(&row.id, &row.html, &row.htmlGz); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan email HTML: %w", err)
+			}
+			batch = append(batch, row)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to read emails to convert: %w", err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		for _, row := range batch {
+			htmlContent, err := storedHTML(row.html, row.htmlGz)
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("email %s: %w", row.id, err)
+			}
+			htmlColumn, htmlGz, err := htmlColumns(htmlContent)
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("email %s: failed to compress HTML: %w", row.id, err)
+			}
+			if _, err := tx.Exec(`UPDATE emails SET html = ?, html_gz = ? WHERE id = ?`, htmlColumn, htmlGz, row.id); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("failed to convert HTML of %s: %w", row.id, err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit HTML conversion: %w", err)
+		}
+		converted += len(batch)
+	}
+
+	if converted > 0 && compressHTMLColumn {
+		log.Printf("Compressed the HTML of %d emails; run /maintenance/vacuum to reclaim the space", converted)
+	} else if converted > 0 {
+		log.Printf("Restored plain HTML for %d emails", converted)
+	}
+	return nil
+}
+
+// decodeRawPayload re-hydrates a Gmail message stored by encodeRawPayload
+func decodeRawPayload(payload string) (*gmail.Message, error) {
+	data := []byte(payload)
+
+	// Uncompressed payloads are stored as plain JSON objects
+	if !strings.HasPrefix(strings.TrimSpace(payload), "{") {
+		compressed, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode raw payload: %w", err)
+		}
+
+		zr, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open gzip payload: %w", err)
+		}
+		defer zr.Close()
+
+		data, err = io.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress raw payload: %w", err)
+		}
+	}
+
+	var msg gmail.Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal raw payload: %w", err)
+	}
+
+	return &msg, nil
+}
+
+// getRawMessage fetches and re-hydrates the stored Gmail message for an email
+func (db *DB) getRawMessage(id string) (*gmail.Message, error) {
+	var payload sql.NullString
+	err := db.QueryRow(`SELECT raw_payload FROM emails WHERE id = ?`, id).Scan(&payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query raw payload for %s: %w", id, err)
+	}
+
+	if !payload.Valid || payload.String == "" {
+		return nil, fmt.Errorf("no raw payload stored for %s", id)
+	}
+
+	return decodeRawPayload(payload.String)
+}
+
+// getSignalEmailsFromRaw re-extracts signal emails from stored raw payloads instead of the html column,
+// leaving out ignored emails.
+// With unparsedOnly, emails the parse stage has already handled are skipped.
+func (db *DB) getSignalEmailsFromRaw(filter LabelFilter, unparsedOnly bool) ([]EmailSignal, error) {
+	query := `
+		SELECT id, raw_payload
+		FROM emails
+		WHERE raw_payload IS NOT NULL AND raw_payload != ''
+		AND ignored = 0`
+	if unparsedOnly {
+		query += `
+		AND ` + unparsedEmailsSQL()
+	}
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query raw payloads: %w", err)
+	}
+	defer rows.Close()
+
+	var emails []EmailSignal
+	for rows.Next() {
+		var id, payload string
+		if err := rows.Scan(&id, &payload); err != nil {
+			log.Printf("Failed to scan raw payload: %v", err)
+			continue
+		}
+
+		msg, err := decodeRawPayload(payload)
+		if err != nil {
+			log.Printf("Failed to re-hydrate email %s: %v", id, err)
+			continue
+		}
+
+		if !filter.allows(msg.LabelIds) {
+			continue
+		}
+
+		htmlContent := extractHTMLFromMessage(msg)
+		if htmlContent == "" {
+			htmlContent = msg.Snippet
+		}
+		if !hasRequiredSignalKeywords(htmlContent) {
+			continue
+		}
+
+		var subject string
+		if msg.Payload != nil {
+			for _, header := range msg.Payload.Headers {
+				if strings.EqualFold(header.Name, "subject") {
+					subject = header.Value
+				}
+			}
+		}
+
+		emails = append(emails, EmailSignal{
+			ID:       msg.Id,
+			ThreadID: msg.ThreadId,
+			Subject:  subject,
+			Date:     time.Unix(msg.InternalDate/1000, 0),
+			HTML:     htmlContent,
+			Labels:   msg.LabelIds,
+		})
+	}
+
+	sort.Slice(emails, func(i, j int) bool {
+		return emails[i].Date.After(emails[j].Date)
+	})
+
+	return emails, nil
+}
+
+// extractHTMLFromMessage extracts HTML content from Gmail message.
+// If the message has no HTML part, its plain text is converted to minimal HTML.
+func extractHTMLFromMessage(msg *gmail.Message) string {
+	if msg.Payload == nil {
+		return ""
+	}
+
+	if htmlContent := extractHTMLFromPart(msg.Payload); htmlContent != "" {
+		return htmlContent
+	}
+
+	return plainTextToHTML(extractPlainTextFromPart(msg.Payload))
+}
+
+// extractHTMLFromPart returns the largest text/html body among all message parts.
+// Tracking wrappers in multipart/alternative messages often carry a tiny decoy HTML part,
+// so the first match is not necessarily the real content.
+func extractHTMLFromPart(part *gmail.MessagePart) string {
+	var largest string
+
+	// Check if this part is HTML
+	if part.MimeType == "text/html" && part.Body != nil && part.Body.Data != "" {
+		decoded, err := decodeBase64URL(part.Body.Data)
+		if err == nil {
+			largest = string(decoded)
+		}
+	}
+
+	// Check parts recursively
+	for _, subPart := range part.Parts {
+		htmlContent := extractHTMLFromPart(subPart)
+		if len(strings.TrimSpace(htmlContent)) > len(strings.TrimSpace(largest)) {
+			largest = htmlContent
+		}
+	}
+
+	return largest
+}
+
+// plainTextToHTML wraps plain text in minimal HTML, preserving line breaks
+func plainTextToHTML(text string) string {
+	if strings.TrimSpace(text) == "" {
+		return ""
+	}
+
+	escaped := html.EscapeString(text)
+	escaped = strings.ReplaceAll(escaped, "\r\n", "\n")
+	escaped = strings.ReplaceAll(escaped, "\n", "<br>\n")
+
+	return "<html><body>" + escaped + "</body></html>"
+}
+
+// signalContentSQL is the text signal keywords are matched against: an emails row's HTML, or its
+// plain-text body when it has no HTML. Compressed HTML cannot be read in SQL, so those rows match
+// on their clean text instead.
+const signalContentSQL = `CASE WHEN html_gz IS NOT NULL THEN clean_text ELSE COALESCE(NULLIF(html, ''), text_body) END`
+
+// signalKeywordPredicate selects content mentioning every required signal keyword.
+// The keywords are validated against signalKeywords, so they are safe to inline.
+func signalKeywordPredicate() string {
+	predicate := `content IS NOT NULL`
+	for _, keyword := range requiredSignalKeywords {
+		predicate += `
+		AND LOWER(content) LIKE '%` + keyword + `%'`
+	}
+	return predicate
+}
+
+// hasRequiredSignalKeywords is signalKeywordPredicate for content already in memory
+func hasRequiredSignalKeywords(content string) bool {
+	lower := strings.ToLower(content)
+	for _, keyword := range requiredSignalKeywords {
+		if !strings.Contains(lower, keyword) {
+			return false
+		}
+	}
+	return true
+}
+
+// unparsedEmailsSQL selects emails no parse has handled yet, and emails the classifier skipped whose
+// score now reaches SIGNAL_SCORE_THRESHOLD. The threshold is an integer, so it is safe to inline.
+func unparsedEmailsSQL() string {
+	return fmt.Sprintf(`(parsed_at IS NULL OR skipped_score >= %d)`, signalScoreThreshold)
+}
+
+// getSignalEmails retrieves emails that contain trading signal keywords and pass the label filter,
+// leaving out ignored emails. Emails without HTML fall back to their plain-text body. With unparsedOnly, emails the parse
+// stage has already handled are skipped.
+func (db *DB) getSignalEmails(filter LabelFilter, unparsedOnly bool) ([]EmailSignal, error) {
+	query := `
+		SELECT id, thread_id, subject, date, content, html_gz, labels
+		FROM (
+			SELECT id, thread_id, subject, date,
+				` + signalContentSQL + ` AS content,
+				html_gz,
+				COALESCE(labels, '') AS labels,
+				parsed_at, skipped_score
+			FROM emails
+			WHERE ignored = 0
+		)
+		WHERE ` + signalKeywordPredicate()
+	if unparsedOnly {
+		query += `
+		AND ` + unparsedEmailsSQL()
+	}
+	query += `
+		ORDER BY date DESC`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query signal emails: %w", err)
+	}
+	defer rows.Close()
+
+	var emails []EmailSignal
+	for rows.Next() {
+		var email EmailSignal
+		var dateStr, labels string
+		var htmlGz []byte
+		
+		if err := rows.Scan(&email.ID, &email.ThreadID, &email.Subject, &dateStr, &email.HTML, &htmlGz, &labels); err != nil {
+			log.Printf("Failed to scan email: %v", err)
+			continue
+		}
+		if len(htmlGz) > 0 {
+			htmlContent, err := decompressHTML(htmlGz)
+			if err != nil {
+				log.Printf("Failed to read HTML of email %s: %v", email.ID, err)
+				continue
+			}
+			email.HTML = htmlContent
+		}
+
+		if labels != "" {
+			email.Labels = strings.Split(labels, ",")
+		}
+		if !filter.allows(email.Labels) {
+			continue
+		}
+
+		// Parse date
+		if parsedDate, err := time.Parse("2006-01-02 15:04:05", dateStr); err == nil {
+			email.Date = parsedDate
+		} else {
+			log.Printf("Failed to parse date %s: %v", dateStr, err)
+			email.Date = time.Now()
+		}
+
+		emails = append(emails, email)
+	}
+
+	return emails, nil
+}
+
+// saveParseResult records an email's parse failures and staging row in one transaction, so a parse
+// whose context is cancelled before the commit leaves nothing behind
+func (db *DB) saveParseResult(ctx context.Context, email EmailSignal, signal *TradingSignal, htmlStripped string, failures []ParseFailure, runAt time.Time) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := saveParseFailures(ctx, tx, email.ID, failures, runAt); err != nil {
+		return err
+	}
+	if err := saveToParseBuyStopTarget(ctx, tx, email, signal, htmlStripped); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit parse result for %s: %w", email.ID, err)
+	}
+	return nil
+}
+
+// saveToParseBuyStopTarget saves parsed data to the staging table
+func saveToParseBuyStopTarget(ctx context.Context, tx *sql.Tx, email EmailSignal, signal *TradingSignal, htmlStripped string) error {
+	log.Printf("SAVING: Email ID %s, cleaned text length: %d", email.ID, len(htmlStripped))
+	log.Printf("SAVING: Cleaned text preview: %s", htmlStripped[:min(100, len(htmlStripped))])
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO parse_buy_stop_target (email_id, ticker, company, email_date, signal_date, entry_date, buy_price, stop_price, target_price, targets, raw_html, parsed_text,
+		                                   ticker_source, buy_source, stop_source, target_source, risk_pct, notes, currency)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(email_id) DO UPDATE SET
+			ticker = excluded.ticker,
+			company = excluded.company,
+			email_date = excluded.email_date,
+			signal_date = excluded.signal_date,
+			entry_date = excluded.entry_date,
+			buy_price = excluded.buy_price,
+			stop_price = excluded.stop_price,
+			target_price = excluded.target_price,
+			targets = excluded.targets,
+			raw_html = excluded.raw_html,
+			parsed_text = excluded.parsed_text,
+			ticker_source = excluded.ticker_source,
+			buy_source = excluded.buy_source,
+			stop_source = excluded.stop_source,
+			target_source = excluded.target_source,
+			risk_pct = excluded.risk_pct,
+			notes = excluded.notes,
+			currency = excluded.currency
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare parse statement: %w", err)
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx,
+		email.ID,
+		signal.Ticker,
+		nullIfEmpty(signal.Company),
+		signal.EmailDate,
+		signal.SignalDate,
+		signal.EntryDate,
+		signal.BuyPrice,
+		signal.StopPrice,
+		signal.TargetPrice,
+		encodeTargets(signal.Targets),
+		htmlStripped,
+		"", // parsed_text field for future use
+		signal.TickerSource,
+		signal.BuySource,
+		signal.StopSource,
+		signal.TargetSource,
+		nullIfZero(signal.RiskPct),
+		signal.Notes,
+		nullIfEmpty(signal.Currency),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert parsed signal: %w", err)
+	}
+
+	return nil
+}
+
+// nullIfZero stores an unset optional number as NULL
+func nullIfZero(value float64) interface{} {
+	if value == 0 {
+		return nil
+	}
+	return value
+}
+
+// encodeTargets stores scaled targets as a JSON array, or NULL for a signal with a single target
+func encodeTargets(targets []float64) interface{} {
+	if len(targets) == 0 {
+		return nil
+	}
+	encoded, _ := json.Marshal(targets)
+	return string(encoded)
+}
+
+// decodeTargets reads a targets column written by encodeTargets; empty or malformed values give nil
+func decodeTargets(raw string) []float64 {
+	if raw == "" {
+		return nil
+	}
+	var targets []float64
+	if err := json.Unmarshal([]byte(raw), &targets); err != nil {
+		log.Printf("Warning: ignoring malformed targets %q: %v", raw, err)
+		return nil
+	}
+	return targets
+}
+
+// nullIfEmpty stores an unset optional string as NULL
+func nullIfEmpty(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// getCleanSignals retrieves clean signals from parse_buy_stop_target: those with a ticker, a buy price,
+// and the stop and target prices the policy requires. Signals archived by /signals/prune are not brought back,
+// nor are tickers TICKER_ALLOWLIST or TICKER_DENYLIST exclude, including ones parsed before the lists were set.
+func (db *DB) getCleanSignals(policy CleanSignalPolicy) ([]CleanSignal, error) {
+	query := `
+		SELECT email_id, ticker, COALESCE(company, ''), COALESCE(email_date, signal_date), signal_date, entry_date, buy_price,
+			COALESCE(stop_price, 0), COALESCE(target_price, 0), COALESCE(targets, ''), COALESCE(ticker_source, ''), COALESCE(buy_source, ''), COALESCE(stop_source, ''), COALESCE(target_source, ''),
+			COALESCE(risk_pct, 0), COALESCE(notes, ''), COALESCE(currency, 'USD')
+		FROM parse_buy_stop_target 
+		WHERE ticker IS NOT NULL 
+		AND ticker != ''
+		AND buy_price IS NOT NULL 
+		AND buy_price > 0
+		AND email_id NOT IN (SELECT email_id FROM signals_archive WHERE email_id IS NOT NULL)`
+	// Prices the policy does not require may be missing, so partial signals reach trade_signals
+	if policy.RequireStop {
+		query += `
+		AND stop_price IS NOT NULL 
+		AND stop_price > 0`
+	}
+	if policy.RequireTarget {
+		query += `
+		AND target_price IS NOT NULL 
+		AND target_price > 0`
+	}
+	query += `
+		ORDER BY signal_date DESC
+	`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query clean signals: %w", err)
+	}
+	defer rows.Close()
+
+	var signals []CleanSignal
+	excluded := 0
+	for rows.Next() {
+		var signal CleanSignal
+		var targets string
+		
+		if err := rows.Scan(
 			&signal.EmailID,
 			&signal.Ticker,
+			&signal.Company,
+			&signal.EmailDate,
 			&signal.SignalDate,
 			&signal.EntryDate,
 			&signal.BuyPrice,
 			&signal.StopPrice,
 			&signal.TargetPrice,
+			&targets,
+			&signal.TickerSource,
+			&signal.BuySource,
+			&signal.StopSource,
+			&signal.TargetSource,
+			&signal.RiskPct,
+			&signal.Notes,
+			&signal.Currency,
 		); err != nil {
 			log.Printf("Failed to scan clean signal: %v", err)
 			continue
 		}
+		if tickerListRejection(signal.Ticker) != nil {
+			excluded++
+			continue
+		}
+		signal.Targets = decodeTargets(targets)
 
 		signals = append(signals, signal)
 	}
+	if excluded > 0 {
+		log.Printf("Skipped %d clean signals whose ticker TICKER_ALLOWLIST or TICKER_DENYLIST excludes", excluded)
+	}
 
 	return signals, nil
 }
@@ -412,34 +1516,45 @@ func upsertToTradeSignals(signal CleanSignal, db *DB, workerID int) error {
 			workerID, signal.EmailID, signal.SignalDate, existingID)
 		return nil
 	} else if err != sql.ErrNoRows {
-		return fmt.Errorf("failed to check existing signal: %v", err)
+		return fmt.Errorf("failed to check existing signal: %w", err)
 	}
 
 	// Insert new signal
 	stmt, err := db.Prepare(`
-		INSERT INTO trade_signals (email_id, ticker, signal_date, entry_date, buy_price, stop_price, target_price)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO trade_signals (email_id, ticker, company, email_date, signal_date, entry_date, buy_price, stop_price, target_price, targets,
+		                           ticker_source, buy_source, stop_source, target_source, risk_pct, notes, currency)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`)
 	if err != nil {
-		return fmt.Errorf("failed to prepare trade signal statement: %v", err)
+		return fmt.Errorf("failed to prepare trade signal statement: %w", err)
 	}
 	defer stmt.Close()
 
 	_, err = stmt.Exec(
 		signal.EmailID,
 		signal.Ticker,
+		nullIfEmpty(signal.Company),
+		signal.EmailDate,
 		signal.SignalDate,
 		signal.EntryDate,
 		signal.BuyPrice,
-		signal.StopPrice,
-		signal.TargetPrice,
+		nullIfZero(signal.StopPrice),
+		nullIfZero(signal.TargetPrice),
+		encodeTargets(signal.Targets),
+		signal.TickerSource,
+		signal.BuySource,
+		signal.StopSource,
+		signal.TargetSource,
+		nullIfZero(signal.RiskPct),
+		signal.Notes,
+		signal.Currency,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to upsert clean signal: %v", err)
+		return fmt.Errorf("failed to upsert clean signal: %w", err)
 	}
 
-	log.Printf("Worker %d: Processed clean signal %s - Ticker: %s, Buy: %.2f, Stop: %.2f, Target: %.2f",
-		workerID, signal.EmailID, signal.Ticker, signal.BuyPrice, signal.StopPrice, signal.TargetPrice)
+	log.Printf("Worker %d: Processed clean signal %s - Ticker: %s, Buy: %s, Stop: %s, Target: %s",
+		workerID, signal.EmailID, signal.Ticker, formatPrice(signal.BuyPrice), formatPrice(signal.StopPrice), formatPrice(signal.TargetPrice))
 
 	return nil
 }
@@ -533,7 +1648,7 @@ func (db *DB) upsertFullEmailToV1_2(msg *gmail.Message) error {
 			html = excluded.html
 	`)
 	if err != nil {
-		return fmt.Errorf("failed to prepare emails_v1_2 statement: %v", err)
+		return fmt.Errorf("failed to prepare emails_v1_2 statement: %w", err)
 	}
 	defer stmt.Close()
 
@@ -552,8 +1667,755 @@ func (db *DB) upsertFullEmailToV1_2(msg *gmail.Message) error {
 		htmlContent,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to upsert email to v1_2: %v", err)
+		return fmt.Errorf("failed to upsert email to v1_2: %w", err)
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// cleanTextFor returns the tag-free text the SQL parser searches, preferring HTML over the text body
+func cleanTextFor(htmlContent, textBody string) string {
+	if strings.TrimSpace(htmlContent) != "" {
+		return htmlToPlainText(htmlContent)
+	}
+	return htmlToPlainText(textBody)
+}
+
+// backfillCleanText fills clean_text for emails stored before the column existed
+func (db *DB) backfillCleanText() error {
+	rows, err := db.Query(`
+		SELECT id, COALESCE(html, ''), html_gz, COALESCE(text_body, '')
+		FROM emails
+		WHERE clean_text IS NULL
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to query emails without clean text: %w", err)
+	}
+
+	cleaned := make(map[string]string)
+	for rows.Next() {
+		var id, htmlContent, textBody string
+		var htmlGz []byte
+		if err := rows.Scan(&id, &htmlContent, &htmlGz, &textBody); err != nil {
+			log.Printf("Failed to scan email for clean text: %v", err)
+			continue
+		}
+		if htmlContent, err = storedHTML(htmlContent, htmlGz); err != nil {
+			log.Printf("Failed to read HTML of email %s: %v", id, err)
+			continue
+		}
+		cleaned[id] = cleanTextFor(htmlContent, textBody)
+	}
+	rows.Close()
+
+	if len(cleaned) == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`UPDATE emails SET clean_text = ? WHERE id = ?`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare clean text statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for id, text := range cleaned {
+		if _, err := stmt.Exec(text, id); err != nil {
+			return fmt.Errorf("failed to save clean text for %s: %w", id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit clean text: %w", err)
+	}
+
+	log.Printf("Backfilled clean_text for %d emails", len(cleaned))
+	return nil
+}
+
+// backfillNormalizedSubjects fills normalized_subject for emails stored before the column existed
+func (db *DB) backfillNormalizedSubjects() error {
+	rows, err := db.Query(`SELECT id, COALESCE(subject, '') FROM emails WHERE normalized_subject IS NULL`)
+	if err != nil {
+		return fmt.Errorf("failed to query emails without normalized subject: %w", err)
+	}
+
+	normalized := make(map[string]string)
+	for rows.Next() {
+		var id, subject string
+		if err := rows.Scan(&id, &subject); err != nil {
+			log.Printf("Failed to scan email for normalized subject: %v", err)
+			continue
+		}
+		normalized[id] = normalizeSubject(subject)
+	}
+	rows.Close()
+
+	if len(normalized) == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`UPDATE emails SET normalized_subject = ? WHERE id = ?`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare normalized subject statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for id, subject := range normalized {
+		if _, err := stmt.Exec(subject, id); err != nil {
+			return fmt.Errorf("failed to save normalized subject for %s: %w", id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit normalized subjects: %w", err)
+	}
+
+	log.Printf("Backfilled normalized_subject for %d emails", len(normalized))
+	return nil
+}
+
+// collapseResentSignals deletes trade signals whose email repeats the normalized subject of an earlier
+// signal sent no more than window before it, so "RE: Buy XYZ" a minute after "Buy XYZ" is not traded twice.
+// The earliest signal of each group is kept. It returns the number of signals removed.
+func (db *DB) collapseResentSignals(window time.Duration) (int, error) {
+	if err := db.backfillNormalizedSubjects(); err != nil {
+		return 0, err
+	}
+
+	result, err := db.Exec(`
+		DELETE FROM trade_signals
+		WHERE email_id IN (
+			SELECT later.email_id
+			FROM trade_signals later
+			JOIN emails later_email ON later_email.id = later.email_id
+			JOIN emails earlier_email ON earlier_email.normalized_subject = later_email.normalized_subject
+			JOIN trade_signals earlier ON earlier.email_id = earlier_email.id
+			WHERE later_email.normalized_subject != ''
+			AND earlier.email_id != later.email_id
+			AND (earlier.signal_date < later.signal_date
+				OR (earlier.signal_date = later.signal_date AND earlier.email_id < later.email_id))
+			AND later.signal_date - earlier.signal_date <= ?
+		)
+	`, window.Milliseconds())
+	if err != nil {
+		return 0, fmt.Errorf("failed to collapse resent signals: %w", err)
+	}
+
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count collapsed signals: %w", err)
+	}
+	return int(removed), nil
+}
+
+// countRows returns the number of rows in a table
+func (db *DB) countRows(table string) (int, error) {
+	var count int
+	if err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", table)).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count rows in %s: %w", table, err)
+	}
+	return count, nil
+}
+
+// clearDerivedTables deletes all parsed and processed signals so they can be rebuilt from emails
+func (db *DB) clearDerivedTables() error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"parse_buy_stop_target", "parse_failures", "trade_signals"} {
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s", table)); err != nil {
+			return fmt.Errorf("failed to clear %s: %w", table, err)
+		}
+	}
+
+	// Every email has to be parsed again to refill them
+	if _, err := tx.Exec(`UPDATE emails SET parsed_at = NULL, skipped_score = NULL`); err != nil {
+		return fmt.Errorf("failed to reset parse watermark: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// updateParseWatermark stamps parsed emails with the run time so incremental parses skip them,
+// and clears the stamp of failed emails so the next run retries them. Parsed emails the classifier
+// skipped also record their score in skipped_score; unparsedEmailsSQL requeues them by it.
+func (db *DB) updateParseWatermark(parsed, failed []string, skippedScores map[string]int, runAt time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`UPDATE emails SET parsed_at = ?, skipped_score = ? WHERE id = ?`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare parse watermark statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, id := range parsed {
+		var score interface{}
+		if s, ok := skippedScores[id]; ok {
+			score = s
+		}
+		if _, err := stmt.Exec(runAt.UTC(), score, id); err != nil {
+			return fmt.Errorf("failed to mark %s parsed: %w", id, err)
+		}
+	}
+	for _, id := range failed {
+		if _, err := stmt.Exec(nil, nil, id); err != nil {
+			return fmt.Errorf("failed to mark %s unparsed: %w", id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit parse watermark: %w", err)
+	}
+	return nil
+}
+
+// getTradeSignals retrieves complete signals from trade_signals for backtesting, ordered by signal date.
+// Signals dated before from or on/after until are skipped; zero times leave that end open.
+// A non-empty provider keeps only signals from that provider's emails.
+func (db *DB) getTradeSignals(from, until time.Time, provider string) ([]CleanSignal, error) {
+	query := `
+		SELECT email_id, ticker, signal_date, entry_date, buy_price, stop_price, target_price, COALESCE(targets, ''), COALESCE(risk_pct, 0)
+		FROM trade_signals
+		WHERE ticker IS NOT NULL
+		AND buy_price > 0
+		AND stop_price > 0
+		AND target_price > 0`
+	var args []interface{}
+	if !from.IsZero() {
+		query += `
+		AND signal_date >= ?`
+		args = append(args, from.UnixMilli())
+	}
+	if !until.IsZero() {
+		query += `
+		AND signal_date < ?`
+		args = append(args, until.UnixMilli())
+	}
+	if provider != "" {
+		query += `
+		AND email_id IN (SELECT id FROM emails WHERE provider = ?)`
+		args = append(args, provider)
+	}
+	query += `
+		ORDER BY signal_date, entry_date`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trade signals: %w", err)
+	}
+	defer rows.Close()
+
+	var signals []CleanSignal
+	for rows.Next() {
+		var signal CleanSignal
+		var targets string
+		if err := rows.Scan(
+			&signal.EmailID,
+			&signal.Ticker,
+			&signal.SignalDate,
+			&signal.EntryDate,
+			&signal.BuyPrice,
+			&signal.StopPrice,
+			&signal.TargetPrice,
+			&targets,
+			&signal.RiskPct,
+		); err != nil {
+			log.Printf("Failed to scan trade signal: %v", err)
+			continue
+		}
+		signal.Targets = decodeTargets(targets)
+		signals = append(signals, signal)
+	}
+
+	return signals, nil
+}
+
+// savePriceBars stores a ticker's daily bars, replacing any already stored for the same dates
+func (db *DB) savePriceBars(ticker string, bars []PriceBar) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO price_bars (ticker, date, open, high, low, close, volume)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(ticker, date) DO UPDATE SET
+			open = excluded.open,
+			high = excluded.high,
+			low = excluded.low,
+			close = excluded.close,
+			volume = excluded.volume
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare price bar statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, bar := range bars {
+		if _, err := stmt.Exec(ticker, formatDate(bar.Date), bar.Open, bar.High, bar.Low, bar.Close, bar.Volume); err != nil {
+			return fmt.Errorf("failed to save price bar %s %s: %w", ticker, formatDate(bar.Date), err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit price bars for %s: %w", ticker, err)
+	}
+	return nil
+}
+
+// getPriceBars retrieves the daily bars for a ticker in date order.
+// price_bars is filled by POST /price-bars from an OHLC download (e.g. yfinance), one row per ticker and YYYY-MM-DD date.
+func (db *DB) getPriceBars(ticker string) ([]PriceBar, error) {
+	rows, err := db.Query(`
+		SELECT date, open, high, low, close
+		FROM price_bars
+		WHERE ticker = ?
+		ORDER BY date
+	`, ticker)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query price bars: %w", err)
+	}
+	defer rows.Close()
+
+	var bars []PriceBar
+	for rows.Next() {
+		var bar PriceBar
+		var dateStr string
+		if err := rows.Scan(&dateStr, &bar.Open, &bar.High, &bar.Low, &bar.Close); err != nil {
+			log.Printf("Failed to scan price bar for %s: %v", ticker, err)
+			continue
+		}
+
+		date, err := time.Parse("2006-01-02", dateStr)
+		if err != nil {
+			log.Printf("Failed to parse price bar date %s for %s: %v", dateStr, ticker, err)
+			continue
+		}
+		bar.Date = date
+		bars = append(bars, bar)
+	}
+
+	return bars, nil
+}
+
+// saveBacktestTrades replaces the ledger stored under params' hash with trades
+func (db *DB) saveBacktestTrades(params BacktestParams, trades []Trade) error {
+	hash, described, runAt := params.Hash(), params.describe(), time.Now().UTC()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM backtest_trades WHERE params_hash = ?`, hash); err != nil {
+		return fmt.Errorf("failed to clear backtest trades for %s: %w", hash, err)
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO backtest_trades (params_hash, params, email_id, ticker, entry_date, entry_price,
+			exit_date, exit_price, exit_reason, r_multiple, pnl, run_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare backtest trade statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, trade := range trades {
+		if _, err := stmt.Exec(hash, described, trade.EmailID, trade.Ticker, trade.EntryDate, trade.EntryPrice,
+			trade.ExitDate, trade.ExitPrice, trade.ExitReason, trade.RMultiple, trade.PnL, runAt); err != nil {
+			return fmt.Errorf("failed to save backtest trade for %s: %w", trade.EmailID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit backtest trades: %w", err)
+	}
+	return nil
+}
+
+// getBacktestTrades returns the ledger stored under a params hash, ordered by entry date
+func (db *DB) getBacktestTrades(hash string) ([]Trade, error) {
+	rows, err := db.Query(`
+		SELECT email_id, ticker, entry_date, entry_price, exit_date, exit_price, exit_reason, COALESCE(r_multiple, 0), pnl
+		FROM backtest_trades
+		WHERE params_hash = ?
+		ORDER BY entry_date, ticker, email_id
+	`, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query backtest trades: %w", err)
+	}
+	defer rows.Close()
+
+	trades := []Trade{}
+	for rows.Next() {
+		var trade Trade
+		if err := rows.Scan(&trade.EmailID, &trade.Ticker, &trade.EntryDate, &trade.EntryPrice,
+			&trade.ExitDate, &trade.ExitPrice, &trade.ExitReason, &trade.RMultiple, &trade.PnL); err != nil {
+			return nil, fmt.Errorf("failed to scan backtest trade: %w", err)
+		}
+		trades = append(trades, trade)
+	}
+	return trades, rows.Err()
+}
+
+// countSQLParsableEmails counts emails with enough clean text for the SQL parser to examine
+func (db *DB) countSQLParsableEmails() (int, error) {
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM emails WHERE LENGTH(TRIM(COALESCE(clean_text, ''))) > 20`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count parsable emails: %w", err)
+	}
+	return count, nil
+}
+
+// parseRunSignalQueries count the complete signals each parser has produced
+var parseRunSignalQueries = map[string]string{
+	"go": `SELECT COUNT(*) FROM parse_buy_stop_target
+		WHERE ticker IS NOT NULL AND ticker != '' AND buy_price > 0`,
+	"sql": `SELECT COUNT(*) FROM trade_signals
+		WHERE buy_price IS NOT NULL AND stop_price IS NOT NULL AND target_price IS NOT NULL`,
+}
+
+// recordParseRun appends a run to parse_runs, counting the signals the parser's table now holds
+func (db *DB) recordParseRun(run ParseRun) error {
+	if err := db.QueryRow(parseRunSignalQueries[run.Parser]).Scan(&run.Signals); err != nil {
+		return fmt.Errorf("failed to count %s parser signals: %w", run.Parser, err)
+	}
+
+	var rejections interface{}
+	if len(run.Rejections) > 0 {
+		encoded, err := json.Marshal(run.Rejections)
+		if err != nil {
+			return fmt.Errorf("failed to encode parse rejections: %w", err)
+		}
+		rejections = string(encoded)
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO parse_runs (parser, started_at, duration_ms, emails, signals, errors, error, no_content, rejections)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, run.Parser, run.StartedAt, run.DurationMs, run.Emails, run.Signals, run.Errors, run.Error, run.NoContent, rejections)
+	if err != nil {
+		return fmt.Errorf("failed to record parse run: %w", err)
+	}
+
+	return nil
+}
+
+// getParseRuns returns the most recent parse runs, newest first
+func (db *DB) getParseRuns(limit int) ([]ParseRun, error) {
+	rows, err := db.Query(`
+		SELECT id, parser, started_at, duration_ms, emails, signals, errors, COALESCE(error, ''), COALESCE(no_content, 0),
+		       COALESCE(rejections, '')
+		FROM parse_runs
+		ORDER BY started_at DESC, id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query parse runs: %w", err)
+	}
+	defer rows.Close()
+
+	runs := []ParseRun{}
+	for rows.Next() {
+		var run ParseRun
+		var rejections string
+		if err := rows.Scan(&run.ID, &run.Parser, &run.StartedAt, &run.DurationMs, &run.Emails, &run.Signals, &run.Errors, &run.Error, &run.NoContent,
+			&rejections); err != nil {
+			return nil, fmt.Errorf("failed to scan parse run: %w", err)
+		}
+		if rejections != "" {
+			if err := json.Unmarshal([]byte(rejections), &run.Rejections); err != nil {
+				return nil, fmt.Errorf("failed to decode rejections of parse run %d: %w", run.ID, err)
+			}
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
+
+// recordParseError saves the latest parse failure of an email, replacing any earlier one
+func (db *DB) recordParseError(emailID string, parseErr error, runAt time.Time) error {
+	_, err := db.Exec(`
+		INSERT INTO parse_errors (email_id, error, run_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(email_id) DO UPDATE SET error = excluded.error, run_at = excluded.run_at
+	`, emailID, parseErr.Error(), runAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record parse error for %s: %w", emailID, err)
+	}
+	return nil
+}
+
+// saveParseFailures replaces the extraction failures recorded for an email; no failures clears them
+func saveParseFailures(ctx context.Context, tx *sql.Tx, emailID string, failures []ParseFailure, runAt time.Time) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM parse_failures WHERE email_id = ?`, emailID); err != nil {
+		return fmt.Errorf("failed to clear parse failures for %s: %w", emailID, err)
+	}
+	for _, failure := range failures {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO parse_failures (email_id, field, keyword, examined_text, run_at)
+			VALUES (?, ?, ?, ?, ?)
+		`, emailID, failure.Field, nullIfEmpty(failure.Keyword), failure.ExaminedText, runAt.UTC()); err != nil {
+			return fmt.Errorf("failed to record %s parse failure for %s: %w", failure.Field, emailID, err)
+		}
+	}
+	return nil
+}
+
+// clearParseError forgets an email's parse failure once it parses successfully
+func (db *DB) clearParseError(emailID string) error {
+	if _, err := db.Exec(`DELETE FROM parse_errors WHERE email_id = ?`, emailID); err != nil {
+		return fmt.Errorf("failed to clear parse error for %s: %w", emailID, err)
+	}
+	return nil
+}
+
+// getParseErrorIDs returns the emails whose most recent parse failed
+func (db *DB) getParseErrorIDs() (map[string]bool, error) {
+	rows, err := db.Query(`SELECT email_id FROM parse_errors`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query parse errors: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan parse error: %w", err)
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// getStoredEmail loads one emails row; it returns sql.ErrNoRows when the id is unknown
+func (db *DB) getStoredEmail(id string) (*StoredEmail, error) {
+	var email StoredEmail
+	var labels string
+	var htmlGz []byte
+	err := db.QueryRow(`
+		SELECT id, COALESCE(thread_id, ''), COALESCE(subject, ''), date,
+		       COALESCE(from_name, ''), COALESCE(from_address, ''), COALESCE(to_address, ''), COALESCE(reply_to, ''),
+		       COALESCE(labels, ''), COALESCE(snippet, ''), COALESCE(html, ''), html_gz, COALESCE(text_body, ''), COALESCE(clean_text, ''),
+		       COALESCE(normalized_subject, ''), ignored
+		FROM emails
+		WHERE id = ?
+	`, id).Scan(&email.ID, &email.ThreadID, &email.Subject, &email.Date,
+		&email.FromName, &email.FromAddress, &email.ToAddress, &email.ReplyTo,
+		&labels, &email.Snippet, &email.HTML, &htmlGz, &email.TextBody, &email.CleanText,
+		&email.NormalizedSubject, &email.Ignored)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load email %s: %w", id, err)
+	}
+	if email.HTML, err = storedHTML(email.HTML, htmlGz); err != nil {
+		return nil, fmt.Errorf("failed to load email %s: %w", id, err)
+	}
+
+	email.Labels = []string{}
+	if labels != "" {
+		email.Labels = strings.Split(labels, ",")
+	}
+	return &email, nil
+}
+
+// setEmailIgnored sets an email's ignored flag, returning sql.ErrNoRows for an unknown ID. Ignoring deletes
+// the email's parsed and trade signals so it drops out of backtests at once; un-ignoring clears parsed_at
+// so the next parse picks it up again.
+func (db *DB) setEmailIgnored(id string, ignored bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	update := `UPDATE emails SET ignored = 1 WHERE id = ?`
+	if !ignored {
+		update = `UPDATE emails SET ignored = 0, parsed_at = NULL WHERE id = ?`
+	}
+	result, err := tx.Exec(update, id)
+	if err != nil {
+		return fmt.Errorf("failed to update email %s: %w", id, err)
+	}
+	if updated, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to update email %s: %w", id, err)
+	} else if updated == 0 {
+		return sql.ErrNoRows
+	}
+
+	if ignored {
+		for _, table := range []string{"parse_buy_stop_target", "parse_failures", "trade_signals"} {
+			if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE email_id = ?`, table), id); err != nil {
+				return fmt.Errorf("failed to delete %s rows of email %s: %w", table, id, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit ignored flag of email %s: %w", id, err)
+	}
+	return nil
+}
+
+// getIgnoredEmailIDs returns the IDs of a thread's emails marked ignored
+func (db *DB) getIgnoredEmailIDs(threadID string) (map[string]bool, error) {
+	rows, err := db.Query(`SELECT id FROM emails WHERE thread_id = ? AND ignored = 1`, threadID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ignored emails of thread %s: %w", threadID, err)
+	}
+	defer rows.Close()
+
+	ignored := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan ignored email: %w", err)
+		}
+		ignored[id] = true
+	}
+	return ignored, rows.Err()
+}
+
+// getParsedSignal loads the parse_buy_stop_target row for an email, or nil if it has none
+func (db *DB) getParsedSignal(emailID string) (*ParsedSignal, error) {
+	var parsed ParsedSignal
+	var targets string
+	err := db.QueryRow(`
+		SELECT COALESCE(ticker, ''), COALESCE(company, ''), COALESCE(email_date, signal_date, 0), COALESCE(signal_date, 0), COALESCE(entry_date, 0),
+		       COALESCE(buy_price, 0), COALESCE(stop_price, 0), COALESCE(target_price, 0), COALESCE(targets, ''),
+		       COALESCE(ticker_source, ''), COALESCE(buy_source, ''), COALESCE(stop_source, ''), COALESCE(target_source, ''),
+		       COALESCE(risk_pct, 0), COALESCE(notes, ''), COALESCE(currency, 'USD'), COALESCE(raw_html, ''), created_at
+		FROM parse_buy_stop_target
+		WHERE email_id = ?
+	`, emailID).Scan(&parsed.Ticker, &parsed.Company, &parsed.EmailDate, &parsed.SignalDate, &parsed.EntryDate,
+		&parsed.BuyPrice, &parsed.StopPrice, &parsed.TargetPrice, &targets,
+		&parsed.TickerSource, &parsed.BuySource, &parsed.StopSource, &parsed.TargetSource,
+		&parsed.RiskPct, &parsed.Notes, &parsed.Currency, &parsed.RawHTML, &parsed.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load parsed signal for %s: %w", emailID, err)
+	}
+	parsed.Targets = decodeTargets(targets)
+	roundPrices(&parsed.BuyPrice, &parsed.StopPrice, &parsed.TargetPrice)
+	for i := range parsed.Targets {
+		roundPrices(&parsed.Targets[i])
+	}
+	return &parsed, nil
+}
+
+// streamEmails calls fn for each emails row in date order, reading from the cursor so the whole
+// table is never held in memory. With signalsOnly, only rows matching the getSignalEmails
+// keyword predicate are visited.
+func (db *DB) streamEmails(signalsOnly bool, fn func(EmailExport) error) error {
+	query := `
+		SELECT id, thread_id, subject, date, from_address, html, html_gz
+		FROM (
+			SELECT id, COALESCE(thread_id, '') AS thread_id, COALESCE(subject, '') AS subject, date,
+				COALESCE(from_address, '') AS from_address, COALESCE(html, '') AS html, html_gz,
+				` + signalContentSQL + ` AS content
+			FROM emails
+		)`
+	if signalsOnly {
+		query += `
+		WHERE ` + signalKeywordPredicate()
+	}
+	query += `
+		ORDER BY date, id`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return fmt.Errorf("failed to query emails: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var email EmailExport
+		var htmlGz []byte
+		if err := rows.Scan(&email.ID, &email.ThreadID, &email.Subject, &email.Date, &email.FromAddress, &email.HTML, &htmlGz); err != nil {
+			return fmt.Errorf("failed to scan email: %w", err)
+		}
+		if email.HTML, err = storedHTML(email.HTML, htmlGz); err != nil {
+			return fmt.Errorf("email %s: %w", email.ID, err)
+		}
+		if err := fn(email); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// DBFileSizes reports the on-disk size of the database and its write-ahead log
+type DBFileSizes struct {
+	DBBytes  int64 `json:"db_bytes"`
+	WALBytes int64 `json:"wal_bytes"`
+}
+
+// dbFileSizes stats dbFile and its -wal file; a missing file counts as zero bytes
+func dbFileSizes() DBFileSizes {
+	var sizes DBFileSizes
+	if info, err := os.Stat(dbFile); err == nil {
+		sizes.DBBytes = info.Size()
+	}
+	if info, err := os.Stat(dbFile + "-wal"); err == nil {
+		sizes.WALBytes = info.Size()
+	}
+	return sizes
+}
+
+// checkpointAndVacuum folds the WAL back into the database, truncates it, and rebuilds the file
+// to reclaim free pages. It reports an error if the checkpoint was blocked by another connection.
+func (db *DB) checkpointAndVacuum() error {
+	var busy, logFrames, checkpointed int
+	if err := db.QueryRow("PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &logFrames, &checkpointed); err != nil {
+		return fmt.Errorf("failed to checkpoint WAL: %w", err)
+	}
+	if busy != 0 {
+		return fmt.Errorf("WAL checkpoint blocked by another connection (%d/%d frames checkpointed)", checkpointed, logFrames)
+	}
+	log.Printf("Checkpointed %d WAL frames", checkpointed)
+
+	if _, err := db.Exec("VACUUM"); err != nil {
+		return fmt.Errorf("failed to vacuum database: %w", err)
+	}
+
+	if err := db.reindexEmailSearch(); err != nil {
+		return err
+	}
+
+	// VACUUM in WAL mode writes through the log, so truncate it again
+	if _, err := db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return fmt.Errorf("failed to checkpoint WAL after vacuum: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,652 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// chdirTemp makes a temporary directory the working directory for the rest of the test, so the
+// relative dbFile is created there
+func chdirTemp(t testing.TB) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// newTestDB opens a fresh database in a temporary working directory
+func newTestDB(t testing.TB) *DB {
+	t.Helper()
+	chdirTemp(t)
+	return openTestDB(t)
+}
+
+// openTestDB opens the database in the working directory and migrates it, as main does at startup
+func openTestDB(t testing.TB) *DB {
+	t.Helper()
+	db, err := setupDatabase()
+	if err != nil {
+		t.Fatalf("setupDatabase: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := migrateDatabase(db); err != nil {
+		t.Fatalf("migrateDatabase: %v", err)
+	}
+	return db
+}
+
+// gmailMessage builds a message from the sender with the given subject, date and payload parts
+func gmailMessage(id, threadID, subject string, date time.Time, parts ...*gmail.MessagePart) *gmail.Message {
+	return &gmail.Message{
+		Id:           id,
+		ThreadId:     threadID,
+		InternalDate: date.UnixMilli(),
+		Payload: &gmail.MessagePart{
+			MimeType: "multipart/alternative",
+			Headers: []*gmail.MessagePartHeader{
+				{Name: "Subject", Value: subject},
+				{Name: "From", Value: "Dr Stoxx <" + targetSender + ">"},
+			},
+			Parts: parts,
+		},
+	}
+}
+
+// gmailPart builds a leaf message part carrying body, encoded the way the Gmail API returns it
+func gmailPart(mimeType, body string) *gmail.MessagePart {
+	return &gmail.MessagePart{
+		MimeType: mimeType,
+		Body:     &gmail.MessagePartBody{Data: base64.URLEncoding.EncodeToString([]byte(body))},
+	}
+}
+
+// saveTestEmail stores an email from the sender with the given subject and HTML body, sent June 3, 2024
+func saveTestEmail(t testing.TB, db *DB, id, subject, html string) {
+	t.Helper()
+	msg := gmailMessage(id, id, subject, time.Date(2024, 6, 3, 13, 0, 0, 0, time.UTC), gmailPart("text/html", html))
+	if err := db.upsertFullEmailToDB(msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractHTMLFromMessage(t *testing.T) {
+	realHTML := "<html><body><p>Buy ACME at $12.50, stop $11.00, target $15.00</p></body></html>"
+	decoyHTML := "<img src=\"https://track.example.com/p.gif\">"
+
+	tests := []struct {
+		name    string
+		payload *gmail.MessagePart
+		want    string
+	}{
+		{
+			name: "decoy html before the real part",
+			payload: &gmail.MessagePart{MimeType: "multipart/alternative", Parts: []*gmail.MessagePart{
+				gmailPart("text/html", decoyHTML),
+				gmailPart("text/plain", "Buy ACME at $12.50"),
+				gmailPart("text/html", realHTML),
+			}},
+			want: realHTML,
+		},
+		{
+			name: "real part nested below a tracking wrapper",
+			payload: &gmail.MessagePart{MimeType: "multipart/mixed", Parts: []*gmail.MessagePart{
+				gmailPart("text/html", decoyHTML),
+				{MimeType: "multipart/alternative", Parts: []*gmail.MessagePart{
+					gmailPart("text/plain", "Buy ACME at $12.50"),
+					gmailPart("text/html", realHTML),
+				}},
+			}},
+			want: realHTML,
+		},
+		{
+			name: "plain text only",
+			payload: &gmail.MessagePart{MimeType: "multipart/alternative", Parts: []*gmail.MessagePart{
+				gmailPart("text/plain", "Buy ACME at $12.50\nStop $11.00"),
+			}},
+			want: "<html><body>Buy ACME at $12.50<br>\nStop $11.00</body></html>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractHTMLFromMessage(&gmail.Message{Payload: tt.payload})
+			if got != tt.want {
+				t.Errorf("extractHTMLFromMessage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractHTMLFromPartPrefersLargest(t *testing.T) {
+	payload := &gmail.MessagePart{MimeType: "multipart/alternative", Parts: []*gmail.MessagePart{
+		gmailPart("text/html", "<p> </p>"),
+		gmailPart("text/html", "<p>Buy ACME at $12.50</p>"),
+	}}
+	if got := extractHTMLFromPart(payload); !strings.Contains(got, "ACME") {
+		t.Errorf("extractHTMLFromPart() = %q, want the part mentioning ACME", got)
+	}
+}
+
+func TestSignalEmailsFallBackToTextBody(t *testing.T) {
+	db := newTestDB(t)
+	date := time.Date(2024, 6, 3, 13, 0, 0, 0, time.UTC)
+
+	// Neither part has an inline body Gmail returns, so the snippet is all there is to parse
+	snippetOnly := gmailMessage("m1", "t1", "Trade alert", date, &gmail.MessagePart{
+		MimeType: "text/plain",
+		Body:     &gmail.MessagePartBody{AttachmentId: "a1", Size: 2048},
+	})
+	snippetOnly.Snippet = "Buy ACME at $12.50, stop $11.00, target $15.00"
+	if got := extractHTMLFromMessage(snippetOnly); got != "" {
+		t.Fatalf("extractHTMLFromMessage() = %q, want no HTML", got)
+	}
+	if err := db.upsertFullEmailToDB(snippetOnly); err != nil {
+		t.Fatal(err)
+	}
+
+	emails, err := db.getSignalEmails(LabelFilter{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(emails) != 1 || emails[0].HTML != snippetOnly.Snippet {
+		t.Fatalf("getSignalEmails() = %+v, want the snippet-only email with its snippet as content", emails)
+	}
+}
+
+func TestSignalEmailsLabelFilter(t *testing.T) {
+	db := newTestDB(t)
+	date := time.Date(2024, 6, 3, 13, 0, 0, 0, time.UTC)
+	labelled := map[string][]string{
+		"alert": {"INBOX", "Label_5"},
+		"promo": {"INBOX", "CATEGORY_PROMOTIONS"},
+	}
+	for id, labels := range labelled {
+		msg := gmailMessage(id, id, "Trade alert", date,
+			gmailPart("text/html", "<p>Buy ACME at $12.50, stop $11.00, target $15.00</p>"))
+		msg.LabelIds = labels
+		if err := db.upsertFullEmailToDB(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"no filter", "", []string{"alert", "promo"}},
+		{"exclude promotions", "excludeLabel=CATEGORY_PROMOTIONS", []string{"alert"}},
+		{"exclude is case-insensitive", "excludeLabel=category_promotions", []string{"alert"}},
+		{"require a user label", "requireLabel=Label_5", []string{"alert"}},
+		{"require and exclude", "requireLabel=INBOX&excludeLabel=Label_5,%20CATEGORY_PROMOTIONS", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			emails, err := db.getSignalEmails(parseLabelFilter(values), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, email := range emails {
+				got = append(got, email.ID)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getSignalEmails() returned %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrateLandingKeepsEveryMessageOfAThread(t *testing.T) {
+	chdirTemp(t)
+
+	// A landing table from before rows were keyed on message id, holding one row per thread
+	legacy, err := sql.Open("sqlite3", sqliteDSN(dbFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := legacy.Exec(`CREATE TABLE email_landing (threadid TEXT PRIMARY KEY, content TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := legacy.Exec(`INSERT INTO email_landing (threadid, content) VALUES ('t0', 'Older alert')`); err != nil {
+		t.Fatal(err)
+	}
+	legacy.Close()
+
+	db := openTestDB(t)
+
+	// A two-message thread: Gmail uses the first message's ID as the thread ID
+	for _, msg := range []*gmail.Message{
+		{Id: "t1", ThreadId: "t1", Snippet: "Buy ACME at $12.50"},
+		{Id: "m2", ThreadId: "t1", Snippet: "Update: raise the stop to $12"},
+	} {
+		if err := db.saveEmailToLanding(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows, err := db.Query(`SELECT messageid, threadid, content FROM email_landing ORDER BY messageid`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got [][3]string
+	for rows.Next() {
+		var row [3]string
+		if err := rows.Scan(&row[0], &row[1], &row[2]); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, row)
+	}
+	want := [][3]string{
+		{"m2", "t1", "Update: raise the stop to $12"},
+		{"t0", "t0", "Older alert"},
+		{"t1", "t1", "Buy ACME at $12.50"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("email_landing = %v, want %v", got, want)
+	}
+
+	threadIDs, err := db.getThreadIDsFromLanding()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(threadIDs, []string{"t0", "t1"}) {
+		t.Errorf("getThreadIDsFromLanding() = %v, want each thread once", threadIDs)
+	}
+}
+
+func TestSetupDatabaseMigratesLegacySenderColumns(t *testing.T) {
+	chdirTemp(t)
+
+	// An emails table from before the sender columns were named from_address and to_address
+	legacy, err := sql.Open("sqlite3", sqliteDSN(dbFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := legacy.Exec(`CREATE TABLE emails (
+		id TEXT PRIMARY KEY,
+		thread_id TEXT NOT NULL,
+		subject TEXT,
+		from_addr TEXT,
+		to_addr TEXT,
+		date DATETIME NOT NULL,
+		snippet TEXT,
+		labels TEXT,
+		plain_text TEXT,
+		html TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		t.Fatal(err)
+	}
+	legacy.Close()
+
+	db := openTestDB(t)
+
+	msg := gmailMessage("m1", "t1", "Trade alert", time.Date(2024, 6, 3, 13, 0, 0, 0, time.UTC),
+		gmailPart("text/html", "<p>Buy ACME at $12.50</p>"))
+	if err := db.upsertFullEmailToDB(msg); err != nil {
+		t.Fatal(err)
+	}
+	var fromAddress string
+	if err := db.QueryRow(`SELECT from_address FROM emails WHERE id = 'm1'`).Scan(&fromAddress); err != nil {
+		t.Fatal(err)
+	}
+	if fromAddress != targetSender {
+		t.Errorf("from_address = %q, want %q", fromAddress, targetSender)
+	}
+}
+
+func TestEmailsTaggedWithProvider(t *testing.T) {
+	db := newTestDB(t)
+	saved := providers
+	t.Cleanup(func() { providers = saved })
+
+	tests := []struct {
+		id, from string
+		want     string
+	}{
+		{"m1", "Dr Stoxx <" + targetSender + ">", "drstoxx"},
+		{"m2", "Acme Picks <picks@alerts.acme.com>", "acme"},
+		{"m3", "news@other.example.com", "other.example.com"},
+	}
+
+	// Emails saved before PROVIDERS named acme are tagged with its domain
+	for _, tt := range tests {
+		msg := gmailMessage(tt.id, tt.id, "Trade alert", time.Date(2024, 6, 3, 13, 0, 0, 0, time.UTC),
+			gmailPart("text/html", "<p>Buy ACME at $12.50</p>"))
+		msg.Payload.Headers[1].Value = tt.from
+		if err := db.upsertFullEmailToDB(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var m2 string
+	if err := db.QueryRow(`SELECT provider FROM emails WHERE id = 'm2'`).Scan(&m2); err != nil {
+		t.Fatal(err)
+	}
+	if m2 != "alerts.acme.com" {
+		t.Errorf("provider before PROVIDERS named acme = %q, want alerts.acme.com", m2)
+	}
+
+	// The next startup re-tags them once the sender is configured
+	list, err := parseProviders("drstoxx=" + targetSender + ",acme=acme.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	providers = list
+	if err := migrateDatabase(db); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from, func(t *testing.T) {
+			var provider string
+			if err := db.QueryRow(`SELECT provider FROM emails WHERE id = ?`, tt.id).Scan(&provider); err != nil {
+				t.Fatal(err)
+			}
+			if provider != tt.want {
+				t.Errorf("provider = %q, want %q", provider, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTMLCompressionRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+	}{
+		{"alert", "<p>Acme Robotics (NASDAQ: ACME)</p><p>Buy at $12.50<br>Stop at $11.00</p>"},
+		{"non-ascii", "<p>Achetez à 12,50 € — objectif £15</p>"},
+		{"large newsletter", strings.Repeat("<td class=\"stoxx-column\">Buy at $12.50</td>\n", 2000)},
+	}
+
+	saved := compressHTMLColumn
+	t.Cleanup(func() { compressHTMLColumn = saved })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compressed, err := compressHTML(tt.html)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := decompressHTML(compressed); err != nil || got != tt.html {
+				t.Fatalf("decompressHTML(compressHTML()) = %q, %v", got, err)
+			}
+
+			// Saved with HTML_COMPRESS on, read back, then restored to plain html at the next startup
+			compressHTMLColumn = true
+			db := newTestDB(t)
+			saveTestEmail(t, db, "m1", "Trade alert", tt.html)
+			var plain sql.NullString
+			var htmlGz []byte
+			if err := db.QueryRow(`SELECT html, html_gz FROM emails WHERE id = 'm1'`).Scan(&plain, &htmlGz); err != nil {
+				t.Fatal(err)
+			}
+			if plain.Valid || len(htmlGz) == 0 {
+				t.Errorf("stored html %v with %d compressed bytes, want only html_gz", plain, len(htmlGz))
+			}
+			if got, err := storedHTML(plain.String, htmlGz); err != nil || got != tt.html {
+				t.Errorf("storedHTML() = %q, %v, want the saved HTML", got, err)
+			}
+
+			compressHTMLColumn = false
+			if err := migrateDatabase(db); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.QueryRow(`SELECT html, html_gz FROM emails WHERE id = 'm1'`).Scan(&plain, &htmlGz); err != nil {
+				t.Fatal(err)
+			}
+			if plain.String != tt.html || htmlGz != nil {
+				t.Errorf("after HTML_COMPRESS was turned off, html_gz has %d bytes and html matches = %v", len(htmlGz), plain.String == tt.html)
+			}
+		})
+	}
+}
+
+func TestCollapseResentSignals(t *testing.T) {
+	db := newTestDB(t)
+	sent := time.Date(2024, 6, 3, 13, 0, 0, 0, time.UTC)
+	emails := []struct {
+		id, subject string
+		date        time.Time
+	}{
+		{"first", "Buy XYZ", sent},
+		{"resend", "RE: Buy XYZ", sent.Add(time.Minute)},
+		{"next week", "Buy XYZ", sent.Add(7 * 24 * time.Hour)},
+		{"other", "Buy ACME", sent.Add(time.Minute)},
+	}
+	for _, e := range emails {
+		saveTestEmail(t, db, e.id, e.subject, "<p>(NASDAQ: XYZ) Buy at $12.50</p>")
+		if _, err := db.Exec(`INSERT INTO trade_signals (email_id, ticker, signal_date, entry_date, buy_price) VALUES (?, 'XYZ', ?, ?, 12.5)`,
+			e.id, e.date.UnixMilli(), e.date.UnixMilli()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := db.collapseResentSignals(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("collapseResentSignals() removed %d signals, want 1", removed)
+	}
+	if got, want := signalIDs(t, db, "trade_signals"), []string{"first", "next week", "other"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("signals left %v, want %v", got, want)
+	}
+}
+
+func TestConcurrentInsertsDoNotLock(t *testing.T) {
+	first := newTestDB(t)
+	// A second handle, as each request handler opens its own
+	second, err := setupDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { second.Close() })
+
+	for _, db := range []*DB{first, second} {
+		var foreignKeys, busyTimeout int
+		if err := db.QueryRow(`PRAGMA foreign_keys`).Scan(&foreignKeys); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.QueryRow(`PRAGMA busy_timeout`).Scan(&busyTimeout); err != nil {
+			t.Fatal(err)
+		}
+		if foreignKeys != 1 || busyTimeout != sqliteBusyTimeout {
+			t.Errorf("foreign_keys = %d and busy_timeout = %d, want 1 and %d", foreignKeys, busyTimeout, sqliteBusyTimeout)
+		}
+	}
+
+	const workers, inserts = 8, 25
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*inserts)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			db := []*DB{first, second}[w%2]
+			for i := 0; i < inserts; i++ {
+				tx, err := db.Begin()
+				if err != nil {
+					errs <- err
+					continue
+				}
+				if _, err := tx.Exec(`INSERT INTO trade_signals (email_id, ticker, signal_date, entry_date, buy_price) VALUES (?, 'ACME', 1717419600000, 1717419600000, 12.5)`,
+					fmt.Sprintf("w%d-%d", w, i)); err != nil {
+					tx.Rollback()
+					errs <- err
+					continue
+				}
+				time.Sleep(time.Millisecond) // hold the write lock so the other handle has to wait for it
+				if err := tx.Commit(); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("concurrent insert failed: %v", err)
+	}
+	var count int
+	if err := first.QueryRow(`SELECT COUNT(*) FROM trade_signals`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != workers*inserts {
+		t.Errorf("%d signals saved, want %d", count, workers*inserts)
+	}
+}
+
+func TestSignalEmailsRequiredKeywords(t *testing.T) {
+	db := newTestDB(t)
+	saveTestEmail(t, db, "complete", "Trade alert", "<p>Acme Holdings (NASDAQ: ACME)</p><p>Buy at $12.50</p><p>Stop at $11.00</p><p>Target at $15.00</p>")
+	saveTestEmail(t, db, "no target", "Trade alert", "<p>Acme Holdings (NASDAQ: ACME)</p><p>Buy at $12.50</p><p>Stop at $11.00</p>")
+
+	tests := []struct {
+		name     string
+		required string
+		want     []string
+	}{
+		{"default buy, stop and target", "buy,stop,target", []string{"complete"}},
+		{"target optional", "buy,stop", []string{"complete", "no target"}},
+	}
+
+	saved := requiredSignalKeywords
+	t.Cleanup(func() { requiredSignalKeywords = saved })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			if requiredSignalKeywords, err = parseRequiredSignalKeywords(tt.required); err != nil {
+				t.Fatal(err)
+			}
+			emails, err := db.getSignalEmails(LabelFilter{}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, email := range emails {
+				got = append(got, email.ID)
+
+				// The parser keeps a signal whose target is missing, with no target price
+				signal, _, rejection, err := extractSignalFields(email)
+				if err != nil || rejection != nil {
+					t.Fatalf("extractSignalFields(%s) = %v, %+v", email.ID, err, rejection)
+				}
+				if email.ID == "no target" && (signal.BuyPrice != 12.5 || signal.StopPrice != 11 || signal.TargetPrice != 0) {
+					t.Errorf("buy %g, stop %g, target %g, want 12.5, 11 and none", signal.BuyPrice, signal.StopPrice, signal.TargetPrice)
+				}
+				if err := db.saveParseResult(context.Background(), email, signal, "", nil, time.Now()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getSignalEmails() returned %v, want %v", got, tt.want)
+			}
+
+			// The clean signal policy follows the keywords, so a parsed signal without a target is processed
+			policy := CleanSignalPolicy{RequireStop: signalKeywordRequired("stop"), RequireTarget: signalKeywordRequired("target")}
+			signals, err := db.getCleanSignals(policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var clean []string
+			for _, signal := range signals {
+				clean = append(clean, signal.EmailID)
+			}
+			sort.Strings(clean)
+			if !reflect.DeepEqual(clean, tt.want) {
+				t.Errorf("getCleanSignals(%+v) returned %v, want %v", policy, clean, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanSignalPolicy(t *testing.T) {
+	db := newTestDB(t)
+	for i, row := range []struct {
+		id           string
+		stop, target interface{}
+	}{
+		{"complete", 11.0, 15.0},
+		{"no target", 11.0, nil},
+		{"no stop", nil, 15.0},
+	} {
+		date := time.Date(2024, 6, 3+i, 13, 0, 0, 0, time.UTC).UnixMilli()
+		if _, err := db.Exec(`INSERT INTO parse_buy_stop_target (email_id, ticker, signal_date, entry_date, buy_price, stop_price, target_price)
+			VALUES (?, 'ACME', ?, ?, 12.5, ?, ?)`, row.id, date, date, row.stop, row.target); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		policy CleanSignalPolicy
+		want   []string
+	}{
+		{"stop and target required", CleanSignalPolicy{RequireStop: true, RequireTarget: true}, []string{"complete"}},
+		{"target optional", CleanSignalPolicy{RequireStop: true}, []string{"complete", "no target"}},
+		{"stop and target optional", CleanSignalPolicy{}, []string{"complete", "no stop", "no target"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signals, err := db.getCleanSignals(tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, signal := range signals {
+				got = append(got, signal.EmailID)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getCleanSignals() returned %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Processing with RequireTarget=false stores the two-price signal with no target
+	saved := cleanSignalPolicy
+	cleanSignalPolicy = CleanSignalPolicy{RequireStop: true}
+	t.Cleanup(func() { cleanSignalPolicy = saved })
+	if err := processSignalsConcurrently(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := signalIDs(t, db, "trade_signals"), []string{"complete", "no target"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("trade_signals holds %v, want %v", got, want)
+	}
+	var stop float64
+	var target sql.NullFloat64
+	if err := db.QueryRow(`SELECT stop_price, target_price FROM trade_signals WHERE email_id = 'no target'`).Scan(&stop, &target); err != nil {
+		t.Fatal(err)
+	}
+	if stop != 11 || target.Valid {
+		t.Errorf("stop %g and target %v, want 11 and NULL", stop, target)
+	}
+}
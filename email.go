@@ -5,9 +5,16 @@ import (
 	"encoding/base64"
 	"fmt"
 	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
+	"unicode"
 
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/api/gmail/v1"
 )
 
@@ -15,7 +22,7 @@ import (
 func processEmail(message *gmail.Message) (*gmail.Message, error) {
 	// Extract message content
 	if err := extractMessageContent(message); err != nil {
-		return nil, fmt.Errorf("failed to extract message content: %v", err)
+		return nil, fmt.Errorf("failed to extract message content: %w", err)
 	}
 	return message, nil
 }
@@ -63,76 +70,261 @@ func extractContent(part *gmail.MessagePart) string {
 	return content.String()
 }
 
-// downloadAllEmailsConcurrently fetches emails from Gmail API with concurrency
-func downloadAllEmailsConcurrently(db *DB) error {
-	log.Printf("Starting concurrent email download from %s", targetSender)
-	
-	ctx := context.Background()
-	service, err := getGmailService(ctx)
+// defaultGmailQuery returns the query used when no custom query is supplied: mail from any configured provider
+func defaultGmailQuery() string {
+	return senderQuery(providers)
+}
+
+// senderQuery returns a Gmail query matching mail from any of the providers' senders
+func senderQuery(list []Provider) string {
+	senders := make([]string, len(list))
+	for i, p := range list {
+		senders[i] = p.Sender
+	}
+	if len(senders) == 1 {
+		return fmt.Sprintf("from:%s", senders[0])
+	}
+	return fmt.Sprintf("from:(%s)", strings.Join(senders, " OR "))
+}
+
+// normalizeGmailQuery validates a raw Gmail search query, falling back to the default sender query
+func normalizeGmailQuery(raw string) (string, error) {
+	query := strings.TrimSpace(raw)
+	if query == "" {
+		return defaultGmailQuery(), nil
+	}
+
+	if len(query) > maxGmailQueryLength {
+		return "", fmt.Errorf("query exceeds %d characters", maxGmailQueryLength)
+	}
+
+	for _, r := range query {
+		if unicode.IsControl(r) {
+			return "", fmt.Errorf("query contains control characters")
+		}
+	}
+
+	return query, nil
+}
+
+// DownloadOptions narrows a download. Zero values keep the default of fetching every match.
+type DownloadOptions struct {
+	Query       string     // Gmail search query; empty uses defaultGmailQuery
+	Since       time.Time  // only messages received after this instant
+	MaxMessages int        // stop listing after this many message IDs; 0 means unlimited
+	Fresh       bool       // list again instead of resuming the saved worklist of an unfinished run
+	Providers   []Provider // only mail from these providers' senders; empty leaves the query as is
+}
+
+// parseDownloadOptions reads q, provider, since (YYYY-MM-DD), maxMessages and resume from request parameters
+func parseDownloadOptions(values url.Values) (DownloadOptions, error) {
+	opts := DownloadOptions{Query: values.Get("q")}
+
+	if raw := values.Get("provider"); raw != "" {
+		list, err := findProviders(raw)
+		if err != nil {
+			return opts, err
+		}
+		opts.Providers = list
+	}
+
+	if raw := values.Get("resume"); raw != "" {
+		resume, err := strconv.ParseBool(raw)
+		if err != nil {
+			return opts, fmt.Errorf("resume must be true or false: %q", raw)
+		}
+		opts.Fresh = !resume
+	}
+
+	if raw := values.Get("since"); raw != "" {
+		since, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			return opts, fmt.Errorf("since must be a YYYY-MM-DD date: %q", raw)
+		}
+		if since.After(time.Now()) {
+			return opts, fmt.Errorf("since %s is in the future", raw)
+		}
+		opts.Since = since
+	}
+
+	if raw := values.Get("maxMessages"); raw != "" {
+		maxMessages, err := strconv.Atoi(raw)
+		if err != nil || maxMessages < 1 || maxMessages > maxDownloadMessages {
+			return opts, fmt.Errorf("maxMessages must be between 1 and %d: %q", maxDownloadMessages, raw)
+		}
+		opts.MaxMessages = maxMessages
+	}
+
+	return opts, nil
+}
+
+// gmailQuery returns the validated query with an after: clause for Since
+func (opts DownloadOptions) gmailQuery() (string, error) {
+	query, err := normalizeGmailQuery(opts.Query)
 	if err != nil {
-		return fmt.Errorf("failed to get Gmail service: %v", err)
+		return "", err
+	}
+	if len(opts.Providers) > 0 {
+		if strings.TrimSpace(opts.Query) == "" {
+			query = senderQuery(opts.Providers)
+		} else {
+			query = fmt.Sprintf("%s %s", query, senderQuery(opts.Providers))
+		}
 	}
+	if !opts.Since.IsZero() {
+		// Gmail reads after:YYYY/MM/DD as midnight Pacific time, so send the exact instant in epoch seconds
+		query = fmt.Sprintf("%s after:%d", query, opts.Since.Unix())
+	}
+	return query, nil
+}
 
-	// Build query to get emails from target sender
-	query := fmt.Sprintf("from:%s", targetSender)
-	log.Printf("Gmail query: %s", query)
+// worklistKey names the saved worklist of a download, so only a run with the same query and cap resumes it
+func (opts DownloadOptions) worklistKey(query string) string {
+	if opts.MaxMessages > 0 {
+		return fmt.Sprintf("%s maxMessages:%d", query, opts.MaxMessages)
+	}
+	return query
+}
 
-	// Get list of message IDs
+// listMessageIDs pages through the IDs of every message matching query, stopping at maxMessages when it is set
+func listMessageIDs(service *gmail.Service, query string, maxMessages int) ([]string, error) {
 	var messageIDs []string
 	pageToken := ""
-	
+
 	for {
 		call := service.Users.Messages.List("me").Q(query).MaxResults(500)
 		if pageToken != "" {
 			call = call.PageToken(pageToken)
 		}
-		
+
 		response, err := call.Do()
 		if err != nil {
-			return fmt.Errorf("failed to list messages: %v", err)
+			return nil, fmt.Errorf("failed to list messages: %w", err)
 		}
 
 		for _, message := range response.Messages {
 			messageIDs = append(messageIDs, message.Id)
 		}
 
+		if maxMessages > 0 && len(messageIDs) >= maxMessages {
+			messageIDs = messageIDs[:maxMessages]
+			log.Printf("Reached maxMessages cap of %d, stopping pagination", maxMessages)
+			break
+		}
+
 		if response.NextPageToken == "" {
 			break
 		}
 		pageToken = response.NextPageToken
-		
+
 		log.Printf("Fetched batch of %d message IDs, total so far: %d", len(response.Messages), len(messageIDs))
 	}
+	return messageIDs, nil
+}
+
+// downloadAllEmailsConcurrently fetches emails matching a Gmail query with concurrency.
+// The listed message IDs are saved to download_worklist and checked off as each is saved, so a run
+// that crashes, fails or times out part way resumes with the remaining IDs unless opts.Fresh is set.
+func downloadAllEmailsConcurrently(ctx context.Context, db *DB, opts DownloadOptions) error {
+	if err := requireBodyScope("Email download"); err != nil {
+		return err
+	}
+
+	query, err := opts.gmailQuery()
+	if err != nil {
+		return fmt.Errorf("invalid Gmail query: %w", err)
+	}
+
+	log.Printf("Starting concurrent email download")
+	
+	service, err := getGmailService(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get Gmail service: %w", err)
+	}
 
-	log.Printf("Found %d total messages from %s", len(messageIDs), targetSender)
+	log.Printf("Gmail query: %s", query)
 
-	if len(messageIDs) == 0 {
-		log.Printf("No messages found from %s", targetSender)
-		return nil
+	worklist := opts.worklistKey(query)
+	if opts.Fresh {
+		if err := db.clearDownloadWorklist(worklist); err != nil {
+			return err
+		}
+	}
+	messageIDs, listed, err := db.getDownloadWorklist(worklist)
+	if err != nil {
+		return err
+	}
+
+	if listed > 0 {
+		log.Printf("Resuming download for query %q: %d of %d listed messages remain", query, len(messageIDs), listed)
+		if len(messageIDs) == 0 {
+			if err := db.clearDownloadWorklist(worklist); err != nil {
+				return err
+			}
+			return nothingToDo("all %d messages listed for query %q were already downloaded", listed, query)
+		}
+	} else {
+		// Get list of message IDs
+		if messageIDs, err = listMessageIDs(service, query, opts.MaxMessages); err != nil {
+			return err
+		}
+
+		log.Printf("Found %d total messages for query %q", len(messageIDs), query)
+
+		if len(messageIDs) == 0 {
+			log.Printf("No messages found for query %q", query)
+			return nothingToDo("no messages matched query %q", query)
+		}
+
+		if err := db.saveDownloadWorklist(worklist, messageIDs); err != nil {
+			return err
+		}
 	}
 
 	// Process messages concurrently
 	numWorkers := 50 // High concurrency for Gmail API
-	jobs := make(chan string, len(messageIDs))
-	results := make(chan error, len(messageIDs))
+	jobs := make(chan string, channelCapacity(numWorkers))
+	results := make(chan error, channelCapacity(numWorkers))
+
+	progress := startProgress("download", "messages", len(messageIDs), 100)
+	defer progress.finish()
 
-	// Start workers
 	var wg sync.WaitGroup
-	for i := 0; i < numWorkers; i++ {
-		wg.Add(1)
-		go func(workerID int) {
-			defer wg.Done()
-			downloadEmailWorker(workerID, service, jobs, results, db)
-		}(i)
-	}
+	if useBatch {
+		// One batch request per gmailBatchSize messages instead of one request per message
+		client, err := getGmailClient(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get Gmail client: %w", err)
+		}
 
-	// Send jobs
-	go func() {
-		for _, messageID := range messageIDs {
-			jobs <- messageID
+		numBatchWorkers := 5 // Each worker issues batches of up to 100 sub-requests
+		batches := make(chan []string, channelCapacity(numBatchWorkers))
+		for i := 0; i < numBatchWorkers; i++ {
+			wg.Add(1)
+			go func(workerID int) {
+				defer wg.Done()
+				downloadBatchWorker(ctx, workerID, service, client, batches, results, progress, db, worklist)
+			}(i)
 		}
-		close(jobs)
-	}()
+
+		var chunks [][]string
+		for start := 0; start < len(messageIDs); start += gmailBatchSize {
+			chunks = append(chunks, messageIDs[start:min(start+gmailBatchSize, len(messageIDs))])
+		}
+		go feedJobs(ctx, batches, chunks)
+	} else {
+		// Start workers
+		for i := 0; i < numWorkers; i++ {
+			wg.Add(1)
+			go func(workerID int) {
+				defer wg.Done()
+				downloadEmailWorker(ctx, workerID, service, jobs, results, progress, db, worklist)
+			}(i)
+		}
+
+		// Send jobs until the stage's timeout
+		go feedJobs(ctx, jobs, messageIDs)
+	}
 
 	// Wait for all workers to complete
 	go func() {
@@ -140,83 +332,207 @@ func downloadAllEmailsConcurrently(db *DB) error {
 		close(results)
 	}()
 
-	// Collect results
-	var errors []error
-	var successCount int
+	// Collect errors; workers count progress as they finish each message
+	failures := newAggregateError("email download", len(messageIDs))
 	for err := range results {
 		if err != nil {
-			errors = append(errors, err)
-		} else {
-			successCount++
-		}
-
-		// Log progress every 100 messages
-		if (successCount+len(errors))%100 == 0 {
-			log.Printf("Progress: %d/%d messages processed", successCount+len(errors), len(messageIDs))
+			failures.Add(err)
 		}
 	}
+	successCount, _, _ := progress.counts()
 
 	log.Printf("Email download complete: %d messages processed successfully, %d errors", 
-		successCount, len(errors))
+		successCount, failures.Len())
 
-	if len(errors) > 0 {
-		log.Printf("First few errors: %v", errors[:min(5, len(errors))])
+	if err := stageTimedOut(ctx, progress, failures); err != nil {
+		log.Printf("%v", err)
+		log.Printf("Kept the download worklist for query %q; the next run fetches the messages left", query)
+		return err
 	}
 
-	return nil
+	if failures.Len() > 0 {
+		log.Printf("%v", failures)
+		remaining, _, err := db.getDownloadWorklist(worklist)
+		if err != nil {
+			return err
+		}
+		if len(remaining) > 0 {
+			log.Printf("Kept the download worklist for query %q; the next run retries the %d failed messages", query, len(remaining))
+			return failures.ErrOrNil()
+		}
+	}
+
+	// Every listed message was saved or is gone from Gmail, so the next run lists new mail
+	if err := db.clearDownloadWorklist(worklist); err != nil {
+		return err
+	}
+	return failures.ErrOrNil()
 }
 
-// downloadEmailWorker processes individual email messages
-func downloadEmailWorker(workerID int, service *gmail.Service, jobs <-chan string, results chan<- error, db *DB) {
+// downloadEmailWorker processes individual email messages, checking each saved one off the worklist.
+// Messages still queued when the stage's ctx ends are left for the next run.
+func downloadEmailWorker(ctx context.Context, workerID int, service *gmail.Service, jobs <-chan string, results chan<- error, progress *stageProgress, db *DB, worklist string) {
 	for messageID := range jobs {
-		err := downloadSingleEmail(workerID, service, messageID, db)
+		if ctx.Err() != nil {
+			continue
+		}
+		itemCtx, endSpan := startSpan(ctx, "download message", attribute.String("message_id", messageID))
+		err := newItemError(messageID, CategoryOther, runWithTimeout(itemCtx, itemTimeout, func(ctx context.Context) error {
+			return downloadSingleEmail(ctx, workerID, service, messageID, db)
+		}))
+		endSpan(err)
+		if err == nil {
+			emailsDownloadedTotal.Inc()
+		}
+		checkOffDownload(workerID, db, worklist, messageID, err)
+		progress.record(err)
 		results <- err
 	}
 }
 
+// downloadBatchWorker fetches batches of messages and reports one result per message, checking each
+// saved one off the worklist
+func downloadBatchWorker(ctx context.Context, workerID int, service *gmail.Service, client *http.Client, batches <-chan []string, results chan<- error, progress *stageProgress, db *DB, worklist string) {
+	for batch := range batches {
+		if ctx.Err() != nil {
+			continue
+		}
+		batchCtx, endSpan := startSpan(ctx, "download batch", attribute.Int("messages", len(batch)))
+		messages, failures := fetchFullMessages(batchCtx, service, client, batch)
+		endSpan(nil)
+		for _, messageID := range batch {
+			var err error
+			if msg, ok := messages[messageID]; ok {
+				if saveErr := db.saveEmailToLanding(msg); saveErr != nil {
+					err = fmt.Errorf("worker %d: failed to save message to landing: %w", workerID, saveErr)
+				}
+			} else {
+				err = fmt.Errorf("worker %d: %w", workerID, failures[messageID])
+			}
+
+			err = newItemError(messageID, CategoryOther, err)
+			if err == nil {
+				emailsDownloadedTotal.Inc()
+			}
+			checkOffDownload(workerID, db, worklist, messageID, err)
+			progress.record(err)
+			results <- err
+		}
+	}
+}
+
+// checkOffDownload marks a message done on the worklist once it is saved, or once Gmail says it is gone
+// for good, so a message deleted between list and get is not retried by every resumed run
+func checkOffDownload(workerID int, db *DB, worklist, messageID string, err error) {
+	if err != nil && !isGmailGone(err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Worker %d: message %s is gone from Gmail, checking it off the worklist: %v", workerID, messageID, err)
+	}
+	if markErr := db.markDownloaded(worklist, messageID); markErr != nil {
+		log.Printf("Worker %d: %v; a resumed run will fetch %s again", workerID, markErr, messageID)
+	}
+}
+
 // downloadSingleEmail fetches and saves a single email
-func downloadSingleEmail(workerID int, service *gmail.Service, messageID string, db *DB) error {
+func downloadSingleEmail(ctx context.Context, workerID int, service *gmail.Service, messageID string, db *DB) error {
 	// Get the full message
-	message, err := service.Users.Messages.Get("me", messageID).Format("full").Do()
+	message, err := service.Users.Messages.Get("me", messageID).Format("full").Context(ctx).Do()
 	if err != nil {
-		return fmt.Errorf("worker %d: failed to get message %s: %v", workerID, messageID, err)
+		return fmt.Errorf("worker %d: failed to get message %s: %w", workerID, messageID, err)
 	}
 
 	// Save to email_landing table first (simplified staging)
 	if err := db.saveEmailToLanding(message); err != nil {
-		return fmt.Errorf("worker %d: failed to save message to landing: %v", workerID, err)
+		return fmt.Errorf("worker %d: failed to save message to landing: %w", workerID, err)
+	}
+
+	return nil
+}
+
+// refreshSingleEmail re-downloads one message from Gmail and saves it over its emails row
+func refreshSingleEmail(ctx context.Context, db *DB, messageID string) error {
+	if err := requireBodyScope("Email refresh"); err != nil {
+		return err
+	}
+
+	service, err := getGmailService(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get Gmail service: %w", err)
+	}
+
+	message, err := service.Users.Messages.Get("me", messageID).Format("full").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get message %s: %w", messageID, err)
+	}
+
+	if err := db.upsertFullEmailToDB(message); err != nil {
+		return fmt.Errorf("failed to save message %s: %w", messageID, err)
 	}
 
+	log.Printf("Refreshed email %s from Gmail", messageID)
 	return nil
 }
 
-// enrichEmailsConcurrently fetches full email data and saves to emails table
-func enrichEmailsConcurrently(db *DB) error {
+// enrichEmailsConcurrently fetches full email data and saves to emails table.
+// Threads enriched within ENRICH_FRESHNESS_HOURS are skipped unless force is set.
+func enrichEmailsConcurrently(ctx context.Context, db *DB, force bool) error {
+	if err := requireBodyScope("Email enrichment"); err != nil {
+		return err
+	}
+
 	log.Printf("Starting concurrent email enrichment")
 	
 	// Get thread IDs from email_landing
 	threadIDs, err := db.getThreadIDsFromLanding()
 	if err != nil {
-		return fmt.Errorf("failed to get thread IDs: %v", err)
+		return fmt.Errorf("failed to get thread IDs: %w", err)
+	}
+
+	if !force {
+		fresh, err := db.getThreadsEnrichedSince(time.Now().Add(-enrichFreshness))
+		if err != nil {
+			return fmt.Errorf("failed to get enriched threads: %w", err)
+		}
+
+		pending := threadIDs[:0]
+		for _, threadID := range threadIDs {
+			if !fresh[threadID] {
+				pending = append(pending, threadID)
+			}
+		}
+		log.Printf("Skipping %d threads enriched within the last %v", len(threadIDs)-len(pending), enrichFreshness)
+		threadIDs = pending
 	}
 
 	log.Printf("Found %d thread IDs to enrich", len(threadIDs))
 
 	if len(threadIDs) == 0 {
 		log.Printf("No thread IDs found for enrichment")
-		return nil
+		return nothingToDo("no threads in email_landing need enrichment")
 	}
 
-	ctx := context.Background()
 	service, err := getGmailService(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get Gmail service: %v", err)
+		return fmt.Errorf("failed to get Gmail service: %w", err)
+	}
+
+	// Batch client for fetching each thread's messages in one request (nil when USE_BATCH is off)
+	var client *http.Client
+	if useBatch {
+		if client, err = getGmailClient(ctx); err != nil {
+			return fmt.Errorf("failed to get Gmail client: %w", err)
+		}
 	}
 
 	// Process thread IDs concurrently
 	numWorkers := 25 // Moderate concurrency for full email fetching
-	jobs := make(chan string, len(threadIDs))
-	results := make(chan error, len(threadIDs))
+	jobs := make(chan string, channelCapacity(numWorkers))
+	results := make(chan error, channelCapacity(numWorkers))
+
+	progress := startProgress("enrich", "threads", len(threadIDs), 10)
+	defer progress.finish()
 
 	// Start workers
 	var wg sync.WaitGroup
@@ -224,17 +540,12 @@ func enrichEmailsConcurrently(db *DB) error {
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
-			enrichEmailWorker(workerID, service, jobs, results, db)
+			enrichEmailWorker(ctx, workerID, service, client, jobs, results, progress, db)
 		}(i)
 	}
 
-	// Send jobs
-	go func() {
-		for _, threadID := range threadIDs {
-			jobs <- threadID
-		}
-		close(jobs)
-	}()
+	// Send jobs until the stage's timeout
+	go feedJobs(ctx, jobs, threadIDs)
 
 	// Wait for all workers to complete
 	go func() {
@@ -242,93 +553,155 @@ func enrichEmailsConcurrently(db *DB) error {
 		close(results)
 	}()
 
-	// Collect results
-	var errors []error
-	var processedCount int
+	// Collect errors; workers count progress as they finish each thread
+	failures := newAggregateError("email enrichment", len(threadIDs))
 	for err := range results {
 		if err != nil {
-			errors = append(errors, err)
-		} else {
-			processedCount++
-		}
-
-		// Log progress every 10 threads
-		if (processedCount+len(errors))%10 == 0 {
-			log.Printf("Progress: %d/%d threads processed", processedCount+len(errors), len(threadIDs))
+			failures.Add(err)
 		}
 	}
+	processedCount, _, _ := progress.counts()
 
-	log.Printf("Enrichment complete: %d threads processed successfully, %d errors", processedCount, len(errors))
+	log.Printf("Enrichment complete: %d threads processed successfully, %d errors", processedCount, failures.Len())
 
-	if len(errors) > 0 {
-		log.Printf("First few errors: %v", errors[:min(5, len(errors))])
+	if err := stageTimedOut(ctx, progress, failures); err != nil {
+		log.Printf("%v", err)
+		return err
 	}
 
-	return nil
+	if failures.Len() > 0 {
+		log.Printf("%v", failures)
+	}
+
+	return failures.ErrOrNil()
 }
 
-// enrichEmailWorker processes individual thread IDs for enrichment
-func enrichEmailWorker(workerID int, service *gmail.Service, jobs <-chan string, results chan<- error, db *DB) {
+// enrichEmailWorker processes individual thread IDs for enrichment, skipping those still queued when ctx ends
+func enrichEmailWorker(ctx context.Context, workerID int, service *gmail.Service, client *http.Client, jobs <-chan string, results chan<- error, progress *stageProgress, db *DB) {
 	for threadID := range jobs {
-		err := enrichSingleThread(workerID, service, threadID, db)
+		if ctx.Err() != nil {
+			continue
+		}
+		itemCtx, endSpan := startSpan(ctx, "enrich thread", attribute.String("thread_id", threadID))
+		err := newItemError(threadID, CategoryOther, runWithTimeout(itemCtx, itemTimeout, func(ctx context.Context) error {
+			return enrichSingleThread(ctx, workerID, service, client, threadID, db)
+		}))
+		endSpan(err)
+		if err != nil {
+			enrichErrorsTotal.Inc()
+		}
+		progress.record(err)
 		results <- err
 	}
 }
 
+// limitThreadMessages keeps the first ENRICH_MESSAGES_PER_THREAD messages of a thread, which Gmail
+// lists oldest first, so only the original message and the earliest replies are fetched
+func limitThreadMessages(thread *gmail.Thread) *gmail.Thread {
+	if enrichMessagesPerThread == 0 || len(thread.Messages) <= enrichMessagesPerThread {
+		return thread
+	}
+	limited := *thread
+	limited.Messages = thread.Messages[:enrichMessagesPerThread]
+	return &limited
+}
+
+// skipIgnoredMessages leaves out the thread's messages whose IDs are in ignored
+func skipIgnoredMessages(thread *gmail.Thread, ignored map[string]bool) *gmail.Thread {
+	if len(ignored) == 0 {
+		return thread
+	}
+	kept := *thread
+	kept.Messages = nil
+	for _, message := range thread.Messages {
+		if !ignored[message.Id] {
+			kept.Messages = append(kept.Messages, message)
+		}
+	}
+	return &kept
+}
+
 // enrichSingleThread fetches full email data for a thread and saves to emails table
-func enrichSingleThread(workerID int, service *gmail.Service, threadID string, db *DB) error {
+func enrichSingleThread(ctx context.Context, workerID int, service *gmail.Service, client *http.Client, threadID string, db *DB) error {
 	// Get messages in the thread
-	thread, err := service.Users.Threads.Get("me", threadID).Do()
+	thread, err := service.Users.Threads.Get("me", threadID).Context(ctx).Do()
 	if err != nil {
-		return fmt.Errorf("worker %d: failed to get thread %s: %v", workerID, threadID, err)
+		return fmt.Errorf("worker %d: failed to get thread %s: %w", workerID, threadID, err)
 	}
+	thread = limitThreadMessages(thread)
+
+	// A sibling brings an ignored email's thread here too; the ignored email itself is neither fetched nor re-saved
+	ignored, err := db.getIgnoredEmailIDs(threadID)
+	if err != nil {
+		return fmt.Errorf("worker %d: %w", workerID, err)
+	}
+	thread = skipIgnoredMessages(thread, ignored)
 
 	// Process each message in the thread
-	for _, message := range thread.Messages {
-		// Get full message content
-		fullMessage, err := service.Users.Messages.Get("me", message.Id).Format("full").Do()
-		if err != nil {
-			log.Printf("Worker %d: failed to get full message %s: %v", workerID, message.Id, err)
-			continue
-		}
+	saved := 0
+	for _, fullMessage := range fetchThreadMessages(ctx, workerID, service, client, thread) {
 
 		// Save to emails table with all fields
 		if err := db.upsertFullEmailToDB(fullMessage); err != nil {
-			log.Printf("Worker %d: failed to save full email %s: %v", workerID, message.Id, err)
+			log.Printf("Worker %d: failed to save full email %s: %v", workerID, fullMessage.Id, err)
+			enrichErrorsTotal.Inc()
 			continue
 		}
+		saved++
+	}
+
+	// Only a fully saved thread is skipped by the next run; partial ones are retried
+	if err := db.recordThreadEnrichment(threadID, len(thread.Messages), saved); err != nil {
+		return fmt.Errorf("worker %d: %w", workerID, err)
+	}
+	if saved < len(thread.Messages) {
+		log.Printf("Worker %d: thread %s saved %d/%d messages, will retry next run", workerID, threadID, saved, len(thread.Messages))
 	}
 
 	return nil
 }
 
 // enrichEmailsV1_2Concurrently re-downloads emails for all thread_ids from emails_v1_1 into emails_v1_2
-func enrichEmailsV1_2Concurrently(db *DB) error {
+func enrichEmailsV1_2Concurrently(ctx context.Context, db *DB) error {
+	if err := requireBodyScope("emails_v1_2 enrichment"); err != nil {
+		return err
+	}
+
 	log.Printf("Starting concurrent email re-download for emails_v1_2 with InternalDate")
 	
 	// Get thread IDs from emails_v1_1
 	threadIDs, err := db.getThreadIDsFromV1_1()
 	if err != nil {
-		return fmt.Errorf("failed to get thread IDs from emails_v1_1: %v", err)
+		return fmt.Errorf("failed to get thread IDs from emails_v1_1: %w", err)
 	}
 
 	log.Printf("Found %d thread IDs from emails_v1_1 to re-download", len(threadIDs))
 
 	if len(threadIDs) == 0 {
 		log.Printf("No thread IDs found in emails_v1_1 for re-download")
-		return nil
+		return nothingToDo("no threads in emails_v1_1 to re-download")
 	}
 
-	ctx := context.Background()
 	service, err := getGmailService(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get Gmail service: %v", err)
+		return fmt.Errorf("failed to get Gmail service: %w", err)
+	}
+
+	// Batch client for fetching each thread's messages in one request (nil when USE_BATCH is off)
+	var client *http.Client
+	if useBatch {
+		if client, err = getGmailClient(ctx); err != nil {
+			return fmt.Errorf("failed to get Gmail client: %w", err)
+		}
 	}
 
 	// Process thread IDs concurrently
 	numWorkers := 25 // Moderate concurrency for full email fetching
-	jobs := make(chan string, len(threadIDs))
-	results := make(chan error, len(threadIDs))
+	jobs := make(chan string, channelCapacity(numWorkers))
+	results := make(chan error, channelCapacity(numWorkers))
+
+	progress := startProgress("enrich_v1_2", "threads", len(threadIDs), 10)
+	defer progress.finish()
 
 	// Start workers
 	var wg sync.WaitGroup
@@ -336,17 +709,12 @@ func enrichEmailsV1_2Concurrently(db *DB) error {
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
-			enrichEmailV1_2Worker(workerID, service, jobs, results, db)
+			enrichEmailV1_2Worker(ctx, workerID, service, client, jobs, results, progress, db)
 		}(i)
 	}
 
-	// Send jobs
-	go func() {
-		for _, threadID := range threadIDs {
-			jobs <- threadID
-		}
-		close(jobs)
-	}()
+	// Send jobs until the stage's timeout
+	go feedJobs(ctx, jobs, threadIDs)
 
 	// Wait for all workers to complete
 	go func() {
@@ -354,62 +722,80 @@ func enrichEmailsV1_2Concurrently(db *DB) error {
 		close(results)
 	}()
 
-	// Collect results
-	var errors []error
-	var processedCount int
+	// Collect errors; workers count progress as they finish each thread
+	failures := newAggregateError("emails_v1_2 enrichment", len(threadIDs))
 	for err := range results {
 		if err != nil {
-			errors = append(errors, err)
-		} else {
-			processedCount++
-		}
-
-		// Log progress every 10 threads
-		if (processedCount+len(errors))%10 == 0 {
-			log.Printf("Progress: %d/%d threads processed", processedCount+len(errors), len(threadIDs))
+			failures.Add(err)
 		}
 	}
+	processedCount, _, _ := progress.counts()
 
-	log.Printf("emails_v1_2 enrichment complete: %d threads processed successfully, %d errors", processedCount, len(errors))
+	log.Printf("emails_v1_2 enrichment complete: %d threads processed successfully, %d errors", processedCount, failures.Len())
 
-	if len(errors) > 0 {
-		log.Printf("First few errors: %v", errors[:min(5, len(errors))])
+	if err := stageTimedOut(ctx, progress, failures); err != nil {
+		log.Printf("%v", err)
+		return err
 	}
 
-	return nil
+	if failures.Len() > 0 {
+		log.Printf("%v", failures)
+	}
+
+	return failures.ErrOrNil()
 }
 
-// enrichEmailV1_2Worker processes individual thread IDs for emails_v1_2 enrichment
-func enrichEmailV1_2Worker(workerID int, service *gmail.Service, jobs <-chan string, results chan<- error, db *DB) {
+// enrichEmailV1_2Worker processes individual thread IDs for emails_v1_2 enrichment, skipping those still queued when ctx ends
+func enrichEmailV1_2Worker(ctx context.Context, workerID int, service *gmail.Service, client *http.Client, jobs <-chan string, results chan<- error, progress *stageProgress, db *DB) {
 	for threadID := range jobs {
-		err := enrichSingleThreadV1_2(workerID, service, threadID, db)
+		if ctx.Err() != nil {
+			continue
+		}
+		itemCtx, endSpan := startSpan(ctx, "enrich thread", attribute.String("thread_id", threadID), attribute.String("table", "emails_v1_2"))
+		err := newItemError(threadID, CategoryOther, runWithTimeout(itemCtx, itemTimeout, func(ctx context.Context) error {
+			return enrichSingleThreadV1_2(ctx, workerID, service, client, threadID, db)
+		}))
+		endSpan(err)
+		if err != nil {
+			enrichErrorsTotal.Inc()
+		}
+		progress.record(err)
 		results <- err
 	}
 }
 
 // enrichSingleThreadV1_2 fetches full email data for a thread and saves to emails_v1_2 table
-func enrichSingleThreadV1_2(workerID int, service *gmail.Service, threadID string, db *DB) error {
+func enrichSingleThreadV1_2(ctx context.Context, workerID int, service *gmail.Service, client *http.Client, threadID string, db *DB) error {
 	// Get messages in the thread
-	thread, err := service.Users.Threads.Get("me", threadID).Do()
+	thread, err := service.Users.Threads.Get("me", threadID).Context(ctx).Do()
 	if err != nil {
-		return fmt.Errorf("worker %d: failed to get thread %s: %v", workerID, threadID, err)
+		return fmt.Errorf("worker %d: failed to get thread %s: %w", workerID, threadID, err)
 	}
+	thread = limitThreadMessages(thread)
 
 	// Process each message in the thread
-	for _, message := range thread.Messages {
-		// Get full message content
-		fullMessage, err := service.Users.Messages.Get("me", message.Id).Format("full").Do()
-		if err != nil {
-			log.Printf("Worker %d: failed to get full message %s: %v", workerID, message.Id, err)
-			continue
-		}
+	for _, fullMessage := range fetchThreadMessages(ctx, workerID, service, client, thread) {
 
 		// Save to emails_v1_2 table with InternalDate
 		if err := db.upsertFullEmailToV1_2(fullMessage); err != nil {
-			log.Printf("Worker %d: failed to save full email to v1_2 %s: %v", workerID, message.Id, err)
+			log.Printf("Worker %d: failed to save full email to v1_2 %s: %v", workerID, fullMessage.Id, err)
+			enrichErrorsTotal.Inc()
 			continue
 		}
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// splitAddress parses an address header like "Dr Stoxx <drstoxx@drstoxx.com>" into display name and address.
+// Malformed headers are returned unchanged as the address with an empty name.
+func splitAddress(header string) (name, address string) {
+	if strings.TrimSpace(header) == "" {
+		return "", ""
+	}
+	parsed, err := mail.ParseAddress(header)
+	if err != nil {
+		return "", header
+	}
+	return parsed.Name, parsed.Address
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/gmail/v1"
+)
+
+// redirectTransport sends every request to target, whatever host it was made for
+type redirectTransport struct{ target *url.URL }
+
+func (rt redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = rt.target.Scheme, rt.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// fakeGmail serves the Gmail API from handler for the rest of the test. It saves an unexpired token in
+// the working directory, so call it after newTestDB, and returns a ctx whose OAuth clients reach handler.
+func fakeGmail(t *testing.T, handler http.Handler) context.Context {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	target, _ := url.Parse(server.URL)
+
+	savedConfig := config
+	config = &oauth2.Config{ClientID: "test", Endpoint: oauth2.Endpoint{TokenURL: server.URL + "/token"}}
+	t.Cleanup(func() { config = savedConfig })
+
+	if err := os.MkdirAll(filepath.Dir(tokenFile), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	token := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour)}
+	if err := saveToken(tokenFile, token); err != nil {
+		t.Fatal(err)
+	}
+	return context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: redirectTransport{target}})
+}
+
+// gmailThreads serves threads.get and messages.get for threads whose first message shares the thread's
+// ID, followed by any replies listed for the thread, and records which threads and messages were fetched
+type gmailThreads struct {
+	replies map[string][]string
+
+	mu       sync.Mutex
+	fetched  []string
+	messages []string
+}
+
+func (g *gmailThreads) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	var body any
+	switch {
+	case strings.Contains(r.URL.Path, "/threads/"):
+		g.mu.Lock()
+		g.fetched = append(g.fetched, id)
+		g.mu.Unlock()
+		thread := &gmail.Thread{Id: id, Messages: []*gmail.Message{{Id: id, ThreadId: id}}}
+		for _, reply := range g.replies[id] {
+			thread.Messages = append(thread.Messages, &gmail.Message{Id: reply, ThreadId: id})
+		}
+		body = thread
+	case strings.Contains(r.URL.Path, "/messages/"):
+		g.mu.Lock()
+		g.messages = append(g.messages, id)
+		g.mu.Unlock()
+		body = gmailMessage(id, id, "Trade alert", time.Date(2024, 6, 3, 13, 0, 0, 0, time.UTC),
+			gmailPart("text/html", "<p>Buy ACME at $12.50</p>"))
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(body)
+}
+
+// threads returns the fetched thread IDs, sorted
+func (g *gmailThreads) threads() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	fetched := append([]string(nil), g.fetched...)
+	sort.Strings(fetched)
+	return fetched
+}
+
+// fetchedMessages returns the fetched message IDs, sorted
+func (g *gmailThreads) fetchedMessages() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	fetched := append([]string(nil), g.messages...)
+	sort.Strings(fetched)
+	return fetched
+}
+
+// gmailMailbox serves messages.list and messages.get for a mailbox holding ids, answering 404 for the
+// gone ones, and records how often it was listed and which messages were fetched
+type gmailMailbox struct {
+	ids  []string
+	gone map[string]bool
+
+	mu      sync.Mutex
+	lists   int
+	fetched []string
+}
+
+func (g *gmailMailbox) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if strings.HasSuffix(r.URL.Path, "/messages") {
+		g.lists++
+		list := &gmail.ListMessagesResponse{}
+		for _, id := range g.ids {
+			list.Messages = append(list.Messages, &gmail.Message{Id: id, ThreadId: id})
+		}
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+	id := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	g.fetched = append(g.fetched, id)
+	if g.gone[id] {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error": {"code": 404, "message": "Requested entity was not found."}}`)
+		return
+	}
+	json.NewEncoder(w).Encode(&gmail.Message{Id: id, ThreadId: id, Snippet: "Buy ACME at $12.50"})
+}
+
+// calls returns how often the mailbox was listed and the fetched message IDs, sorted, then forgets them
+func (g *gmailMailbox) calls() (int, []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	lists, fetched := g.lists, g.fetched
+	g.lists, g.fetched = 0, nil
+	sort.Strings(fetched)
+	return lists, fetched
+}
+
+func TestSplitAddress(t *testing.T) {
+	tests := []struct {
+		header      string
+		wantName    string
+		wantAddress string
+	}{
+		{"Dr Stoxx <drstoxx@drstoxx.com>", "Dr Stoxx", "drstoxx@drstoxx.com"},
+		{`"Stoxx, Dr" <drstoxx@drstoxx.com>`, "Stoxx, Dr", "drstoxx@drstoxx.com"},
+		{"drstoxx@drstoxx.com", "", "drstoxx@drstoxx.com"},
+		{"Dr Stoxx <not an address", "", "Dr Stoxx <not an address"},
+		{"  ", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			name, address := splitAddress(tt.header)
+			if name != tt.wantName || address != tt.wantAddress {
+				t.Errorf("splitAddress() = %q, %q, want %q, %q", name, address, tt.wantName, tt.wantAddress)
+			}
+		})
+	}
+}
+
+func TestUpsertStoresSenderNameAndReplyTo(t *testing.T) {
+	db := newTestDB(t)
+	msg := gmailMessage("m1", "t1", "Trade alert", time.Date(2024, 6, 3, 13, 0, 0, 0, time.UTC),
+		gmailPart("text/html", "<p>Buy ACME at $12.50</p>"))
+	msg.Payload.Headers = append(msg.Payload.Headers, &gmail.MessagePartHeader{Name: "Reply-To", Value: "Support <help@drstoxx.com>"})
+	if err := db.upsertFullEmailToDB(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	var fromName, fromAddress, replyTo string
+	if err := db.QueryRow(`SELECT from_name, from_address, reply_to FROM emails WHERE id = 'm1'`).Scan(&fromName, &fromAddress, &replyTo); err != nil {
+		t.Fatal(err)
+	}
+	if fromName != "Dr Stoxx" || fromAddress != targetSender || replyTo != "help@drstoxx.com" {
+		t.Errorf("stored %q <%s> replying to %s", fromName, fromAddress, replyTo)
+	}
+}
+
+func TestEnrichResumeSkipsEnrichedThreads(t *testing.T) {
+	tests := []struct {
+		name  string
+		force bool
+		setup func(db *DB) error // marks t1 before the run
+		want  []string
+	}{
+		{
+			name:  "resumed run",
+			setup: func(db *DB) error { return db.recordThreadEnrichment("t1", 1, 1) },
+			want:  []string{"t2"},
+		},
+		{
+			name:  "force",
+			force: true,
+			setup: func(db *DB) error { return db.recordThreadEnrichment("t1", 1, 1) },
+			want:  []string{"t1", "t2"},
+		},
+		{
+			name:  "partially saved thread",
+			setup: func(db *DB) error { return db.recordThreadEnrichment("t1", 2, 1) },
+			want:  []string{"t1", "t2"},
+		},
+		{
+			name: "enriched before the freshness window",
+			setup: func(db *DB) error {
+				_, err := db.Exec(`INSERT INTO thread_enrichment (thread_id, enriched_at, reported_messages, saved_messages) VALUES ('t1', ?, 1, 1)`,
+					time.Now().UTC().Add(-enrichFreshness-time.Hour))
+				return err
+			},
+			want: []string{"t1", "t2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			gmailAPI := &gmailThreads{}
+			ctx := fakeGmail(t, gmailAPI)
+			for _, id := range []string{"t1", "t2"} {
+				if err := db.saveEmailToLanding(&gmail.Message{Id: id, ThreadId: id, Snippet: "Buy ACME"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := tt.setup(db); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := enrichEmailsConcurrently(ctx, db, tt.force); err != nil {
+				t.Fatal(err)
+			}
+			if got := gmailAPI.threads(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fetched threads %v, want %v", got, tt.want)
+			}
+
+			// Both threads are now fresh, so another run has nothing to do
+			if err := enrichEmailsConcurrently(ctx, db, false); !isEmptyInput(err) {
+				t.Errorf("second run error = %v, want nothing to do", err)
+			}
+		})
+	}
+}
+
+func TestEnrichMessagesPerThread(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+		want  []string
+	}{
+		{"every message by default", 0, []string{"r1", "r2", "t1"}},
+		{"original message only", 1, []string{"t1"}},
+		{"original and first reply", 2, []string{"r1", "t1"}},
+		{"limit above the thread length", 5, []string{"r1", "r2", "t1"}},
+	}
+
+	saved := enrichMessagesPerThread
+	t.Cleanup(func() { enrichMessagesPerThread = saved })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enrichMessagesPerThread = tt.limit
+			db := newTestDB(t)
+			gmailAPI := &gmailThreads{replies: map[string][]string{"t1": {"r1", "r2"}}}
+			ctx := fakeGmail(t, gmailAPI)
+			if err := db.saveEmailToLanding(&gmail.Message{Id: "t1", ThreadId: "t1", Snippet: "Buy ACME"}); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := enrichEmailsConcurrently(ctx, db, false); err != nil {
+				t.Fatal(err)
+			}
+			if got := gmailAPI.fetchedMessages(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fetched messages %v, want %v", got, tt.want)
+			}
+
+			var stored int
+			if err := db.QueryRow(`SELECT COUNT(*) FROM emails`).Scan(&stored); err != nil {
+				t.Fatal(err)
+			}
+			if stored != len(tt.want) {
+				t.Errorf("stored %d emails, want %d", stored, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestEnrichSkipsIgnoredEmail(t *testing.T) {
+	db := newTestDB(t)
+	gmailAPI := &gmailThreads{replies: map[string][]string{"t1": {"r1"}}}
+	ctx := fakeGmail(t, gmailAPI)
+	for _, id := range []string{"t1", "r1"} {
+		if err := db.saveEmailToLanding(&gmail.Message{Id: id, ThreadId: "t1", Snippet: "Buy ACME"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enrichEmailsConcurrently(ctx, db, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ignore the original; its reply still brings the thread back to enrichment
+	if err := db.setEmailIgnored("t1", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`UPDATE emails SET subject = 'Marked as junk' WHERE id = 't1'`); err != nil {
+		t.Fatal(err)
+	}
+	gmailAPI.mu.Lock()
+	gmailAPI.messages = nil
+	gmailAPI.mu.Unlock()
+	if err := enrichEmailsConcurrently(ctx, db, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := gmailAPI.fetchedMessages(); !reflect.DeepEqual(got, []string{"r1"}) {
+		t.Errorf("fetched messages %v, want only the reply r1", got)
+	}
+	var subject string
+	var ignored bool
+	if err := db.QueryRow(`SELECT subject, ignored FROM emails WHERE id = 't1'`).Scan(&subject, &ignored); err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Marked as junk" || !ignored {
+		t.Errorf("ignored email has subject %q and ignored=%v, want it left as it was", subject, ignored)
+	}
+
+	// With its ignored email left out, the thread counts as fully enriched
+	incomplete, err := db.getIncompleteThreads()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(incomplete) != 0 {
+		t.Errorf("incomplete threads %+v, want none", incomplete)
+	}
+}
+
+func TestGmailQuerySince(t *testing.T) {
+	tests := []struct {
+		name  string
+		since time.Time
+		want  string
+	}{
+		{"no since", time.Time{}, "from:alerts@example.com"},
+		{"midnight UTC", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), "from:alerts@example.com after:1709251200"},
+		{"keeps the time of day", time.Date(2024, 3, 1, 15, 30, 0, 0, time.UTC), "from:alerts@example.com after:1709307000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DownloadOptions{Query: "from:alerts@example.com", Since: tt.since}
+			got, err := opts.gmailQuery()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("gmailQuery() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownloadResumesWorklist(t *testing.T) {
+	tests := []struct {
+		name        string
+		fresh       bool
+		worklist    []string // saved by an earlier run that stopped part way
+		done        []string // checked off by that run
+		gone        map[string]bool
+		wantFetched []string
+		wantLists   int
+		wantErr     bool
+	}{
+		{
+			name:        "restart fetches only the remainder",
+			worklist:    []string{"m1", "m2", "m3", "m4"},
+			done:        []string{"m1", "m2"},
+			wantFetched: []string{"m3", "m4"},
+		},
+		{
+			name:        "fresh run lists again",
+			fresh:       true,
+			worklist:    []string{"m1", "m2", "m3", "m4"},
+			done:        []string{"m1", "m2"},
+			wantFetched: []string{"m1", "m2", "m3", "m4"},
+			wantLists:   1,
+		},
+		{
+			name:        "message deleted since it was listed",
+			worklist:    []string{"m1", "m2", "m3", "m4"},
+			done:        []string{"m1", "m2"},
+			gone:        map[string]bool{"m3": true},
+			wantFetched: []string{"m3", "m4"},
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			mailbox := &gmailMailbox{ids: []string{"m1", "m2", "m3", "m4"}, gone: tt.gone}
+			ctx := fakeGmail(t, mailbox)
+
+			opts := DownloadOptions{Fresh: tt.fresh}
+			query, err := opts.gmailQuery()
+			if err != nil {
+				t.Fatal(err)
+			}
+			worklist := opts.worklistKey(query)
+			if err := db.saveDownloadWorklist(worklist, tt.worklist); err != nil {
+				t.Fatal(err)
+			}
+			for _, id := range tt.done {
+				if err := db.markDownloaded(worklist, id); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = downloadAllEmailsConcurrently(ctx, db, opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("downloadAllEmailsConcurrently() = %v, want error %v", err, tt.wantErr)
+			}
+			if lists, fetched := mailbox.calls(); lists != tt.wantLists || !reflect.DeepEqual(fetched, tt.wantFetched) {
+				t.Errorf("listed %d times and fetched %v, want %d and %v", lists, fetched, tt.wantLists, tt.wantFetched)
+			}
+
+			// The worklist is finished, so the next run lists new mail instead of retrying
+			if pending, listed, err := db.getDownloadWorklist(worklist); err != nil || listed != 0 {
+				t.Fatalf("worklist kept %d listed messages (%v pending), want it cleared: %v", listed, pending, err)
+			}
+			downloadAllEmailsConcurrently(ctx, db, DownloadOptions{})
+			if lists, _ := mailbox.calls(); lists != 1 {
+				t.Errorf("next run listed %d times, want 1", lists)
+			}
+		})
+	}
+}
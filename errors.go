@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+// ErrorCategory groups item failures so callers can tell auth problems from bad data
+type ErrorCategory string
+
+const (
+	CategoryAuth      ErrorCategory = "auth"
+	CategoryRateLimit ErrorCategory = "rate-limit"
+	CategoryParse     ErrorCategory = "parse"
+	CategoryDB        ErrorCategory = "db"
+	CategoryTimeout   ErrorCategory = "timeout"
+	CategoryOther     ErrorCategory = "other"
+)
+
+// errItemTimeout marks an item that was abandoned after itemTimeout
+var errItemTimeout = errors.New("item timed out")
+
+// runWithTimeout runs one item's work under the stage's ctx, giving up once timeout passes so a pathological
+// item cannot stall its worker; a timeout of 0 waits as long as the stage does. Work that ignores ctx keeps
+// running in the background and its result is discarded.
+func runWithTimeout(ctx context.Context, timeout time.Duration, work func(ctx context.Context) error) error {
+	if timeout <= 0 {
+		return work(ctx)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- work(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("%w after %s", errItemTimeout, timeout)
+	}
+}
+
+// stageContext returns the context a pipeline stage runs under, which ends at the stage's timeout if it has one.
+// It carries parent's trace in a span for the stage, ended by the returned cancel, but not parent's
+// cancellation, so a client that disconnects does not stop the stage.
+func stageContext(parent context.Context, stage string) (context.Context, context.CancelFunc) {
+	ctx, span := tracer.Start(context.WithoutCancel(parent), "stage "+stage, trace.WithAttributes(attribute.String("stage", stage)))
+	var cancel context.CancelFunc
+	if timeout := stageTimeouts[stage]; timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	return ctx, func() {
+		cancel()
+		span.End()
+	}
+}
+
+// feedJobs sends items to a stage's workers and closes jobs, stopping early once the stage's ctx ends
+func feedJobs[T any](ctx context.Context, jobs chan<- T, items []T) {
+	defer close(jobs)
+	for _, item := range items {
+		select {
+		case jobs <- item:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// StageTimeoutError reports a stage that reached its timeout before working through all its items.
+// Items in flight at the deadline are cancelled and counted as failed; the rest were never started.
+type StageTimeoutError struct {
+	Stage     string
+	Timeout   time.Duration
+	Processed int
+	Failed    int
+	Remaining int
+	Failures  error // the stage's item failures, nil when there were none
+}
+
+func (e *StageTimeoutError) Error() string {
+	msg := fmt.Sprintf("%s timed out after %s: %d items processed, %d failed, %d not started",
+		e.Stage, e.Timeout, e.Processed, e.Failed, e.Remaining)
+	if e.Failures != nil {
+		msg += "; " + e.Failures.Error()
+	}
+	return msg
+}
+
+// Unwrap exposes the deadline and the item failures to errors.Is and errors.As
+func (e *StageTimeoutError) Unwrap() []error {
+	if e.Failures == nil {
+		return []error{context.DeadlineExceeded}
+	}
+	return []error{context.DeadlineExceeded, e.Failures}
+}
+
+// stageTimedOut returns a StageTimeoutError when the stage's ctx ended with items left unstarted,
+// and nil when the stage got through every item
+func stageTimedOut(ctx context.Context, progress *stageProgress, failures *AggregateError) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	processed, failed, skipped := progress.counts()
+	remaining := int(progress.total) - processed - failed - skipped
+	if remaining <= 0 {
+		return nil
+	}
+	return &StageTimeoutError{
+		Stage:     progress.stage,
+		Timeout:   stageTimeouts[progress.stage],
+		Processed: processed,
+		Failed:    failed,
+		Remaining: remaining,
+		Failures:  failures.ErrOrNil(),
+	}
+}
+
+// isStageTimeout reports whether err means a stage stopped at its timeout
+func isStageTimeout(err error) bool {
+	var timeout *StageTimeoutError
+	return errors.As(err, &timeout)
+}
+
+// ItemError wraps a failure with the ID of the email, thread or signal it concerns
+type ItemError struct {
+	ID       string
+	Category ErrorCategory
+	Err      error
+}
+
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("%s [%s]: %v", e.ID, e.Category, e.Err)
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// newItemError wraps err with the item ID, classifying it with fallback when the cause is not recognized.
+// It returns nil when err is nil so workers can wrap results unconditionally.
+func newItemError(id string, fallback ErrorCategory, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ItemError{ID: id, Category: classifyError(err, fallback), Err: err}
+}
+
+// classifyError inspects the wrapped error chain for Gmail, OAuth and SQLite errors
+func classifyError(err error, fallback ErrorCategory) ErrorCategory {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusTooManyRequests:
+			return CategoryRateLimit
+		case http.StatusForbidden:
+			for _, item := range apiErr.Errors {
+				if strings.Contains(item.Reason, "RateLimitExceeded") || item.Reason == "rateLimitExceeded" {
+					return CategoryRateLimit
+				}
+			}
+			return CategoryAuth
+		case http.StatusUnauthorized:
+			return CategoryAuth
+		}
+	}
+
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) || errors.Is(err, errNoToken) || errors.Is(err, errNoRefreshToken) {
+		return CategoryAuth
+	}
+
+	if errors.Is(err, errItemTimeout) || errors.Is(err, context.DeadlineExceeded) {
+		return CategoryTimeout
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return CategoryDB
+	}
+
+	return fallback
+}
+
+// AggregateError collects the item failures of one concurrent stage.
+// It is safe for use from multiple goroutines.
+type AggregateError struct {
+	Stage string
+	Total int
+
+	mu     sync.Mutex
+	errs   []*ItemError
+	counts map[ErrorCategory]int
+}
+
+// newAggregateError creates an empty aggregate for a stage processing total items
+func newAggregateError(stage string, total int) *AggregateError {
+	return &AggregateError{Stage: stage, Total: total, counts: make(map[ErrorCategory]int)}
+}
+
+// Add records a failure; errors that are not ItemErrors are recorded under CategoryOther
+func (a *AggregateError) Add(err error) {
+	if err == nil {
+		return
+	}
+
+	var itemErr *ItemError
+	if !errors.As(err, &itemErr) {
+		itemErr = &ItemError{Category: CategoryOther, Err: err}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.errs = append(a.errs, itemErr)
+	a.counts[itemErr.Category]++
+}
+
+// Len returns the number of recorded failures
+func (a *AggregateError) Len() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.errs)
+}
+
+// Count returns the number of failures in a category
+func (a *AggregateError) Count(category ErrorCategory) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.counts[category]
+}
+
+// IDs returns the IDs of the items that failed in a category
+func (a *AggregateError) IDs(category ErrorCategory) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var ids []string
+	for _, e := range a.errs {
+		if e.Category == category {
+			ids = append(ids, e.ID)
+		}
+	}
+	return ids
+}
+
+// ErrOrNil returns the aggregate if any failures were recorded
+func (a *AggregateError) ErrOrNil() error {
+	if a.Len() == 0 {
+		return nil
+	}
+	return a
+}
+
+// Error summarizes counts by category followed by the first few failures
+func (a *AggregateError) Error() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	categories := make([]string, 0, len(a.counts))
+	for category, count := range a.counts {
+		categories = append(categories, fmt.Sprintf("%s: %d", category, count))
+	}
+	sort.Strings(categories)
+
+	var first []string
+	for _, e := range a.errs[:min(5, len(a.errs))] {
+		first = append(first, e.Error())
+	}
+
+	return fmt.Sprintf("%s: %d/%d items failed (%s); first errors: %s",
+		a.Stage, len(a.errs), a.Total, strings.Join(categories, ", "), strings.Join(first, "; "))
+}
+
+// Unwrap exposes the individual item errors to errors.Is and errors.As
+func (a *AggregateError) Unwrap() []error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	errs := make([]error, len(a.errs))
+	for i, e := range a.errs {
+		errs[i] = e
+	}
+	return errs
+}
+
+// EmptyInputError reports that a stage ran but had nothing to work on
+type EmptyInputError struct {
+	Reason string
+}
+
+func (e *EmptyInputError) Error() string {
+	return "nothing to do: " + e.Reason
+}
+
+// nothingToDo returns an EmptyInputError with a formatted reason
+func nothingToDo(format string, args ...interface{}) error {
+	return &EmptyInputError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// isEmptyInput reports whether err means the stage found no input
+func isEmptyInput(err error) bool {
+	var empty *EmptyInputError
+	return errors.As(err, &empty)
+}
+
+// isPartialFailure reports whether err is an aggregate where some items succeeded and none failed on auth.
+// A stage that timed out is not a partial failure, whatever its items did.
+func isPartialFailure(err error) bool {
+	if isStageTimeout(err) {
+		return false
+	}
+	var agg *AggregateError
+	return errors.As(err, &agg) && agg.Count(CategoryAuth) == 0 && agg.Len() < agg.Total
+}
+
+// isGmailNotFound reports whether err is Gmail saying the requested message or thread does not exist
+func isGmailNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+// isGmailGone reports whether err is Gmail refusing a message for good: deleted since it was listed (404)
+// or an ID it does not accept (400). Fetching it again on a later run cannot succeed.
+func isGmailGone(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && (apiErr.Code == http.StatusNotFound || apiErr.Code == http.StatusBadRequest)
+}
+
+// isAuthError reports whether err means there is no usable Gmail login: no saved token,
+// a token that cannot be refreshed, or Gmail rejecting the credentials
+func isAuthError(err error) bool {
+	return classifyError(err, CategoryOther) == CategoryAuth
+}
+
+// isAuthFailure reports whether err means the saved Gmail login no longer works,
+// either for the whole stage or for at least one of its items
+func isAuthFailure(err error) bool {
+	if isAuthError(err) {
+		return true
+	}
+	var agg *AggregateError
+	return errors.As(err, &agg) && agg.Count(CategoryAuth) > 0
+}
+
+// writeStageDone reports a stage that got through all its input, as JSON like writeStageError's outcomes
+func writeStageDone(w http.ResponseWriter, stage string) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok", "message": stage + " completed successfully"})
+}
+
+// writeStageError reports a failed stage as JSON. A stage with no input returns 200 with {"status":"empty"},
+// partial failures return 200 with {"status":"partial"} and the summary, auth failures return 401 pointing
+// to /login, a stage that hit its timeout returns 504 with how far it got, and anything else is a 500.
+func writeStageError(w http.ResponseWriter, stage string, err error) {
+	var empty *EmptyInputError
+	if errors.As(err, &empty) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "empty", "reason": empty.Reason})
+		return
+	}
+
+	if isPartialFailure(err) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "partial", "error": fmt.Sprintf("%s completed with errors: %v", stage, err)})
+		return
+	}
+
+	switch status := stageErrorStatus(err); status {
+	case http.StatusUnauthorized:
+		writeAuthError(w, fmt.Errorf("%s failed: %w", stage, err))
+	case http.StatusGatewayTimeout:
+		writeJSONError(w, status, fmt.Sprintf("%s stopped: %v", stage, err))
+	default:
+		writeJSONError(w, status, fmt.Sprintf("%s failed: %v", stage, err))
+	}
+}
+
+// stageErrorStatus is the HTTP status a stage that returned err reports, as writeStageError does:
+// 200 for no error, empty input or partial failures, 401 for auth, 504 for a timeout, else 500
+func stageErrorStatus(err error) int {
+	switch {
+	case err == nil || isEmptyInput(err) || isPartialFailure(err):
+		return http.StatusOK
+	case isAuthFailure(err):
+		return http.StatusUnauthorized
+	case isStageTimeout(err):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
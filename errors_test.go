@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestStageErrorStatus(t *testing.T) {
+	partial := newAggregateError("parse", 3)
+	partial.Add(newItemError("m1", CategoryParse, errors.New("no ticker")))
+	allFailed := newAggregateError("parse", 1)
+	allFailed.Add(newItemError("m1", CategoryParse, errors.New("no ticker")))
+	authFailed := newAggregateError("download", 3)
+	authFailed.Add(newItemError("m1", CategoryOther, errNoToken))
+
+	tests := []struct {
+		name       string
+		err        error
+		want       int
+		wantStatus string // the body's "status", set only for outcomes that are not errors
+	}{
+		{"success", nil, http.StatusOK, ""},
+		{"empty input", nothingToDo("no emails"), http.StatusOK, "empty"},
+		{"partial failure", partial, http.StatusOK, "partial"},
+		{"every item failed", allFailed, http.StatusInternalServerError, ""},
+		{"auth", authFailed, http.StatusUnauthorized, ""},
+		{"timeout", &StageTimeoutError{Stage: "parse", Failures: partial}, http.StatusGatewayTimeout, ""},
+		{"timeout with auth failures", &StageTimeoutError{Stage: "download", Failures: authFailed}, http.StatusUnauthorized, ""},
+		{"other", errors.New("disk full"), http.StatusInternalServerError, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stageErrorStatus(tt.err); got != tt.want {
+				t.Errorf("stageErrorStatus() = %d, want %d", got, tt.want)
+			}
+			if tt.err == nil {
+				return
+			}
+			rec := httptest.NewRecorder()
+			writeStageError(rec, "Stage", tt.err)
+			if rec.Code != tt.want {
+				t.Errorf("writeStageError wrote %d, want %d", rec.Code, tt.want)
+			}
+			var body map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Header().Get("Content-Type") != "application/json" {
+				t.Fatalf("writeStageError wrote %q as %s, want JSON: %v", rec.Body, rec.Header().Get("Content-Type"), err)
+			}
+			if body["status"] != tt.wantStatus || (tt.wantStatus != "empty" && body["error"] == "") {
+				t.Errorf("writeStageError wrote %v, want status %q with the error", body, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestReprocessReportsStageTimeout(t *testing.T) {
+	db := newTestDB(t)
+	alert := gmailMessage("m1", "t1", "Trade alert", time.Date(2024, 6, 3, 13, 0, 0, 0, time.UTC),
+		gmailPart("text/html", "<p>Acme (NASDAQ: ACME) Buy at $12.50, stop at $11.00, target at $15.00</p>"))
+	if err := db.upsertFullEmailToDB(alert); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := stageTimeouts["parse"]
+	stageTimeouts["parse"] = time.Nanosecond
+	t.Cleanup(func() { stageTimeouts["parse"] = saved })
+
+	rec := httptest.NewRecorder()
+	reprocessHandler(rec, httptest.NewRequest(http.MethodPost, "/reprocess?confirm=true", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("reprocess returned %d (%s), want 504 as /parse-signals gives", rec.Code, rec.Body)
+	}
+}
+
+func TestEnrichStopsAtStageDeadline(t *testing.T) {
+	const threads = 40 // more than the enrich stage's 25 workers, so some are never started
+	tests := []struct {
+		name        string
+		hang        bool // Gmail never answers, as when the stage is stuck retrying rate limits
+		timeout     time.Duration
+		wantTimeout bool
+	}{
+		{"deadline hit", true, 100 * time.Millisecond, true},
+		{"finished before the deadline", false, time.Minute, false},
+	}
+
+	saved := stageTimeouts["enrich"]
+	t.Cleanup(func() { stageTimeouts["enrich"] = saved })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stageTimeouts["enrich"] = tt.timeout
+			db := newTestDB(t)
+			gmailAPI := &gmailThreads{}
+			ctx := fakeGmail(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.hang {
+					<-r.Context().Done()
+					return
+				}
+				gmailAPI.ServeHTTP(w, r)
+			}))
+			for i := 0; i < threads; i++ {
+				id := fmt.Sprintf("t%02d", i)
+				if err := db.saveEmailToLanding(&gmail.Message{Id: id, ThreadId: id, Snippet: "Buy ACME"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			stageCtx, cancel := stageContext(ctx, "enrich")
+			defer cancel()
+			start := time.Now()
+			err := enrichEmailsConcurrently(stageCtx, db, false)
+			if elapsed := time.Since(start); elapsed > tt.timeout+5*time.Second {
+				t.Errorf("stage ran %v past its %v deadline", elapsed, tt.timeout)
+			}
+
+			var timeout *StageTimeoutError
+			if !tt.wantTimeout {
+				if err != nil {
+					t.Fatalf("enrichEmailsConcurrently() = %v, want success", err)
+				}
+				return
+			}
+			if !errors.As(err, &timeout) || !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("enrichEmailsConcurrently() = %v, want a StageTimeoutError", err)
+			}
+			if timeout.Stage != "enrich" || timeout.Timeout != tt.timeout || timeout.Processed != 0 || timeout.Remaining == 0 ||
+				timeout.Processed+timeout.Failed+timeout.Remaining != threads {
+				t.Errorf("timeout reported %+v, want the %d threads split between failed and not started", timeout, threads)
+			}
+			if status := stageErrorStatus(err); status != http.StatusGatewayTimeout {
+				t.Errorf("stageErrorStatus() = %d, want 504", status)
+			}
+		})
+	}
+}
+
+func TestStageHandlersAnswerJSON(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		emails     bool
+		wantCode   int
+		wantStatus string
+	}{
+		{"nothing to parse", http.MethodPost, false, http.StatusOK, "empty"},
+		{"parsed", http.MethodPost, true, http.StatusOK, "ok"},
+		{"wrong method", http.MethodDelete, true, http.StatusMethodNotAllowed, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			if tt.emails {
+				saveTestEmail(t, db, "alert", "Today's Stock Pick", alertHTML)
+			}
+
+			rec := httptest.NewRecorder()
+			parseSignalsHandler(rec, httptest.NewRequest(tt.method, "/parse-signals", nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantCode)
+			}
+			var body map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Header().Get("Content-Type") != "application/json" {
+				t.Fatalf("body %q as %s, want JSON: %v", rec.Body, rec.Header().Get("Content-Type"), err)
+			}
+			if body["status"] != tt.wantStatus || (tt.wantStatus == "" && body["error"] == "") {
+				t.Errorf("body %v, want status %q", body, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestDownloadWithoutTokenAsksForLogin(t *testing.T) {
+	newTestDB(t) // no token has been saved in the temporary directory
+
+	rec := httptest.NewRecorder()
+	downloadEmailsHandler(rec, httptest.NewRequest(http.MethodPost, "/download-emails", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status %d, want 401: %s", rec.Code, rec.Body)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body %q is not JSON: %v", rec.Body, err)
+	}
+	if body["login"] != "/login" || !strings.Contains(body["error"], "no saved Gmail token") {
+		t.Errorf("body %v, want the missing token error and a link to /login", body)
+	}
+}
+
+func TestFeedJobsBeyondChannelCapacity(t *testing.T) {
+	saved := channelBufferPerWorker
+	channelBufferPerWorker = 1
+	t.Cleanup(func() { channelBufferPerWorker = saved })
+
+	const numWorkers = 2
+	items := make([]int, 10*channelCapacity(numWorkers))
+	for i := range items {
+		items[i] = i
+	}
+
+	// Workers and collector wired the way each stage wires them
+	jobs := make(chan int, channelCapacity(numWorkers))
+	results := make(chan int, channelCapacity(numWorkers))
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range jobs {
+				results <- item
+			}
+		}()
+	}
+	go feedJobs(context.Background(), jobs, items)
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	seen := make(map[int]bool, len(items))
+	timeout := time.After(5 * time.Second)
+	for len(seen) < len(items) {
+		select {
+		case item, ok := <-results:
+			if !ok {
+				t.Fatalf("results closed after %d of %d items", len(seen), len(items))
+			}
+			seen[item] = true
+		case <-timeout:
+			t.Fatalf("stuck after %d of %d items", len(seen), len(items))
+		}
+	}
+
+	// The stage end to end, with six times as many signals as its jobs channel holds
+	db := newTestDB(t)
+	const signals = 30
+	for i := 0; i < signals; i++ {
+		date := time.Date(2024, 6, 3, 13, i, 0, 0, time.UTC).UnixMilli()
+		if _, err := db.Exec(`INSERT INTO parse_buy_stop_target (email_id, ticker, signal_date, entry_date, buy_price, stop_price, target_price)
+			VALUES (?, 'ACME', ?, ?, 12.5, 11, 15)`, fmt.Sprintf("signal-%d", i), date, date); err != nil {
+			t.Fatal(err)
+		}
+	}
+	done := make(chan error, 1)
+	go func() { done <- processSignalsConcurrently(context.Background(), db) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("processSignalsConcurrently did not finish")
+	}
+	if got := len(signalIDs(t, db, "trade_signals")); got != signals {
+		t.Errorf("trade_signals holds %d signals, want %d", got, signals)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+const (
+	gmailBatchURL = "https://gmail.googleapis.com/batch/gmail/v1"
+	// gmailBatchSize is the maximum number of sub-requests Gmail accepts in one batch
+	gmailBatchSize = 100
+)
+
+// batchGetMessages fetches up to gmailBatchSize messages with a single multipart batch request.
+// Messages whose sub-request failed are reported in the returned error map.
+func batchGetMessages(ctx context.Context, client *http.Client, ids []string, format string) (map[string]*gmail.Message, map[string]error, error) {
+	if len(ids) > gmailBatchSize {
+		return nil, nil, fmt.Errorf("batch of %d exceeds limit of %d", len(ids), gmailBatchSize)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for i, id := range ids {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "application/http")
+		header.Set("Content-ID", fmt.Sprintf("<item-%d>", i))
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create batch part: %w", err)
+		}
+		fmt.Fprintf(part, "GET /gmail/v1/users/me/messages/%s?format=%s\r\n\r\n", url.PathEscape(id), url.QueryEscape(format))
+	}
+	if err := mw.Close(); err != nil {
+		return nil, nil, fmt.Errorf("failed to close batch body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, gmailBatchURL, &body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build batch request: %w", err)
+	}
+	req.Header.Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+
+	// Gmail charges quota per sub-request; the transport takes the token for the first
+	if err := gmailLimiter.Wait(ctx, len(ids)-1); err != nil {
+		return nil, nil, fmt.Errorf("batch request failed: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("batch request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, nil, fmt.Errorf("batch request returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || params["boundary"] == "" {
+		return nil, nil, fmt.Errorf("batch response has no multipart boundary: %v", err)
+	}
+
+	messages := make(map[string]*gmail.Message)
+	failures := make(map[string]error)
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return messages, failures, fmt.Errorf("failed to read batch response: %w", err)
+		}
+
+		// Responses echo the request Content-ID as <response-item-N>
+		contentID := strings.Trim(part.Header.Get("Content-ID"), "<>")
+		index, err := strconv.Atoi(strings.TrimPrefix(contentID, "response-item-"))
+		if err != nil || index < 0 || index >= len(ids) {
+			log.Printf("Skipping batch response part with unexpected Content-ID %q", contentID)
+			continue
+		}
+		id := ids[index]
+
+		subResp, err := http.ReadResponse(bufio.NewReader(part), nil)
+		if err != nil {
+			failures[id] = fmt.Errorf("failed to read batch sub-response: %w", err)
+			continue
+		}
+		data, err := io.ReadAll(subResp.Body)
+		subResp.Body.Close()
+		if err != nil {
+			failures[id] = fmt.Errorf("failed to read batch sub-response body: %w", err)
+			continue
+		}
+		if subResp.StatusCode != http.StatusOK {
+			failures[id] = fmt.Errorf("batch sub-request returned %s", subResp.Status)
+			continue
+		}
+
+		var msg gmail.Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			failures[id] = fmt.Errorf("failed to decode message: %w", err)
+			continue
+		}
+		messages[id] = &msg
+	}
+
+	return messages, failures, nil
+}
+
+// fetchFullMessages gets full messages for ids, one batch request per gmailBatchSize IDs.
+// Anything the batch did not return is fetched individually with messages.get.
+func fetchFullMessages(ctx context.Context, service *gmail.Service, client *http.Client, ids []string) (map[string]*gmail.Message, map[string]error) {
+	messages := make(map[string]*gmail.Message)
+	failures := make(map[string]error)
+
+	for start := 0; start < len(ids); start += gmailBatchSize {
+		chunk := ids[start:min(start+gmailBatchSize, len(ids))]
+
+		batch, batchFailures, err := batchGetMessages(ctx, client, chunk, "full")
+		if err != nil {
+			log.Printf("Batch get of %d messages failed, falling back to individual requests: %v", len(chunk), err)
+		} else if len(batchFailures) > 0 {
+			log.Printf("Batch get: %d/%d sub-requests failed, retrying individually", len(batchFailures), len(chunk))
+		}
+
+		for _, id := range chunk {
+			if msg, ok := batch[id]; ok {
+				messages[id] = msg
+				continue
+			}
+
+			msg, err := service.Users.Messages.Get("me", id).Format("full").Context(ctx).Do()
+			if err != nil {
+				failures[id] = fmt.Errorf("failed to get message %s: %w", id, err)
+				continue
+			}
+			messages[id] = msg
+		}
+	}
+
+	return messages, failures
+}
+
+// fetchThreadMessages gets the full messages of a thread, batched when client is non-nil.
+// Messages that could not be fetched are logged, counted as enrich errors and skipped.
+func fetchThreadMessages(ctx context.Context, workerID int, service *gmail.Service, client *http.Client, thread *gmail.Thread) []*gmail.Message {
+	var messages []*gmail.Message
+	if client != nil {
+		ids := make([]string, 0, len(thread.Messages))
+		for _, message := range thread.Messages {
+			ids = append(ids, message.Id)
+		}
+
+		fetched, failures := fetchFullMessages(ctx, service, client, ids)
+		for _, id := range ids {
+			if msg, ok := fetched[id]; ok {
+				messages = append(messages, msg)
+				continue
+			}
+			log.Printf("Worker %d: %v", workerID, failures[id])
+			enrichErrorsTotal.Inc()
+		}
+		return messages
+	}
+
+	for _, message := range thread.Messages {
+		fullMessage, err := service.Users.Messages.Get("me", message.Id).Format("full").Context(ctx).Do()
+		if err != nil {
+			log.Printf("Worker %d: failed to get full message %s: %v", workerID, message.Id, err)
+			enrichErrorsTotal.Inc()
+			continue
+		}
+		messages = append(messages, fullMessage)
+	}
+	return messages
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idempotencyHeader names the client-chosen key that makes retried pipeline POSTs safe
+const idempotencyHeader = "Idempotency-Key"
+
+// idempotentResponse is a completed response kept for replay, or a placeholder while its request runs
+type idempotentResponse struct {
+	done        bool
+	status      int
+	contentType string
+	body        []byte
+	expires     time.Time
+}
+
+// idempotencyStore remembers recent responses by route and Idempotency-Key, in memory only
+type idempotencyStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*idempotentResponse
+}
+
+// pipelineIdempotency holds the pipeline routes' recent responses for the lifetime of the server
+var pipelineIdempotency = &idempotencyStore{ttl: idempotencyTTL, entries: make(map[string]*idempotentResponse)}
+
+// begin claims key for a new request. It returns the earlier response when the key was already used,
+// and inFlight when that request has not finished yet.
+func (s *idempotencyStore) begin(key string, now time.Time) (cached *idempotentResponse, inFlight bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, entry := range s.entries {
+		if entry.done && now.After(entry.expires) {
+			delete(s.entries, k)
+		}
+	}
+
+	if entry, ok := s.entries[key]; ok {
+		return entry, !entry.done
+	}
+	s.entries[key] = &idempotentResponse{}
+	return nil, false
+}
+
+// finish keeps a successful response for the store's TTL. Other responses release the key, so a
+// retry after a failure or a 409 from the stage guard runs again.
+func (s *idempotencyStore) finish(key string, response idempotentResponse, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if response.status < 200 || response.status >= 300 {
+		delete(s.entries, key)
+		return
+	}
+	response.done = true
+	response.expires = now.Add(s.ttl)
+	s.entries[key] = &response
+}
+
+// responseRecorder passes a response through while keeping a copy of its status and body
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
+
+// withIdempotency wraps a pipeline handler so a POST repeating a recent Idempotency-Key gets the
+// first run's response instead of running the stage again. Requests without the header are unaffected.
+func withIdempotency(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if r.Method != http.MethodPost || key == "" {
+			handler(w, r)
+			return
+		}
+		key = r.URL.Path + " " + key
+
+		cached, inFlight := pipelineIdempotency.begin(key, time.Now())
+		if inFlight {
+			writeJSONError(w, http.StatusConflict, "A request with this Idempotency-Key is still running; retry when it finishes")
+			return
+		}
+		if cached != nil {
+			if cached.contentType != "" {
+				w.Header().Set("Content-Type", cached.contentType)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(cached.status)
+			w.Write(cached.body)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		defer func() {
+			pipelineIdempotency.finish(key, idempotentResponse{
+				status:      recorder.status,
+				contentType: recorder.Header().Get("Content-Type"),
+				body:        recorder.body.Bytes(),
+			}, time.Now())
+		}()
+		handler(recorder, r)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// useIdempotencyStore gives the test an empty store in place of the server's
+func useIdempotencyStore(t *testing.T) {
+	saved := pipelineIdempotency
+	pipelineIdempotency = &idempotencyStore{ttl: idempotencyTTL, entries: make(map[string]*idempotentResponse)}
+	t.Cleanup(func() { pipelineIdempotency = saved })
+}
+
+func idempotentPost(key string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/parse-signals", nil)
+	req.Header.Set(idempotencyHeader, key)
+	return req
+}
+
+func TestIdempotencyKeyRunsHandlerOnce(t *testing.T) {
+	useIdempotencyStore(t)
+
+	runs := 0
+	handler := withIdempotency(func(w http.ResponseWriter, r *http.Request) {
+		runs++
+		writeStageDone(w, "parse")
+	})
+
+	first := httptest.NewRecorder()
+	handler(first, idempotentPost("abc"))
+	second := httptest.NewRecorder()
+	handler(second, idempotentPost("abc"))
+
+	if runs != 1 {
+		t.Fatalf("handler ran %d times, want 1", runs)
+	}
+	if second.Code != first.Code || second.Body.String() != first.Body.String() {
+		t.Errorf("replay = %d %q, want %d %q", second.Code, second.Body.String(), first.Code, first.Body.String())
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("replayed response is missing the Idempotent-Replayed header")
+	}
+
+	// A different key runs the stage again
+	handler(httptest.NewRecorder(), idempotentPost("def"))
+	if runs != 2 {
+		t.Errorf("handler ran %d times after a new key, want 2", runs)
+	}
+}
+
+func TestConflictResponsesShareShape(t *testing.T) {
+	useIdempotencyStore(t)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}
+	done := make(chan struct{})
+	go func() {
+		withIdempotency(withStageGuard(slow, "parse"))(httptest.NewRecorder(), idempotentPost("abc"))
+		close(done)
+	}()
+	<-started
+	defer func() {
+		close(release)
+		<-done
+	}()
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		req     *http.Request
+	}{
+		{"same Idempotency-Key", withIdempotency(func(http.ResponseWriter, *http.Request) {}), idempotentPost("abc")},
+		{"stage already running", withStageGuard(func(http.ResponseWriter, *http.Request) {}, "parse"), httptest.NewRequest(http.MethodPost, "/parse-signals", nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler(rec, tt.req)
+			if rec.Code != http.StatusConflict {
+				t.Fatalf("status = %d, want 409", rec.Code)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			var body map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body %q is not JSON: %v", rec.Body.String(), err)
+			}
+			if len(body) != 1 || body["error"] == "" {
+				t.Errorf("body = %v, want only an error field", body)
+			}
+		})
+	}
+}
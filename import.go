@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// maxImportBytes bounds the .mbox or .eml file accepted by /import
+const maxImportBytes = 64 << 20
+
+// importIDPrefix marks emails rows that came from /import rather than Gmail
+const importIDPrefix = "imp-"
+
+// mboxFromRe matches the "From " separator line that starts each message in an mbox file
+var mboxFromRe = regexp.MustCompile(`(?m)^From .*\r?\n`)
+
+// mboxEscapedFromRe matches a body line that mbox escaped as ">From ", possibly more than once
+var mboxEscapedFromRe = regexp.MustCompile(`(?m)^>(>*From )`)
+
+// ImportResult is the /import response
+type ImportResult struct {
+	Format   string   `json:"format"` // "mbox" or "eml"
+	Messages int      `json:"messages"`
+	Imported int      `json:"imported"`
+	IDs      []string `json:"ids"`              // emails.id of each imported message
+	Errors   []string `json:"errors,omitempty"` // messages that could not be read or saved
+}
+
+// splitMbox returns the messages of an mbox file, with ">From " escapes undone
+func splitMbox(data []byte) [][]byte {
+	var messages [][]byte
+	locs := mboxFromRe.FindAllIndex(data, -1)
+	for i, loc := range locs {
+		end := len(data)
+		if i+1 < len(locs) {
+			end = locs[i+1][0]
+		}
+		message := mboxEscapedFromRe.ReplaceAll(data[loc[1]:end], []byte("$1"))
+		if len(bytes.TrimSpace(message)) > 0 {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+// importID derives a stable emails.id from a Message-ID, so importing the same file twice updates
+// the same rows. Messages without one are keyed by their content.
+func importID(messageID string, raw []byte) string {
+	key := []byte(strings.Trim(strings.TrimSpace(messageID), "<>"))
+	if len(key) == 0 {
+		key = raw
+	}
+	sum := sha256.Sum256(key)
+	return importIDPrefix + hex.EncodeToString(sum[:8])
+}
+
+// threadRoot returns the Message-ID that starts a message's thread: the first References entry,
+// then In-Reply-To, then the message's own ID
+func threadRoot(header mail.Header) string {
+	if refs := strings.Fields(header.Get("References")); len(refs) > 0 {
+		return refs[0]
+	}
+	if inReplyTo := strings.TrimSpace(header.Get("In-Reply-To")); inReplyTo != "" {
+		return inReplyTo
+	}
+	return header.Get("Message-Id")
+}
+
+// decodeTransfer undoes a part's Content-Transfer-Encoding
+func decodeTransfer(encoding string, body io.Reader) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		// The standard decoder skips the line breaks base64 bodies are wrapped with
+		return io.ReadAll(base64.NewDecoder(base64.StdEncoding, body))
+	case "quoted-printable":
+		return io.ReadAll(quotedprintable.NewReader(body))
+	default:
+		return io.ReadAll(body)
+	}
+}
+
+// messagePartFromMIME converts a MIME entity into the gmail.MessagePart shape the enrichment code
+// reads, with each leaf body base64url-encoded as Gmail returns it
+func messagePartFromMIME(header textproto.MIMEHeader, body io.Reader) (*gmail.MessagePart, error) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", nil // RFC 2045's default for a missing or malformed type
+	}
+	part := &gmail.MessagePart{MimeType: mediaType, Filename: params["name"]}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			child, err := reader.NextRawPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s part: %w", mediaType, err)
+			}
+			childPart, err := messagePartFromMIME(child.Header, child)
+			if err != nil {
+				return nil, err
+			}
+			part.Parts = append(part.Parts, childPart)
+		}
+		return part, nil
+	}
+
+	decoded, err := decodeTransfer(header.Get("Content-Transfer-Encoding"), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s body: %w", mediaType, err)
+	}
+	part.Body = &gmail.MessagePartBody{Data: base64.URLEncoding.EncodeToString(decoded), Size: int64(len(decoded))}
+	return part, nil
+}
+
+// messageFromRFC822 builds a gmail.Message from one RFC 822 message, so it can be stored by
+// upsertFullEmailToDB exactly like a downloaded one
+func messageFromRFC822(raw []byte) (*gmail.Message, error) {
+	parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+
+	payload, err := messagePartFromMIME(textproto.MIMEHeader(parsed.Header), parsed.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Headers in a stable order, with RFC 2047 encoded words such as =?UTF-8?Q?...?= decoded
+	decoder := new(mime.WordDecoder)
+	names := make([]string, 0, len(parsed.Header))
+	for name := range parsed.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range parsed.Header[name] {
+			if decodedValue, err := decoder.DecodeHeader(value); err == nil {
+				value = decodedValue
+			}
+			payload.Headers = append(payload.Headers, &gmail.MessagePartHeader{Name: name, Value: value})
+		}
+	}
+
+	msg := &gmail.Message{
+		Id:       importID(parsed.Header.Get("Message-Id"), raw),
+		ThreadId: importID(threadRoot(parsed.Header), raw),
+		Payload:  payload,
+	}
+	if date, err := parsed.Header.Date(); err == nil {
+		msg.InternalDate = date.UnixMilli()
+	}
+
+	// A Gmail-style snippet: the start of the plain text on one line
+	text := extractPlainTextFromPart(payload)
+	if text == "" {
+		text = htmlToPlainText(extractHTMLFromPart(payload))
+	}
+	snippet := []rune(strings.Join(strings.Fields(text), " "))
+	msg.Snippet = string(snippet[:min(len(snippet), 200)])
+	return msg, nil
+}
+
+// readImportUpload returns the uploaded file: the "file" field of a multipart form, or the raw request body
+func readImportUpload(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, fmt.Errorf("multipart upload needs a \"file\" field: %w", err)
+		}
+		defer file.Close()
+		return io.ReadAll(file)
+	}
+	return io.ReadAll(r.Body)
+}
+
+// importHandler serves POST /import: an uploaded .mbox or .eml file is read without Gmail and each
+// message upserted into emails, ready for /parse-signals
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	data, err := readImportUpload(w, r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("failed to read upload (at most %d bytes): %v", maxImportBytes, err))
+		return
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "upload is empty")
+		return
+	}
+
+	// An mbox file starts with its first message's "From " separator; anything else is one message
+	result := ImportResult{Format: "eml", IDs: []string{}}
+	messages := [][]byte{data}
+	if bytes.HasPrefix(data, []byte("From ")) {
+		result.Format = "mbox"
+		messages = splitMbox(data)
+	}
+	result.Messages = len(messages)
+
+	db, err := setupDatabase()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
+		return
+	}
+	defer db.Close()
+
+	for i, raw := range messages {
+		msg, err := messageFromRFC822(raw)
+		if err == nil {
+			err = db.upsertFullEmailToDB(msg)
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("message %d: %v", i+1, err))
+			continue
+		}
+		result.Imported++
+		result.IDs = append(result.IDs, msg.Id)
+	}
+	log.Printf("Import (%s): %d/%d messages saved to emails, %d errors", result.Format, result.Imported, result.Messages, len(result.Errors))
+
+	status := http.StatusOK
+	if result.Imported == 0 {
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, result)
+}
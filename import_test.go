@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportEML(t *testing.T) {
+	eml, err := os.ReadFile("testdata/alert.eml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := newTestDB(t)
+
+	// Importing the same file twice updates the one row its Message-ID keys
+	var result ImportResult
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		importHandler(rec, httptest.NewRequest(http.MethodPost, "/import", bytes.NewReader(eml)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("POST /import = %d %s", rec.Code, rec.Body.String())
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		if result.Format != "eml" || result.Messages != 1 || result.Imported != 1 || len(result.Errors) != 0 {
+			t.Fatalf("import result = %+v, want one eml message imported", result)
+		}
+	}
+	var rows int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM emails`).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 1 {
+		t.Errorf("emails holds %d rows after importing twice, want 1", rows)
+	}
+
+	var subject, fromName, fromAddress, textBody string
+	var date time.Time
+	if err := db.QueryRow(`SELECT subject, from_name, from_address, text_body, date FROM emails WHERE id = ?`, result.IDs[0]).
+		Scan(&subject, &fromName, &fromAddress, &textBody, &date); err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Trade alert – ACME" {
+		t.Errorf("subject = %q, want the decoded Trade alert – ACME", subject)
+	}
+	if fromName != "Trade Alerts" || fromAddress != "alerts@example.com" {
+		t.Errorf("sender = %q <%s>, want Trade Alerts <alerts@example.com>", fromName, fromAddress)
+	}
+	if want := time.Date(2024, 6, 3, 13, 0, 0, 0, time.UTC); !date.Equal(want) {
+		t.Errorf("date = %v, want %v", date, want)
+	}
+	if !strings.Contains(textBody, "stop at $11.00, target") {
+		t.Errorf("text_body = %q, want the quoted-printable soft break joined", textBody)
+	}
+
+	// The imported message parses like a downloaded one
+	if err := parseSignalsConcurrently(context.Background(), db, ParseOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	var ticker string
+	var buy, stop, target float64
+	if err := db.QueryRow(`SELECT ticker, buy_price, stop_price, target_price FROM parse_buy_stop_target WHERE email_id = ?`, result.IDs[0]).
+		Scan(&ticker, &buy, &stop, &target); err != nil {
+		t.Fatal(err)
+	}
+	if ticker != "ACME" || buy != 12.5 || stop != 11 || target != 15 {
+		t.Errorf("parsed %s %g/%g/%g, want ACME 12.5/11/15", ticker, buy, stop, target)
+	}
+}
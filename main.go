@@ -1,10 +1,19 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"runtime"
+	"strconv"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -17,23 +26,56 @@ const (
 	tokenFile       = ".credentials/token.json"
 	dbFile          = "backteststoxx_emails.db"
 	targetSender    = "drstoxx@drstoxx.com"
+
+	// defaultRedirectURI is the local callback server, used for installed clients and web clients without redirect_uris
+	defaultRedirectURI = "http://localhost:8080/oauth/callback"
+
+	maxGmailQueryLength = 1024
+	maxDownloadMessages = 100000
 )
 
 // Global configuration variable
 var config *oauth2.Config
 
+// Build information, set at build time with
+// -ldflags "-X main.version=v1.2.0 -X main.gitCommit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	gitCommit = "dev"
+	buildTime = "dev"
+)
+
+// BuildInfo identifies the running build
+type BuildInfo struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"git_commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// currentBuildInfo returns the build information embedded at link time
+func currentBuildInfo() BuildInfo {
+	return BuildInfo{Version: version, GitCommit: gitCommit, BuildTime: buildTime, GoVersion: runtime.Version()}
+}
+
 // Type definitions
+
+// ClientCredentials holds the client fields shared by "web" and "installed" credential files
+type ClientCredentials struct {
+	ClientID                string   `json:"client_id"`
+	ProjectID               string   `json:"project_id"`
+	AuthURI                 string   `json:"auth_uri"`
+	TokenURI                string   `json:"token_uri"`
+	AuthProviderX509CertURL string   `json:"auth_provider_x509_cert_url"`
+	ClientSecret            string   `json:"client_secret"`
+	RedirectURIs            []string `json:"redirect_uris"`
+	JavascriptOrigins       []string `json:"javascript_origins,omitempty"`
+}
+
+// CredentialInfo is a Google OAuth client file; exactly one of Web or Installed (desktop) is set
 type CredentialInfo struct {
-	Web struct {
-		ClientID                string   `json:"client_id"`
-		ProjectID               string   `json:"project_id"`
-		AuthURI                 string   `json:"auth_uri"`
-		TokenURI                string   `json:"token_uri"`
-		AuthProviderX509CertURL string   `json:"auth_provider_x509_cert_url"`
-		ClientSecret            string   `json:"client_secret"`
-		RedirectURIs            []string `json:"redirect_uris"`
-		JavascriptOrigins       []string `json:"javascript_origins,omitempty"`
-	} `json:"web"`
+	Web       *ClientCredentials `json:"web,omitempty"`
+	Installed *ClientCredentials `json:"installed,omitempty"`
 }
 
 type OAuthClientInfo struct {
@@ -63,27 +105,156 @@ type EmailSignal struct {
 	ThreadID string
 	Subject  string
 	Date     time.Time
-	HTML     string
+	HTML     string   // HTML body, or the plain-text body when the email has no HTML
+	Labels   []string // Gmail label IDs, e.g. INBOX or CATEGORY_PROMOTIONS
+	Text     string   // HTML with tags stripped, set by dropEmptyEmails for the classifier and parser; "" until then
 }
 
 type TradingSignal struct {
 	EmailID     string
 	Ticker      string
-	SignalDate  int64
+	Company     string // name written before "(NASDAQ: TICKER)", e.g. "Acme Corp"; empty when none
+	EmailDate   int64  // when the email was sent
+	SignalDate  int64  // effective signal date: the email date, or a trade date stated in the body
 	EntryDate   int64
 	BuyPrice    float64
 	StopPrice   float64
 	TargetPrice float64
+	Targets     []float64 // scaled targets in order, e.g. [55 60] for "Target 1: 55, Target 2: 60"; nil for one target
+	Currency    string    // ISO code the prices were quoted in, e.g. "GBP" for "£12.50"; prices are in USD once converted
+	RiskPct     float64   // stated account risk in percent, e.g. 1.5 for "risk 1.5%"; 0 when none
+	Notes       string    // risk and position sizing phrases, e.g. "risk 2%; half position"
+
+	// Provenance: which parser and pattern produced each field (e.g. "go:buy_at", "sql:at_segment")
+	TickerSource string
+	BuySource    string
+	StopSource   string
+	TargetSource string
 }
 
 type CleanSignal struct {
 	EmailID     string
 	Ticker      string
+	Company     string
+	EmailDate   int64
 	SignalDate  int64
 	EntryDate   int64
 	BuyPrice    float64
 	StopPrice   float64
 	TargetPrice float64
+	Targets     []float64 // scaled targets in order; nil when the email gave one target
+	RiskPct     float64   // 0 when the email stated none
+	Notes       string
+	Currency    string // the currency the email quoted; prices are in USD
+
+	TickerSource string
+	BuySource    string
+	StopSource   string
+	TargetSource string
+}
+
+// ParseRun is one row of the parse_runs audit log
+type ParseRun struct {
+	ID         int64          `json:"id"`
+	Parser     string         `json:"parser"` // go or sql
+	StartedAt  time.Time      `json:"started_at"`
+	DurationMs int64          `json:"duration_ms"`
+	Emails     int            `json:"emails"`
+	Signals    int            `json:"signals"`
+	Errors     int            `json:"errors"`
+	NoContent  int            `json:"no_content"` // emails with no text left after stripping tags
+	Rejections map[string]int `json:"rejections,omitempty"` // signals failing validation, by reason (e.g. inverted)
+	Error      string         `json:"error,omitempty"`
+}
+
+// StoredEmail is an emails row as served by /emails/{id}, without the raw payload
+type StoredEmail struct {
+	ID                string    `json:"id"`
+	ThreadID          string    `json:"thread_id"`
+	Subject           string    `json:"subject"`
+	Date              time.Time `json:"date"`
+	FromName          string    `json:"from_name"`
+	FromAddress       string    `json:"from_address"`
+	ToAddress         string    `json:"to_address"`
+	ReplyTo           string    `json:"reply_to"`
+	Labels            []string  `json:"labels"`
+	Snippet           string    `json:"snippet"`
+	HTML              string    `json:"html"`
+	TextBody          string    `json:"text_body"`
+	CleanText         string    `json:"clean_text"`
+	NormalizedSubject string    `json:"normalized_subject"` // subject without RE:/FWD:/[RESEND] prefixes, lowercased
+	Ignored           bool      `json:"ignored"`            // excluded from parsing and enrichment by POST /emails/{id}/ignore
+}
+
+// ThreadEnrichment is a thread_enrichment row as served by /threads/incomplete
+type ThreadEnrichment struct {
+	ThreadID         string    `json:"thread_id"`
+	ReportedMessages int       `json:"reported_messages"` // len(thread.Messages) from Gmail, capped at ENRICH_MESSAGES_PER_THREAD
+	SavedMessages    int       `json:"saved_messages"`
+	EnrichedAt       time.Time `json:"enriched_at"`
+}
+
+// EmailExport is one line of the /emails.jsonl export
+type EmailExport struct {
+	ID          string    `json:"id"`
+	ThreadID    string    `json:"thread_id"`
+	Subject     string    `json:"subject"`
+	Date        time.Time `json:"date"`
+	FromAddress string    `json:"from_address"`
+	HTML        string    `json:"html"`
+}
+
+// ParsedSignal is a parse_buy_stop_target row
+type ParsedSignal struct {
+	Ticker       string    `json:"ticker"`
+	Company      string    `json:"company,omitempty"`
+	EmailDate    int64     `json:"email_date"`  // milliseconds, when the email was sent
+	SignalDate   int64     `json:"signal_date"` // milliseconds, effective
+	EntryDate    int64     `json:"entry_date"`  // milliseconds
+	BuyPrice     float64   `json:"buy_price"`
+	StopPrice    float64   `json:"stop_price"`
+	TargetPrice  float64   `json:"target_price"`
+	Targets      []float64 `json:"targets,omitempty"` // scaled targets in order, the first being target_price
+	RiskPct      float64   `json:"risk_pct,omitempty"`
+	Notes        string    `json:"notes,omitempty"`
+	Currency     string    `json:"currency"` // the currency the email quoted; prices are in USD
+	TickerSource string    `json:"ticker_source"`
+	BuySource    string    `json:"buy_source"`
+	StopSource   string    `json:"stop_source"`
+	TargetSource string    `json:"target_source"`
+	RawHTML      string    `json:"raw_html"` // the cleaned text the parser read
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// ParseOneResult is what the Go parser extracts from one email posted to /parse-one.
+// Fields are filled even when validation rejects the signal, so partial matches can be inspected.
+type ParseOneResult struct {
+	Valid           bool      `json:"valid"`
+	Rejection       string    `json:"rejection,omitempty"`        // why validation failed
+	RejectionReason string    `json:"rejection_reason,omitempty"` // the key parse_runs counts it under, e.g. inverted
+	Ticker          string    `json:"ticker"`
+	Company         string    `json:"company,omitempty"`
+	EmailDate       int64     `json:"email_date"`  // milliseconds, when the email was sent
+	SignalDate      int64     `json:"signal_date"` // milliseconds, effective
+	EntryDate       int64     `json:"entry_date"`  // milliseconds
+	BuyPrice        float64   `json:"buy_price"`
+	StopPrice       float64   `json:"stop_price"`
+	TargetPrice     float64   `json:"target_price"`
+	Targets         []float64 `json:"targets,omitempty"` // scaled targets in order, the first being target_price
+	RiskPct         float64   `json:"risk_pct,omitempty"`
+	Notes           string    `json:"notes,omitempty"`
+	Currency        string    `json:"currency,omitempty"` // the currency the email quoted; prices are in USD once converted
+	TickerSource    string    `json:"ticker_source"`
+	BuySource       string    `json:"buy_source"`
+	StopSource      string    `json:"stop_source"`
+	TargetSource    string    `json:"target_source"`
+	CleanedText     string    `json:"cleaned_text"`
+}
+
+// EmailDetail pairs a stored email with its parsed signal, if it produced one
+type EmailDetail struct {
+	Email  StoredEmail   `json:"email"`
+	Parsed *ParsedSignal `json:"parsed"`
 }
 
 // min returns the minimum of two integers
@@ -125,6 +296,8 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
             <h3>🔧 Setup & Authentication</h3>
             <p>First, you need to authenticate with Gmail API:</p>
             <a href="/login" class="button">🔐 Login with Gmail</a>
+            <a href="/auth/status" class="button secondary">🔎 Token Status</a>
+            <a href="/auth/profile" class="button secondary">👤 Account Profile</a>
         </div>
 
         <div class="info">
@@ -132,12 +305,12 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
             <p>Process emails through the complete pipeline:</p>
             
             <div class="endpoint">
-                <strong>1. Download Emails:</strong> POST /download-emails<br>
-                <small>Downloads all emails from drstoxx@drstoxx.com to email_landing table</small>
+                <strong>1. Download Emails:</strong> POST /download-emails?q=&lt;gmail query&gt;&amp;provider=NAME[,NAME]&amp;since=YYYY-MM-DD&amp;maxMessages=N&amp;resume=true|false<br>
+                <small>Downloads all emails matching the query (default: from any PROVIDERS sender, or only the named providers') to email_landing table; an interrupted or partly failed run resumes with the messages it has not saved yet unless resume=false</small>
             </div>
             
             <div class="endpoint">
-                <strong>2. Enrich Emails:</strong> POST /enrich-emails<br>
+                <strong>2. Enrich Emails:</strong> POST /enrich-emails[?force=true]<br>
                 <small>Fetches full email content and saves to emails table</small>
             </div>
             
@@ -148,7 +321,7 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
             
             <div class="endpoint">
                 <strong>3. Parse Signals (Go):</strong> POST /parse-signals<br>
-                <small>Extracts trading signals from email HTML using Go parsing logic</small>
+                <small>Extracts trading signals from email HTML using Go parsing logic (only emails not parsed by an earlier run; add ?all=true to re-parse every email, ?source=raw to re-parse stored raw messages, ?onlyFailed=true to retry emails whose last parse failed)</small>
             </div>
             
             <div class="endpoint">
@@ -156,10 +329,110 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
                 <small>⭐ Extracts trading signals using proven SQL parsing logic</small>
             </div>
             
+            <div class="endpoint">
+                <strong>Import:</strong> POST /import with an .mbox or .eml file (raw body or multipart "file" field)<br>
+                <small>Saves each message to emails without Gmail, ready for /parse-signals; re-importing a file updates the same rows</small>
+            </div>
+            
+            <div class="endpoint">
+                <strong>Price Bars:</strong> POST /price-bars?ticker=ACME with a CSV of daily bars (raw body or multipart "file" field)<br>
+                <small>Loads the bars /backtest trades against; needs date, open, high, low and close columns, plus ticker when the file covers several tickers. A yfinance download loads as is</small>
+            </div>
+            
+            <div class="endpoint">
+                <strong>Parse One:</strong> POST /parse-one<br>
+                <small>Runs the Go parser over one pasted email (raw HTML or {"html": ...} JSON) and returns every extracted field and its source; nothing is stored</small>
+            </div>
+            
             <div class="endpoint">
                 <strong>4. Process Signals:</strong> POST /process-signals<br>
                 <small>Processes clean signals to trade_signals table with uniqueness</small>
             </div>
+            
+            <div class="endpoint">
+                <strong>Maintenance:</strong> POST /maintenance/vacuum<br>
+                <small>Checkpoints and truncates the WAL, then VACUUMs the database; refused while any pipeline stage is running</small>
+            </div>
+            
+            <div class="endpoint">
+                <strong>Parse Runs:</strong> GET /parse-runs[?limit=N]<br>
+                <small>History of Go and SQL parsing runs: emails examined, signals produced, errors and duration</small>
+            </div>
+            
+            <div class="endpoint">
+                <strong>Parser Diff:</strong> GET /parse/diff[?limit=N]<br>
+                <small>Runs the Go and SQL parsers over the N most recent signal emails (default 200) without saving anything, and lists the emails where they disagree on the ticker or a price, with both readings side by side</small>
+            </div>
+            
+            <div class="endpoint">
+                <strong>Refresh Email:</strong> POST /emails/{id}/refresh<br>
+                <small>Re-downloads one message from Gmail, saves it over the stored email and returns the refreshed record</small>
+            </div>
+            
+            <div class="endpoint">
+                <strong>Ignore Email:</strong> POST /emails/{id}/ignore[?ignored=false]<br>
+                <small>Marks a known junk email so enrichment and parsing skip it from now on, deleting the signals it already produced; ignored=false takes the mark off</small>
+            </div>
+            
+            <div class="endpoint">
+                <strong>Search Emails:</strong> GET /emails/search?q=&lt;words&gt;[&amp;limit=N&amp;offset=N]<br>
+                <small>Emails whose subject or text contains every word, with a snippet around the match; uses an FTS5 index when built with -tags sqlite_fts5</small>
+            </div>
+            
+            <div class="endpoint">
+                <strong>Incomplete Threads:</strong> GET /threads/incomplete<br>
+                <small>Threads whose last enrichment saved fewer messages than Gmail reported</small>
+            </div>
+            
+            <div class="endpoint">
+                <strong>Signal Anomalies:</strong> GET /signals/anomalies[?max_rr=10&amp;min_rr=0.2&amp;min_repeat=3]<br>
+                <small>Read-only QA of trade_signals: absurd reward:risk, equal buy/stop/target, repeated round prices and tickers with no exchange mention or price data, each with its reasons</small>
+            </div>
+            
+            <div class="endpoint">
+                <strong>Latest Signal:</strong> GET /signals/latest[?since=UNIX_MS&amp;provider=NAME]<br>
+                <small>The newest trade_signals row by signal date, or with since every signal dated after it (oldest first), for alerting integrations to poll; 204 when there is none</small>
+            </div>
+            
+            <div class="endpoint">
+                <strong>Prune Signals:</strong> POST /signals/prune?before=YYYY-MM-DD&amp;confirm=true<br>
+                <small>Moves trade signals dated before the cutoff into signals_archive and returns how many were archived; /process-signals does not bring them back</small>
+            </div>
+            
+            <div class="endpoint">
+                <strong>Run All:</strong> POST /run-all[?skipDownload=true&amp;force=true]<br>
+                <small>Runs download, enrich, parse and process in order, stopping at the first failed stage; returns a JSON summary per stage</small>
+            </div>
+            
+            <div class="endpoint">
+                <strong>Reprocess:</strong> POST /reprocess?confirm=true<br>
+                <small>Clears parse_buy_stop_target and trade_signals, then re-runs parsing and processing from stored emails</small>
+            </div>
+            
+            <div class="endpoint">
+                <strong>5. Backtest:</strong> GET /backtest?resolution=stop_first|target_first|proportional&amp;slippageBps=N&amp;commissionPerShare=N&amp;riskPct=N&amp;from=YYYY-MM-DD&amp;to=YYYY-MM-DD&amp;cutoff=YYYY-MM-DD&amp;provider=NAME&amp;benchmark=SPY<br>
+                <small>Simulates trade_signals against daily bars in price_bars (same-bar stop/target defaults to stop_first); cutoff returns separate in-sample and out-of-sample results; benchmark adds that ticker's buy-and-hold return, CAGR and the strategy's excess return</small>
+            </div>
+            
+            <div class="endpoint">
+                <strong>Backtest by Ticker:</strong> GET /backtest/by-ticker?minTrades=N<br>
+                <small>Per-ticker trade count, win rate, average R and net return, sorted by net return</small>
+            </div>
+            
+            <div class="endpoint">
+                <strong>Backtest Trades:</strong> GET /backtest/trades?hash=H|&lt;backtest params&gt;[&amp;format=csv]<br>
+                <small>Per-trade ledger (entry/exit dates and prices, exit reason, R multiple, P&amp;L) saved by the backtest run with those parameters</small>
+            </div>
+            
+            <div class="endpoint">
+                <strong>Backtest Compare:</strong> POST /backtest/compare with [{"resolution":"stop_first"}, {"resolution":"target_first","slippageBps":5}]<br>
+                <small>Runs each parameter set (named as in /backtest, sharing from/to/provider) over the same signals and returns every summary plus each key metric side by side with its change from the first set</small>
+            </div>
+            
+            <div class="endpoint">
+                <strong>Backtest Monte Carlo:</strong> POST /backtest/monte-carlo?iterations=1000&amp;seed=1&amp;method=bootstrap|shuffle&amp;ruinPct=50&amp;&lt;backtest params&gt;<br>
+                <small>Resamples the backtest's completed trade returns (capped at 10000 iterations, seeded for repeatable runs) and returns p5/p50/p95 of final return and max drawdown plus the probability of ruin</small>
+            </div>
         </div>
 
         <div class="info">
@@ -238,117 +511,736 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 
 func downloadEmailsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost && r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	opts, err := parseDownloadOptions(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	db, err := setupDatabase()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Database setup failed: %v", err), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
 		return
 	}
 	defer db.Close()
 
-	if err := downloadAllEmailsConcurrently(db); err != nil {
-		http.Error(w, fmt.Sprintf("Email download failed: %v", err), http.StatusInternalServerError)
+	ctx, cancel := stageContext(r.Context(), "download")
+	defer cancel()
+
+	start := time.Now()
+	err = downloadAllEmailsConcurrently(ctx, db, opts)
+	finishStage(ctx, db, "download", "email_landing", start, err)
+	if err != nil {
+		writeStageError(w, "Email download", err)
 		return
 	}
 
-	fmt.Fprint(w, "Email download completed successfully")
+	writeStageDone(w, "Email download")
 }
 
 func enrichEmailsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost && r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	db, err := setupDatabase()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Database setup failed: %v", err), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
 		return
 	}
 	defer db.Close()
 
-	if err := enrichEmailsConcurrently(db); err != nil {
-		http.Error(w, fmt.Sprintf("Email enrichment failed: %v", err), http.StatusInternalServerError)
+	ctx, cancel := stageContext(r.Context(), "enrich")
+	defer cancel()
+
+	start := time.Now()
+	err = enrichEmailsConcurrently(ctx, db, r.URL.Query().Get("force") == "true")
+	finishStage(ctx, db, "enrich", "emails", start, err)
+	if err != nil {
+		writeStageError(w, "Email enrichment", err)
 		return
 	}
 
-	fmt.Fprint(w, "Email enrichment completed successfully")
+	writeStageDone(w, "Email enrichment")
 }
 
 func parseSignalsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost && r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	db, err := setupDatabase()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Database setup failed: %v", err), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
 		return
 	}
 	defer db.Close()
 
-	if err := parseSignalsConcurrently(db); err != nil {
-		http.Error(w, fmt.Sprintf("Signal parsing failed: %v", err), http.StatusInternalServerError)
+	ctx, cancel := stageContext(r.Context(), "parse")
+	defer cancel()
+
+	start := time.Now()
+	err = parseSignalsConcurrently(ctx, db, parseOptionsFrom(r.URL.Query()))
+	finishStage(ctx, db, "parse", "parse_buy_stop_target", start, err)
+	if err != nil {
+		writeStageError(w, "Signal parsing", err)
 		return
 	}
 
-	fmt.Fprint(w, "Signal parsing completed successfully")
+	writeStageDone(w, "Signal parsing")
 }
 
 func processSignalsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost && r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	db, err := setupDatabase()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Database setup failed: %v", err), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
 		return
 	}
 	defer db.Close()
 
-	if err := processSignalsConcurrently(db); err != nil {
-		http.Error(w, fmt.Sprintf("Signal processing failed: %v", err), http.StatusInternalServerError)
+	ctx, cancel := stageContext(r.Context(), "process")
+	defer cancel()
+
+	start := time.Now()
+	err = processSignalsConcurrently(ctx, db)
+	finishStage(ctx, db, "process", "trade_signals", start, err)
+	if err != nil {
+		writeStageError(w, "Signal processing", err)
 		return
 	}
 
-	fmt.Fprint(w, "Signal processing completed successfully")
+	writeStageDone(w, "Signal processing")
 }
 
 func enrichEmailsV1_2Handler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost && r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	db, err := setupDatabase()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
+		return
+	}
+	defer db.Close()
+
+	ctx, cancel := stageContext(r.Context(), "enrich_v1_2")
+	defer cancel()
+
+	start := time.Now()
+	err = enrichEmailsV1_2Concurrently(ctx, db)
+	finishStage(ctx, db, "enrich_v1_2", "emails_v1_2", start, err)
+	if err != nil {
+		writeStageError(w, "emails_v1_2 enrichment", err)
+		return
+	}
+
+	writeStageDone(w, "emails_v1_2 enrichment")
+}
+
+// emailHandler serves GET /emails/{id} as JSON, or the stored HTML alone with ?raw=true,
+// POST /emails/{id}/refresh and POST /emails/{id}/ignore
+func emailHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/emails/")
+	if refreshID, ok := strings.CutSuffix(id, "/refresh"); ok {
+		emailRefreshHandler(w, r, refreshID)
+		return
+	}
+	if ignoreID, ok := strings.CutSuffix(id, "/ignore"); ok {
+		emailIgnoreHandler(w, r, ignoreID)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if id == "" || strings.Contains(id, "/") {
+		writeJSONError(w, http.StatusNotFound, "Use /emails/{id}")
+		return
+	}
+
+	db, err := setupDatabase()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
+		return
+	}
+	defer db.Close()
+
+	email, err := db.getStoredEmail(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("Email %s not found", id))
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if r.URL.Query().Get("raw") == "true" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, email.HTML)
+		return
+	}
+
+	parsed, err := db.getParsedSignal(id)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, EmailDetail{Email: *email, Parsed: parsed})
+}
+
+// emailRefreshHandler re-downloads one email from Gmail, saves it over the stored row and returns it
+// as GET /emails/{id} would. Parsed signals are left alone; changed content is picked up by the next parse.
+func emailRefreshHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if id == "" || strings.Contains(id, "/") {
+		writeJSONError(w, http.StatusNotFound, "Use /emails/{id}/refresh")
+		return
+	}
+
+	db, err := setupDatabase()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
+		return
+	}
+	defer db.Close()
+
+	if err := refreshSingleEmail(r.Context(), db, id); err != nil {
+		switch {
+		case isGmailNotFound(err):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		case isAuthError(err):
+			writeAuthError(w, err)
+		default:
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	email, err := db.getStoredEmail(id)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	parsed, err := db.getParsedSignal(id)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, EmailDetail{Email: *email, Parsed: parsed})
+}
+
+// emailIgnoreHandler marks one email as junk so every later stage skips it, and returns it as GET /emails/{id}
+// would. ?ignored=false takes the mark off again.
+func emailIgnoreHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if id == "" || strings.Contains(id, "/") {
+		writeJSONError(w, http.StatusNotFound, "Use /emails/{id}/ignore")
+		return
+	}
+
+	ignored := true
+	if raw := r.URL.Query().Get("ignored"); raw != "" {
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("ignored must be true or false: %q", raw))
+			return
+		}
+		ignored = value
+	}
+
+	db, err := setupDatabase()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
+		return
+	}
+	defer db.Close()
+
+	if err := db.setEmailIgnored(id, ignored); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, fmt.Sprintf("Email %s not found", id))
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("Email %s ignored: %t", id, ignored)
+
+	email, err := db.getStoredEmail(id)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	parsed, err := db.getParsedSignal(id)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, EmailDetail{Email: *email, Parsed: parsed})
+}
+
+// maxParseOneBytes bounds the email accepted by /parse-one
+const maxParseOneBytes = 1 << 20
+
+// parseOneHandler runs the Go parser over one email in the request body, given as raw HTML or as
+// {"html": "...", "date": "RFC 3339 time"} JSON. Nothing is stored and Gmail is not called.
+func parseOneHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxParseOneBytes))
+	if err != nil {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("email must be at most %d bytes", maxParseOneBytes))
+		return
+	}
+
+	email := EmailSignal{ID: "parse-one", HTML: string(body), Date: time.Now()}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var req struct {
+			HTML string    `json:"html"`
+			Date time.Time `json:"date"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+			return
+		}
+		email.HTML = req.HTML
+		if !req.Date.IsZero() {
+			email.Date = req.Date
+		}
+	}
+
+	signal, cleanedText, rejection, err := extractSignalFields(email)
+	if errors.Is(err, errNoContent) {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	result := ParseOneResult{
+		Valid:        rejection == nil,
+		Ticker:       signal.Ticker,
+		Company:      signal.Company,
+		EmailDate:    signal.EmailDate,
+		SignalDate:   signal.SignalDate,
+		EntryDate:    signal.EntryDate,
+		BuyPrice:     signal.BuyPrice,
+		StopPrice:    signal.StopPrice,
+		TargetPrice:  signal.TargetPrice,
+		Targets:      signal.Targets,
+		RiskPct:      signal.RiskPct,
+		Notes:        signal.Notes,
+		Currency:     signal.Currency,
+		TickerSource: signal.TickerSource,
+		BuySource:    signal.BuySource,
+		StopSource:   signal.StopSource,
+		TargetSource: signal.TargetSource,
+		CleanedText:  cleanedText,
+	}
+	if rejection != nil {
+		result.Rejection, result.RejectionReason = rejection.Detail, rejection.Reason
+	}
+	roundPrices(&result.BuyPrice, &result.StopPrice, &result.TargetPrice)
+	for i := range result.Targets {
+		roundPrices(&result.Targets[i])
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// emailsExportHandler streams every email as JSON Lines, or only likely signals with ?signalsOnly=true
+func emailsExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	db, err := setupDatabase()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Database setup failed: %v", err), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
 		return
 	}
 	defer db.Close()
 
-	if err := enrichEmailsV1_2Concurrently(db); err != nil {
-		http.Error(w, fmt.Sprintf("emails_v1_2 enrichment failed: %v", err), http.StatusInternalServerError)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	count := 0
+	err = db.streamEmails(r.URL.Query().Get("signalsOnly") == "true", func(email EmailExport) error {
+		if err := encoder.Encode(email); err != nil {
+			return err
+		}
+		count++
+		if flusher != nil && count%100 == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil && count == 0 {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err != nil {
+		// Headers are already sent, so the truncated stream is the only signal to the client
+		log.Printf("Email export stopped after %d emails: %v", count, err)
 		return
 	}
+	log.Printf("Exported %d emails", count)
+}
 
-	fmt.Fprint(w, "emails_v1_2 enrichment completed successfully")
+// incompleteThreadsHandler lists threads whose last enrichment saved fewer messages than Gmail reported
+func incompleteThreadsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	db, err := setupDatabase()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
+		return
+	}
+	defer db.Close()
+
+	threads, err := db.getIncompleteThreads()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, threads)
+}
+
+// parseRunsHandler lists the most recent parsing runs, newest first
+func parseRunsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	limit := 50
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("limit must be a positive integer: %q", raw))
+			return
+		}
+		limit = n
+	}
+
+	db, err := setupDatabase()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
+		return
+	}
+	defer db.Close()
+
+	runs, err := db.getParseRuns(limit)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, runs)
+}
+
+// maintenanceVacuumHandler checkpoints the WAL and vacuums the database, reporting file sizes before and after
+func maintenanceVacuumHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	db, err := setupDatabase()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
+		return
+	}
+	defer db.Close()
+
+	before := dbFileSizes()
+	start := time.Now()
+	if err := db.checkpointAndVacuum(); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	after := dbFileSizes()
+	log.Printf("Maintenance: vacuum took %v, database %d -> %d bytes, WAL %d -> %d bytes",
+		time.Since(start), before.DBBytes, after.DBBytes, before.WALBytes, after.WALBytes)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"before":      before,
+		"after":       after,
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+}
+
+// ReprocessCounts holds derived table sizes around a reprocess run
+type ReprocessCounts struct {
+	ParseBuyStopTarget int `json:"parse_buy_stop_target"`
+	TradeSignals       int `json:"trade_signals"`
+}
+
+// derivedTableCounts counts rows in the tables rebuilt by /reprocess
+func derivedTableCounts(db *DB) (ReprocessCounts, error) {
+	var counts ReprocessCounts
+	var err error
+	if counts.ParseBuyStopTarget, err = db.countRows("parse_buy_stop_target"); err != nil {
+		return counts, err
+	}
+	if counts.TradeSignals, err = db.countRows("trade_signals"); err != nil {
+		return counts, err
+	}
+	return counts, nil
+}
+
+// reprocessHandler clears derived tables and rebuilds them from the emails table
+func reprocessHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if r.URL.Query().Get("confirm") != "true" {
+		writeJSONError(w, http.StatusBadRequest, "reprocess deletes parse_buy_stop_target and trade_signals; pass confirm=true to proceed")
+		return
+	}
+
+	db, err := setupDatabase()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
+		return
+	}
+	defer db.Close()
+
+	before, err := derivedTableCounts(db)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := db.clearDerivedTables(); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to clear derived tables: %v", err))
+		return
+	}
+	log.Printf("Reprocess: cleared %d parsed and %d trade signals", before.ParseBuyStopTarget, before.TradeSignals)
+
+	parseCtx, cancelParse := stageContext(r.Context(), "parse")
+	defer cancelParse()
+	err = parseSignalsConcurrently(parseCtx, db, ParseOptions{All: true, Labels: parseLabelFilter(r.URL.Query())})
+	recordSpanError(parseCtx, err)
+	if status := stageErrorStatus(err); status != http.StatusOK {
+		writeJSONError(w, status, fmt.Sprintf("Signal parsing failed: %v", err))
+		return
+	}
+
+	processCtx, cancelProcess := stageContext(r.Context(), "process")
+	defer cancelProcess()
+	err = processSignalsConcurrently(processCtx, db)
+	recordSpanError(processCtx, err)
+	if status := stageErrorStatus(err); status != http.StatusOK {
+		writeJSONError(w, status, fmt.Sprintf("Signal processing failed: %v", err))
+		return
+	}
+
+	after, err := derivedTableCounts(db)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]ReprocessCounts{"before": before, "after": after})
+}
+
+// StageSummary reports the outcome of one stage of a /run-all pipeline
+type StageSummary struct {
+	Stage      string `json:"stage"`
+	Status     string `json:"status"` // ok, empty, partial, timeout, failed or skipped
+	Rows       int    `json:"rows"`   // rows in the stage's output table afterwards
+	Failed     int    `json:"failed"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// pipelineStage pairs a stage with the table it fills
+type pipelineStage struct {
+	name  string
+	table string
+	run   func(ctx context.Context, db *DB) error
+}
+
+// runStage runs a single pipeline stage under its timeout and a span of parent, summarizes it and
+// notifies the completion webhook
+func runStage(parent context.Context, db *DB, stage pipelineStage) (StageSummary, error) {
+	ctx, cancel := stageContext(parent, stage.name)
+	defer cancel()
+
+	start := time.Now()
+	err := stage.run(ctx, db)
+	recordSpanError(ctx, err)
+	summary, err := summarizeStage(db, stage.name, stage.table, time.Since(start), err)
+	notifyStageCompletion(summary)
+	return summary, err
+}
+
+// summarizeStage reports the outcome of a stage that returned err. Empty input and partial failures
+// are reported but do not count as a stage error; a stage that timed out reports its rows so far but does.
+func summarizeStage(db *DB, name, table string, duration time.Duration, err error) (StageSummary, error) {
+	summary := StageSummary{Stage: name, Status: "ok", DurationMs: duration.Milliseconds()}
+
+	var empty *EmptyInputError
+	if errors.As(err, &empty) {
+		summary.Status = "empty"
+		summary.Error = empty.Reason
+		err = nil
+	}
+
+	var timeout *StageTimeoutError
+	if errors.As(err, &timeout) {
+		summary.Status = "timeout"
+		summary.Error = err.Error()
+		summary.Failed = timeout.Failed
+		if rows, countErr := db.countRows(table); countErr == nil {
+			summary.Rows = rows
+		}
+		return summary, err
+	}
+
+	if err != nil {
+		summary.Error = err.Error()
+		var agg *AggregateError
+		if errors.As(err, &agg) {
+			summary.Failed = agg.Len()
+		}
+		if !isPartialFailure(err) {
+			summary.Status = "failed"
+			return summary, err
+		}
+		summary.Status = "partial"
+	}
+
+	rows, err := db.countRows(table)
+	if err != nil {
+		summary.Status = "failed"
+		summary.Error = err.Error()
+		return summary, err
+	}
+	summary.Rows = rows
+	return summary, nil
+}
+
+// runAllHandler runs download, enrich, parse and process in order, stopping at the first failed stage
+func runAllHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	opts, err := parseDownloadOptions(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	db, err := setupDatabase()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
+		return
+	}
+	defer db.Close()
+
+	force := r.URL.Query().Get("force") == "true"
+	parseOpts := ParseOptions{Labels: parseLabelFilter(r.URL.Query())}
+	stages := []pipelineStage{
+		{"download", "email_landing", func(ctx context.Context, db *DB) error { return downloadAllEmailsConcurrently(ctx, db, opts) }},
+		{"enrich", "emails", func(ctx context.Context, db *DB) error { return enrichEmailsConcurrently(ctx, db, force) }},
+		{"parse", "parse_buy_stop_target", func(ctx context.Context, db *DB) error { return parseSignalsConcurrently(ctx, db, parseOpts) }},
+		{"process", "trade_signals", processSignalsConcurrently},
+	}
+
+	skipDownload := r.URL.Query().Get("skipDownload") == "true"
+	summaries := make([]StageSummary, 0, len(stages))
+	status := http.StatusOK
+	for _, stage := range stages {
+		if stage.name == "download" && skipDownload {
+			summaries = append(summaries, StageSummary{Stage: stage.name, Status: "skipped"})
+			continue
+		}
+
+		log.Printf("Run all: starting %s", stage.name)
+		summary, err := runStage(r.Context(), db, stage)
+		summaries = append(summaries, summary)
+		if err != nil {
+			log.Printf("Run all: %s failed, stopping: %v", stage.name, err)
+			status = stageErrorStatus(err)
+			break
+		}
+	}
+
+	writeJSON(w, status, map[string][]StageSummary{"stages": summaries})
+}
+
+// versionHandler serves GET /version, the build information of the running server
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, currentBuildInfo())
 }
 
 func main() {
+	build := currentBuildInfo()
+	log.Printf("backteststoxx %s (commit %s, built %s, %s)", build.Version, build.GitCommit, build.BuildTime, build.GoVersion)
+
 	// Create credentials directory if it doesn't exist
 	if err := os.MkdirAll(tokenDir, 0700); err != nil {
 		log.Fatalf("Unable to create credentials directory: %v", err)
 	}
 
+	if err := validateConfig(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	log.Printf("Token store: %s", tokenStoreLocation())
+
+	shutdownTracing, err := initTracing(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// Load OAuth configuration
-	var err error
 	config, err = loadCredentials(credentialsFile)
 	if err != nil {
 		log.Fatalf("Failed to load credentials: %v", err)
@@ -363,6 +1255,9 @@ func main() {
 		log.Fatalf("Failed to setup database: %v", err)
 	}
 	defer db.Close()
+	if err := migrateDatabase(db); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
 
 	log.Printf("Database setup completed")
 
@@ -370,23 +1265,53 @@ func main() {
 	http.HandleFunc("/", homeHandler)
 	http.HandleFunc("/login", handleLogin)
 	http.HandleFunc("/oauth/callback", handleOAuthCallback)
-	http.HandleFunc("/download-emails", downloadEmailsHandler)
-	http.HandleFunc("/enrich-emails", enrichEmailsHandler)
-	http.HandleFunc("/enrich-emails-v1-2", enrichEmailsV1_2Handler)
-	http.HandleFunc("/parse-signals", parseSignalsHandler)
-	http.HandleFunc("/sql-parse-signals", sqlParseSignalsHandler)
-	http.HandleFunc("/process-signals", processSignalsHandler)
-
-	// Determine port
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-
-	log.Printf("Server starting on :%s", port)
-	log.Printf("Visit http://localhost:%s to get started", port)
-	
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
+	http.HandleFunc("/auth/status", handleAuthStatus)
+	http.HandleFunc("/auth/refresh", handleAuthRefresh)
+	http.HandleFunc("/auth/profile", handleAuthProfile)
+	// Pipeline stages reject a second request with 409 while the same stage is running
+	http.HandleFunc("/download-emails", withIdempotency(withStageGuard(downloadEmailsHandler, "download")))
+	http.HandleFunc("/enrich-emails", withIdempotency(withStageGuard(enrichEmailsHandler, "enrich")))
+	http.HandleFunc("/enrich-emails-v1-2", withIdempotency(withStageGuard(enrichEmailsV1_2Handler, "enrich_v1_2")))
+	http.HandleFunc("/parse-signals", withIdempotency(withStageGuard(parseSignalsHandler, "parse")))
+	http.HandleFunc("/sql-parse-signals", withIdempotency(withStageGuard(sqlParseSignalsHandler, "parse")))
+	http.HandleFunc("/process-signals", withIdempotency(withStageGuard(processSignalsHandler, "process")))
+	http.HandleFunc("/run-all", withIdempotency(withStageGuard(runAllHandler, "download", "enrich", "parse", "process")))
+	http.HandleFunc("/reprocess", withIdempotency(withStageGuard(reprocessHandler, "parse", "process")))
+	http.HandleFunc("/maintenance/vacuum", withIdempotency(withStageGuard(maintenanceVacuumHandler, pipelineStages...)))
+	http.HandleFunc("/emails/", emailHandler)
+	http.HandleFunc("/emails/search", emailSearchHandler)
+	http.HandleFunc("/emails.jsonl", emailsExportHandler)
+	http.HandleFunc("/import", importHandler)
+	http.HandleFunc("/price-bars", priceBarsHandler)
+	http.HandleFunc("/parse-one", parseOneHandler)
+	http.HandleFunc("/parse-runs", parseRunsHandler)
+	http.HandleFunc("/parse/diff", parseDiffHandler)
+	http.HandleFunc("/threads/incomplete", incompleteThreadsHandler)
+	http.HandleFunc("/backtest", backtestHandler)
+	http.HandleFunc("/backtest/by-ticker", backtestByTickerHandler)
+	http.HandleFunc("/backtest/trades", backtestTradesHandler)
+	http.HandleFunc("/backtest/compare", backtestCompareHandler)
+	http.HandleFunc("/backtest/monte-carlo", backtestMonteCarloHandler)
+	http.HandleFunc("/signals/anomalies", signalAnomaliesHandler)
+	http.HandleFunc("/signals/latest", latestSignalHandler)
+	http.HandleFunc("/signals/prune", withIdempotency(withStageGuard(signalPruneHandler, "process")))
+	http.HandleFunc("/version", versionHandler)
+	http.Handle("/metrics", metricsHandler)
+
+	// Determine listen address
+	addr, err := listenAddress()
+	if err != nil {
+		log.Fatalf("Invalid listen address: %v", err)
+	}
+	host, port, _ := net.SplitHostPort(addr)
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+
+	log.Printf("Server starting on %s", addr)
+	log.Printf("Visit http://%s to get started", net.JoinHostPort(host, port))
+
+	if err := http.ListenAndServe(addr, traceHandler(withAccessLog(http.DefaultServeMux))); err != nil {
 		log.Fatalf("Server failed to start: %v", err)
 	}
 }
\ No newline at end of file
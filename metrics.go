@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Pipeline metrics exposed on /metrics. Labels are kept low-cardinality (no per-ticker or per-email labels).
+var (
+	emailsDownloadedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "emails_downloaded_total",
+		Help: "Messages saved to email_landing by the download stage.",
+	})
+
+	enrichErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "enrich_errors_total",
+		Help: "Threads or messages that failed during enrichment.",
+	})
+
+	signalsParsedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "signals_parsed_total",
+		Help: "Emails run through the Go parser, by result (valid, empty, no_content, error, skipped).",
+	}, []string{"result"})
+
+	signalsRejectedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "signals_rejected_total",
+		Help: "Signals the Go parser found but rejected in validation, by reason.",
+	}, []string{"reason"})
+
+	parseDurationSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "parse_duration_seconds",
+		Help:    "Time to parse and save a single email.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	gmailAPIRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gmail_api_requests_total",
+		Help: "HTTP requests made to the Gmail API, by response status code.",
+	}, []string{"status"})
+)
+
+// metricsTransport counts Gmail API requests by response status
+type metricsTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip records the status of each request before returning the response
+func (t metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		gmailAPIRequestsTotal.WithLabelValues("error").Inc()
+		return nil, err
+	}
+	gmailAPIRequestsTotal.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+	return resp, nil
+}
+
+// instrumentClient wraps an HTTP client so its requests are counted in gmail_api_requests_total
+func instrumentClient(client *http.Client) *http.Client {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client.Transport = metricsTransport{base: base}
+	return client
+}
+
+// metricsHandler serves the Prometheus metrics
+var metricsHandler = promhttp.Handler()
+
+// progressCollector exposes the running stages' counts on /metrics
+type progressCollector struct{}
+
+// stageItemsDesc describes the stage_items gauges progressCollector reports
+var stageItemsDesc = prometheus.NewDesc("stage_items", "Items of each running pipeline stage, by state (total, processed, failed, skipped).", []string{"stage", "state"}, nil)
+
+func (progressCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- stageItemsDesc
+}
+
+func (progressCollector) Collect(ch chan<- prometheus.Metric) {
+	activeProgress.Range(func(_, value any) bool {
+		p := value.(*stageProgress)
+		processed, failed, skipped := p.counts()
+		for state, n := range map[string]int{"total": int(p.total), "processed": processed, "failed": failed, "skipped": skipped} {
+			ch <- prometheus.MustNewConstMetric(stageItemsDesc, prometheus.GaugeValue, float64(n), p.stage, state)
+		}
+		return true
+	})
+}
+
+func init() {
+	prometheus.MustRegister(progressCollector{})
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// Limits and defaults of one /backtest/monte-carlo request
+const (
+	defaultMonteCarloIterations = 1000
+	maxMonteCarloIterations     = 10000
+	defaultMonteCarloSeed       = 1
+	defaultRuinPct              = 50 // a drawdown from peak equity this deep counts as ruin
+)
+
+// MonteCarloParams controls how the backtest's trades are resampled
+type MonteCarloParams struct {
+	Iterations int     `json:"iterations"`
+	Seed       uint64  `json:"seed"`     // equal seeds give equal results for the same trades
+	Method     string  `json:"method"`   // "bootstrap" draws trades with replacement, "shuffle" reorders them
+	RuinPct    float64 `json:"ruin_pct"` // drawdown from peak equity counted as ruin
+}
+
+// Percentiles summarizes one metric's distribution over the resampled runs
+type Percentiles struct {
+	P5  float64 `json:"p5"`
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+}
+
+// MonteCarloResult is the /backtest/monte-carlo response. Equity compounds each completed trade's
+// account return, in the order the resampling drew them.
+type MonteCarloResult struct {
+	ParamsHash string `json:"params_hash"` // the backtest whose ledger was resampled, as for /backtest/trades
+	MonteCarloParams
+	Trades          int         `json:"trades"`           // completed trades resampled in each run
+	FinalReturnPct  Percentiles `json:"final_return_pct"` // account return after the last trade
+	MaxDrawdownPct  Percentiles `json:"max_drawdown_pct"`
+	RuinProbability float64     `json:"ruin_probability"` // share of runs whose drawdown reached ruin_pct
+}
+
+// parseMonteCarloParams reads iterations, seed, method and ruinPct, defaulting each when absent
+func parseMonteCarloParams(r *http.Request) (MonteCarloParams, error) {
+	query := r.URL.Query()
+	params := MonteCarloParams{
+		Iterations: defaultMonteCarloIterations,
+		Seed:       defaultMonteCarloSeed,
+		Method:     "bootstrap",
+		RuinPct:    defaultRuinPct,
+	}
+
+	if raw := query.Get("iterations"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 1 || value > maxMonteCarloIterations {
+			return params, fmt.Errorf("iterations must be between 1 and %d: %q", maxMonteCarloIterations, raw)
+		}
+		params.Iterations = value
+	}
+	if raw := query.Get("seed"); raw != "" {
+		value, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return params, fmt.Errorf("seed must be a non-negative integer: %q", raw)
+		}
+		params.Seed = value
+	}
+	if raw := query.Get("method"); raw != "" {
+		if raw != "bootstrap" && raw != "shuffle" {
+			return params, fmt.Errorf("method must be bootstrap or shuffle: %q", raw)
+		}
+		params.Method = raw
+	}
+	if raw := query.Get("ruinPct"); raw != "" {
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil || value <= 0 || value > 100 {
+			return params, fmt.Errorf("ruinPct must be a number above 0 and at most 100: %q", raw)
+		}
+		params.RuinPct = value
+	}
+	return params, nil
+}
+
+// equityPath compounds account returns in order from an equity of 1, returning the total return and the
+// deepest drawdown from a running peak, both in percent. Equity that is wiped out stays at zero.
+func equityPath(returnsPct []float64) (finalReturnPct, maxDrawdownPct float64) {
+	equity, peak := 1.0, 1.0
+	for _, returnPct := range returnsPct {
+		equity = math.Max(equity*(1+returnPct/100), 0)
+		peak = math.Max(peak, equity)
+		maxDrawdownPct = math.Max(maxDrawdownPct, (peak-equity)/peak*100)
+	}
+	return (equity - 1) * 100, maxDrawdownPct
+}
+
+// percentile returns the p-th percentile of sorted values, interpolating between the nearest two
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(rank)
+	if lower+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lower] + (rank-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
+
+// percentiles sorts values in place and returns their 5th, 50th and 95th percentiles
+func percentiles(values []float64) Percentiles {
+	sort.Float64s(values)
+	return Percentiles{P5: percentile(values, 5), P50: percentile(values, 50), P95: percentile(values, 95)}
+}
+
+// monteCarlo resamples the completed trades' account returns params.Iterations times
+func monteCarlo(returnsPct []float64, params MonteCarloParams) MonteCarloResult {
+	result := MonteCarloResult{MonteCarloParams: params, Trades: len(returnsPct)}
+	if len(returnsPct) == 0 {
+		return result
+	}
+
+	rng := rand.New(rand.NewPCG(params.Seed, params.Seed))
+	finals := make([]float64, params.Iterations)
+	drawdowns := make([]float64, params.Iterations)
+	sample := make([]float64, len(returnsPct))
+	ruined := 0
+	for i := range params.Iterations {
+		if params.Method == "shuffle" {
+			copy(sample, returnsPct)
+			rng.Shuffle(len(sample), func(a, b int) { sample[a], sample[b] = sample[b], sample[a] })
+		} else {
+			for j := range sample {
+				sample[j] = returnsPct[rng.IntN(len(returnsPct))]
+			}
+		}
+		finals[i], drawdowns[i] = equityPath(sample)
+		if drawdowns[i] >= params.RuinPct {
+			ruined++
+		}
+	}
+
+	result.FinalReturnPct = percentiles(finals)
+	result.MaxDrawdownPct = percentiles(drawdowns)
+	result.RuinProbability = float64(ruined) / float64(params.Iterations)
+	return result
+}
+
+// HTTP handler for Monte Carlo resampling of a backtest's completed trades. The backtest runs with the
+// query parameters /backtest takes and saves its ledger as /backtest does; cutoff is not supported.
+func backtestMonteCarloHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	params, err := parseBacktestParams(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !params.Cutoff.IsZero() {
+		writeJSONError(w, http.StatusBadRequest, "cutoff is not supported by /backtest/monte-carlo; use from and to")
+		return
+	}
+	mcParams, err := parseMonteCarloParams(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	db, err := setupDatabase()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
+		return
+	}
+	defer db.Close()
+
+	summary, err := runBacktest(db, params)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Backtest failed: %v", err))
+		return
+	}
+
+	var returnsPct []float64
+	for _, trade := range summary.Results {
+		if trade.Status != StatusOpen && trade.Entered {
+			returnsPct = append(returnsPct, trade.AccountReturnPct)
+		}
+	}
+
+	result := monteCarlo(returnsPct, mcParams)
+	result.ParamsHash = summary.ParamsHash
+	writeJSON(w, http.StatusOK, result)
+}
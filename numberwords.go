@@ -0,0 +1,127 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// numberWordValues are the words spelled-out prices are built from, such as "fifty dollars",
+// "forty eight fifty" or "one hundred and five dollars and twenty cents"
+var numberWordValues = map[string]int{
+	"one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6, "seven": 7, "eight": 8, "nine": 9,
+	"ten": 10, "eleven": 11, "twelve": 12, "thirteen": 13, "fourteen": 14, "fifteen": 15,
+	"sixteen": 16, "seventeen": 17, "eighteen": 18, "nineteen": 19,
+	"twenty": 20, "thirty": 30, "forty": 40, "fifty": 50, "sixty": 60, "seventy": 70, "eighty": 80, "ninety": 90,
+	"hundred": 100,
+}
+
+// spelledNumberRe matches a run of number words, "dollars" and "cents", optionally joined by "and".
+// A run never ends on "and", so "fifty and sell" keeps its "and".
+var spelledNumberRe = func() *regexp.Regexp {
+	words := make([]string, 0, len(numberWordValues))
+	for word := range numberWordValues {
+		words = append(words, word)
+	}
+	// Longest first, so "seventeen" is not matched as "seven"
+	sort.Slice(words, func(i, j int) bool { return len(words[i]) > len(words[j]) })
+	word := `(?:` + strings.Join(words, "|") + `|dollars?|cents?)`
+	return regexp.MustCompile(`\b` + word + `(?:[\s-]+(?:and[\s-]+)?` + word + `)*\b`)
+}()
+
+// spelledNumberGroup is one number within a run, e.g. "forty eight" in "forty eight fifty"
+type spelledNumberGroup struct {
+	value int
+	cents bool // followed by "cents"
+}
+
+// parseSpelledNumber converts a run matched by spelledNumberRe to a price. Two groups are read as
+// dollars and cents ("forty eight fifty" is 48.50), as is an explicit "... dollars and ... cents".
+// Runs of single digit words without "dollars" or "cents" are left alone, since they are usually prose
+// like "buy one share"; so are runs with more than two groups, which have no clear reading.
+func parseSpelledNumber(run string) (float64, bool) {
+	var groups []spelledNumberGroup
+	current, previous := -1, ""
+	qualifies := false // a word worth ten or more, or a currency word
+	flush := func() {
+		if current >= 0 {
+			groups = append(groups, spelledNumberGroup{value: current})
+		}
+		current = -1
+	}
+
+	for _, token := range strings.FieldsFunc(run, func(r rune) bool { return r == ' ' || r == '-' || r == '\t' || r == '\n' }) {
+		switch token {
+		case "and":
+			continue
+		case "dollar", "dollars":
+			qualifies = true
+			flush()
+			previous = token
+			continue
+		case "cent", "cents":
+			qualifies = true
+			if current >= 0 {
+				groups = append(groups, spelledNumberGroup{value: current, cents: true})
+				current = -1
+			} else if len(groups) > 0 {
+				groups[len(groups)-1].cents = true
+			}
+			previous = token
+			continue
+		}
+
+		value := numberWordValues[token]
+		if value >= 10 {
+			qualifies = true
+		}
+		previousValue := numberWordValues[previous]
+		switch {
+		case token == "hundred":
+			if current <= 0 {
+				current = 1
+			}
+			current *= 100
+		case current < 0:
+			current = value
+		case previous == "hundred":
+			current += value
+		case previousValue >= 20 && previousValue%10 == 0 && value < 10 && current%100 == previousValue:
+			// "forty eight", or "one hundred forty eight"
+			current += value
+		default:
+			// A new number starts: "eight" then "fifty" in "forty eight fifty"
+			flush()
+			current = value
+		}
+		previous = token
+	}
+	flush()
+
+	if !qualifies || len(groups) == 0 || len(groups) > 2 {
+		return 0, false
+	}
+	if len(groups) == 1 {
+		if groups[0].cents {
+			return float64(groups[0].value) / 100, true
+		}
+		return float64(groups[0].value), true
+	}
+	if groups[0].cents || groups[1].value >= 100 {
+		return 0, false
+	}
+	return float64(groups[0].value) + float64(groups[1].value)/100, true
+}
+
+// replaceSpelledPrices rewrites spelled-out numbers in lowercase text as digits, e.g.
+// "buy at fifty dollars" becomes "buy at 50"
+func replaceSpelledPrices(textLower string) string {
+	return spelledNumberRe.ReplaceAllStringFunc(textLower, func(run string) string {
+		price, ok := parseSpelledNumber(run)
+		if !ok {
+			return run
+		}
+		return strconv.FormatFloat(price, 'f', -1, 64)
+	})
+}
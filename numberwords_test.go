@@ -0,0 +1,66 @@
+package main
+
+import "testing"
+
+func TestParseSpelledNumber(t *testing.T) {
+	tests := []struct {
+		run    string
+		want   float64
+		wantOK bool
+	}{
+		{"fifty dollars", 50, true},
+		{"forty eight fifty", 48.5, true},
+		{"twelve fifty", 12.5, true},
+		{"forty-eight", 48, true},
+		{"one hundred and five dollars and twenty cents", 105.2, true},
+		{"seventy five cents", 0.75, true},
+		{"one", 0, false},               // a single small number is usually prose, as in "buy one share"
+		{"ten twenty thirty", 0, false}, // three groups have no clear reading
+	}
+
+	for _, tt := range tests {
+		got, ok := parseSpelledNumber(tt.run)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseSpelledNumber(%q) = %g, %v, want %g, %v", tt.run, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestReplaceSpelledPrices(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"buy at fifty dollars", "buy at 50"},
+		{"stop at forty eight fifty", "stop at 48.5"},
+		{"buy one share at fifty dollars and sell", "buy one share at 50 and sell"},
+		{"target 60", "target 60"},
+	}
+
+	for _, tt := range tests {
+		if got := replaceSpelledPrices(tt.text); got != tt.want {
+			t.Errorf("replaceSpelledPrices(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSpelledPricesFlag(t *testing.T) {
+	email := EmailSignal{ID: "spelled", HTML: "<p>Acme Holdings (NASDAQ: ACME)</p><p>Buy at fifty dollars</p><p>Stop at forty eight fifty</p>"}
+
+	saved := spelledPrices
+	t.Cleanup(func() { spelledPrices = saved })
+	for _, enabled := range []bool{false, true} {
+		spelledPrices = enabled
+		signal, _, _, err := extractSignalFields(email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantBuy, wantStop := 0.0, 0.0
+		if enabled {
+			wantBuy, wantStop = 50, 48.5
+		}
+		if signal.BuyPrice != wantBuy || signal.StopPrice != wantStop {
+			t.Errorf("SPELLED_PRICES=%v: buy %g, stop %g, want %g and %g", enabled, signal.BuyPrice, signal.StopPrice, wantBuy, wantStop)
+		}
+	}
+}
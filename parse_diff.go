@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Limits on the emails one /parse/diff request compares
+const (
+	defaultParseDiffEmails = 200
+	maxParseDiffEmails     = 5000
+)
+
+// ParserFields are what one parser extracted from an email; prices it did not find are 0
+type ParserFields struct {
+	Ticker       string  `json:"ticker"`
+	BuyPrice     float64 `json:"buy_price"`
+	StopPrice    float64 `json:"stop_price"`
+	TargetPrice  float64 `json:"target_price"`
+	TickerSource string  `json:"ticker_source,omitempty"`
+	BuySource    string  `json:"buy_source,omitempty"`
+	StopSource   string  `json:"stop_source,omitempty"`
+	TargetSource string  `json:"target_source,omitempty"`
+}
+
+// ParserDisagreement is one email the Go and SQL parsers read differently
+type ParserDisagreement struct {
+	EmailID string       `json:"email_id"`
+	Subject string       `json:"subject"`
+	Fields  []string     `json:"fields"` // the fields that differ: ticker, buy_price, stop_price, target_price
+	Go      ParserFields `json:"go"`
+	SQL     ParserFields `json:"sql"`
+}
+
+// ParseDiff is the /parse/diff response
+type ParseDiff struct {
+	Emails        int                  `json:"emails"` // emails both parsers read
+	Disagreements int                  `json:"disagreements"`
+	ByField       map[string]int       `json:"by_field"`
+	Diffs         []ParserDisagreement `json:"diffs"`
+}
+
+// sqlParserFields runs the SQL parser's ticker and price extraction over emailIDs and returns what it found
+// by email ID. The working table is a temp table inside a transaction that is rolled back, so nothing is written.
+func sqlParserFields(db *DB, emailIDs []string) (map[string]ParserFields, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`CREATE TEMP TABLE tmp_diff_signals (email_id TEXT PRIMARY KEY, ticker TEXT, ticker_source TEXT)`); err != nil {
+		return nil, fmt.Errorf("failed to create tmp_diff_signals: %w", err)
+	}
+	stmt, err := tx.Prepare(`INSERT OR IGNORE INTO tmp_diff_signals (email_id) VALUES (?)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare tmp_diff_signals statement: %w", err)
+	}
+	defer stmt.Close()
+	for _, id := range emailIDs {
+		if _, err := stmt.Exec(id); err != nil {
+			return nil, fmt.Errorf("failed to list %s for the SQL parser: %w", id, err)
+		}
+	}
+
+	tickerQuery, args := tickerExtractionQuery("tmp_diff_signals")
+	if _, err := tx.Exec(`
+		UPDATE tmp_diff_signals
+		SET ticker = vt.ticker,
+			ticker_source = vt.ticker_source
+		FROM (`+tickerQuery+`) vt
+		WHERE vt.email_id = tmp_diff_signals.email_id`, args...); err != nil {
+		return nil, fmt.Errorf("failed to extract tickers: %w", err)
+	}
+
+	fields := make(map[string]ParserFields, len(emailIDs))
+	rows, err := tx.Query(`SELECT email_id, ticker, ticker_source FROM tmp_diff_signals WHERE ticker IS NOT NULL`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read extracted tickers: %w", err)
+	}
+	for rows.Next() {
+		var id string
+		var found ParserFields
+		if err := rows.Scan(&id, &found.Ticker, &found.TickerSource); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan extracted ticker: %w", err)
+		}
+		fields[id] = found
+	}
+	rows.Close()
+
+	priceQuery, args := priceExtractionQuery("tmp_diff_signals")
+	rows, err = tx.Query(`
+		SELECT email_id, buy_price, stop_price, target_price, buy_source, stop_source, target_source
+		FROM (`+priceQuery+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract prices: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var buy, stop, target float64
+		var buySource, stopSource, targetSource string
+		if err := rows.Scan(&id, &buy, &stop, &target, &buySource, &stopSource, &targetSource); err != nil {
+			return nil, fmt.Errorf("failed to scan extracted prices: %w", err)
+		}
+		found := fields[id]
+		found.BuyPrice, found.StopPrice, found.TargetPrice = buy, stop, target
+		found.BuySource, found.StopSource, found.TargetSource = buySource, stopSource, targetSource
+		fields[id] = found
+	}
+	return fields, rows.Err()
+}
+
+// rounded returns the fields with prices rounded for the response
+func (f ParserFields) rounded() ParserFields {
+	roundPrices(&f.BuyPrice, &f.StopPrice, &f.TargetPrice)
+	return f
+}
+
+// parserFieldsDiffer lists the fields two parsers extracted differently; prices agree when they match to PRICE_DECIMALS places
+func parserFieldsDiffer(goFields, sqlFields ParserFields) []string {
+	var differ []string
+	if !strings.EqualFold(goFields.Ticker, sqlFields.Ticker) {
+		differ = append(differ, "ticker")
+	}
+	for _, price := range []struct {
+		name              string
+		goPrice, sqlPrice float64
+	}{
+		{"buy_price", goFields.BuyPrice, sqlFields.BuyPrice},
+		{"stop_price", goFields.StopPrice, sqlFields.StopPrice},
+		{"target_price", goFields.TargetPrice, sqlFields.TargetPrice},
+	} {
+		if roundPrice(price.goPrice) != roundPrice(price.sqlPrice) {
+			differ = append(differ, price.name)
+		}
+	}
+	return differ
+}
+
+// HTTP handler for comparing the Go and SQL parsers over the most recent signal emails, without saving
+// anything. Only emails where they disagree on the ticker or a price are listed.
+func parseDiffHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	limit := defaultParseDiffEmails
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 1 || value > maxParseDiffEmails {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d: %q", maxParseDiffEmails, raw))
+			return
+		}
+		limit = value
+	}
+
+	db, err := setupDatabase()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
+		return
+	}
+	defer db.Close()
+
+	emails, err := db.getSignalEmails(LabelFilter{}, false)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	emails = emails[:min(limit, len(emails))]
+
+	emailIDs := make([]string, len(emails))
+	for i, email := range emails {
+		emailIDs[i] = email.ID
+	}
+	sqlFields, err := sqlParserFields(db, emailIDs)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("SQL parser failed: %v", err))
+		return
+	}
+
+	diff := ParseDiff{Emails: len(emails), ByField: make(map[string]int), Diffs: []ParserDisagreement{}}
+	for _, email := range emails {
+		// Rejected signals still carry what was found, which is what the SQL side is compared with
+		var goFields ParserFields
+		if signal, _, _, err := extractSignalFieldsTraced(email, nil); err == nil {
+			goFields = ParserFields{
+				Ticker:       signal.Ticker,
+				BuyPrice:     signal.BuyPrice,
+				StopPrice:    signal.StopPrice,
+				TargetPrice:  signal.TargetPrice,
+				TickerSource: signal.TickerSource,
+				BuySource:    signal.BuySource,
+				StopSource:   signal.StopSource,
+				TargetSource: signal.TargetSource,
+			}
+		}
+
+		differ := parserFieldsDiffer(goFields, sqlFields[email.ID])
+		if len(differ) == 0 {
+			continue
+		}
+		for _, field := range differ {
+			diff.ByField[field]++
+		}
+		diff.Diffs = append(diff.Diffs, ParserDisagreement{
+			EmailID: email.ID,
+			Subject: email.Subject,
+			Fields:  differ,
+			Go:      goFields.rounded(),
+			SQL:     sqlFields[email.ID].rounded(),
+		})
+	}
+	diff.Disagreements = len(diff.Diffs)
+
+	writeJSON(w, http.StatusOK, diff)
+}
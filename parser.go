@@ -1,38 +1,142 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"net/url"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/microcosm-cc/bluemonday"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-// parseSignalsConcurrently processes emails to extract trading signals
-func parseSignalsConcurrently(db *DB) error {
-	log.Printf("Starting concurrent signal parsing")
-	
+// ParseOptions selects which emails the Go parser examines
+type ParseOptions struct {
+	FromRaw    bool        // re-extract emails from stored raw payloads instead of the html column
+	OnlyFailed bool        // only emails whose last parse failed
+	All        bool        // include emails already parsed by an earlier run
+	Labels     LabelFilter // label requirements every parsed email must meet
+}
+
+// parseOptionsFrom reads source=raw, onlyFailed, all and the label filter from request parameters
+func parseOptionsFrom(values url.Values) ParseOptions {
+	return ParseOptions{
+		FromRaw:    values.Get("source") == "raw",
+		OnlyFailed: values.Get("onlyFailed") == "true",
+		All:        values.Get("all") == "true",
+		Labels:     parseLabelFilter(values),
+	}
+}
+
+// parseSignalsConcurrently processes emails to extract trading signals.
+// By default only emails no earlier run has handled are parsed; opts.All re-parses every email,
+// and opts.OnlyFailed limits the run to emails whose last parse failed whether or not they were handled.
+// Emails not started before the stage's ctx ends stay unparsed for the next run.
+func parseSignalsConcurrently(ctx context.Context, db *DB, opts ParseOptions) (err error) {
+	log.Printf("Starting concurrent signal parsing (from raw payloads: %v, only failed: %v, all: %v)", opts.FromRaw, opts.OnlyFailed, opts.All)
+
+	run := ParseRun{Parser: "go", StartedAt: time.Now()}
+	defer func() { saveParseRun(db, &run, err) }()
+
 	// Get emails that contain trading signal keywords
-	emails, err := db.getSignalEmails()
+	unparsedOnly := !opts.All && !opts.OnlyFailed
+	var emails []EmailSignal
+	if opts.FromRaw {
+		emails, err = db.getSignalEmailsFromRaw(opts.Labels, unparsedOnly)
+	} else {
+		emails, err = db.getSignalEmails(opts.Labels, unparsedOnly)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to get signal emails: %v", err)
+		return fmt.Errorf("failed to get signal emails: %w", err)
+	}
+
+	if unparsedOnly {
+		log.Printf("Found %d new emails with potential trading signals", len(emails))
+		if len(emails) == 0 {
+			return nothingToDo("no new emails since the last parse")
+		}
+	} else {
+		log.Printf("Found %d emails with potential trading signals", len(emails))
 	}
 
-	log.Printf("Found %d emails with potential trading signals", len(emails))
+	if opts.OnlyFailed {
+		failed, err := db.getParseErrorIDs()
+		if err != nil {
+			return err
+		}
+		retry := emails[:0]
+		for _, email := range emails {
+			if failed[email.ID] {
+				retry = append(retry, email)
+			}
+		}
+		emails = retry
+		log.Printf("Retrying %d emails that failed a previous parse", len(emails))
+		if len(emails) == 0 {
+			return nothingToDo("no emails failed a previous parse")
+		}
+	}
+
+	// Every examined email is marked parsed at the end unless it fails, including ones dropped below.
+	// Blank emails stay marked until a new download changes their content; emails the classifier
+	// skipped keep their score, so they are examined again once SIGNAL_SCORE_THRESHOLD drops to it.
+	examined := make([]string, len(emails))
+	for i, email := range emails {
+		examined[i] = email.ID
+	}
+	failedIDs, unstartedIDs := make(map[string]bool), make(map[string]bool)
+	var skippedScores map[string]int
+	defer func() {
+		parsed, failed := make([]string, 0, len(examined)), make([]string, 0, len(failedIDs))
+		for _, id := range examined {
+			if unstartedIDs[id] {
+				continue
+			}
+			if failedIDs[id] {
+				failed = append(failed, id)
+			} else {
+				parsed = append(parsed, id)
+			}
+		}
+		if watermarkErr := db.updateParseWatermark(parsed, failed, skippedScores, run.StartedAt); watermarkErr != nil {
+			log.Printf("Warning: %v", watermarkErr)
+		}
+	}()
+
+	// Blank emails are reported as no_content instead of reaching the classifier as low scorers
+	emails, run.NoContent = dropEmptyEmails(emails)
+	if run.NoContent > 0 {
+		log.Printf("Skipped %d emails with no text content", run.NoContent)
+	}
+
+	// Skip marketing mail that only mentions buy/stop/target in boilerplate
+	candidates := len(emails)
+	emails, skippedScores = filterLikelySignals(emails)
+	log.Printf("Classifier kept %d/%d emails as likely signals", len(emails), candidates)
+	run.Emails = len(emails)
 
 	if len(emails) == 0 {
 		log.Printf("No emails found with trading signal keywords")
-		return nil
+		return nothingToDo("no emails with trading signal keywords")
 	}
 
 	// Process emails concurrently
 	numWorkers := 10 // Moderate concurrency for parsing
-	jobs := make(chan EmailSignal, len(emails))
-	results := make(chan error, len(emails))
+	jobs := make(chan EmailSignal, channelCapacity(numWorkers))
+	results := make(chan parseOutcome, channelCapacity(numWorkers))
+
+	progress := startProgress("parse", "emails", len(emails), 25)
+	defer progress.finish()
 
 	// Start workers
 	var wg sync.WaitGroup
@@ -40,17 +144,12 @@ func parseSignalsConcurrently(db *DB) error {
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
-			parseSignalWorker(workerID, jobs, results, db)
+			parseSignalWorker(ctx, workerID, run.StartedAt, jobs, results, progress, db)
 		}(i)
 	}
 
-	// Send jobs
-	go func() {
-		for _, email := range emails {
-			jobs <- email
-		}
-		close(jobs)
-	}()
+	// Send jobs until the stage's timeout
+	go feedJobs(ctx, jobs, emails)
 
 	// Wait for all workers to complete
 	go func() {
@@ -58,294 +157,1233 @@ func parseSignalsConcurrently(db *DB) error {
 		close(results)
 	}()
 
-	// Collect results
-	var errors []error
-	var processedCount int
-	for err := range results {
-		if err != nil {
-			errors = append(errors, err)
-		} else {
-			processedCount++
+	// Collect errors and rejections; workers count progress as they finish each email
+	failures := newAggregateError("signal parsing", len(emails))
+	startedIDs := make(map[string]bool, len(emails))
+	for outcome := range results {
+		startedIDs[outcome.emailID] = true
+		err := outcome.err
+		if outcome.rejection != "" {
+			if run.Rejections == nil {
+				run.Rejections = make(map[string]int)
+			}
+			run.Rejections[outcome.rejection]++
+		}
+		if err != nil && !errors.Is(err, errNoContent) {
+			failures.Add(err)
+			var itemErr *ItemError
+			if errors.As(err, &itemErr) {
+				failedIDs[itemErr.ID] = true
+			}
 		}
+	}
+	processedCount, _, noContentCount := progress.counts()
+
+	log.Printf("Signal parsing complete: %d emails processed successfully, %d without content, %d errors, rejections by reason: %v",
+		processedCount, noContentCount, failures.Len(), run.Rejections)
+	run.Errors = failures.Len()
+	run.NoContent += noContentCount
 
-		// Log progress every 25 emails
-		if (processedCount+len(errors))%25 == 0 {
-			log.Printf("Parsing progress: %d/%d emails processed", processedCount+len(errors), len(emails))
+	if err := stageTimedOut(ctx, progress, failures); err != nil {
+		for _, email := range emails {
+			if !startedIDs[email.ID] {
+				unstartedIDs[email.ID] = true
+			}
 		}
+		log.Printf("%v", err)
+		return err
 	}
 
-	log.Printf("Signal parsing complete: %d emails processed successfully, %d errors", processedCount, len(errors))
+	if failures.Len() > 0 {
+		log.Printf("%v", failures)
+	}
 
-	if len(errors) > 0 {
-		log.Printf("First few parsing errors: %v", errors[:min(5, len(errors))])
+	return failures.ErrOrNil()
+}
+
+// LabelFilter restricts parsing by Gmail label IDs. System labels use IDs like INBOX or
+// CATEGORY_PROMOTIONS; user labels use IDs like Label_12 rather than their display names.
+// Emails enriched before labels were stored have none, so they fail any required label.
+type LabelFilter struct {
+	Require []string // every one of these labels must be present
+	Exclude []string // none of these labels may be present
+}
+
+// parseLabelFilter reads comma-separated requireLabel and excludeLabel request parameters
+func parseLabelFilter(values url.Values) LabelFilter {
+	split := func(raw string) []string {
+		var labels []string
+		for _, label := range strings.Split(raw, ",") {
+			if label = strings.TrimSpace(label); label != "" {
+				labels = append(labels, label)
+			}
+		}
+		return labels
 	}
+	return LabelFilter{Require: split(values.Get("requireLabel")), Exclude: split(values.Get("excludeLabel"))}
+}
 
-	return nil
+// allows reports whether an email with the given labels passes the filter
+func (f LabelFilter) allows(labels []string) bool {
+	has := func(want string) bool {
+		for _, label := range labels {
+			if strings.EqualFold(label, want) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, label := range f.Require {
+		if !has(label) {
+			return false
+		}
+	}
+	for _, label := range f.Exclude {
+		if has(label) {
+			return false
+		}
+	}
+	return true
 }
 
-// parseSignalWorker processes individual emails for signal extraction
-func parseSignalWorker(workerID int, jobs <-chan EmailSignal, results chan<- error, db *DB) {
+// saveParseRun finishes a run with its duration and outcome and appends it to parse_runs.
+// A failure to record is logged rather than failing the parse itself.
+func saveParseRun(db *DB, run *ParseRun, err error) {
+	run.DurationMs = time.Since(run.StartedAt).Milliseconds()
+	if err != nil && !isEmptyInput(err) {
+		run.Error = err.Error()
+		if run.Errors == 0 {
+			run.Errors = 1
+		}
+	}
+
+	if recordErr := db.recordParseRun(*run); recordErr != nil {
+		log.Printf("Warning: %v", recordErr)
+	}
+}
+
+// errNoContent marks an email with no text left after stripping tags. It is counted
+// separately rather than as a parse failure, and no staging row is saved for it.
+var errNoContent = errors.New("email has no text content")
+
+// dropEmptyEmails removes emails with no text left after stripping tags and returns how many it removed.
+// It sets Text on the emails it keeps, so the classifier and the workers do not strip the tags again.
+func dropEmptyEmails(emails []EmailSignal) ([]EmailSignal, int) {
+	kept := emails[:0]
+	for _, email := range emails {
+		email.Text = htmlToPlainText(email.HTML)
+		if email.Text == "" {
+			signalsParsedTotal.WithLabelValues("no_content").Inc()
+			continue
+		}
+		kept = append(kept, email)
+	}
+	return kept, len(emails) - len(kept)
+}
+
+// parseOutcome is one email's result from parseSignalWorker
+type parseOutcome struct {
+	emailID   string
+	err       error
+	rejection string // reason validation rejected the signal, "" when valid or not reached
+}
+
+// parseSignalWorker processes individual emails for signal extraction. Failures are recorded in
+// parse_errors under the run's start time so ?onlyFailed=true can retry them; a success clears them.
+// An email still parsing after itemTimeout is recorded as a timeout and the worker moves on, and
+// emails still queued when the stage's ctx ends are skipped.
+func parseSignalWorker(ctx context.Context, workerID int, runAt time.Time, jobs <-chan EmailSignal, results chan<- parseOutcome, progress *stageProgress, db *DB) {
 	for email := range jobs {
-		err := parseSignalFromEmail(workerID, email, db)
-		results <- err
+		if ctx.Err() != nil {
+			continue
+		}
+		start := time.Now()
+		itemCtx, endSpan := startSpan(ctx, "parse email", attribute.String("email_id", email.ID))
+		var found, rejection string
+		err := runWithTimeout(itemCtx, itemTimeout, func(ctx context.Context) error {
+			var err error
+			found, err = parseSignalFromEmail(ctx, workerID, runAt, email, db)
+			return err
+		})
+		if !errors.Is(err, errItemTimeout) {
+			rejection = found // an abandoned parse may still be writing found
+		}
+		err = newItemError(email.ID, CategoryParse, err)
+		endSpan(err)
+		parseDurationSeconds.Observe(time.Since(start).Seconds())
+
+		var trackErr error
+		switch {
+		case errors.Is(err, errNoContent):
+			signalsParsedTotal.WithLabelValues("no_content").Inc()
+		case err != nil:
+			signalsParsedTotal.WithLabelValues("error").Inc()
+			trackErr = db.recordParseError(email.ID, err, runAt)
+		default:
+			trackErr = db.clearParseError(email.ID)
+		}
+		if trackErr != nil {
+			log.Printf("Worker %d: Warning: %v", workerID, trackErr)
+		}
+		progress.record(err)
+		results <- parseOutcome{emailID: email.ID, err: err, rejection: rejection}
 	}
 }
 
-// parseSignalFromEmail extracts trading signal from a single email
-func parseSignalFromEmail(workerID int, email EmailSignal, db *DB) error {
-	signal, cleanedText, err := extractTradingSignalWithText(email)
+// parseSignalFromEmail extracts trading signal from a single email, returning the reason
+// validation rejected it ("" when valid). Nothing is saved once ctx is done. A missing ticker or buy price
+// is also saved to parse_failures with the text examined for it, under the run's start time.
+func parseSignalFromEmail(ctx context.Context, workerID int, runAt time.Time, email EmailSignal, db *DB) (string, error) {
+	signal, cleanedText, rejection, err := extractSignalFields(email)
+	if errors.Is(err, errNoContent) {
+		log.Printf("Worker %d: Email %s has no text content, skipping", workerID, email.ID)
+		return "", err
+	}
 	if err != nil {
-		return fmt.Errorf("failed to extract signal: %v", err)
+		return "", fmt.Errorf("failed to extract signal: %w", err)
+	}
+	// A parse that outlived its timeout has already been recorded as failed, so it saves nothing
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	var failures []ParseFailure
+	if rejection != nil && rejection.Reason == rejectMissingFields {
+		failures = extractionFailures(signal, cleanedText)
 	}
 
 	// Always save to staging table, even if no valid signal found
+	result := "valid"
+	var reason string
+	if rejection != nil {
+		reason = rejection.Reason
+		signal = nil
+	}
 	if signal == nil {
+		result = "empty"
 		// Create empty signal for failed parsing
 		signal = &TradingSignal{
 			EmailID:    email.ID,
+			EmailDate:  email.Date.Unix() * 1000,
 			SignalDate: email.Date.Unix() * 1000,
-			EntryDate:  email.Date.Add(24*time.Hour).Unix() * 1000,
+			EntryDate:  email.Date.Add(entryOffset).Unix() * 1000,
 		}
+	}
+
+	// Save failures and the parse_buy_stop_target staging row together, bound to ctx so a timeout
+	// that fires while they wait on the database cannot commit them
+	if err := db.saveParseResult(ctx, email, signal, cleanedText, failures, runAt); err != nil {
+		return reason, fmt.Errorf("failed to save parsed signal: %w", err)
+	}
+	if reason != "" {
+		signalsRejectedTotal.WithLabelValues(reason).Inc()
+	}
+	if result == "empty" {
 		log.Printf("Worker %d: No valid signal found in email %s, saving empty record", workerID, email.ID)
 	} else {
-		log.Printf("Worker %d: Parsed signal for %s - Ticker: %s, Buy: %.2f, Stop: %.2f, Target: %.2f",
-			workerID, email.ID, signal.Ticker, signal.BuyPrice, signal.StopPrice, signal.TargetPrice)
+		log.Printf("Worker %d: Parsed signal for %s - Ticker: %s, Buy: %s, Stop: %s, Target: %s",
+			workerID, email.ID, signal.Ticker, formatPrice(signal.BuyPrice), formatPrice(signal.StopPrice), formatPrice(signal.TargetPrice))
 	}
+	signalsParsedTotal.WithLabelValues(result).Inc()
+
+	return reason, nil
+}
+
+var (
+	// lineBreakTagRe matches <br> and block-level tags, which start a new line in rendered mail
+	lineBreakTagRe = regexp.MustCompile(`(?i)<(?:br|hr)\b[^>]*>|</?(?:p|div|tr|li|ul|ol|table|h[1-6]|blockquote|pre|section)\b[^>]*>`)
+	// tableCellEndRe matches the end of a table cell, which separates cells on the same line
+	tableCellEndRe = regexp.MustCompile(`(?i)</t[dh]\s*>`)
+	spaceRunRe     = regexp.MustCompile(`[^\S\n]+`)
+	lineBreakRunRe = regexp.MustCompile(`\s*\n\s*`)
+)
 
-	// Save to parse_buy_stop_target staging table with cleaned text
-	if err := saveToParseBuyStopTarget(email, signal, cleanedText, db); err != nil {
-		return fmt.Errorf("failed to save parsed signal: %v", err)
+// htmlToPlainText uses bluemonday to strip all HTML/XML tags (dropping style and script
+// contents). Line breaks and block-level tags become newlines, so lines like "Buy 50" /
+// "Stop 45" stay separate; other whitespace collapses to single spaces and blank lines are dropped.
+func htmlToPlainText(htmlContent string) string {
+	plainText := lineBreakTagRe.ReplaceAllString(htmlContent, "\n")
+	plainText = tableCellEndRe.ReplaceAllString(plainText, " ")
+	plainText = bluemonday.StripTagsPolicy().Sanitize(plainText)
+	plainText = spaceRunRe.ReplaceAllString(plainText, " ")
+	plainText = lineBreakRunRe.ReplaceAllString(plainText, "\n")
+	return strings.TrimSpace(plainText)
+}
+
+var (
+	// forwardMarkerRe matches the line mail clients put above a forwarded message: Gmail's
+	// "---------- Forwarded message ---------" and Apple Mail's "Begin forwarded message:"
+	forwardMarkerRe = regexp.MustCompile(`(?i)-{2,}\s*forwarded message\s*-{2,}|begin forwarded message\s*:?`)
+	// forwardHeaderRe matches one header line of the forwarded message's From/Date/Subject/To block
+	forwardHeaderRe = regexp.MustCompile(`(?i)^(?:from|date|sent|subject|to|cc|reply-to)\s*:`)
+)
+
+// forwardedContent returns the text below the last forward marker, the innermost original message of an
+// alert forwarded one or more times, and false when the email was not forwarded
+func forwardedContent(plainText string) (string, bool) {
+	markers := forwardMarkerRe.FindAllStringIndex(plainText, -1)
+	if len(markers) == 0 {
+		return plainText, false
+	}
+	return plainText[markers[len(markers)-1][1]:], true
+}
+
+// stripForwardHeaders drops the From/Date/Subject/To lines that open a forwarded message's plain text,
+// so a subject such as "Buy ACME" is not read as the alert itself
+func stripForwardHeaders(plainText string) string {
+	lines := strings.Split(plainText, "\n")
+	start := 0
+	for start < len(lines) && forwardHeaderRe.MatchString(strings.TrimSpace(lines[start])) {
+		start++
 	}
+	return strings.TrimSpace(strings.Join(lines[start:], "\n"))
+}
 
-	return nil
+// Reasons a parsed signal fails validation, as counted in parse_runs.rejections
+const (
+	rejectMissingFields    = "missing_ticker_or_buy"
+	rejectPriceRange       = "price_out_of_range"
+	rejectInverted         = "inverted" // stop above buy and target below it, as when the two were swapped
+	rejectTargetBelowBuy   = "target_below_buy"
+	rejectStopAboveBuy     = "stop_above_buy"
+	rejectCurrency         = "non_usd_currency"   // prices in another currency that NON_USD_SIGNALS does not convert
+	rejectTickerNotAllowed = "ticker_not_allowed" // a ticker missing from TICKER_ALLOWLIST
+	rejectTickerDenied     = "ticker_denied"      // a ticker on TICKER_DENYLIST
+)
+
+// SignalRejection explains why validation rejected a parsed signal
+type SignalRejection struct {
+	Reason string // one of the reject* constants
+	Detail string // the prices involved, for logs and /parse-one
+}
+
+// ParseFailure is a field the parser could not extract, with the text it examined for it, as saved in
+// parse_failures so common failure shapes can be found and given patterns
+type ParseFailure struct {
+	Field        string // "ticker" or "buy_price"
+	Keyword      string // the entry keyword whose window was examined for a buy price; "" when none matched
+	ExaminedText string // the cleaned text for a ticker, or the window around the entry keyword for a buy price
+}
+
+// extractionFailures lists the required fields missing from a signal parsed from cleanedText
+func extractionFailures(signal *TradingSignal, cleanedText string) []ParseFailure {
+	var failures []ParseFailure
+	if signal.Ticker == "" {
+		failures = append(failures, ParseFailure{Field: "ticker", ExaminedText: cleanedText})
+	}
+	if signal.BuyPrice == 0 {
+		keyword, window := buyPriceWindow(cleanedText)
+		failures = append(failures, ParseFailure{Field: "buy_price", Keyword: keyword, ExaminedText: window})
+	}
+	return failures
+}
+
+// parseTrace collects one email's "PARSING:" lines. Under PARSE_TRACE=failures they are held until
+// validation rejects the signal and dropped otherwise; a nil trace drops every line.
+type parseTrace struct {
+	emailID string
+	lines   []string
+}
+
+// newParseTrace returns the trace for one email, or nil when PARSE_TRACE=off
+func newParseTrace(emailID string) *parseTrace {
+	if parseTraceMode == "off" {
+		return nil
+	}
+	return &parseTrace{emailID: emailID}
+}
+
+// Printf logs a trace line at once under PARSE_TRACE=all, and otherwise holds it for reject
+func (t *parseTrace) Printf(format string, args ...any) {
+	if t == nil {
+		return
+	}
+	if parseTraceMode == "all" {
+		log.Printf("PARSING: "+format, args...)
+		return
+	}
+	t.lines = append(t.lines, fmt.Sprintf(format, args...))
+}
+
+// reject logs why the email's signal failed validation with its full cleaned text, up to
+// PARSE_TRACE_MAX_TEXT characters, followed by any held trace lines
+func (t *parseTrace) reject(rejection *SignalRejection, plainText string) {
+	if t == nil {
+		return
+	}
+	text := []rune(plainText)
+	if len(text) > parseTraceMaxText {
+		text = append(text[:parseTraceMaxText], []rune(fmt.Sprintf(" ... [%d more chars]", len(text)-parseTraceMaxText))...)
+	}
+	log.Printf("PARSING: Email ID %s rejected (%s: %s), cleaned text: %s", t.emailID, rejection.Reason, rejection.Detail, string(text))
+	for _, line := range t.lines {
+		log.Printf("PARSING: %s", line)
+	}
+	t.lines = nil
+}
+
+// extractSignalFields runs every extraction pattern over an email and returns whatever was found,
+// the cleaned text, and why validation rejected the signal (nil when it is valid)
+func extractSignalFields(email EmailSignal) (*TradingSignal, string, *SignalRejection, error) {
+	return extractSignalFieldsTraced(email, newParseTrace(email.ID))
 }
 
-// extractTradingSignalWithText parses HTML content and returns both signal and cleaned text
-func extractTradingSignalWithText(email EmailSignal) (*TradingSignal, string, error) {
-	htmlContent := email.HTML
-	log.Printf("PARSING: Email ID %s, original HTML length: %d", email.ID, len(htmlContent))
-	log.Printf("PARSING: Original HTML first 200 chars: %s", strings.ReplaceAll(htmlContent[:min(200, len(htmlContent))], "\n", " "))
+// extractSignalFieldsTraced is extractSignalFields logging to trace, which may be nil for no logging
+func extractSignalFieldsTraced(email EmailSignal, trace *parseTrace) (*TradingSignal, string, *SignalRejection, error) {
+	trace.Printf("Email ID %s, original HTML length: %d", email.ID, len(email.HTML))
+	trace.Printf("Original HTML first 200 chars: %s", strings.ReplaceAll(email.HTML[:min(200, len(email.HTML))], "\n", " "))
 
-	// Limit to first 1000 characters of HTML
-	if len(htmlContent) > 1000 {
-		htmlContent = htmlContent[:1000]
-		log.Printf("PARSING: Truncated HTML to 1000 chars")
+	// Strip all HTML/XML tags and normalize whitespace, unless dropEmptyEmails already has
+	plainText := email.Text
+	if plainText == "" {
+		plainText = htmlToPlainText(email.HTML)
 	}
 
-	// Use bluemonday to properly strip all HTML/XML tags and entities
-	p := bluemonday.StripTagsPolicy()
-	plainText := p.Sanitize(htmlContent)
-	log.Printf("PARSING: After bluemonday stripping, length: %d", len(plainText))
-	log.Printf("PARSING: Stripped text first 200 chars: %s", strings.ReplaceAll(plainText[:min(200, len(plainText))], "\n", " "))
+	// A forwarded alert is parsed from the original message rather than the forwarding wrapper
+	plainText, forwarded := forwardedContent(plainText)
+	if forwarded {
+		plainText = stripForwardHeaders(plainText)
+		trace.Printf("Forwarded email, parsing the %d chars below the last forward marker", len(plainText))
+	}
 
-	// Clean up whitespace and normalize
-	plainText = regexp.MustCompile(`[\r\n\t]+`).ReplaceAllString(plainText, " ")
-	plainText = regexp.MustCompile(`\s+`).ReplaceAllString(plainText, " ")
-	plainText = strings.TrimSpace(plainText)
-	log.Printf("PARSING: After whitespace cleanup, length: %d", len(plainText))
-	log.Printf("PARSING: Final cleaned text: %s", plainText[:min(200, len(plainText))])
+	// Limit to the first 1000 characters of text. Cutting the HTML instead left little but the
+	// <head> and <style> of most newsletters.
+	if len(plainText) > 1000 {
+		plainText = strings.TrimSpace(strings.ToValidUTF8(plainText[:1000], ""))
+		trace.Printf("Truncated text to 1000 chars")
+	}
+	trace.Printf("After stripping and whitespace cleanup, length: %d", len(plainText))
+	if plainText == "" {
+		return nil, "", nil, errNoContent
+	}
+	trace.Printf("Final cleaned text: %s", plainText[:min(200, len(plainText))])
 
 	// Create cleaned lowercase version for raw_html field storage
 	cleanedText := strings.ToLower(plainText)
-	log.Printf("PARSING: Lowercase version for storage: %s", cleanedText[:min(100, len(cleanedText))])
+	trace.Printf("Lowercase version for storage: %s", cleanedText[:min(100, len(cleanedText))])
 
 	// Keep original case for ticker extraction, lowercase for price patterns
 	htmlLower := strings.ToLower(plainText)
 
+	signalDate, entryDate := signalDates(email.Date, htmlLower, trace)
+
 	// Initialize signal
 	signal := &TradingSignal{
 		EmailID:    email.ID,
-		SignalDate: email.Date.Unix() * 1000,                   // Convert to milliseconds
-		EntryDate:  email.Date.Add(24*time.Hour).Unix() * 1000, // Next day in milliseconds
+		EmailDate:  email.Date.Unix() * 1000, // Convert to milliseconds
+		SignalDate: signalDate.Unix() * 1000,
+		EntryDate:  entryDate.Unix() * 1000,
 	}
 
 	// Extract ticker symbol using proven patterns from existing codebase
-	extractTicker(signal, plainText, htmlLower)
+	extractTicker(signal, plainText, htmlLower, trace)
 
-	// Extract prices
-	extractBuyPrice(signal, htmlLower)
-	extractStopPrice(signal, htmlLower)
-	extractTargetPrice(signal, htmlLower)
+	// Extract prices, from digits rewritten out of spelled-out numbers when SPELLED_PRICES is on
+	priceText := htmlLower
+	if spelledPrices {
+		priceText = replaceSpelledPrices(htmlLower)
+	}
+	extractBuyPrice(signal, priceText, trace)
+	extractStopPrice(signal, priceText, trace)
+	extractTargetPrice(signal, priceText, trace)
+	extractTargets(signal, priceText, trace)
+	extractRiskNotes(signal, htmlLower, trace)
 
 	// Validate signal - must have ticker and at least buy price
-	log.Printf("PARSING: Final signal validation - Ticker: '%s', BuyPrice: %.2f, StopPrice: %.2f, TargetPrice: %.2f",
-		signal.Ticker, signal.BuyPrice, signal.StopPrice, signal.TargetPrice)
+	trace.Printf("Final signal validation - Ticker: '%s', BuyPrice: %s, StopPrice: %s, TargetPrice: %s",
+		signal.Ticker, formatPrice(signal.BuyPrice), formatPrice(signal.StopPrice), formatPrice(signal.TargetPrice))
 
 	if signal.Ticker == "" || signal.BuyPrice == 0 {
-		log.Printf("PARSING: Signal validation FAILED - missing ticker or buy price")
-		return nil, cleanedText, nil // No valid signal found
+		trace.Printf("Signal validation FAILED - missing ticker or buy price")
+		rejection := &SignalRejection{Reason: rejectMissingFields, Detail: "missing ticker or buy price"}
+		trace.reject(rejection, plainText)
+		return signal, cleanedText, rejection, nil
+	}
+
+	if rejection := tickerListRejection(signal.Ticker); rejection != nil {
+		trace.Printf("Signal validation FAILED - %s", rejection.Detail)
+		trace.reject(rejection, plainText)
+		return signal, cleanedText, rejection, nil
+	}
+
+	// Backtests are in dollars, so a price quoted in another currency is converted or rejected
+	if rejection := convertSignalCurrency(signal, trace); rejection != nil {
+		trace.Printf("Signal validation FAILED - %s", rejection.Detail)
+		trace.reject(rejection, plainText)
+		return signal, cleanedText, rejection, nil
+	}
+
+	if rejection := checkPriceSanity(signal); rejection != nil {
+		trace.Printf("Signal validation FAILED - %s", rejection.Detail)
+		trace.reject(rejection, plainText)
+		return signal, cleanedText, rejection, nil
+	}
+
+	trace.Printf("Signal validation PASSED - returning valid signal")
+	return signal, cleanedText, nil, nil
+}
+
+// signalDates returns the effective signal date and the entry date of an email sent at sent.
+// A trade date stated in the body replaces the email date when SIGNAL_DATE_SOURCE=content. It keeps
+// the email's time of day, so signals stated for the same day keep distinct dates. Entry timing
+// phrases and ENTRY_OFFSET_HOURS then count from the signal date, but a trade cannot enter
+// before the email announcing it was sent.
+func signalDates(sent time.Time, textLower string, trace *parseTrace) (time.Time, time.Time) {
+	signalDate := sent
+	if signalDateSource == "content" {
+		if stated, ok := statedTradeDate(textLower, sent, trace); ok {
+			trace.Printf("Trade date from text: %s", formatDate(stated))
+			utc := sent.UTC()
+			signalDate = stated.Add(utc.Sub(utc.Truncate(24 * time.Hour)))
+		}
+	}
+
+	entryDate := entryDateFor(signalDate, textLower, trace)
+	if entryDate.Before(sent) {
+		trace.Printf("Entry %s is before the email was sent, entering at %s instead", entryDate.Format(time.RFC3339), sent.Format(time.RFC3339))
+		entryDate = sent
+	}
+	return signalDate, entryDate
+}
+
+var (
+	// Entry timing phrases such as "enter today", "buy tomorrow" or "on the open Monday"
+	entrySameDayRe = regexp.MustCompile(`\b(?:enter|entry|buy|open)\b[^.\n]{0,30}?\b(?:today|now|immediately|this morning)\b`)
+	entryNextDayRe = regexp.MustCompile(`\b(?:enter|entry|buy|open)\b[^.\n]{0,30}?\btomorrow\b`)
+	entryWeekdayRe = regexp.MustCompile(`\b(?:enter|entry|buy|open)\b[^.\n]{0,30}?\b(monday|tuesday|wednesday|thursday|friday)\b`)
+	weekdaysByName = map[string]time.Weekday{
+		"monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+		"thursday": time.Thursday, "friday": time.Friday,
+	}
+)
+
+// entryDateFor returns when a signal sent at signalDate should enter. Explicit timing in the
+// text wins: same day, tomorrow, or the next named weekday (today if it is that weekday).
+// Otherwise the entry is signalDate plus ENTRY_OFFSET_HOURS.
+func entryDateFor(signalDate time.Time, textLower string, trace *parseTrace) time.Time {
+	if entrySameDayRe.MatchString(textLower) {
+		trace.Printf("Entry timing from text: same day")
+		return signalDate
+	}
+	if entryNextDayRe.MatchString(textLower) {
+		trace.Printf("Entry timing from text: tomorrow")
+		return signalDate.AddDate(0, 0, 1)
+	}
+	if matches := entryWeekdayRe.FindStringSubmatch(textLower); len(matches) > 1 {
+		days := (int(weekdaysByName[matches[1]]) - int(signalDate.Weekday()) + 7) % 7
+		trace.Printf("Entry timing from text: %s, %d days after the signal", matches[1], days)
+		return signalDate.AddDate(0, 0, days)
+	}
+	return signalDate.Add(entryOffset)
+}
+
+var (
+	// Trade dates stated after "for", such as "for Monday, June 3, 2024", "for June 3" or "for Monday 6/3/24"
+	statedMonthDateRe   = regexp.MustCompile(`\bfor\s+(?:(monday|tuesday|wednesday|thursday|friday),?\s+)?(january|february|march|april|may|june|july|august|september|october|november|december|jan|feb|mar|apr|jun|jul|aug|sept|sep|oct|nov|dec)\.?\s+(\d{1,2})(?:st|nd|rd|th)?\b(?:,?\s+(\d{4})\b)?`)
+	statedNumericDateRe = regexp.MustCompile(`\bfor\s+(?:(monday|tuesday|wednesday|thursday|friday),?\s+)?(\d{1,2})/(\d{1,2})(?:/(\d{4}|\d{2}))?\b`)
+)
+
+// statedTradeDate finds a trade date stated in the text and returns it as midnight UTC, the day
+// the backtest enters on. A date without a year takes the one that puts it within six months of
+// sent. Impossible dates and dates whose named weekday disagrees with the calendar are ignored.
+func statedTradeDate(textLower string, sent time.Time, trace *parseTrace) (time.Time, bool) {
+	var weekday, monthText, dayText, yearText string
+	var month time.Month
+	if m := statedMonthDateRe.FindStringSubmatch(textLower); m != nil {
+		weekday, monthText, dayText, yearText = m[1], m[2], m[3], m[4]
+		for candidate := time.January; candidate <= time.December; candidate++ {
+			if strings.HasPrefix(strings.ToLower(candidate.String()), monthText[:3]) {
+				month = candidate
+				break
+			}
+		}
+	} else if m := statedNumericDateRe.FindStringSubmatch(textLower); m != nil {
+		weekday, monthText, dayText, yearText = m[1], m[2], m[3], m[4]
+		n, _ := strconv.Atoi(monthText)
+		month = time.Month(n)
+	} else {
+		return time.Time{}, false
+	}
+
+	day, _ := strconv.Atoi(dayText)
+	sent = sent.UTC()
+	year := sent.Year()
+	if yearText != "" {
+		year, _ = strconv.Atoi(yearText)
+		if year < 100 {
+			year += 2000
+		}
+	}
+
+	date := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	if month < time.January || month > time.December || date.Month() != month || date.Day() != day {
+		return time.Time{}, false
+	}
+	if yearText == "" {
+		const halfYear = 183 * 24 * time.Hour
+		if date.Before(sent.Add(-halfYear)) {
+			date = date.AddDate(1, 0, 0)
+		} else if date.After(sent.Add(halfYear)) {
+			date = date.AddDate(-1, 0, 0)
+		}
+	}
+
+	if weekday != "" && date.Weekday() != weekdaysByName[weekday] {
+		trace.Printf("Ignoring stated date %s, which is a %s, not %s", formatDate(date), date.Weekday(), weekday)
+		return time.Time{}, false
+	}
+	return date, true
+}
+
+// proximityMaxTickerLength caps tickers found without exchange context, so longer
+// symbols only come from (EXCHANGE: TICKER) mentions rather than random uppercase words
+const proximityMaxTickerLength = 5
+
+// tickerPattern matches a ticker of up to maxLen base letters with an optional class-share suffix (e.g. BRK.B)
+func tickerPattern(maxLen int) string {
+	return fmt.Sprintf(`[A-Z]{%d,%d}(?:\.[A-Z])?`, tickerMinLength, maxLen)
+}
+
+// maxCompanyWords caps the words taken as a company name before an exchange-formatted ticker
+const maxCompanyWords = 6
+
+// companyConnectors may join the capitalized words of a company name, as in "Bank of America"
+var companyConnectors = map[string]bool{"of": true, "and": true, "&": true}
+
+// companyBefore returns the company name ending text, which precedes a "(NASDAQ: TICKER)" mention:
+// the capitalized words on its last line, back to the first other word, sentence break or price
+// keyword, so "Buy Acme Corp" gives "Acme Corp". It returns "" when no name precedes the parenthesis.
+func companyBefore(text string) string {
+	if i := strings.LastIndex(text, "\n"); i >= 0 {
+		text = text[i+1:]
+	}
+	words := strings.Fields(text)
+	priceWords := make(map[string]bool)
+	for _, word := range concatKeywords(keywords.EntryKeywords, keywords.StopKeywords, keywords.TargetKeywords) {
+		priceWords[word] = true
 	}
 
-	log.Printf("PARSING: Signal validation PASSED - returning valid signal")
-	return signal, cleanedText, nil
+	start := len(words)
+	for i := len(words) - 1; i >= 0 && len(words)-i <= maxCompanyWords; i-- {
+		word := words[i]
+		if i < len(words)-1 && strings.ContainsAny(word[len(word)-1:], ".:;!?") {
+			break // the end of a sentence or label, as in "Alert: Acme Corp"
+		}
+		bare := strings.TrimRight(word, ".,")
+		if bare == "" || priceWords[strings.ToLower(bare)] {
+			break
+		}
+		if _, err := strconv.ParseFloat(strings.TrimPrefix(bare, "$"), 64); err == nil {
+			break // a price, not a name like "3M"
+		}
+		first, _ := utf8.DecodeRuneInString(bare)
+		if !unicode.IsUpper(first) && !unicode.IsDigit(first) && !companyConnectors[bare] {
+			break
+		}
+		start = i
+	}
+	for start < len(words) && companyConnectors[words[start]] {
+		start++
+	}
+	return strings.TrimRight(strings.Join(words[start:], " "), ",")
+}
+
+// isValidTickerLength checks the base symbol length, ignoring any class-share suffix
+func isValidTickerLength(ticker string, maxLen int) bool {
+	base := strings.SplitN(ticker, ".", 2)[0]
+	return len(base) >= tickerMinLength && len(base) <= maxLen
+}
+
+// tickerListRejection returns why TICKER_ALLOWLIST or TICKER_DENYLIST excludes a ticker, or nil when it is accepted
+func tickerListRejection(ticker string) *SignalRejection {
+	ticker = strings.ToUpper(ticker)
+	if tickerDenylist[ticker] {
+		return &SignalRejection{rejectTickerDenied, fmt.Sprintf("ticker %s is on TICKER_DENYLIST", ticker)}
+	}
+	if tickerAllowlist != nil && !tickerAllowlist[ticker] {
+		return &SignalRejection{rejectTickerNotAllowed, fmt.Sprintf("ticker %s is not on TICKER_ALLOWLIST", ticker)}
+	}
+	return nil
+}
+
+// checkPriceSanity applies the configured price range and the long-trade ordering stop < buy < target,
+// loosened by PRICE_RELATIONSHIP_TOLERANCE. Missing stop or target prices are not checked.
+// It returns nil if the signal passes.
+func checkPriceSanity(signal *TradingSignal) *SignalRejection {
+	prices := []struct {
+		name  string
+		value float64
+	}{
+		{"buy", signal.BuyPrice},
+		{"stop", signal.StopPrice},
+		{"target", signal.TargetPrice},
+	}
+	for _, p := range prices {
+		if p.value != 0 && (p.value <= priceMin || p.value >= priceMax) {
+			return &SignalRejection{rejectPriceRange, fmt.Sprintf("%s price %s outside range (%g, %g)", p.name, formatPrice(p.value), priceMin, priceMax)}
+		}
+	}
+
+	targetBelow := signal.TargetPrice != 0 && signal.TargetPrice < signal.BuyPrice*relationshipTolerance
+	stopAbove := signal.StopPrice != 0 && signal.BuyPrice < signal.StopPrice*relationshipTolerance
+	switch {
+	case targetBelow && stopAbove:
+		return &SignalRejection{rejectInverted, fmt.Sprintf("stop %s above and target %s below buy %s", formatPrice(signal.StopPrice), formatPrice(signal.TargetPrice), formatPrice(signal.BuyPrice))}
+	case targetBelow:
+		return &SignalRejection{rejectTargetBelowBuy, fmt.Sprintf("target %s below buy %s * %g", formatPrice(signal.TargetPrice), formatPrice(signal.BuyPrice), relationshipTolerance)}
+	case stopAbove:
+		return &SignalRejection{rejectStopAboveBuy, fmt.Sprintf("buy %s below stop %s * %g", formatPrice(signal.BuyPrice), formatPrice(signal.StopPrice), relationshipTolerance)}
+	}
+
+	return nil
+}
+
+// namedPattern pairs a regex with the provenance name recorded when it produces a field
+type namedPattern struct {
+	name    string
+	pattern string
+	re      *regexp.Regexp
+}
+
+// newNamedPattern compiles pattern under name
+func newNamedPattern(name, pattern string) namedPattern {
+	return namedPattern{name, pattern, regexp.MustCompile(pattern)}
+}
+
+// parserPatternSet holds the Go parser's configuration-dependent regexes, compiled once rather than per email.
+// Go's regexp package is RE2-based and matches in linear time, so no pattern can backtrack catastrophically;
+// the lazy gaps that remain, such as the entry-day rules' [^.\n]{0,30}?, are bounded anyway.
+type parserPatternSet struct {
+	exchange  []namedPattern // "(NASDAQ: ACME)" and "NASDAQ: ACME" for each TICKER_EXCHANGES entry
+	proximity []namedPattern // tickers next to "buy", a label or a price, for emails without an exchange
+
+	entry, stop, stopSynonyms, target, targetSynonyms []namedPattern
+
+	// The keywords of the other two prices, which end each price's search window
+	notBuy, notStop, notTarget []namedPattern
+}
+
+// parserPatterns is rebuilt by validateConfig once TICKER_EXCHANGES and KEYWORDS_FILE have been read
+var parserPatterns = compileParserPatterns()
+
+// compileParserPatterns compiles the parser's regexes for the current ticker and keyword configuration
+func compileParserPatterns() *parserPatternSet {
+	proximityTicker := tickerPattern(min(tickerMaxLength, proximityMaxTickerLength))
+	return &parserPatternSet{
+		exchange: exchangePatterns(tickerExchanges, tickerPattern(tickerMaxLength)),
+		proximity: []namedPattern{
+			newNamedPattern("go:ticker_buy", `\b(`+proximityTicker+`)\s*(?:buy|BUY)`),                    // Ticker followed by buy
+			newNamedPattern("go:buy_ticker", `(?:buy|BUY)\s*(`+proximityTicker+`)\b`),                    // Buy followed by ticker
+			newNamedPattern("go:ticker_label", `(?:symbol|ticker|stock)[:=]?\s*(`+proximityTicker+`)\b`), // Explicit ticker mention
+			newNamedPattern("go:ticker_at_price", `\b(`+proximityTicker+`)\s+at\s+\$?\d+`),               // Ticker at price
+			newNamedPattern("go:ticker_dash_price", `\b(`+proximityTicker+`)\s*[-:]\s*\$?\d+`),           // Ticker: price or Ticker - price
+		},
+		entry:          keywordPatterns(keywords.EntryKeywords),
+		stop:           keywordPatterns(keywords.StopKeywords),
+		stopSynonyms:   keywordPatterns(keywords.StopSynonyms),
+		target:         keywordPatterns(keywords.TargetKeywords),
+		targetSynonyms: keywordPatterns(keywords.TargetSynonyms),
+		notBuy:         keywordPatterns(concatKeywords(keywords.StopKeywords, keywords.StopSynonyms, keywords.TargetKeywords, keywords.TargetSynonyms)),
+		notStop:        keywordPatterns(concatKeywords(keywords.EntryKeywords, keywords.TargetKeywords, keywords.TargetSynonyms)),
+		notTarget:      keywordPatterns(concatKeywords(keywords.EntryKeywords, keywords.StopKeywords, keywords.StopSynonyms)),
+	}
+}
+
+// exchangePatterns builds the exchange-format ticker patterns: "(NASDAQ: TICKER)" for every exchange
+// first, then the bare "NASDAQ: TICKER", each named go:<exchange>_paren or go:<exchange>
+func exchangePatterns(exchanges []string, ticker string) []namedPattern {
+	patterns := make([]namedPattern, 0, 2*len(exchanges))
+	for _, exchange := range exchanges {
+		name := "go:" + strings.ToLower(exchange) + "_paren"
+		patterns = append(patterns, newNamedPattern(name, `\(\s*`+regexp.QuoteMeta(exchange)+`:\s*(`+ticker+`)\s*\)`))
+	}
+	for _, exchange := range exchanges {
+		name := "go:" + strings.ToLower(exchange)
+		patterns = append(patterns, newNamedPattern(name, regexp.QuoteMeta(exchange)+`:\s*(`+ticker+`)\b`))
+	}
+	return patterns
 }
 
 // extractTicker extracts ticker symbol using proven patterns
-func extractTicker(signal *TradingSignal, plainText, htmlLower string) {
+func extractTicker(signal *TradingSignal, plainText, htmlLower string, trace *parseTrace) {
 	// Common exclusion words that are not tickers
 	exclusionWords := map[string]bool{
 		"BUY": true, "SELL": true, "STOP": true, "TARGET": true, "PRICE": true,
 		"ENTRY": true, "EXIT": true, "LOSS": true, "PROFIT": true, "TAKE": true,
 		"AT": true, "TO": true, "FROM": true, "AND": true, "OR": true, "THE": true,
 	}
-	log.Printf("PARSING: Starting ticker extraction from text: %s", plainText[:min(100, len(plainText))])
+	trace.Printf("Starting ticker extraction from text: %s", plainText[:min(100, len(plainText))])
 
 	// Primary: Exchange format patterns (most reliable from SQL implementation)
-	exchangePatterns := []string{
-		`\(\s*NASDAQ:\s*([A-Z]{2,5})\s*\)`, // (NASDAQ: TICKER)
-		`\(\s*NYSE:\s*([A-Z]{2,5})\s*\)`,   // (NYSE: TICKER)
-		`NASDAQ:\s*([A-Z]{2,5})\b`,         // NASDAQ: TICKER
-		`NYSE:\s*([A-Z]{2,5})\b`,           // NYSE: TICKER
-	}
-
-	for _, pattern := range exchangePatterns {
-		re := regexp.MustCompile(pattern)
-		if matches := re.FindStringSubmatch(plainText); len(matches) > 1 {
-			ticker := strings.ToUpper(matches[1])
-			log.Printf("PARSING: Found exchange pattern match: %s -> %s", pattern, ticker)
-			if !exclusionWords[ticker] && len(ticker) >= 2 && len(ticker) <= 5 {
+	for _, np := range parserPatterns.exchange {
+		if loc := np.re.FindStringSubmatchIndex(plainText); loc != nil {
+			ticker := strings.ToUpper(plainText[loc[2]:loc[3]])
+			trace.Printf("Found exchange pattern match: %s -> %s", np.pattern, ticker)
+			if !exclusionWords[ticker] && isValidTickerLength(ticker, tickerMaxLength) {
 				signal.Ticker = ticker
-				log.Printf("PARSING: Set ticker from exchange pattern: %s", ticker)
+				signal.TickerSource = np.name
+				if strings.HasSuffix(np.name, "_paren") {
+					signal.Company = companyBefore(plainText[:loc[0]])
+				}
+				trace.Printf("Set ticker from exchange pattern: %s (company %q)", ticker, signal.Company)
 				return
 			} else {
-				log.Printf("PARSING: Rejected ticker %s (excluded or invalid length)", ticker)
+				trace.Printf("Rejected ticker %s (excluded or invalid length)", ticker)
 			}
 		}
 	}
 
 	// Secondary: Proximity patterns (from main.go implementation)
 	if signal.Ticker == "" {
-		log.Printf("PARSING: No ticker found in exchange patterns, trying proximity patterns")
-		proximityPatterns := []string{
-			`\b([A-Z]{2,5})\s*(?:buy|BUY)`,                  // Ticker followed by buy
-			`(?:buy|BUY)\s*([A-Z]{2,5})\b`,                  // Buy followed by ticker
-			`(?:symbol|ticker|stock)[:=]?\s*([A-Z]{2,5})\b`, // Explicit ticker mention
-			`\b([A-Z]{2,5})\s+at\s+\$?\d+`,                  // Ticker at price
-			`\b([A-Z]{2,5})\s*[-:]\s*\$?\d+`,                // Ticker: price or Ticker - price
-		}
-
-		for _, pattern := range proximityPatterns {
-			re := regexp.MustCompile(pattern)
-			if matches := re.FindStringSubmatch(plainText); len(matches) > 1 {
+		trace.Printf("No ticker found in exchange patterns, trying proximity patterns")
+		proximityMax := min(tickerMaxLength, proximityMaxTickerLength)
+		for _, np := range parserPatterns.proximity {
+			if matches := np.re.FindStringSubmatch(plainText); len(matches) > 1 {
 				ticker := strings.ToUpper(matches[1])
-				log.Printf("PARSING: Found proximity pattern match: %s -> %s", pattern, ticker)
-				if !exclusionWords[ticker] && len(ticker) >= 2 && len(ticker) <= 5 {
+				trace.Printf("Found proximity pattern match: %s -> %s", np.pattern, ticker)
+				if !exclusionWords[ticker] && isValidTickerLength(ticker, proximityMax) {
 					signal.Ticker = ticker
-					log.Printf("PARSING: Set ticker from proximity pattern: %s", ticker)
+					signal.TickerSource = np.name
+					trace.Printf("Set ticker from proximity pattern: %s", ticker)
 					return
 				} else {
-					log.Printf("PARSING: Rejected proximity ticker %s (excluded or invalid length)", ticker)
+					trace.Printf("Rejected proximity ticker %s (excluded or invalid length)", ticker)
 				}
 			}
 			// Also try with lowercase version for case variations
-			if matches := re.FindStringSubmatch(htmlLower); len(matches) > 1 {
+			if matches := np.re.FindStringSubmatch(htmlLower); len(matches) > 1 {
 				ticker := strings.ToUpper(matches[1])
-				log.Printf("PARSING: Found lowercase proximity pattern match: %s -> %s", pattern, ticker)
-				if !exclusionWords[ticker] && len(ticker) >= 2 && len(ticker) <= 5 {
+				trace.Printf("Found lowercase proximity pattern match: %s -> %s", np.pattern, ticker)
+				if !exclusionWords[ticker] && isValidTickerLength(ticker, proximityMax) {
 					signal.Ticker = ticker
-					log.Printf("PARSING: Set ticker from lowercase proximity pattern: %s", ticker)
+					signal.TickerSource = np.name + "_lower"
+					trace.Printf("Set ticker from lowercase proximity pattern: %s", ticker)
 					return
 				} else {
-					log.Printf("PARSING: Rejected lowercase proximity ticker %s (excluded or invalid length)", ticker)
+					trace.Printf("Rejected lowercase proximity ticker %s (excluded or invalid length)", ticker)
 				}
 			}
 		}
 	}
 }
 
-// extractBuyPrice extracts buy price from text
-func extractBuyPrice(signal *TradingSignal, htmlLower string) {
-	log.Printf("PARSING: Starting BUY price extraction from: %s", htmlLower[:min(100, len(htmlLower))])
-	buyPatterns := []string{
-		`buy.*?(?:at|@|price|:)?\s*\$?(\d+\.?\d*)`,
-		`entry.*?(?:at|@|price|:)?\s*\$?(\d+\.?\d*)`,
-		`buy\s+(?:at\s+)?\$?(\d+\.?\d*)`,
+// priceWindow bounds how far after a keyword its price may appear, like the SQL parser's 100-char segments
+const priceWindow = 100
+
+// reverseWindow bounds how far before a keyword a number may appear in the reversed "48 stop" form
+const reverseWindow = 20
+
+// synonymWindow is the tighter bound for synonyms such as "support" and "resistance", which also occur
+// in ordinary prose ("customer support"). Only a number written right after one, as in "support at 48"
+// or "resistance: $60", counts as a price; a number further along its window does not.
+const synonymWindow = 20
+
+// priceNumberRe finds a price, including the leading-dot form "$.125" so sub-dollar quotes keep their decimals
+var priceNumberRe = regexp.MustCompile(currencySymbolPattern + `?(\d+(?:\.\d+)?|\.\d+)`)
+
+// roundPrice rounds a price to PRICE_DECIMALS places for a JSON response
+func roundPrice(price float64) float64 {
+	scale := math.Pow10(priceDecimals)
+	return math.Round(price*scale) / scale
+}
+
+// roundPrices rounds each price in place with roundPrice
+func roundPrices(prices ...*float64) {
+	for _, price := range prices {
+		*price = roundPrice(*price)
 	}
+}
 
-	for _, pattern := range buyPatterns {
-		re := regexp.MustCompile(pattern)
-		if matches := re.FindStringSubmatch(htmlLower); len(matches) > 1 {
-			log.Printf("PARSING: Found BUY price pattern match: %s -> %s", pattern, matches[1])
-			if price, err := strconv.ParseFloat(matches[1], 64); err == nil {
-				signal.BuyPrice = price
-				log.Printf("PARSING: Set BUY price: %.2f", price)
-				return
-			} else {
-				log.Printf("PARSING: Failed to parse BUY price %s: %v", matches[1], err)
+// formatPrice formats a price for logs with PRICE_DECIMALS places, dropping trailing zeros past the second.
+// It rounds like roundPrice, so a log line shows the same price as the JSON response.
+func formatPrice(price float64) string {
+	formatted := strconv.FormatFloat(roundPrice(price), 'f', priceDecimals, 64)
+	if dot := strings.IndexByte(formatted, '.'); dot >= 0 {
+		keep := dot + 1 + min(2, priceDecimals)
+		formatted = formatted[:keep] + strings.TrimRight(formatted[keep:], "0")
+	}
+	return formatted
+}
+
+// keywordPatterns turns configured keywords into regexes named go:<keyword> for provenance.
+// Spaces and hyphens in a keyword match either separator, and word boundaries are added
+// where the keyword starts or ends with a letter or digit.
+func keywordPatterns(words []string) []namedPattern {
+	patterns := make([]namedPattern, 0, len(words))
+	for _, word := range words {
+		pattern := strings.NewReplacer(" ", `[-\s]?`, "-", `[-\s]?`).Replace(regexp.QuoteMeta(word))
+		if isWordChar(word[0]) {
+			pattern = `\b` + pattern
+		}
+		if isWordChar(word[len(word)-1]) {
+			pattern += `\b`
+		}
+		patterns = append(patterns, newNamedPattern("go:"+word, pattern))
+	}
+	return patterns
+}
+
+// isWordChar reports whether b is a regex word character
+func isWordChar(b byte) bool {
+	return b == '_' || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9')
+}
+
+// keywordMatch is one occurrence of a price keyword in the text
+type keywordMatch struct {
+	start, end int
+	name       string
+}
+
+// findKeywords returns every occurrence of the keywords in text order
+func findKeywords(keywords []namedPattern, text string) []keywordMatch {
+	var matches []keywordMatch
+	for _, kw := range keywords {
+		for _, loc := range kw.re.FindAllStringIndex(text, -1) {
+			matches = append(matches, keywordMatch{start: loc[0], end: loc[1], name: kw.name})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].start < matches[j].start })
+	return matches
+}
+
+// Price confidence levels, from a number that merely follows a keyword to an explicit "at $X"
+const (
+	confidenceLoose    = 1 // a number later in the keyword's window, e.g. "buy zone 49-51"
+	confidenceReversed = 2 // a number right before the keyword, e.g. "$50 is our buy point"
+	confidenceDirect   = 3 // a number right after the keyword, e.g. "buy: $50"
+	confidenceExplicit = 4 // "at" or "@" before the number, e.g. "buy at $50"
+)
+
+var (
+	explicitPriceRe = regexp.MustCompile(`^[\s:]*(?:at\b|@)\s*` + currencySymbolPattern + `?(\d+(?:\.\d+)?|\.\d+)`)
+	directPriceRe   = regexp.MustCompile(`^[\s:]*` + currencySymbolPattern + `?(\d+(?:\.\d+)?|\.\d+)`)
+
+	// reversedPriceRe finds a number ending the text before a keyword, optionally followed by "is our" or "as the"
+	reversedPriceRe = regexp.MustCompile(currencySymbolPattern + `?(\d+(?:\.\d+)?|\.\d+)[\s:]*(?:(?:is|as)\s+(?:our|the|a|my)\s+)?$`)
+	// keywordPriceGapRe matches the text between a keyword and a number that is that keyword's own price
+	keywordPriceGapRe = regexp.MustCompile(`^[\s:]*(?:at\b|@)?\s*` + currencySymbolPattern + `?$`)
+)
+
+// priceCandidate is a price found after one keyword occurrence
+type priceCandidate struct {
+	price      float64
+	source     string
+	confidence int
+	priority   int  // index of the keyword in its configured list
+	synonym    bool // found after a synonym, which ranks below every keyword
+	distance   int  // characters between the keyword and the number, before or after it
+	pos        int
+	start, end int // where the number is in the text, for the currency written around it
+}
+
+// better reports whether c should be chosen over other: a keyword's price over a synonym's, then higher
+// confidence, then the higher-priority keyword, then the number closest to its keyword, then the earlier mention
+func (c priceCandidate) better(other priceCandidate) bool {
+	if c.synonym != other.synonym {
+		return other.synonym
+	}
+	if c.confidence != other.confidence {
+		return c.confidence > other.confidence
+	}
+	if c.priority != other.priority {
+		return c.priority < other.priority
+	}
+	if c.distance != other.distance {
+		return c.distance < other.distance
+	}
+	return c.pos < other.pos
+}
+
+// extractPrice collects a candidate after every occurrence of every keyword and returns the most
+// confident one. Each search window ends priceWindow characters after the keyword (synonymWindow
+// for synonyms) or at the next keyword for another price, whichever comes first, so a buy keyword
+// cannot pick up the stop or target number that follows it. Synonyms rank below every keyword.
+// The currency written around the chosen number is returned with it.
+func extractPrice(label string, keywords, synonyms, otherKeywords []namedPattern, htmlLower string, trace *parseTrace) (float64, string, string) {
+	others := findKeywords(otherKeywords, htmlLower)
+
+	var best *priceCandidate
+	for priority, keyword := range append(append([]namedPattern{}, keywords...), synonyms...) {
+		synonym := priority >= len(keywords)
+		window := priceWindow
+		if synonym {
+			window = synonymWindow
+		}
+		for _, candidate := range priceCandidates(label, keyword, priority, window, others, htmlLower, trace) {
+			if synonym {
+				if candidate.confidence == confidenceLoose {
+					continue // "customer support is open 24 hours" names no price
+				}
+				candidate.synonym = true
+			}
+			if best == nil || candidate.better(*best) {
+				best = &candidate
 			}
 		}
 	}
+	if best == nil {
+		return 0, "", ""
+	}
+
+	currency := priceCurrency(htmlLower, best.start, best.end)
+	trace.Printf("Set %s price: %s %s (keyword %s, confidence %d)", label, formatPrice(best.price), currency, best.source, best.confidence)
+	return best.price, best.source, currency
 }
 
-// extractStopPrice extracts stop loss price from text
-func extractStopPrice(signal *TradingSignal, htmlLower string) {
-	log.Printf("PARSING: Starting STOP price extraction")
-	stopPatterns := []string{
-		`(?:stop|stop[-\s]?loss).*?(?:at|@|price|:)?\s*\$?(\d+\.?\d*)`,
-		`(?:sl|s\.l\.).*?(?:at|@|price|:)?\s*\$?(\d+\.?\d*)`,
-		`stop\s+(?:at\s+)?\$?(\d+\.?\d*)`,
-	}
-
-	for _, pattern := range stopPatterns {
-		re := regexp.MustCompile(pattern)
-		if matches := re.FindStringSubmatch(htmlLower); len(matches) > 1 {
-			log.Printf("PARSING: Found STOP price pattern match: %s -> %s", pattern, matches[1])
-			if price, err := strconv.ParseFloat(matches[1], 64); err == nil {
-				signal.StopPrice = price
-				log.Printf("PARSING: Set STOP price: %.2f", price)
-				return
-			} else {
-				log.Printf("PARSING: Failed to parse STOP price %s: %v", matches[1], err)
+// priceCandidates returns the price found within maxWindow characters after each occurrence of keyword,
+// and any number written just before it
+func priceCandidates(label string, keyword namedPattern, priority, maxWindow int, others []keywordMatch, htmlLower string, trace *parseTrace) []priceCandidate {
+	var candidates []priceCandidate
+	for _, kw := range findKeywords([]namedPattern{keyword}, htmlLower) {
+		if candidate, ok := reversedCandidate(label, kw, priority, others, htmlLower, trace); ok {
+			candidates = append(candidates, candidate)
+		}
+
+		windowEnd := min(kw.end+maxWindow, len(htmlLower))
+		for _, other := range others {
+			if other.start >= kw.end {
+				windowEnd = min(windowEnd, other.start)
+				break
+			}
+		}
+
+		window := htmlLower[kw.end:windowEnd]
+		confidence := confidenceExplicit
+		loc := explicitPriceRe.FindStringSubmatchIndex(window)
+		if loc == nil {
+			confidence = confidenceDirect
+			loc = directPriceRe.FindStringSubmatchIndex(window)
+		}
+		if loc == nil {
+			// Prefer a number on the keyword's own line over one further down
+			confidence = confidenceLoose
+			if line, _, found := strings.Cut(window, "\n"); found {
+				loc = priceNumberRe.FindStringSubmatchIndex(line)
 			}
+			if loc == nil {
+				loc = priceNumberRe.FindStringSubmatchIndex(window)
+			}
+		}
+		if len(loc) < 4 {
+			continue
+		}
+		number := window[loc[2]:loc[3]]
+		distance := strings.Index(window, number)
+
+		trace.Printf("Found %s price near keyword %s: %s (confidence %d)", label, kw.name, number, confidence)
+		price, err := strconv.ParseFloat(number, 64)
+		if err != nil {
+			trace.Printf("Failed to parse %s price %s: %v", label, number, err)
+			continue
+		}
+		candidates = append(candidates, priceCandidate{
+			price:      price,
+			source:     kw.name,
+			confidence: confidence,
+			priority:   priority,
+			distance:   distance,
+			pos:        kw.start,
+			start:      kw.end + loc[2],
+			end:        kw.end + loc[3],
+		})
+	}
+	return candidates
+}
+
+// reversedCandidate finds a price written before a keyword, as in "$50 is our buy point" or "48 stop".
+// The look-back stops reverseWindow characters before the keyword or at the previous keyword for another
+// price, and a number that directly follows that keyword is left to it: in "stop 45 target" the 45 is the stop.
+func reversedCandidate(label string, kw keywordMatch, priority int, others []keywordMatch, htmlLower string, trace *parseTrace) (priceCandidate, bool) {
+	windowStart := max(kw.start-reverseWindow, 0)
+	var previous *keywordMatch
+	for i := range others {
+		if others[i].end <= kw.start && others[i].end > windowStart {
+			windowStart = others[i].end
+			previous = &others[i]
+		}
+	}
+
+	window := htmlLower[windowStart:kw.start]
+	loc := reversedPriceRe.FindStringSubmatchIndex(window)
+	if loc == nil {
+		return priceCandidate{}, false
+	}
+	if previous != nil && keywordPriceGapRe.MatchString(window[:loc[2]]) {
+		return priceCandidate{}, false
+	}
+
+	number := window[loc[2]:loc[3]]
+	price, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		trace.Printf("Failed to parse %s price %s: %v", label, number, err)
+		return priceCandidate{}, false
+	}
+	trace.Printf("Found %s price before keyword %s: %s (confidence %d)", label, kw.name, number, confidenceReversed)
+	return priceCandidate{
+		price:      price,
+		source:     kw.name + "_before",
+		confidence: confidenceReversed,
+		priority:   priority,
+		distance:   len(window) - loc[3],
+		pos:        kw.start,
+		start:      windowStart + loc[2],
+		end:        windowStart + loc[3],
+	}, true
+}
+
+// buyPriceWindow returns the first entry keyword in the cleaned text and the text extractPrice examined around
+// it: the look-back for a number written before it and the window after it up to the next stop or target
+// keyword. Without an entry keyword the whole text was searched in vain, so that is returned.
+func buyPriceWindow(cleanedText string) (string, string) {
+	text := cleanedText
+	if spelledPrices {
+		text = replaceSpelledPrices(cleanedText)
+	}
+	matches := findKeywords(parserPatterns.entry, text)
+	if len(matches) == 0 {
+		return "", text
+	}
+
+	kw := matches[0]
+	start := max(kw.start-reverseWindow, 0)
+	end := min(kw.end+priceWindow, len(text))
+	for _, other := range findKeywords(parserPatterns.notBuy, text) {
+		if other.end <= kw.start && other.end > start {
+			start = other.end
+		}
+		if other.start >= kw.end {
+			end = min(end, other.start)
+			break
 		}
 	}
+	return kw.name, text[start:end]
+}
+
+// extractBuyPrice extracts buy price from text, and the currency it is quoted in as the signal's currency
+func extractBuyPrice(signal *TradingSignal, htmlLower string, trace *parseTrace) {
+	trace.Printf("Starting BUY price extraction from: %s", htmlLower[:min(100, len(htmlLower))])
+	signal.BuyPrice, signal.BuySource, signal.Currency = extractPrice("BUY", parserPatterns.entry, nil, parserPatterns.notBuy, htmlLower, trace)
+}
+
+// extractStopPrice extracts stop loss price from text
+func extractStopPrice(signal *TradingSignal, htmlLower string, trace *parseTrace) {
+	trace.Printf("Starting STOP price extraction")
+	signal.StopPrice, signal.StopSource, _ = extractPrice("STOP", parserPatterns.stop, parserPatterns.stopSynonyms, parserPatterns.notStop, htmlLower, trace)
 }
 
 // extractTargetPrice extracts target price from text
-func extractTargetPrice(signal *TradingSignal, htmlLower string) {
-	log.Printf("PARSING: Starting TARGET price extraction")
-	targetPatterns := []string{
-		`(?:target|take[-\s]?profit).*?(?:at|@|price|:)?\s*\$?(\d+\.?\d*)`,
-		`(?:tp|t\.p\.).*?(?:at|@|price|:)?\s*\$?(\d+\.?\d*)`,
-		`target\s+(?:at\s+)?\$?(\d+\.?\d*)`,
-	}
-
-	for _, pattern := range targetPatterns {
-		re := regexp.MustCompile(pattern)
-		if matches := re.FindStringSubmatch(htmlLower); len(matches) > 1 {
-			log.Printf("PARSING: Found TARGET price pattern match: %s -> %s", pattern, matches[1])
-			if price, err := strconv.ParseFloat(matches[1], 64); err == nil {
-				signal.TargetPrice = price
-				log.Printf("PARSING: Set TARGET price: %.2f", price)
-				return
-			} else {
-				log.Printf("PARSING: Failed to parse TARGET price %s: %v", matches[1], err)
-			}
+func extractTargetPrice(signal *TradingSignal, htmlLower string, trace *parseTrace) {
+	trace.Printf("Starting TARGET price extraction")
+	signal.TargetPrice, signal.TargetSource, _ = extractPrice("TARGET", parserPatterns.target, parserPatterns.targetSynonyms, parserPatterns.notTarget, htmlLower, trace)
+}
+
+// numberedTargetRe matches a scaled target such as "target 1: 55", "target #2 at $60", "tgt 3 - 65" or "t1 @ £55",
+// capturing the target's number and its price. The separator after the number keeps "target 55" a plain target.
+var numberedTargetRe = regexp.MustCompile(`\b(?:(?:target|tgt|pt)\s*#?\s*([1-9])|t([1-9]))\s*(?:[:=)\-]|\bat\b|@)\s*(?:at\b|@)?\s*` +
+	currencySymbolPattern + `?(\d+(?:\.\d+)?|\.\d+)`)
+
+// extractNumberedTargets finds scaled targets ("Target 1: 55, Target 2: 60") and returns their prices
+// ordered by target number. The first mention of each number wins.
+func extractNumberedTargets(htmlLower string) []float64 {
+	byNumber := make(map[int]float64)
+	for _, match := range numberedTargetRe.FindAllStringSubmatch(htmlLower, -1) {
+		number, _ := strconv.Atoi(match[1] + match[2]) // exactly one of the two groups is set
+		if _, seen := byNumber[number]; seen {
+			continue
+		}
+		if price, err := strconv.ParseFloat(match[3], 64); err == nil && price > 0 {
+			byNumber[number] = price
+		}
+	}
+
+	numbers := make([]int, 0, len(byNumber))
+	for number := range byNumber {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+	targets := make([]float64, 0, len(numbers))
+	for _, number := range numbers {
+		targets = append(targets, byNumber[number])
+	}
+	return targets
+}
+
+// extractTargets records numbered targets in Targets. The first becomes TargetPrice, replacing what
+// extractTargetPrice found, since that reads the "1" in "target 1: 55" as the price.
+func extractTargets(signal *TradingSignal, htmlLower string, trace *parseTrace) {
+	targets := extractNumberedTargets(htmlLower)
+	if len(targets) == 0 {
+		return
+	}
+	signal.Targets = targets
+	signal.TargetPrice, signal.TargetSource = targets[0], "go:target_numbered"
+	trace.Printf("Found %d numbered targets: %v", len(targets), targets)
+}
+
+var (
+	// riskPctRe matches stated account risk such as "risk 2%", "risking 1.5%" or "risk: 1%"
+	riskPctRe = regexp.MustCompile(`\brisk(?:ing)?\s*(?:of\s+|[:=]\s*)?(\d+(?:\.\d+)?)\s*%`)
+	// pctRiskRe matches the same with the number first, such as "2% risk"
+	pctRiskRe = regexp.MustCompile(`\b(\d+(?:\.\d+)?)\s*%\s*risk\b`)
+	// positionNoteRe matches sizing commentary such as "half position" or "starter size"
+	positionNoteRe = regexp.MustCompile(`\b(?:half|quarter|third|full|double|small|smaller|starter|partial)\s+(?:a\s+)?(?:position|size|sized)\b`)
+)
+
+// maxRiskPct is the largest stated risk accepted; bigger numbers are more likely price moves than account risk
+const maxRiskPct = 10
+
+// extractRiskNotes records stated per-trade risk as RiskPct and collects risk and sizing phrases in Notes
+func extractRiskNotes(signal *TradingSignal, htmlLower string, trace *parseTrace) {
+	var notes []string
+	for _, re := range []*regexp.Regexp{riskPctRe, pctRiskRe} {
+		match := re.FindStringSubmatch(htmlLower)
+		if match == nil {
+			continue
+		}
+		notes = append(notes, match[0])
+		if pct, err := strconv.ParseFloat(match[1], 64); err == nil && pct > 0 && pct <= maxRiskPct && signal.RiskPct == 0 {
+			signal.RiskPct = pct
+			trace.Printf("Found stated risk: %g%%", pct)
 		}
 	}
+	notes = append(notes, positionNoteRe.FindAllString(htmlLower, -1)...)
+	signal.Notes = strings.Join(notes, "; ")
 }
 
-// processSignalsConcurrently processes clean signals to trade_signals table
-func processSignalsConcurrently(db *DB) error {
+// concatKeywords joins keyword lists into a new slice
+func concatKeywords(lists ...[]string) []string {
+	var all []string
+	for _, list := range lists {
+		all = append(all, list...)
+	}
+	return all
+}
+
+// processSignalsConcurrently processes clean signals to trade_signals table, stopping when ctx ends
+func processSignalsConcurrently(ctx context.Context, db *DB) error {
 	log.Printf("Starting concurrent signal processing")
 	
 	// Get clean signals from parse_buy_stop_target
-	signals, err := db.getCleanSignals()
+	signals, err := db.getCleanSignals(cleanSignalPolicy)
 	if err != nil {
-		return fmt.Errorf("failed to get clean signals: %v", err)
+		return fmt.Errorf("failed to get clean signals: %w", err)
 	}
 
 	log.Printf("Found %d clean signals to process", len(signals))
 
 	if len(signals) == 0 {
 		log.Printf("No clean signals found for processing")
-		return nil
+		return nothingToDo("no parsed signals to process")
 	}
 
 	// Process signals concurrently
 	numWorkers := 5 // Lower concurrency for database operations
-	jobs := make(chan CleanSignal, len(signals))
-	results := make(chan error, len(signals))
+	jobs := make(chan CleanSignal, channelCapacity(numWorkers))
+	results := make(chan error, channelCapacity(numWorkers))
+
+	progress := startProgress("process", "signals", len(signals), 20)
+	defer progress.finish()
 
 	// Start workers
 	var wg sync.WaitGroup
@@ -353,17 +1391,12 @@ func processSignalsConcurrently(db *DB) error {
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
-			processSignalWorker(workerID, jobs, results, db)
+			processSignalWorker(ctx, workerID, jobs, results, progress, db)
 		}(i)
 	}
 
-	// Send jobs
-	go func() {
-		for _, signal := range signals {
-			jobs <- signal
-		}
-		close(jobs)
-	}()
+	// Send jobs until the stage's timeout
+	go feedJobs(ctx, jobs, signals)
 
 	// Wait for all workers to complete
 	go func() {
@@ -371,35 +1404,47 @@ func processSignalsConcurrently(db *DB) error {
 		close(results)
 	}()
 
-	// Collect results
-	var errors []error
-	var processedCount int
+	// Collect errors; workers count progress as they finish each signal
+	failures := newAggregateError("signal processing", len(signals))
 	for err := range results {
 		if err != nil {
-			errors = append(errors, err)
-		} else {
-			processedCount++
+			failures.Add(err)
 		}
+	}
+	processedCount, _, _ := progress.counts()
 
-		// Log progress every 20 signals
-		if (processedCount+len(errors))%20 == 0 {
-			log.Printf("Processing progress: %d/%d signals processed", processedCount+len(errors), len(signals))
+	log.Printf("Signal processing complete: %d signals processed successfully, %d errors", processedCount, failures.Len())
+
+	if resendWindow > 0 {
+		removed, err := db.collapseResentSignals(resendWindow)
+		if err != nil {
+			return err
 		}
+		log.Printf("Collapsed %d resent signals within %v of an earlier alert", removed, resendWindow)
 	}
 
-	log.Printf("Signal processing complete: %d signals processed successfully, %d errors", processedCount, len(errors))
+	if err := stageTimedOut(ctx, progress, failures); err != nil {
+		log.Printf("%v", err)
+		return err
+	}
 
-	if len(errors) > 0 {
-		log.Printf("First few processing errors: %v", errors[:min(5, len(errors))])
+	if failures.Len() > 0 {
+		log.Printf("%v", failures)
 	}
 
-	return nil
+	return failures.ErrOrNil()
 }
 
-// processSignalWorker processes individual clean signals
-func processSignalWorker(workerID int, jobs <-chan CleanSignal, results chan<- error, db *DB) {
+// processSignalWorker processes individual clean signals, skipping those still queued when ctx ends
+func processSignalWorker(ctx context.Context, workerID int, jobs <-chan CleanSignal, results chan<- error, progress *stageProgress, db *DB) {
 	for signal := range jobs {
-		err := upsertToTradeSignals(signal, db, workerID)
+		if ctx.Err() != nil {
+			continue
+		}
+		_, endSpan := startSpan(ctx, "process signal", attribute.String("email_id", signal.EmailID), attribute.String("ticker", signal.Ticker))
+		err := newItemError(signal.EmailID, CategoryDB, upsertToTradeSignals(signal, db, workerID))
+		endSpan(err)
+		progress.record(err)
 		results <- err
 	}
 }
\ No newline at end of file
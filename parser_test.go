@@ -0,0 +1,896 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExtractPricesNearestKeyword(t *testing.T) {
+	tests := []struct {
+		name                          string
+		text                          string
+		wantBuy, wantStop, wantTarget float64
+	}{
+		{"stop before buy", "stop 183 on a close. buy under 200, target 240", 200, 183, 240},
+		{"stop before buy on another line", "stop loss: $45.00\nwe will buy at $50.00 and look for a target of $60.00", 50, 45, 60},
+		{"buy then stop", "buy under 200 stop 183 target 240", 200, 183, 240},
+		{"target first", "target $15 is the goal. buy @ 12.50 with a stop at 11", 12.5, 11, 15},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signal := &TradingSignal{}
+			extractBuyPrice(signal, tt.text, nil)
+			extractStopPrice(signal, tt.text, nil)
+			extractTargetPrice(signal, tt.text, nil)
+			if signal.BuyPrice != tt.wantBuy || signal.StopPrice != tt.wantStop || signal.TargetPrice != tt.wantTarget {
+				t.Errorf("buy %g, stop %g, target %g, want %g, %g, %g",
+					signal.BuyPrice, signal.StopPrice, signal.TargetPrice, tt.wantBuy, tt.wantStop, tt.wantTarget)
+			}
+		})
+	}
+}
+
+func TestExtractPricePrefersConfidentMatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		wantBuy    float64
+		wantSource string
+	}{
+		{"explicit after loose", "buy zone 49-51 for a starter. add more: buy at $50.25", 50.25, "go:buy"},
+		{"explicit after direct", "buy: 48 on weakness, or buy @ 50 on strength", 50, "go:buy"},
+		{"direct after loose", "buy zone near 49 if it dips. entry 51", 51, "go:entry"},
+		{"direct after reversed", "$47 is our buy point. buy 49 on a close above", 49, "go:buy"},
+		{"keyword priority breaks a tie", "entry at 49. buy at 50", 50, "go:buy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signal := &TradingSignal{}
+			extractBuyPrice(signal, tt.text, nil)
+			if signal.BuyPrice != tt.wantBuy || signal.BuySource != tt.wantSource {
+				t.Errorf("buy %g from %s, want %g from %s", signal.BuyPrice, signal.BuySource, tt.wantBuy, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestLineBrokenSignalBlocks(t *testing.T) {
+	tests := []struct {
+		name      string
+		html      string
+		wantLines int
+	}{
+		{
+			name:      "br",
+			html:      `Acme Robotics (NASDAQ: ACME)<br>Buy<br>50.00<br>Stop<br>45.00<br/>Target<br>60.00`,
+			wantLines: 7,
+		},
+		{
+			name:      "paragraphs, stop first",
+			html:      `<p>Acme Robotics (NASDAQ: ACME)</p><p>Stop 45.00</p><p>Target 60.00</p><p>Buy 50.00</p>`,
+			wantLines: 4,
+		},
+		{
+			name: "table",
+			html: `<p>Acme Robotics (NASDAQ: ACME)</p><table><tr><td>Buy</td><td>50.00</td></tr>` +
+				`<tr><td>Stop</td><td>45.00</td></tr><tr><td>Target</td><td>60.00</td></tr></table>`,
+			wantLines: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if lines := strings.Split(htmlToPlainText(tt.html), "\n"); len(lines) != tt.wantLines {
+				t.Errorf("htmlToPlainText() gave %d lines %q, want %d", len(lines), lines, tt.wantLines)
+			}
+			signal, _, rejection, err := extractSignalFieldsTraced(EmailSignal{ID: tt.name, HTML: tt.html}, nil)
+			if err != nil || rejection != nil {
+				t.Fatalf("extractSignalFieldsTraced() rejected the signal: %v %+v", err, rejection)
+			}
+			if signal.Ticker != "ACME" || signal.BuyPrice != 50 || signal.StopPrice != 45 || signal.TargetPrice != 60 {
+				t.Errorf("parsed %s buy %g stop %g target %g, want ACME 50, 45, 60",
+					signal.Ticker, signal.BuyPrice, signal.StopPrice, signal.TargetPrice)
+			}
+		})
+	}
+}
+
+func TestEntryDateFor(t *testing.T) {
+	sent := time.Date(2024, 6, 5, 14, 0, 0, 0, time.UTC) // a Wednesday
+	tests := []struct {
+		name   string
+		text   string
+		offset time.Duration // ENTRY_OFFSET_HOURS
+		want   time.Time
+	}{
+		{"same day", "buy acme now under $50", 24 * time.Hour, sent},
+		{"tomorrow", "enter tomorrow at the open", 24 * time.Hour, sent.AddDate(0, 0, 1)},
+		{"next weekday", "we buy on the open monday", 24 * time.Hour, sent.AddDate(0, 0, 5)},
+		{"named weekday is today", "entry wednesday near $50", 24 * time.Hour, sent},
+		{"timing phrase beats the offset", "enter tomorrow", 72 * time.Hour, sent.AddDate(0, 0, 1)},
+		{"default offset", "acme at $50", 24 * time.Hour, sent.Add(24 * time.Hour)},
+		{"two-day offset", "acme at $50", 48 * time.Hour, sent.Add(48 * time.Hour)},
+		{"same-session offset", "acme at $50", 0, sent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := entryOffset
+			entryOffset = tt.offset
+			t.Cleanup(func() { entryOffset = saved })
+
+			if got := entryDateFor(sent, tt.text, nil); !got.Equal(tt.want) {
+				t.Errorf("entryDateFor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// alertHTML is a complete alert the classifier keeps and the parser accepts
+const alertHTML = `<p>Acme Robotics (NASDAQ: ACME) is today's pick.</p><p>Buy at $12.50<br>Stop at $11.00<br>Target at $15.00</p>`
+
+func TestParseCountsBlankEmailAsNoContent(t *testing.T) {
+	db := newTestDB(t)
+	saveTestEmail(t, db, "alert", "Today's Stock Pick", alertHTML)
+	// Signal keywords only in markup: nothing is left once tags are stripped
+	saveTestEmail(t, db, "blank", "Today's Stock Pick",
+		`<style>.buy-button, .stop-link, .target-box { color: #333; }</style><img src="pick.png" alt="">`)
+
+	if err := parseSignalsConcurrently(context.Background(), db, ParseOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var noContent, emails, errorCount int
+	if err := db.QueryRow(`SELECT no_content, emails, errors FROM parse_runs ORDER BY id DESC LIMIT 1`).Scan(&noContent, &emails, &errorCount); err != nil {
+		t.Fatal(err)
+	}
+	if noContent != 1 || emails != 1 || errorCount != 0 {
+		t.Errorf("parse run counted %d no-content, %d emails, %d errors, want 1, 1, 0", noContent, emails, errorCount)
+	}
+
+	for _, tt := range []struct {
+		table string
+		want  int
+	}{
+		{"parse_buy_stop_target", 0},
+		{"parse_errors", 0},
+		{"parse_failures", 0},
+	} {
+		var count int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM ` + tt.table + ` WHERE email_id = 'blank'`).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != tt.want {
+			t.Errorf("%s has %d rows for the blank email, want %d", tt.table, count, tt.want)
+		}
+	}
+}
+
+func TestParseWatermarkRequeuesSkippedEmails(t *testing.T) {
+	db := newTestDB(t)
+	saveTestEmail(t, db, "alert", "Today's Stock Pick", alertHTML)
+	saveTestEmail(t, db, promoEmail.ID, promoEmail.Subject, promoEmail.HTML)
+	saveTestEmail(t, db, "blank", "Today's Stock Pick", `<style>.buy, .stop, .target { color: #333; }</style>`)
+
+	saved := signalScoreThreshold
+	t.Cleanup(func() { signalScoreThreshold = saved })
+
+	// Each run parses the emails not yet handled under the threshold in force
+	steps := []struct {
+		name       string
+		threshold  int
+		wantParsed []string // emails given a staging row by this run; nil when it has nothing to do
+	}{
+		{"first run", saved, []string{"alert"}},
+		{"no new emails", saved, nil},
+		{"threshold lowered to the promotion's score", scoreSignalEmail(promoEmail).Score, []string{"promo"}},
+		{"promotion already parsed", scoreSignalEmail(promoEmail).Score, nil},
+		{"threshold raised again", saved, nil},
+	}
+
+	for _, step := range steps {
+		signalScoreThreshold = step.threshold
+		if _, err := db.Exec(`DELETE FROM parse_buy_stop_target`); err != nil {
+			t.Fatal(err)
+		}
+
+		err := parseSignalsConcurrently(context.Background(), db, ParseOptions{})
+		if step.wantParsed == nil {
+			if !isEmptyInput(err) {
+				t.Errorf("%s: error = %v, want nothing to do", step.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+
+		var parsed []string
+		rows, err := db.Query(`SELECT email_id FROM parse_buy_stop_target ORDER BY email_id`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				t.Fatal(err)
+			}
+			parsed = append(parsed, id)
+		}
+		rows.Close()
+		if !reflect.DeepEqual(parsed, step.wantParsed) {
+			t.Errorf("%s: parsed %v, want %v", step.name, parsed, step.wantParsed)
+		}
+	}
+}
+
+func TestSignalDates(t *testing.T) {
+	sent := time.Date(2024, 6, 5, 14, 0, 0, 0, time.UTC) // a Wednesday afternoon
+	at := func(d int) time.Time { return time.Date(2024, 6, d, 14, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name       string
+		source     string // SIGNAL_DATE_SOURCE
+		text       string
+		offset     time.Duration // ENTRY_OFFSET_HOURS
+		wantSignal time.Time
+		wantEntry  time.Time
+	}{
+		{"email date", "email", "for friday, june 7: buy acme at $50", 24 * time.Hour, sent, at(6)},
+		{"stated date", "content", "for friday, june 7: buy acme at $50", 24 * time.Hour, at(7), at(8)},
+		{"stated date with an offset", "content", "for 6/7 buy acme at $50", 48 * time.Hour, at(7), at(9)},
+		{"stated date with a timing phrase", "content", "for 6/7: enter tomorrow at the open", 48 * time.Hour, at(7), at(8)},
+		{"stated date entering the same day", "content", "for friday, june 7: buy now", 24 * time.Hour, at(7), at(7)},
+		{"past stated date enters when sent", "content", "for monday, june 3, 2024: buy acme at $50", 24 * time.Hour, at(3), sent},
+		{"past stated date entering the same day", "content", "for monday, june 3, 2024 enter today", 24 * time.Hour, at(3), sent},
+		{"weekday disagreeing with the date", "content", "for tuesday, june 3, 2024: buy acme at $50", 24 * time.Hour, sent, at(6)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			savedSource, savedOffset := signalDateSource, entryOffset
+			signalDateSource, entryOffset = tt.source, tt.offset
+			t.Cleanup(func() { signalDateSource, entryOffset = savedSource, savedOffset })
+
+			signalDate, entryDate := signalDates(sent, tt.text, nil)
+			if !signalDate.Equal(tt.wantSignal) || !entryDate.Equal(tt.wantEntry) {
+				t.Errorf("signalDates() = %v entering %v, want %v entering %v", signalDate, entryDate, tt.wantSignal, tt.wantEntry)
+			}
+		})
+	}
+}
+
+func TestTimedOutParseSavesNothing(t *testing.T) {
+	db := newTestDB(t)
+	tests := []struct {
+		name string
+		html string
+	}{
+		{"complete alert", alertHTML},
+		{"missing buy price", `<p>Acme Robotics (NASDAQ: ACME) is today's pick.</p><p>Buy at the open<br>Stop at $11.00<br>Target at $15.00</p>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saveTestEmail(t, db, "m1", "Today's Stock Pick", tt.html)
+			email := EmailSignal{ID: "m1", Subject: "Today's Stock Pick", Date: time.Date(2024, 6, 3, 13, 0, 0, 0, time.UTC), HTML: tt.html}
+
+			// Another connection holds the write lock, so the parse's saves wait past the item timeout
+			locker, err := sql.Open("sqlite3", sqliteDSN(dbFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer locker.Close()
+			conn, err := locker.Conn(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if _, err := conn.ExecContext(context.Background(), `BEGIN IMMEDIATE`); err != nil {
+				t.Fatal(err)
+			}
+
+			finished := make(chan struct{})
+			err = runWithTimeout(context.Background(), 200*time.Millisecond, func(ctx context.Context) error {
+				defer close(finished)
+				_, err := parseSignalFromEmail(ctx, 1, time.Now(), email, db)
+				return err
+			})
+			if !errors.Is(err, errItemTimeout) {
+				t.Fatalf("runWithTimeout() = %v, want errItemTimeout", err)
+			}
+			if _, err := conn.ExecContext(context.Background(), `ROLLBACK`); err != nil {
+				t.Fatal(err)
+			}
+			<-finished
+
+			for _, table := range []string{"parse_buy_stop_target", "parse_failures"} {
+				var count int
+				if err := db.QueryRow(`SELECT COUNT(*) FROM ` + table + ` WHERE email_id = 'm1'`).Scan(&count); err != nil {
+					t.Fatal(err)
+				}
+				if count != 0 {
+					t.Errorf("%s has %d rows for the timed-out parse, want none", table, count)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkExtractSignalFields measures the per-email parse time with the patterns compiled once,
+// against recompiling them for every email as the extractors used to
+func BenchmarkExtractSignalFields(b *testing.B) {
+	benchmarks := []struct {
+		name      string
+		recompile bool
+	}{
+		{"precompiled", false},
+		{"compiled per email", true},
+	}
+
+	saved := parserPatterns
+	b.Cleanup(func() { parserPatterns = saved })
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if bm.recompile {
+					parserPatterns = compileParserPatterns()
+				}
+				if _, _, rejection, err := extractSignalFields(realAlert); err != nil || rejection != nil {
+					b.Fatalf("extractSignalFields() rejected the alert: %v %+v", err, rejection)
+				}
+			}
+		})
+	}
+}
+
+func TestForwardedAlertParsesOriginal(t *testing.T) {
+	// The forwarder's note and the forwarded headers both name a different ticker and price
+	note := `<p>Worth a look? I'd rather own Xylem Corp (NYSE: XYZ): buy at $40.00 with a stop at $36.00.</p>`
+	headers := `<p>From: Dr Stoxx &lt;alerts@example.com&gt;<br>Date: Mon, Jun 3, 2024<br>Subject: Buy XYZ at $41.00<br>To: me@example.com</p>`
+	tests := []struct {
+		name string
+		html string
+	}{
+		{"gmail forward", note + `<div>---------- Forwarded message ---------</div>` + headers + alertHTML},
+		{"apple mail forward", note + `<div>Begin forwarded message:</div>` + headers + alertHTML},
+		{"forwarded twice", note + `<div>---------- Forwarded message ---------</div>` + headers +
+			`<p>Fwd again, see below</p><div>Begin forwarded message:</div>` + headers + alertHTML},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := EmailSignal{ID: "fwd", Subject: "Fwd: Today's Stock Pick", Date: time.Date(2024, 6, 3, 15, 0, 0, 0, time.UTC), HTML: tt.html}
+			signal, _, rejection, err := extractSignalFields(email)
+			if err != nil || rejection != nil {
+				t.Fatalf("extractSignalFields() rejected the forwarded alert: %v %+v", err, rejection)
+			}
+			if signal.Ticker != "ACME" || signal.BuyPrice != 12.5 || signal.StopPrice != 11 || signal.TargetPrice != 15 {
+				t.Errorf("parsed %s buy %g stop %g target %g, want ACME 12.5 11 15",
+					signal.Ticker, signal.BuyPrice, signal.StopPrice, signal.TargetPrice)
+			}
+		})
+	}
+}
+
+func TestParseRecordsExtractionFailures(t *testing.T) {
+	type failureRow struct{ field, keyword, examined string }
+	tests := []struct {
+		name string
+		html string
+		want []failureRow
+	}{
+		{name: "complete alert", html: alertHTML},
+		{
+			name: "no ticker",
+			html: `<p>Acme Robotics is the pick today.</p><p>Buy at $12.50<br>Stop at $11.00<br>Target at $15.00</p>`,
+			want: []failureRow{{"ticker", "", "acme robotics is the pick today.\nbuy at $12.50\nstop at $11.00\ntarget at $15.00"}},
+		},
+		{
+			name: "buy price spelled out",
+			html: `<p>Acme Robotics (NASDAQ: ACME) is the pick today.</p><p>Buy at the open<br>Stop at $11.00<br>Target at $15.00</p>`,
+			want: []failureRow{{"buy_price", "go:buy", " is the pick today.\nbuy at the open\n"}},
+		},
+		{
+			name: "no entry keyword",
+			html: `<p>Acme Robotics (NASDAQ: ACME) is the pick today.</p><p>Stop at $11.00<br>Target at $15.00</p>`,
+			want: []failureRow{{"buy_price", "", "acme robotics (nasdaq: acme) is the pick today.\nstop at $11.00\ntarget at $15.00"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			saveTestEmail(t, db, "m1", "Today's Stock Pick", tt.html)
+			email := EmailSignal{ID: "m1", Subject: "Today's Stock Pick", Date: time.Date(2024, 6, 3, 13, 0, 0, 0, time.UTC), HTML: tt.html}
+			if _, err := parseSignalFromEmail(context.Background(), 1, time.Now(), email, db); err != nil {
+				t.Fatal(err)
+			}
+
+			rows, err := db.Query(`SELECT field, COALESCE(keyword, ''), examined_text FROM parse_failures WHERE email_id = 'm1' ORDER BY field`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			var got []failureRow
+			for rows.Next() {
+				var row failureRow
+				if err := rows.Scan(&row.field, &row.keyword, &row.examined); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, row)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parse_failures = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTickerAllowAndDenyLists(t *testing.T) {
+	tests := []struct {
+		name          string
+		allow, deny   string
+		wantRejection string
+		wantClean     []string
+	}{
+		{name: "no lists", wantClean: []string{"ACME", "XYZ"}},
+		{name: "on the allowlist", allow: "acme, BRK.B", wantClean: []string{"ACME"}},
+		{name: "missing from the allowlist", allow: "XYZ", wantRejection: rejectTickerNotAllowed, wantClean: []string{"XYZ"}},
+		{name: "on the denylist", deny: "ACME", wantRejection: rejectTickerDenied, wantClean: []string{"XYZ"}},
+		{name: "denylist wins over allowlist", allow: "ACME XYZ", deny: "ACME", wantRejection: rejectTickerDenied, wantClean: []string{"XYZ"}},
+	}
+
+	savedAllow, savedDeny := tickerAllowlist, tickerDenylist
+	t.Cleanup(func() { tickerAllowlist, tickerDenylist = savedAllow, savedDeny })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tickerAllowlist, tickerDenylist = nil, nil
+			var err error
+			if tt.allow != "" {
+				if tickerAllowlist, err = parseTickerList("TICKER_ALLOWLIST", tt.allow); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.deny != "" {
+				if tickerDenylist, err = parseTickerList("TICKER_DENYLIST", tt.deny); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, _, rejection, err := extractSignalFields(EmailSignal{ID: "alert", HTML: alertHTML})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var reason string
+			if rejection != nil {
+				reason = rejection.Reason
+			}
+			if reason != tt.wantRejection {
+				t.Errorf("parsing ACME rejected with %q, want %q", reason, tt.wantRejection)
+			}
+
+			// Signals parsed before the lists were set are filtered when they are cleaned
+			db := newTestDB(t)
+			for _, ticker := range []string{"ACME", "XYZ"} {
+				if _, err := db.Exec(`INSERT INTO parse_buy_stop_target (email_id, ticker, signal_date, entry_date, buy_price, stop_price, target_price)
+					VALUES (?, ?, 1717419600000, 1717419600000, 12.5, 11, 15)`, ticker, ticker); err != nil {
+					t.Fatal(err)
+				}
+			}
+			signals, err := db.getCleanSignals(CleanSignalPolicy{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var clean []string
+			for _, signal := range signals {
+				clean = append(clean, signal.Ticker)
+			}
+			sort.Strings(clean)
+			if !reflect.DeepEqual(clean, tt.wantClean) {
+				t.Errorf("getCleanSignals() returned %v, want %v", clean, tt.wantClean)
+			}
+		})
+	}
+}
+
+func TestExtractTickerClassShares(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		want  string
+		inSQL bool // the SQL parser reads only "(EXCHANGE: TICKER)" mentions
+	}{
+		{"class share in parentheses", "Berkshire Hathaway (NYSE: BRK.B) buy at $410", "BRK.B", true},
+		{"four letters and a class", "Acme Holdings (NASDAQ: ACME.A) buy at $12.50", "ACME.A", true},
+		{"four letters and a class without parentheses", "NASDAQ: ACME.A buy at $12.50", "ACME.A", false},
+		{"class share without an exchange", "Buy BRK.B at $410", "BRK.B", false},
+		{"plain ticker", "Acme Holdings (NASDAQ: ACME) buy at $12.50", "ACME", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signal := &TradingSignal{}
+			extractTicker(signal, tt.text, strings.ToLower(tt.text), nil)
+			if signal.Ticker != tt.want {
+				t.Errorf("ticker = %q from %s, want %q", signal.Ticker, signal.TickerSource, tt.want)
+			}
+			if !tt.inSQL {
+				return
+			}
+			if got := sqlExtractedTicker(t, tt.text); got != tt.want {
+				t.Errorf("SQL parser ticker = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// useTickerConfig sets TICKER_MAX_LENGTH and TICKER_EXCHANGES for the rest of the test
+func useTickerConfig(t *testing.T, maxLength int, exchanges []string) {
+	t.Helper()
+	savedMax, savedExchanges := tickerMaxLength, tickerExchanges
+	tickerMaxLength, tickerExchanges = maxLength, exchanges
+	parserPatterns = compileParserPatterns()
+	t.Cleanup(func() {
+		tickerMaxLength, tickerExchanges = savedMax, savedExchanges
+		parserPatterns = compileParserPatterns()
+	})
+}
+
+func TestLongTickersNeedExchange(t *testing.T) {
+	useTickerConfig(t, 7, tickerExchanges)
+
+	tests := []struct {
+		name  string
+		text  string
+		want  string
+		inSQL bool
+	}{
+		{"six letters in parentheses", "Acme Global (NYSE: ACMEGL) buy at $12.50", "ACMEGL", true},
+		{"seven letters without parentheses", "NASDAQ: ACMEGLB buy at $12.50", "ACMEGLB", false},
+		{"six letters with a class", "Acme Global (NYSE: ACMEGL.A) buy at $12.50", "ACMEGL.A", true},
+		{"too long even with an exchange", "Acme Global (NYSE: ACMEGLOB) buy at $12.50", "", true},
+		{"six letters without an exchange", "BREAKING buy at $12.50", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signal := &TradingSignal{}
+			extractTicker(signal, tt.text, strings.ToLower(tt.text), nil)
+			if signal.Ticker != tt.want {
+				t.Errorf("ticker = %q from %s, want %q", signal.Ticker, signal.TickerSource, tt.want)
+			}
+			if !tt.inSQL {
+				return
+			}
+			want := tt.want
+			if want == "" {
+				want = "PARSED" // the placeholder stays when no ticker is found
+			}
+			if got := sqlExtractedTicker(t, tt.text); got != want {
+				t.Errorf("SQL parser ticker = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestTickerExchangesAMEX(t *testing.T) {
+	const text = "Xyz Holdings (AMEX: XYZ) buy at $12.50"
+
+	// Neither parser reads an AMEX listing until TICKER_EXCHANGES names it
+	signal := &TradingSignal{}
+	extractTicker(signal, text, strings.ToLower(text), nil)
+	if signal.Ticker != "" {
+		t.Errorf("default exchanges: ticker %q, want none", signal.Ticker)
+	}
+	if got := sqlExtractedTicker(t, text); got != "PARSED" {
+		t.Errorf("default exchanges: SQL ticker %q, want none", got)
+	}
+
+	exchanges, err := parseTickerExchanges("nasdaq, NYSE, amex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	useTickerConfig(t, tickerMaxLength, exchanges)
+
+	signal = &TradingSignal{}
+	extractTicker(signal, text, strings.ToLower(text), nil)
+	if signal.Ticker != "XYZ" || signal.Company != "Xyz Holdings" {
+		t.Errorf("TICKER_EXCHANGES with AMEX: ticker %q of company %q, want XYZ of Xyz Holdings", signal.Ticker, signal.Company)
+	}
+	if got := sqlExtractedTicker(t, text); got != "XYZ" {
+		t.Errorf("TICKER_EXCHANGES with AMEX: SQL ticker %q, want XYZ", got)
+	}
+}
+
+func TestPriceSanityRange(t *testing.T) {
+	tests := []struct {
+		name              string
+		min, max          float64
+		buy, stop, target float64
+		wantRejection     string
+	}{
+		{"$900 signal with the defaults", 0, 10000, 905.5, 870, 980, ""},
+		{"$900 signal above a lowered PRICE_MAX", 0, 500, 905.5, 870, 980, rejectPriceRange},
+		{"penny stock above PRICE_MIN", 0.01, 10000, 0.45, 0.4, 0.6, ""},
+		{"penny stock below a raised PRICE_MIN", 1, 10000, 0.45, 0.4, 0.6, rejectPriceRange},
+	}
+
+	savedMin, savedMax := priceMin, priceMax
+	t.Cleanup(func() { priceMin, priceMax = savedMin, savedMax })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			priceMin, priceMax = tt.min, tt.max
+			rejection := checkPriceSanity(&TradingSignal{BuyPrice: tt.buy, StopPrice: tt.stop, TargetPrice: tt.target})
+			var reason string
+			if rejection != nil {
+				reason = rejection.Reason
+			}
+			if reason != tt.wantRejection {
+				t.Errorf("checkPriceSanity() rejected with %q, want %q", reason, tt.wantRejection)
+			}
+		})
+	}
+
+	// The SQL parser's validated_prices reads the same range
+	priceMin, priceMax = savedMin, savedMax
+	db := newTestDB(t)
+	saveTestEmail(t, db, "m1", "Trade alert", "<p>Acme Holdings (NASDAQ: ACME) is today's pick.</p><p>Buy at $905.50</p><p>Stop at $870.00</p><p>Target at $980.00</p>")
+	if _, err := db.Exec(`INSERT INTO trade_signals (email_id, ticker, signal_date, entry_date, buy_price) VALUES ('m1', 'PARSED', 1717419600000, 1717419600000, 1)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := materializedSQLParse(db); err != nil {
+		t.Fatal(err)
+	}
+	var buy, stop, target float64
+	if err := db.QueryRow(`SELECT buy_price, stop_price, target_price FROM trade_signals WHERE email_id = 'm1'`).Scan(&buy, &stop, &target); err != nil {
+		t.Fatal(err)
+	}
+	if buy != 905.5 || stop != 870 || target != 980 {
+		t.Errorf("SQL parser: buy %g, stop %g, target %g, want 905.5, 870 and 980", buy, stop, target)
+	}
+}
+
+func TestSupportResistanceSynonyms(t *testing.T) {
+	tests := []struct {
+		name                 string
+		text                 string
+		wantStop, wantTarget float64
+		// whether the SQL parser takes a price after "support" and after "resistance"
+		wantSupport, wantResistance bool
+	}{
+		{"technical phrasing", "acme holdings (nasdaq: acme) buy at $52. support at 48, resistance at $60", 48, 60, true, true},
+		{"keywords win over synonyms", "buy at $52. stop 47 on a close. support at 48. target $61 near resistance", 47, 61, true, false},
+		{"customer support", "buy at $52. questions about your membership? our customer support team is open 24 hours", 0, 0, false, false},
+		{"resistance in prose", "buy at $52. we expect some resistance from sellers over the next 2 weeks", 0, 0, false, false},
+	}
+
+	db := newTestDB(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signal := &TradingSignal{}
+			extractStopPrice(signal, tt.text, nil)
+			extractTargetPrice(signal, tt.text, nil)
+			if signal.StopPrice != tt.wantStop || signal.TargetPrice != tt.wantTarget {
+				t.Errorf("stop %g from %s, target %g from %s, want %g and %g",
+					signal.StopPrice, signal.StopSource, signal.TargetPrice, signal.TargetSource, tt.wantStop, tt.wantTarget)
+			}
+
+			for _, set := range []struct {
+				name  string
+				words []string
+				want  bool
+			}{
+				{"stop", keywords.StopSynonyms, tt.wantSupport},
+				{"target", keywords.TargetSynonyms, tt.wantResistance},
+			} {
+				expr, args := synonymPositionSQL("@text", set.name, set.words)
+				var pos int
+				if err := db.QueryRow(`SELECT `+expr, append(args, sql.Named("text", strings.ToUpper(tt.text)))...).Scan(&pos); err != nil {
+					t.Fatal(err)
+				}
+				if (pos > 0) != set.want {
+					t.Errorf("SQL %s synonym position %d, want found = %v", set.name, pos, set.want)
+				}
+			}
+		})
+	}
+
+	// The SQL parser reads the synonyms too
+	saveTestEmail(t, db, "m1", "Trade alert", "<p>Acme Holdings (NASDAQ: ACME) is today's pick.</p><p>Buy at $52.00</p><p>Support at $48.00</p><p>Resistance at $60.00</p>")
+	if _, err := db.Exec(`INSERT INTO trade_signals (email_id, ticker, signal_date, entry_date, buy_price) VALUES ('m1', 'PARSED', 1717419600000, 1717419600000, 1)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := materializedSQLParse(db); err != nil {
+		t.Fatal(err)
+	}
+	var stop, target float64
+	var stopSource, targetSource string
+	if err := db.QueryRow(`SELECT stop_price, target_price, stop_source, target_source FROM trade_signals WHERE email_id = 'm1'`).
+		Scan(&stop, &target, &stopSource, &targetSource); err != nil {
+		t.Fatal(err)
+	}
+	if stop != 48 || target != 60 {
+		t.Errorf("SQL parser: stop %g from %s, target %g from %s, want 48 and 60", stop, stopSource, target, targetSource)
+	}
+}
+
+func TestExtractRiskNotes(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		wantRisk  float64
+		wantNotes string
+	}{
+		{"risk 1.5%", "buy at $52, stop 48. risk 1.5% of the account", 1.5, "risk 1.5%"},
+		{"number first", "buy at $52. a 2% risk, half position to start", 2, "2% risk; half position"},
+		{"price move, not account risk", "risking 25% downside if the stop is hit", 0, "risking 25%"},
+		{"no risk stated", "buy at $52, stop 48, target 60", 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signal := &TradingSignal{}
+			extractRiskNotes(signal, tt.text, nil)
+			if signal.RiskPct != tt.wantRisk || signal.Notes != tt.wantNotes {
+				t.Errorf("risk %g%% with notes %q, want %g%% with %q", signal.RiskPct, signal.Notes, tt.wantRisk, tt.wantNotes)
+			}
+		})
+	}
+}
+
+func TestSubDollarPrices(t *testing.T) {
+	tests := []struct {
+		name              string
+		html              string
+		buy, stop, target float64
+	}{
+		{"third decimal", "<p>Buy at 0.125</p><p>Stop at 0.11</p><p>Target at 0.1575</p>", 0.125, 0.11, 0.1575},
+		{"leading dot", "<p>Buy at $.125</p><p>Stop at $.11</p><p>Target at $.15</p>", 0.125, 0.11, 0.15},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			html := "<p>Xyz Holdings (NASDAQ: XYZ)</p>" + tt.html
+			signal, _, _, err := extractSignalFields(EmailSignal{ID: "m1", HTML: html})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if signal.BuyPrice != tt.buy || signal.StopPrice != tt.stop || signal.TargetPrice != tt.target {
+				t.Errorf("Go parser: %g/%g/%g, want %g/%g/%g", signal.BuyPrice, signal.StopPrice, signal.TargetPrice, tt.buy, tt.stop, tt.target)
+			}
+			if buy, stop, target := sqlParsedPrices(t, html); buy != tt.buy || stop != tt.stop || target != tt.target {
+				t.Errorf("SQL parser: %g/%g/%g, want %g/%g/%g", buy, stop, target, tt.buy, tt.stop, tt.target)
+			}
+		})
+	}
+
+	// PRICE_DECIMALS only changes how prices are shown
+	saved := priceDecimals
+	t.Cleanup(func() { priceDecimals = saved })
+	for _, tt := range []struct {
+		decimals    int
+		price       float64
+		wantRounded float64
+		wantFormat  string
+	}{
+		{4, 0.125, 0.125, "0.125"},
+		{4, 50, 50, "50.00"},
+		{2, 0.125, 0.13, "0.13"},
+		{0, 12.5, 13, "13"},
+	} {
+		priceDecimals = tt.decimals
+		if got := roundPrice(tt.price); got != tt.wantRounded {
+			t.Errorf("PRICE_DECIMALS=%d: roundPrice(%g) = %g, want %g", tt.decimals, tt.price, got, tt.wantRounded)
+		}
+		if got := formatPrice(tt.price); got != tt.wantFormat {
+			t.Errorf("PRICE_DECIMALS=%d: formatPrice(%g) = %q, want %q", tt.decimals, tt.price, got, tt.wantFormat)
+		}
+	}
+}
+
+func TestCheckPriceSanityOrdering(t *testing.T) {
+	tests := []struct {
+		name              string
+		buy, stop, target float64
+		wantRejection     string
+	}{
+		{"ordered", 50, 45, 60, ""},
+		{"inverted", 50, 60, 40, rejectInverted},
+		{"stop above buy", 50, 60, 70, rejectStopAboveBuy},
+		{"target below buy", 50, 45, 40, rejectTargetBelowBuy},
+		{"stop just above buy, within tolerance", 50, 52, 60, ""},
+		{"target just below buy, within tolerance", 50, 45, 48, ""},
+		{"no stop or target", 50, 0, 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rejection := checkPriceSanity(&TradingSignal{BuyPrice: tt.buy, StopPrice: tt.stop, TargetPrice: tt.target})
+			var reason string
+			if rejection != nil {
+				reason = rejection.Reason
+			}
+			if reason != tt.wantRejection {
+				t.Errorf("checkPriceSanity() rejected with %q, want %q", reason, tt.wantRejection)
+			}
+		})
+	}
+
+	// A tighter PRICE_RELATIONSHIP_TOLERANCE rejects what the default lets through
+	saved := relationshipTolerance
+	relationshipTolerance = 1
+	t.Cleanup(func() { relationshipTolerance = saved })
+	if rejection := checkPriceSanity(&TradingSignal{BuyPrice: 50, StopPrice: 52, TargetPrice: 60}); rejection == nil || rejection.Reason != rejectStopAboveBuy {
+		t.Errorf("checkPriceSanity() with tolerance 1 = %+v, want %s", rejection, rejectStopAboveBuy)
+	}
+}
+
+func TestCompanyBefore(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Buy Acme Corp ", "Acme Corp"},
+		{"Today's pick is Acme Corp, ", "Acme Corp"},
+		{"Alert: Bank of America ", "Bank of America"},
+		{"Buy at $12.50 3M Company ", "3M Company"},
+		{"Earlier news.\nAcme Corp ", "Acme Corp"},
+		{"today we like ", ""},
+	}
+
+	for _, tt := range tests {
+		if got := companyBefore(tt.text); got != tt.want {
+			t.Errorf("companyBefore(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+
+	// The parser records the name before an exchange-formatted ticker
+	signal := &TradingSignal{}
+	text := "Buy Acme Corp (NASDAQ: ACME) at $12.50"
+	extractTicker(signal, text, strings.ToLower(text), nil)
+	if signal.Ticker != "ACME" || signal.Company != "Acme Corp" {
+		t.Errorf("ticker %q of company %q, want ACME of Acme Corp", signal.Ticker, signal.Company)
+	}
+}
+
+func TestNumberedTargets(t *testing.T) {
+	tests := []struct {
+		text string
+		want []float64
+	}{
+		{"target 1: 55, target 2: 60, target 3: 65", []float64{55, 60, 65}},
+		{"t1 @ £12.50 t2 @ £14 t3 @ £16", []float64{12.5, 14, 16}},
+		{"target #1 at $20, target 2: €40, tgt 3 - 45", []float64{20, 40, 45}},
+		{"target 3: 65, target 1: 55, target 2: 60", []float64{55, 60, 65}},
+		{"target 55", []float64{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := extractNumberedTargets(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extractNumberedTargets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Pound targets are converted with the buy and stop prices
+	savedMode, savedRates := nonUSDSignals, fxRates
+	t.Cleanup(func() { nonUSDSignals, fxRates = savedMode, savedRates })
+	nonUSDSignals, fxRates = "convert", map[string]float64{"GBP": 2}
+	signal, _, rejection, err := extractSignalFields(EmailSignal{ID: "gbp", HTML: `<p>Acme Robotics (NASDAQ: ACME) is today's pick.</p>` +
+		`<p>Buy at £10.00<br>Stop at £9.00<br>T1 @ £12.50<br>T2 @ £14.00<br>T3 @ £16.00</p>`})
+	if err != nil || rejection != nil {
+		t.Fatalf("extractSignalFields() rejected the alert: %v %+v", err, rejection)
+	}
+	if signal.Currency != "GBP" || !reflect.DeepEqual(signal.Targets, []float64{25, 28, 32}) || signal.TargetPrice != 25 {
+		t.Errorf("parsed %s targets %v (target %g), want GBP targets [25 28 32]", signal.Currency, signal.Targets, signal.TargetPrice)
+	}
+}
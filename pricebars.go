@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PriceBarImportResult is the /price-bars response
+type PriceBarImportResult struct {
+	Rows     int      `json:"rows"`
+	Imported int      `json:"imported"`
+	Tickers  []string `json:"tickers"`
+	Errors   []string `json:"errors,omitempty"` // rows that could not be read
+}
+
+// priceBarColumns are the CSV columns a price bar needs; volume and ticker are optional
+var priceBarColumns = []string{"date", "open", "high", "low", "close"}
+
+// parsePriceBarCSV reads daily bars from CSV with a header row, keyed by ticker. Columns are found by
+// name, case-insensitively, so a yfinance or Yahoo download (Date,Open,High,Low,Close,Adj Close,Volume)
+// loads as is; its rows take defaultTicker, while a ticker column allows many tickers in one file.
+// Dates may carry a time after the YYYY-MM-DD, which is dropped.
+func parsePriceBarCSV(data []byte, defaultTicker string) (map[string][]PriceBar, int, []string, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, name := range priceBarColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, 0, nil, fmt.Errorf("CSV header has no %q column", name)
+		}
+	}
+	tickerColumn, hasTicker := columns["ticker"]
+	if !hasTicker && defaultTicker == "" {
+		return nil, 0, nil, fmt.Errorf("CSV has no ticker column; pass ticker")
+	}
+	volumeColumn, hasVolume := columns["volume"]
+
+	bars := make(map[string][]PriceBar)
+	rows := 0
+	var rowErrors []string
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		rows++
+		if err != nil {
+			rowErrors = append(rowErrors, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+
+		field := func(column int) string {
+			if column < len(record) {
+				return strings.TrimSpace(record[column])
+			}
+			return ""
+		}
+		ticker := defaultTicker
+		if hasTicker && field(tickerColumn) != "" {
+			ticker = strings.ToUpper(field(tickerColumn))
+		}
+
+		rawDate := field(columns["date"])
+		date, err := time.Parse("2006-01-02", rawDate[:min(10, len(rawDate))])
+		if err != nil {
+			rowErrors = append(rowErrors, fmt.Sprintf("line %d: invalid date %q", line, rawDate))
+			continue
+		}
+		var prices [4]float64
+		for i, name := range priceBarColumns[1:] {
+			if prices[i], err = strconv.ParseFloat(field(columns[name]), 64); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, fmt.Sprintf("line %d: invalid price: %v", line, err))
+			continue
+		}
+
+		bar := PriceBar{Date: date, Open: prices[0], High: prices[1], Low: prices[2], Close: prices[3]}
+		if hasVolume {
+			bar.Volume, _ = strconv.ParseInt(field(volumeColumn), 10, 64)
+		}
+		bars[ticker] = append(bars[ticker], bar)
+	}
+	return bars, rows, rowErrors, nil
+}
+
+// HTTP handler for POST /price-bars: loads a CSV of daily OHLC bars into price_bars, replacing any
+// bar already stored for the same ticker and date. The backtest reads its prices from here.
+func priceBarsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	data, err := readImportUpload(w, r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("failed to read upload (at most %d bytes): %v", maxImportBytes, err))
+		return
+	}
+	bars, rows, rowErrors, err := parsePriceBarCSV(data, strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("ticker"))))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	db, err := setupDatabase()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
+		return
+	}
+	defer db.Close()
+
+	result := PriceBarImportResult{Rows: rows, Tickers: []string{}, Errors: rowErrors}
+	for ticker, tickerBars := range bars {
+		if err := db.savePriceBars(ticker, tickerBars); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		result.Imported += len(tickerBars)
+		result.Tickers = append(result.Tickers, ticker)
+	}
+	sort.Strings(result.Tickers)
+	log.Printf("Price bars: %d/%d rows saved for %d tickers, %d errors", result.Imported, result.Rows, len(result.Tickers), len(result.Errors))
+
+	status := http.StatusOK
+	if result.Imported == 0 {
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, result)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// stageProgress counts a concurrent stage's finished items. Workers record each item as it finishes,
+// so the counts are live while the stage runs and exact when it ends.
+type stageProgress struct {
+	stage string // pipeline stage name, e.g. "download"
+	unit  string // what an item is, for logs, e.g. "messages"
+	total int64
+	every int64 // log progress each time this many items have finished
+
+	processed atomic.Int64
+	failed    atomic.Int64
+	skipped   atomic.Int64 // emails with no content, which are neither processed nor failed
+	done      atomic.Int64
+}
+
+// activeProgress holds the progress of every running stage, for /metrics
+var activeProgress sync.Map // stage name -> *stageProgress
+
+// startProgress begins counting a stage of total items, logging every N finished items.
+// Call finish when the stage's workers are done.
+func startProgress(stage, unit string, total, every int) *stageProgress {
+	p := &stageProgress{stage: stage, unit: unit, total: int64(total), every: int64(max(every, 1))}
+	activeProgress.Store(stage, p)
+	return p
+}
+
+// record counts one finished item by its error. Each multiple of every, and the last item, is logged
+// exactly once whichever worker finishes it.
+func (p *stageProgress) record(err error) {
+	switch {
+	case err == nil:
+		p.processed.Add(1)
+	case errors.Is(err, errNoContent):
+		p.skipped.Add(1)
+	default:
+		p.failed.Add(1)
+	}
+
+	if done := p.done.Add(1); done%p.every == 0 || done == p.total {
+		log.Printf("%s progress: %d/%d %s processed", p.stage, done, p.total, p.unit)
+	}
+}
+
+// counts returns how many items were processed, failed and skipped so far
+func (p *stageProgress) counts() (processed, failed, skipped int) {
+	return int(p.processed.Load()), int(p.failed.Load()), int(p.skipped.Load())
+}
+
+// finish stops reporting the stage on /metrics
+func (p *stageProgress) finish() {
+	activeProgress.CompareAndDelete(p.stage, p)
+}
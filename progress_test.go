@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestStageProgressCounts(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	const total = 1000
+	progress := startProgress("test", "items", total, 100)
+	if p, ok := activeProgress.Load("test"); !ok || p != progress {
+		t.Fatal("started stage is missing from activeProgress")
+	}
+
+	// Each item is processed, skipped or failed, recorded from many workers at once
+	items := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range items {
+				switch i % 10 {
+				case 0:
+					progress.record(errNoContent)
+				case 1, 2:
+					progress.record(errors.New("boom"))
+				default:
+					progress.record(nil)
+				}
+			}
+		}()
+	}
+	for i := 0; i < total; i++ {
+		items <- i
+	}
+	close(items)
+	wg.Wait()
+
+	processed, failed, skipped := progress.counts()
+	if processed != 700 || failed != 200 || skipped != 100 {
+		t.Errorf("counts() = %d processed, %d failed, %d skipped, want 700, 200 and 100", processed, failed, skipped)
+	}
+	if sum := processed + failed + skipped; sum != total {
+		t.Errorf("counts add up to %d, want %d", sum, total)
+	}
+
+	// Every hundredth item is logged exactly once, the last being the full count
+	for done := 100; done <= total; done += 100 {
+		line := fmt.Sprintf("test progress: %d/%d items processed", done, total)
+		if n := strings.Count(logs.String(), line); n != 1 {
+			t.Errorf("%q logged %d times, want once", line, n)
+		}
+	}
+
+	progress.finish()
+	if _, ok := activeProgress.Load("test"); ok {
+		t.Error("finished stage is still in activeProgress")
+	}
+}
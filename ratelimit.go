@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a token-bucket rate limiter shared by every goroutine calling Gmail.
+// A nil *tokenBucket never blocks.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens added per second
+	burst  float64 // most tokens the bucket holds
+	tokens float64 // may go negative while callers wait on reserved tokens
+	last   time.Time
+}
+
+// newTokenBucket returns a full bucket refilled at rate tokens per second, or nil when rate is not positive.
+// The burst is one second's worth of tokens, at least one.
+func newTokenBucket(rate float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	burst := math.Max(1, math.Ceil(rate))
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// gmailLimiter caps Gmail API requests across all stages and workers at GMAIL_QPS
+var gmailLimiter = newTokenBucket(gmailQPS)
+
+// Wait blocks until n tokens are available or ctx is done. Tokens are reserved before
+// sleeping, so concurrent callers are served in the order they arrived.
+func (b *tokenBucket) Wait(ctx context.Context, n int) error {
+	if b == nil || n <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// Hand back the reservation so later callers are not delayed by a request that never ran
+		b.mu.Lock()
+		b.tokens += float64(n)
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// rateLimitTransport takes one token from a limiter before each request
+type rateLimitTransport struct {
+	base    http.RoundTripper
+	limiter *tokenBucket
+}
+
+// RoundTrip waits for the limiter, then sends the request
+func (t rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context(), 1); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// rateLimitClient wraps an HTTP client so its requests wait on gmailLimiter
+func rateLimitClient(client *http.Client) *http.Client {
+	if gmailLimiter == nil {
+		return client
+	}
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client.Transport = rateLimitTransport{base: base, limiter: gmailLimiter}
+	return client
+}
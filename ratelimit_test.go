@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketRate(t *testing.T) {
+	const qps = 40
+	const waits = 60 // a full burst of 40, then 20 more at 40 a second
+	bucket := newTokenBucket(qps)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < waits/4; j++ {
+				if err := bucket.Wait(context.Background(), 1); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	want := time.Duration(float64(waits-qps) / qps * float64(time.Second))
+	if elapsed := time.Since(start); elapsed < want-50*time.Millisecond || elapsed > want+500*time.Millisecond {
+		t.Errorf("%d waits at %d QPS took %v, want about %v", waits, qps, elapsed, want)
+	}
+}
+
+func TestTokenBucketWaitCanceled(t *testing.T) {
+	bucket := newTokenBucket(1)
+	if err := bucket.Wait(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := bucket.Wait(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait() = %v, want the context's deadline error", err)
+	}
+
+	// A nil bucket, as with GMAIL_QPS=0, never blocks
+	var unlimited *tokenBucket
+	if err := unlimited.Wait(ctx, 100); err != nil {
+		t.Errorf("nil bucket Wait() = %v", err)
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// emails_fts is an FTS5 index over email subjects and text, kept in sync with emails by triggers.
+// go-sqlite3 only compiles FTS5 in with the sqlite_fts5 build tag (go build -tags sqlite_fts5);
+// without it, /emails/search falls back to LIKE scans. Index rows share their email's rowid.
+
+// searchBodySQL is the text indexed for an emails row: clean text, falling back to the plain-text body
+func searchBodySQL(row string) string {
+	return fmt.Sprintf(`COALESCE(NULLIF(%[1]sclean_text, ''), NULLIF(%[1]stext_body, ''), %[1]shtml, '')`, row)
+}
+
+// emailSearchTriggers keep emails_fts in step with every insert, content update and delete on emails
+var emailSearchTriggers = map[string]string{
+	"emails_fts_insert": `CREATE TRIGGER emails_fts_insert AFTER INSERT ON emails BEGIN
+			INSERT INTO emails_fts (rowid, subject, body) VALUES (new.rowid, COALESCE(new.subject, ''), ` + searchBodySQL("new.") + `);
+		END`,
+	"emails_fts_update": `CREATE TRIGGER emails_fts_update AFTER UPDATE OF subject, clean_text, text_body, html ON emails BEGIN
+			DELETE FROM emails_fts WHERE rowid = old.rowid;
+			INSERT INTO emails_fts (rowid, subject, body) VALUES (new.rowid, COALESCE(new.subject, ''), ` + searchBodySQL("new.") + `);
+		END`,
+	"emails_fts_delete": `CREATE TRIGGER emails_fts_delete AFTER DELETE ON emails BEGIN
+			DELETE FROM emails_fts WHERE rowid = old.rowid;
+		END`,
+}
+
+// hasFTS5 reports whether the linked SQLite was compiled with FTS5
+func hasFTS5(db *sql.DB) (bool, error) {
+	var enabled bool
+	if err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&enabled); err != nil {
+		return false, fmt.Errorf("failed to check for FTS5: %w", err)
+	}
+	return enabled, nil
+}
+
+// setupEmailSearch creates emails_fts and its triggers when FTS5 is available, building the index
+// from existing rows the first time. Without FTS5 the triggers are dropped, since they would make
+// every write to emails fail; the index is rebuilt once a build with FTS5 runs again.
+func setupEmailSearch(db *sql.DB) error {
+	enabled, err := hasFTS5(db)
+	if err != nil {
+		return err
+	}
+
+	if !enabled {
+		for name := range emailSearchTriggers {
+			if _, err := db.Exec(fmt.Sprintf("DROP TRIGGER IF EXISTS %s", name)); err != nil {
+				return fmt.Errorf("failed to drop trigger %s: %w", name, err)
+			}
+		}
+		return nil
+	}
+
+	if _, err := db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS emails_fts USING fts5(subject, body)`); err != nil {
+		return fmt.Errorf("failed to create email search index: %w", err)
+	}
+
+	var triggers int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'emails\_fts\_%' ESCAPE '\'`).Scan(&triggers); err != nil {
+		return fmt.Errorf("failed to check email search triggers: %w", err)
+	}
+	if triggers == len(emailSearchTriggers) {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for name, trigger := range emailSearchTriggers {
+		if _, err := tx.Exec(fmt.Sprintf("DROP TRIGGER IF EXISTS %s", name)); err != nil {
+			return fmt.Errorf("failed to drop trigger %s: %w", name, err)
+		}
+		if _, err := tx.Exec(trigger); err != nil {
+			return fmt.Errorf("failed to create trigger %s: %w", name, err)
+		}
+	}
+	indexed, err := rebuildEmailSearchIndex(tx)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit email search index: %w", err)
+	}
+
+	log.Printf("Built email search index over %d emails", indexed)
+	return nil
+}
+
+// rebuildEmailSearchIndex refills emails_fts from emails and returns how many rows it indexed
+func rebuildEmailSearchIndex(tx *sql.Tx) (int64, error) {
+	if _, err := tx.Exec(`DELETE FROM emails_fts`); err != nil {
+		return 0, fmt.Errorf("failed to clear email search index: %w", err)
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO emails_fts (rowid, subject, body)
+		SELECT rowid, COALESCE(subject, ''), ` + searchBodySQL("") + `
+		FROM emails
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to build email search index: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// reindexEmailSearch rebuilds emails_fts when it exists. VACUUM may renumber emails rowids, which
+// the index rows are keyed on, so it runs after every vacuum.
+func (db *DB) reindexEmailSearch() error {
+	enabled, err := hasFTS5(db.DB)
+	if err != nil || !enabled {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	indexed, err := rebuildEmailSearchIndex(tx)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit email search index: %w", err)
+	}
+
+	log.Printf("Rebuilt email search index over %d emails", indexed)
+	return nil
+}
+
+// EmailSearchHit is one email matching a /emails/search query
+type EmailSearchHit struct {
+	ID       string    `json:"id"`
+	ThreadID string    `json:"thread_id"`
+	Subject  string    `json:"subject"`
+	Date     time.Time `json:"date"`
+	Snippet  string    `json:"snippet"` // text around the first match, with matches in [brackets]
+}
+
+// EmailSearchPage is one page of /emails/search results
+type EmailSearchPage struct {
+	Query   string           `json:"query"`
+	Mode    string           `json:"mode"` // fts, or like when SQLite was built without FTS5
+	Total   int              `json:"total"`
+	Limit   int              `json:"limit"`
+	Offset  int              `json:"offset"`
+	Results []EmailSearchHit `json:"results"`
+}
+
+// ftsQuery quotes each term so FTS5 reads it literally; the terms are ANDed
+func ftsQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+	return strings.Join(quoted, " ")
+}
+
+// searchEmails returns one page of emails whose subject or text contains every term,
+// best matches first with FTS5 and newest first without it
+func (db *DB) searchEmails(terms []string, limit, offset int) (EmailSearchPage, error) {
+	page := EmailSearchPage{Query: strings.Join(terms, " "), Limit: limit, Offset: offset, Results: []EmailSearchHit{}}
+
+	enabled, err := hasFTS5(db.DB)
+	if err != nil {
+		return page, err
+	}
+	if enabled {
+		page.Mode = "fts"
+		err = db.searchEmailsFTS(&page, terms)
+	} else {
+		page.Mode = "like"
+		err = db.searchEmailsLike(&page, terms)
+	}
+	return page, err
+}
+
+// searchEmailsFTS fills page from emails_fts, snippets marked by FTS5
+func (db *DB) searchEmailsFTS(page *EmailSearchPage, terms []string) error {
+	match := ftsQuery(terms)
+	if err := db.QueryRow(`SELECT COUNT(*) FROM emails_fts WHERE emails_fts MATCH ?`, match).Scan(&page.Total); err != nil {
+		return fmt.Errorf("failed to count search matches: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT e.id, COALESCE(e.thread_id, ''), COALESCE(e.subject, ''), e.date,
+		       snippet(emails_fts, -1, '[', ']', '…', 24)
+		FROM emails_fts
+		JOIN emails e ON e.rowid = emails_fts.rowid
+		WHERE emails_fts MATCH ?
+		ORDER BY rank
+		LIMIT ? OFFSET ?
+	`, match, page.Limit, page.Offset)
+	if err != nil {
+		return fmt.Errorf("failed to search emails: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hit EmailSearchHit
+		if err := rows.Scan(&hit.ID, &hit.ThreadID, &hit.Subject, &hit.Date, &hit.Snippet); err != nil {
+			return fmt.Errorf("failed to scan search result: %w", err)
+		}
+		page.Results = append(page.Results, hit)
+	}
+	return rows.Err()
+}
+
+// searchEmailsLike fills page with a LIKE scan over emails, building snippets in Go
+func (db *DB) searchEmailsLike(page *EmailSearchPage, terms []string) error {
+	var where []string
+	var args []interface{}
+	for _, term := range terms {
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(term) + "%"
+		where = append(where, `(COALESCE(subject, '') LIKE ? ESCAPE '\' OR `+searchBodySQL("")+` LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern)
+	}
+	filter := strings.Join(where, " AND ")
+
+	if err := db.QueryRow(`SELECT COUNT(*) FROM emails WHERE `+filter, args...).Scan(&page.Total); err != nil {
+		return fmt.Errorf("failed to count search matches: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT id, COALESCE(thread_id, ''), COALESCE(subject, ''), date, `+searchBodySQL("")+`
+		FROM emails
+		WHERE `+filter+`
+		ORDER BY date DESC, id
+		LIMIT ? OFFSET ?
+	`, append(args, page.Limit, page.Offset)...)
+	if err != nil {
+		return fmt.Errorf("failed to search emails: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hit EmailSearchHit
+		var body string
+		if err := rows.Scan(&hit.ID, &hit.ThreadID, &hit.Subject, &hit.Date, &body); err != nil {
+			return fmt.Errorf("failed to scan search result: %w", err)
+		}
+		hit.Snippet = likeSnippet(body, terms)
+		page.Results = append(page.Results, hit)
+	}
+	return rows.Err()
+}
+
+// snippetRadius is how many bytes of context likeSnippet keeps on each side of the first match
+const snippetRadius = 80
+
+// likeSnippet returns the text around the first term found in body, with that match in [brackets].
+// It returns the start of body when only the subject matched.
+func likeSnippet(body string, terms []string) string {
+	body = strings.Join(strings.Fields(body), " ")
+	lower := strings.ToLower(body)
+
+	start, end := -1, -1
+	for _, term := range terms {
+		if i := strings.Index(lower, strings.ToLower(term)); i >= 0 && (start < 0 || i < start) {
+			start, end = i, i+len(term)
+		}
+	}
+	if start < 0 {
+		return truncateRunes(body, 2*snippetRadius)
+	}
+
+	from, to := max(0, start-snippetRadius), min(len(body), end+snippetRadius)
+	// Keep the cut on UTF-8 boundaries
+	for from > 0 && !utf8.RuneStart(body[from]) {
+		from--
+	}
+	for to < len(body) && !utf8.RuneStart(body[to]) {
+		to++
+	}
+
+	snippet := body[from:start] + "[" + body[start:end] + "]" + body[end:to]
+	if from > 0 {
+		snippet = "…" + snippet
+	}
+	if to < len(body) {
+		snippet += "…"
+	}
+	return snippet
+}
+
+// truncateRunes shortens s to at most n runes, marking the cut with an ellipsis
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "…"
+}
+
+// emailSearchHandler serves GET /emails/search?q=...&limit=N&offset=N. Every word of q must
+// appear in the subject or text of a matching email.
+func emailSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	terms := strings.Fields(query.Get("q"))
+	if len(terms) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+
+	limit, offset := 20, 0
+	if raw := query.Get("limit"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 1 || value > 100 {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("limit must be an integer from 1 to 100: %q", raw))
+			return
+		}
+		limit = value
+	}
+	if raw := query.Get("offset"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 0 {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("offset must be a non-negative integer: %q", raw))
+			return
+		}
+		offset = value
+	}
+
+	db, err := setupDatabase()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
+		return
+	}
+	defer db.Close()
+
+	page, err := db.searchEmails(terms, limit, offset)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
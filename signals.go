@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// TradeSignal is one trade_signals row as served by /signals/latest
+type TradeSignal struct {
+	EmailID      string    `json:"email_id"`
+	Ticker       string    `json:"ticker"`
+	Company      string    `json:"company,omitempty"`
+	Provider     string    `json:"provider,omitempty"` // the newsletter whose email gave the signal
+	EmailDate    int64     `json:"email_date"`         // milliseconds, when the email was sent
+	SignalDate   int64     `json:"signal_date"`        // milliseconds, effective
+	EntryDate    int64     `json:"entry_date"`         // milliseconds
+	BuyPrice     float64   `json:"buy_price"`
+	StopPrice    float64   `json:"stop_price"`
+	TargetPrice  float64   `json:"target_price"`
+	Targets      []float64 `json:"targets,omitempty"` // scaled targets in order, the first being target_price
+	RiskPct      float64   `json:"risk_pct,omitempty"`
+	Notes        string    `json:"notes,omitempty"`
+	Currency     string    `json:"currency"` // the currency the email quoted; prices are in USD
+	TickerSource string    `json:"ticker_source"`
+	BuySource    string    `json:"buy_source"`
+	StopSource   string    `json:"stop_source"`
+	TargetSource string    `json:"target_source"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// tradeSignalColumns selects a trade_signals row in TradeSignal field order
+const tradeSignalColumns = `email_id, ticker, COALESCE(company, ''), COALESCE(email_date, signal_date), signal_date, entry_date,
+		buy_price, COALESCE(stop_price, 0), COALESCE(target_price, 0), COALESCE(targets, ''), COALESCE(risk_pct, 0), COALESCE(notes, ''),
+		COALESCE(currency, 'USD'), COALESCE(ticker_source, ''), COALESCE(buy_source, ''), COALESCE(stop_source, ''), COALESCE(target_source, ''), created_at,
+		COALESCE((SELECT provider FROM emails WHERE emails.id = trade_signals.email_id), '')`
+
+// getLatestTradeSignals returns the newest trade signal by signal date, or with since every signal dated
+// after that many milliseconds, oldest first so a poller can carry the last signal_date forward.
+// A non-empty provider keeps only signals from that provider's emails.
+func (db *DB) getLatestTradeSignals(since int64, hasSince bool, provider string) ([]TradeSignal, error) {
+	query := `SELECT ` + tradeSignalColumns + `
+		FROM trade_signals
+		WHERE 1 = 1`
+	var args []interface{}
+	if provider != "" {
+		query += `
+		AND email_id IN (SELECT id FROM emails WHERE provider = ?)`
+		args = append(args, provider)
+	}
+	if hasSince {
+		query += `
+		AND signal_date > ?
+		ORDER BY signal_date, id`
+		args = append(args, since)
+	} else {
+		query += `
+		ORDER BY signal_date DESC, id DESC
+		LIMIT 1`
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trade signals: %w", err)
+	}
+	defer rows.Close()
+
+	var signals []TradeSignal
+	for rows.Next() {
+		var signal TradeSignal
+		var targets string
+		if err := rows.Scan(&signal.EmailID, &signal.Ticker, &signal.Company, &signal.EmailDate, &signal.SignalDate, &signal.EntryDate,
+			&signal.BuyPrice, &signal.StopPrice, &signal.TargetPrice, &targets, &signal.RiskPct, &signal.Notes,
+			&signal.Currency, &signal.TickerSource, &signal.BuySource, &signal.StopSource, &signal.TargetSource, &signal.CreatedAt, &signal.Provider); err != nil {
+			return nil, fmt.Errorf("failed to scan trade signal: %w", err)
+		}
+		signal.Targets = decodeTargets(targets)
+		roundPrices(&signal.BuyPrice, &signal.StopPrice, &signal.TargetPrice)
+		for i := range signal.Targets {
+			roundPrices(&signal.Targets[i])
+		}
+		signals = append(signals, signal)
+	}
+	return signals, rows.Err()
+}
+
+// archivedSignalColumns are the trade_signals columns copied into signals_archive
+const archivedSignalColumns = `id, email_id, ticker, company, email_date, signal_date, entry_date, buy_price, stop_price, target_price,
+		targets, risk_pct, notes, currency, ticker_source, buy_source, stop_source, target_source, created_at`
+
+// pruneTradeSignals moves trade signals dated before the cutoff into signals_archive and returns how many
+// were moved. The process stage skips archived emails, so pruned signals stay out of later runs.
+func (db *DB) pruneTradeSignals(before time.Time) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	cutoff := before.UnixMilli()
+	if _, err := tx.Exec(`
+		INSERT OR REPLACE INTO signals_archive (`+archivedSignalColumns+`)
+		SELECT `+archivedSignalColumns+`
+		FROM trade_signals
+		WHERE signal_date < ?`, cutoff); err != nil {
+		return 0, fmt.Errorf("failed to archive trade signals: %w", err)
+	}
+	result, err := tx.Exec(`DELETE FROM trade_signals WHERE signal_date < ?`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete archived trade signals: %w", err)
+	}
+	pruned, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count archived trade signals: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit pruned trade signals: %w", err)
+	}
+	return int(pruned), nil
+}
+
+// signalPruneHandler serves POST /signals/prune?before=YYYY-MM-DD&confirm=true, archiving every trade signal
+// dated before that day (UTC) so old signals drop out of queries and backtests
+func signalPruneHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	raw := r.URL.Query().Get("before")
+	before, err := time.Parse("2006-01-02", raw)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("before must be a YYYY-MM-DD date: %q", raw))
+		return
+	}
+
+	if r.URL.Query().Get("confirm") != "true" {
+		writeJSONError(w, http.StatusBadRequest, "prune moves trade_signals rows into signals_archive; pass confirm=true to proceed")
+		return
+	}
+
+	db, err := setupDatabase()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
+		return
+	}
+	defer db.Close()
+
+	pruned, err := db.pruneTradeSignals(before)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("Pruned %d trade signals dated before %s into signals_archive", pruned, raw)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"before":   raw,
+		"archived": pruned,
+	})
+}
+
+// HTTP handler for the newest trade signal, for alerting integrations to poll. ?since=<unix_ms> returns
+// every signal dated after it instead, and ?provider= limits either to one newsletter. Both answer 204
+// when there is nothing to return.
+func latestSignalHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var provider string
+	if name := r.URL.Query().Get("provider"); name != "" {
+		found, err := findProviders(name)
+		if err != nil || len(found) != 1 {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("provider must name one configured provider: %q", name))
+			return
+		}
+		provider = found[0].Name
+	}
+
+	var since int64
+	raw := r.URL.Query().Get("since")
+	if raw != "" {
+		var err error
+		if since, err = strconv.ParseInt(raw, 10, 64); err != nil || since < 0 {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("since must be a non-negative unix timestamp in milliseconds: %q", raw))
+			return
+		}
+	}
+
+	db, err := setupDatabase()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
+		return
+	}
+	defer db.Close()
+
+	signals, err := db.getLatestTradeSignals(since, raw != "", provider)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	switch {
+	case len(signals) == 0:
+		w.WriteHeader(http.StatusNoContent)
+	case raw != "":
+		writeJSON(w, http.StatusOK, signals)
+	default:
+		writeJSON(w, http.StatusOK, signals[0])
+	}
+}
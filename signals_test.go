@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// signalIDs returns the email IDs of the rows in table, in order
+func signalIDs(t *testing.T, db *DB, table string) []string {
+	t.Helper()
+	rows, err := db.Query(`SELECT email_id FROM ` + table + ` ORDER BY email_id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestSignalPruneArchivesOnlyOlderSignals(t *testing.T) {
+	signalDates := map[string]time.Time{
+		"may":         time.Date(2024, 5, 31, 13, 0, 0, 0, time.UTC),
+		"june-2-late": time.Date(2024, 6, 2, 23, 59, 0, 0, time.UTC),
+		"cutoff-day":  time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC),
+		"july":        time.Date(2024, 7, 1, 13, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		name         string
+		query        string
+		wantStatus   int
+		wantArchived []string
+		wantKept     []string
+	}{
+		{
+			name:         "cutoff keeps signals from that day on",
+			query:        "before=2024-06-03&confirm=true",
+			wantStatus:   http.StatusOK,
+			wantArchived: []string{"june-2-late", "may"},
+			wantKept:     []string{"cutoff-day", "july"},
+		},
+		{
+			name:       "cutoff before every signal",
+			query:      "before=2024-01-01&confirm=true",
+			wantStatus: http.StatusOK,
+			wantKept:   []string{"cutoff-day", "july", "june-2-late", "may"},
+		},
+		{
+			name:       "unconfirmed prune changes nothing",
+			query:      "before=2024-06-03",
+			wantStatus: http.StatusBadRequest,
+			wantKept:   []string{"cutoff-day", "july", "june-2-late", "may"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			for id, date := range signalDates {
+				if _, err := db.Exec(`INSERT INTO trade_signals (email_id, ticker, signal_date, entry_date, buy_price) VALUES (?, 'ACME', ?, ?, 12.5)`,
+					id, date.UnixMilli(), date.UnixMilli()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rec := httptest.NewRecorder()
+			signalPruneHandler(rec, httptest.NewRequest(http.MethodPost, "/signals/prune?"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus == http.StatusOK {
+				var body struct{ Archived int }
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+					t.Fatal(err)
+				}
+				if body.Archived != len(tt.wantArchived) {
+					t.Errorf("archived = %d, want %d", body.Archived, len(tt.wantArchived))
+				}
+			}
+
+			if got := signalIDs(t, db, "signals_archive"); !reflect.DeepEqual(got, tt.wantArchived) {
+				t.Errorf("signals_archive = %v, want %v", got, tt.wantArchived)
+			}
+			if got := signalIDs(t, db, "trade_signals"); !reflect.DeepEqual(got, tt.wantKept) {
+				t.Errorf("trade_signals = %v, want %v", got, tt.wantKept)
+			}
+		})
+	}
+}
@@ -1,86 +1,157 @@
 package main
 
 import (
+	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // executeSQLParsing runs the proven SQL parsing logic
-func executeSQLParsing(db *DB) error {
+func executeSQLParsing(db *DB) (err error) {
 	log.Printf("Starting SQL-based parsing using proven extraction logic")
 
+	run := ParseRun{Parser: "sql", StartedAt: time.Now()}
+	defer func() { saveParseRun(db, &run, err) }()
+
+	// The SQL parser searches tag-free text so words inside CSS or attributes are ignored
+	if err := db.backfillCleanText(); err != nil {
+		return fmt.Errorf("clean text backfill failed: %w", err)
+	}
+
+	if run.Emails, err = db.countSQLParsableEmails(); err != nil {
+		return err
+	}
+
 	// Step 1: Extract tickers using exchange format patterns
 	if err := extractTickersSQL(db); err != nil {
-		return fmt.Errorf("ticker extraction failed: %v", err)
+		return fmt.Errorf("ticker extraction failed: %w", err)
 	}
 
 	// Step 2: Extract prices using position-based parsing
 	if err := extractPricesSQL(db); err != nil {
-		return fmt.Errorf("price extraction failed: %v", err)
+		return fmt.Errorf("price extraction failed: %w", err)
 	}
 
 	// Step 3: Show results
 	if err := showExtractionResults(db); err != nil {
-		return fmt.Errorf("failed to show results: %v", err)
+		return fmt.Errorf("failed to show results: %w", err)
 	}
 
 	log.Printf("SQL-based parsing completed successfully")
 	return nil
 }
 
-// extractTickersSQL executes the proven ticker extraction logic
-func extractTickersSQL(db *DB) error {
-	log.Printf("Extracting tickers using proven SQL logic...")
+// runMaterializedUpdate builds a temp table from materializeSQL and applies it with updateSQL,
+// after running the optional resetSQL, all in one transaction. Materializing once and joining
+// avoids re-running the extraction CTEs per row in correlated subqueries.
+func runMaterializedUpdate(db *DB, tempTable, resetSQL, materializeSQL, updateSQL string, args ...interface{}) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
 
-	// First clear existing tickers
-	if _, err := db.Exec("UPDATE trade_signals SET ticker = NULL"); err != nil {
-		return fmt.Errorf("failed to clear tickers: %v", err)
+	if resetSQL != "" {
+		if _, err := tx.Exec(resetSQL); err != nil {
+			return fmt.Errorf("failed to reset before %s: %w", tempTable, err)
+		}
 	}
 
-	// Execute the proven ticker extraction query
-	tickerExtractionSQL := `
+	if _, err := tx.Exec(fmt.Sprintf("DROP TABLE IF EXISTS temp.%s", tempTable)); err != nil {
+		return fmt.Errorf("failed to drop %s: %w", tempTable, err)
+	}
+
+	start := time.Now()
+	if _, err := tx.Exec(materializeSQL, args...); err != nil {
+		return fmt.Errorf("failed to materialize %s: %w", tempTable, err)
+	}
+	materialized := time.Since(start)
+
+	if _, err := tx.Exec(fmt.Sprintf("CREATE INDEX temp.idx_%s_email_id ON %s(email_id)", tempTable, tempTable)); err != nil {
+		return fmt.Errorf("failed to index %s: %w", tempTable, err)
+	}
+
+	result, err := tx.Exec(updateSQL)
+	if err != nil {
+		return fmt.Errorf("failed to apply %s: %w", tempTable, err)
+	}
+	updated, _ := result.RowsAffected()
+
+	if _, err := tx.Exec(fmt.Sprintf("DROP TABLE temp.%s", tempTable)); err != nil {
+		return fmt.Errorf("failed to drop %s: %w", tempTable, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit %s: %w", tempTable, err)
+	}
+
+	log.Printf("Applied %s: %d rows updated (materialized in %v, total %v)",
+		tempTable, updated, materialized, time.Since(start))
+	return nil
+}
+
+// exchangeCaseBranches builds the WHEN branches of the ticker and ticker_source CASE expressions,
+// one per exchange in order: the text after "NASDAQ:" up to the next ")" is the ticker, recorded
+// as sql:nasdaq. Exchange names are validated letters and digits, so they are inlined as literals.
+func exchangeCaseBranches(exchanges []string) (tickerCases, sourceCases string) {
+	var tickers, sources strings.Builder
+	for _, exchange := range exchanges {
+		prefix := exchange + ":"
+		match := fmt.Sprintf(`UPPER(email_text) LIKE '%%%s%%' AND UPPER(email_text) LIKE '%%(%%'`, prefix)
+		after := fmt.Sprintf(`SUBSTR(UPPER(email_text), INSTR(UPPER(email_text), '%s') + %d)`, prefix, len(prefix))
+		fmt.Fprintf(&tickers, `
+					WHEN %s
+					THEN TRIM(SUBSTR(%s, 1, INSTR(%s, ')') - 1))`, match, after, after)
+		fmt.Fprintf(&sources, `
+					WHEN %s THEN 'sql:%s'`, match, strings.ToLower(exchange))
+	}
+	return tickers.String(), sources.String()
+}
+
+// tickerExtractionQuery builds the proven ticker extraction over the emails listed in signalsTable,
+// selecting email_id, ticker and ticker_source for each email where a valid ticker was found
+func tickerExtractionQuery(signalsTable string) (string, []interface{}) {
+	exchangeTickerCases, exchangeSourceCases := exchangeCaseBranches(tickerExchanges)
+
+	query := `
 		WITH email_content AS (
-			-- Get plain_text content for searching
+			-- Get tag-free clean_text content for searching, with line breaks flattened to spaces
 			SELECT 
 				e.id as email_id,
-				COALESCE(e.html, '') as email_text
+				REPLACE(COALESCE(e.clean_text, ''), char(10), ' ') as email_text
 			FROM emails e
-			JOIN trade_signals ts ON e.id = ts.email_id
+			JOIN ` + signalsTable + ` ts ON e.id = ts.email_id
 		),
 		extracted_tickers AS (
 			-- Extract tickers using exchange format pattern
 			SELECT 
 				email_id,
 				email_text,
-				-- Match format: "Company Name (Exchange: TICKER)"
-				CASE 
-					-- Nasdaq format - strict uppercase match after colon
-					WHEN UPPER(email_text) LIKE '%NASDAQ:%' AND UPPER(email_text) LIKE '%(%'
-					THEN TRIM(SUBSTR(
-						SUBSTR(UPPER(email_text), INSTR(UPPER(email_text), 'NASDAQ:') + 7),
-						1,
-						INSTR(SUBSTR(UPPER(email_text), INSTR(UPPER(email_text), 'NASDAQ:') + 7), ')') - 1
-					))
-					-- NYSE format - strict uppercase match after colon  
-					WHEN UPPER(email_text) LIKE '%NYSE:%' AND UPPER(email_text) LIKE '%(%'
-					THEN TRIM(SUBSTR(
-						SUBSTR(UPPER(email_text), INSTR(UPPER(email_text), 'NYSE:') + 5),
-						1,
-						INSTR(SUBSTR(UPPER(email_text), INSTR(UPPER(email_text), 'NYSE:') + 5), ')') - 1
-					))
-				END as ticker
+				-- Match format: "Company Name (Exchange: TICKER)", one branch per TICKER_EXCHANGES entry
+				CASE ` + exchangeTickerCases + `
+				END as ticker,
+				CASE ` + exchangeSourceCases + `
+				END as ticker_source
 			FROM email_content
 		),
 		valid_tickers AS (
 			-- Filter out invalid tickers with stricter validation
 			SELECT 
 				email_id,
-				ticker
+				ticker,
+				ticker_source
 			FROM extracted_tickers
 			WHERE ticker IS NOT NULL
-				-- Must be 2-5 uppercase letters
-				AND LENGTH(ticker) BETWEEN 2 AND 5
+				-- Must be within the configured length, optionally with a class-share suffix (e.g. BRK.B)
+				AND (
+					LENGTH(ticker) BETWEEN @min_len AND @max_len
+					OR (LENGTH(ticker) BETWEEN @min_len + 2 AND @max_len + 2 AND ticker GLOB '*.[A-Z]')
+				)
 				-- Must not be common words or abbreviations
 				AND ticker NOT IN (
 					'A', 'I', 'AT', 'BE', 'DO', 'GO', 'IF', 'IN', 'IS', 'IT', 'NO', 'OF', 'ON', 'OR', 
@@ -92,25 +163,40 @@ func extractTickersSQL(db *DB) error {
 					'PICK', 'UPDATE', 'WEEKLY', 'TRIAL', 'SAVE'
 				)
 		)
+		SELECT email_id, ticker, ticker_source
+		FROM valid_tickers`
+
+	return query, []interface{}{
+		sql.Named("min_len", tickerMinLength),
+		sql.Named("max_len", tickerMaxLength),
+	}
+}
+
+// extractTickersSQL executes the proven ticker extraction logic
+func extractTickersSQL(db *DB) error {
+	log.Printf("Extracting tickers using proven SQL logic...")
+
+	// Materialize the proven ticker extraction into a temp table, then apply it with one join-based UPDATE
+	tickerQuery, args := tickerExtractionQuery("trade_signals")
+	tickerExtractionSQL := `
+		CREATE TEMP TABLE tmp_valid_tickers AS` + tickerQuery
+
+	tickerUpdateSQL := `
 		UPDATE trade_signals
-		SET ticker = (
-			SELECT ticker 
-			FROM valid_tickers 
-			WHERE valid_tickers.email_id = trade_signals.email_id
-		)
-		WHERE EXISTS (
-			SELECT 1 
-			FROM valid_tickers 
-			WHERE valid_tickers.email_id = trade_signals.email_id
-		)`
+		SET ticker = vt.ticker,
+			ticker_source = vt.ticker_source
+		FROM tmp_valid_tickers vt
+		WHERE vt.email_id = trade_signals.email_id`
 
-	if _, err := db.Exec(tickerExtractionSQL); err != nil {
-		return fmt.Errorf("failed to execute ticker extraction: %v", err)
+	// ticker is NOT NULL, so a signal the extraction finds no ticker for keeps the one processing stored
+	err := runMaterializedUpdate(db, "tmp_valid_tickers", "", tickerExtractionSQL, tickerUpdateSQL, args...)
+	if err != nil {
+		return fmt.Errorf("failed to execute ticker extraction: %w", err)
 	}
 
 	// Get ticker extraction stats
 	var totalSignals, signalsWithTickers int
-	err := db.QueryRow(`
+	err = db.QueryRow(`
 		SELECT 
 			COUNT(*) as total_signals,
 			SUM(CASE WHEN ticker IS NOT NULL THEN 1 ELSE 0 END) as signals_with_tickers
@@ -118,7 +204,7 @@ func extractTickersSQL(db *DB) error {
 	`).Scan(&totalSignals, &signalsWithTickers)
 
 	if err != nil {
-		return fmt.Errorf("failed to get ticker stats: %v", err)
+		return fmt.Errorf("failed to get ticker stats: %w", err)
 	}
 
 	percentage := float64(signalsWithTickers) / float64(totalSignals) * 100
@@ -128,21 +214,78 @@ func extractTickersSQL(db *DB) error {
 	return nil
 }
 
-// extractPricesSQL executes the proven price extraction logic
-func extractPricesSQL(db *DB) error {
-	log.Printf("Extracting prices using proven SQL logic...")
+// keywordPositionSQL builds an expression for the position in column of the first keyword that occurs,
+// trying keywords in priority order, or 0 when none does. Keywords must start a word and are bound
+// as named args @<prefix>_kw_<n>.
+func keywordPositionSQL(column, prefix string, words []string) (string, []interface{}) {
+	var terms []string
+	var args []interface{}
+	for i, word := range words {
+		name := fmt.Sprintf("%s_kw_%d", prefix, i)
+		terms = append(terms, fmt.Sprintf("NULLIF(INSTR(' ' || %s, @%s), 0)", column, name))
+		args = append(args, sql.Named(name, " "+strings.ToUpper(word)))
+	}
+	return fmt.Sprintf("COALESCE(%s, 0)", strings.Join(terms, ", ")), args
+}
 
-	// Execute the proven price extraction query
-	priceExtractionSQL := `
+// synonymPositionSQL is keywordPositionSQL for price synonyms: like the Go parser, it only counts a
+// synonym with a price written right after it ("SUPPORT AT 48", "RESISTANCE: $60"), so "customer
+// support" in prose is ignored. Synonyms are bound as @<prefix>_syn_<n>.
+func synonymPositionSQL(column, prefix string, words []string) (string, []interface{}) {
+	if len(words) == 0 {
+		return "0", nil
+	}
+
+	var terms []string
+	var args []interface{}
+	for i, word := range words {
+		name := fmt.Sprintf("%s_syn_%d", prefix, i)
+		pos := fmt.Sprintf("INSTR(' ' || %s, @%s)", column, name)
+		after := fmt.Sprintf("LTRIM(SUBSTR(%s, %s + %d, %d), ' :@')", column, pos, len(word), synonymWindow)
+		terms = append(terms, fmt.Sprintf("CASE WHEN %[1]s > 0 AND (%[2]s GLOB '[0-9$]*' OR %[2]s GLOB '.[0-9]*' OR %[2]s GLOB 'AT [0-9$.]*') THEN %[1]s END",
+			pos, after))
+		args = append(args, sql.Named(name, " "+strings.ToUpper(word)))
+	}
+	return fmt.Sprintf("COALESCE(%s, 0)", strings.Join(terms, ", ")), args
+}
+
+// beforeDollarSQL builds a condition for a "$N" price ending the text before a keyword, followed
+// by nothing or by a phrase like "IS OUR", as in "$50 IS OUR BUY POINT". It only holds when that
+// number is closer to the keyword than the AT, @ or $ price the segment after the keyword would give,
+// and when it is not the price of an earlier keyword: in "BUY AT $50 STOP 45" the $50 is not the stop.
+// keywordArgs names the bound keyword parameters, each a space followed by the keyword.
+func beforeDollarSQL(before, segment string, keywordArgs []string) string {
+	prefix := fmt.Sprintf("(' ' || RTRIM(SUBSTR(%[1]s, 1, INSTR(%[1]s, '$') - 1)))", before)
+	notKeywordPrice := []string{prefix + " NOT LIKE '% AT'", prefix + " NOT LIKE '%@'"}
+	for _, name := range keywordArgs {
+		notKeywordPrice = append(notKeywordPrice, fmt.Sprintf("%s NOT LIKE '%%' || @%s", prefix, name))
+	}
+
+	return fmt.Sprintf(`(%[1]s LIKE '%%$%%'
+						AND TRIM(LTRIM(SUBSTR(%[1]s, INSTR(%[1]s, '$') + 1), '0123456789.,'))
+							IN ('', 'IS OUR', 'IS THE', 'IS MY', 'AS OUR', 'AS THE', 'AS A')
+						AND %[3]s
+						AND LENGTH(%[1]s) - INSTR(%[1]s, '$') < CASE
+							WHEN %[2]s LIKE '%%AT %%' THEN INSTR(%[2]s, 'AT ')
+							WHEN %[2]s LIKE '%%@ %%' THEN INSTR(%[2]s, '@ ')
+							WHEN %[2]s LIKE '%%$%%' THEN INSTR(%[2]s, '$')
+							ELSE LENGTH(%[2]s) + 1000
+						END)`, before, segment, strings.Join(notKeywordPrice, "\n\t\t\t\t\t\tAND "))
+}
+
+// priceExtractionQuery builds the proven price extraction over the emails listed in signalsTable with
+// a ticker, selecting each email's validated prices and their sources
+func priceExtractionQuery(signalsTable string) (string, []interface{}) {
+	query := `
 		WITH valid_emails AS (
 			-- Get emails with sufficient content and valid tickers
 			SELECT 
 				e.id as email_id,
 				ts.ticker,
-				UPPER(TRIM(COALESCE(e.html, ''))) as email_text
+				UPPER(TRIM(REPLACE(COALESCE(e.clean_text, ''), char(10), ' '))) as email_text
 			FROM emails e
-			JOIN trade_signals ts ON e.id = ts.email_id
-			WHERE LENGTH(TRIM(COALESCE(e.html, ''))) > 20
+			JOIN ` + signalsTable + ` ts ON e.id = ts.email_id
+			WHERE LENGTH(TRIM(COALESCE(e.clean_text, ''))) > 20
 			  AND ts.ticker IS NOT NULL
 		),
 		price_positions AS (
@@ -150,12 +293,11 @@ func extractPricesSQL(db *DB) error {
 				email_id,
 				ticker,
 				email_text,
-				-- Find positions of key words
-				INSTR(email_text, 'BUY') as buy_pos,
-				INSTR(email_text, 'STOP') as stop_pos,
-				INSTR(email_text, 'TARGET') as target_pos
+				-- Find positions of key words (configured keywords, in priority order, then synonyms)
+				{{entry_pos}} as buy_pos,
+				COALESCE(NULLIF({{stop_pos}}, 0), {{stop_synonym_pos}}) as stop_pos,
+				COALESCE(NULLIF({{target_pos}}, 0), {{target_synonym_pos}}) as target_pos
 			FROM valid_emails
-			WHERE INSTR(email_text, 'BUY') > 0  -- Only process emails with BUY signal
 		),
 		number_positions AS (
 			SELECT 
@@ -165,11 +307,16 @@ func extractPricesSQL(db *DB) error {
 				buy_pos,
 				stop_pos,
 				target_pos,
-				-- Extract text segments after keywords (larger context)
-				SUBSTR(email_text, buy_pos, 100) as buy_segment,
-				SUBSTR(email_text, stop_pos, 100) as stop_segment,
-				SUBSTR(email_text, target_pos, 100) as target_segment
+				-- Extract text segments after keywords (SQL_SEGMENT_LENGTH characters)
+				SUBSTR(email_text, buy_pos, @segment_len) as buy_segment,
+				SUBSTR(email_text, stop_pos, @segment_len) as stop_segment,
+				SUBSTR(email_text, target_pos, @segment_len) as target_segment,
+				-- Text just before each keyword (SQL_LOOKBEHIND_LENGTH characters), for the reversed "$50 IS OUR BUY POINT" form
+				SUBSTR(email_text, MAX(buy_pos - @before_len, 1), buy_pos - MAX(buy_pos - @before_len, 1)) as buy_before,
+				SUBSTR(email_text, MAX(stop_pos - @before_len, 1), stop_pos - MAX(stop_pos - @before_len, 1)) as stop_before,
+				SUBSTR(email_text, MAX(target_pos - @before_len, 1), target_pos - MAX(target_pos - @before_len, 1)) as target_before
 			FROM price_positions
+			WHERE buy_pos > 0  -- Only process emails with an entry keyword
 		),
 		extracted_numbers AS (
 			SELECT 
@@ -177,6 +324,8 @@ func extractPricesSQL(db *DB) error {
 				ticker,
 				-- Extract first number after BUY (simplified version)
 				CASE 
+					WHEN {{buy_before_dollar}} THEN
+						CAST(REPLACE(SUBSTR(buy_before, INSTR(buy_before, '$') + 1), ',', '') AS DECIMAL)
 					WHEN buy_segment LIKE '%AT %' THEN
 						CAST(TRIM(REPLACE(REPLACE(REPLACE(
 							SUBSTR(buy_segment, INSTR(buy_segment, 'AT ') + 3, 20),
@@ -190,8 +339,16 @@ func extractPricesSQL(db *DB) error {
 							SUBSTR(buy_segment, INSTR(buy_segment, '$') + 1, 20),
 							'$', ''), ' ', ''), ',', '')) AS DECIMAL)
 				END as buy_price,
+				CASE 
+					WHEN {{buy_before_dollar}} THEN 'sql:dollar_before'
+					WHEN buy_segment LIKE '%AT %' THEN 'sql:at_segment'
+					WHEN buy_segment LIKE '%@ %' THEN 'sql:at_sign_segment'
+					WHEN buy_segment LIKE '%$%' THEN 'sql:dollar_segment'
+				END as buy_source,
 				-- Extract first number after STOP
 				CASE 
+					WHEN {{stop_before_dollar}} THEN
+						CAST(REPLACE(SUBSTR(stop_before, INSTR(stop_before, '$') + 1), ',', '') AS DECIMAL)
 					WHEN stop_segment LIKE '%AT %' THEN
 						CAST(TRIM(REPLACE(REPLACE(REPLACE(
 							SUBSTR(stop_segment, INSTR(stop_segment, 'AT ') + 3, 20),
@@ -205,8 +362,16 @@ func extractPricesSQL(db *DB) error {
 							SUBSTR(stop_segment, INSTR(stop_segment, '$') + 1, 20),
 							'$', ''), ' ', ''), ',', '')) AS DECIMAL)
 				END as stop_price,
+				CASE 
+					WHEN {{stop_before_dollar}} THEN 'sql:dollar_before'
+					WHEN stop_segment LIKE '%AT %' THEN 'sql:at_segment'
+					WHEN stop_segment LIKE '%@ %' THEN 'sql:at_sign_segment'
+					WHEN stop_segment LIKE '%$%' THEN 'sql:dollar_segment'
+				END as stop_source,
 				-- Extract first number after TARGET
 				CASE 
+					WHEN {{target_before_dollar}} THEN
+						CAST(REPLACE(SUBSTR(target_before, INSTR(target_before, '$') + 1), ',', '') AS DECIMAL)
 					WHEN target_segment LIKE '%AT %' THEN
 						CAST(TRIM(REPLACE(REPLACE(REPLACE(
 							SUBSTR(target_segment, INSTR(target_segment, 'AT ') + 3, 20),
@@ -219,7 +384,13 @@ func extractPricesSQL(db *DB) error {
 						CAST(TRIM(REPLACE(REPLACE(REPLACE(
 							SUBSTR(target_segment, INSTR(target_segment, '$') + 1, 20),
 							'$', ''), ' ', ''), ',', '')) AS DECIMAL)
-				END as target_price
+				END as target_price,
+				CASE 
+					WHEN {{target_before_dollar}} THEN 'sql:dollar_before'
+					WHEN target_segment LIKE '%AT %' THEN 'sql:at_segment'
+					WHEN target_segment LIKE '%@ %' THEN 'sql:at_sign_segment'
+					WHEN target_segment LIKE '%$%' THEN 'sql:dollar_segment'
+				END as target_source
 			FROM number_positions
 		),
 		validated_prices AS (
@@ -229,46 +400,85 @@ func extractPricesSQL(db *DB) error {
 				ticker,
 				buy_price,
 				stop_price,
-				target_price
+				target_price,
+				buy_source,
+				stop_source,
+				target_source
 			FROM extracted_numbers
 			WHERE 
-				-- Ensure prices are positive and within reasonable range
-				buy_price > 0 AND buy_price < 10000
-				AND stop_price > 0 AND stop_price < 10000
-				AND target_price > 0 AND target_price < 10000
-				-- Basic price relationship validation (with tolerance)
-				AND target_price >= buy_price * 0.9  -- Allow 10% tolerance
-				AND buy_price >= stop_price * 0.9    -- Allow 10% tolerance
+				-- Ensure prices are within the configured range (PRICE_MIN/PRICE_MAX)
+				buy_price > @min_price AND buy_price < @max_price
+				AND stop_price > @min_price AND stop_price < @max_price
+				AND target_price > @min_price AND target_price < @max_price
+				-- Basic price relationship validation (PRICE_RELATIONSHIP_TOLERANCE, 10% by default)
+				AND target_price >= buy_price * @tolerance
+				AND buy_price >= stop_price * @tolerance
 		)
+		SELECT * FROM validated_prices`
+
+	args := []interface{}{
+		sql.Named("min_price", priceMin),
+		sql.Named("max_price", priceMax),
+		sql.Named("tolerance", relationshipTolerance),
+		sql.Named("segment_len", sqlSegmentLength),
+		sql.Named("before_len", sqlLookbehindLength),
+	}
+	var positions, keywordArgNames []string
+	for _, set := range []struct {
+		name  string
+		words []string
+	}{
+		{"entry", keywords.EntryKeywords},
+		{"stop", keywords.StopKeywords},
+		{"target", keywords.TargetKeywords},
+	} {
+		expr, keywordArgs := keywordPositionSQL("email_text", set.name, set.words)
+		positions = append(positions, "{{"+set.name+"_pos}}", expr)
+		args = append(args, keywordArgs...)
+		for _, arg := range keywordArgs {
+			keywordArgNames = append(keywordArgNames, arg.(sql.NamedArg).Name)
+		}
+	}
+	for _, set := range []struct {
+		name  string
+		words []string
+	}{
+		{"stop", keywords.StopSynonyms},
+		{"target", keywords.TargetSynonyms},
+	} {
+		expr, synonymArgs := synonymPositionSQL("email_text", set.name, set.words)
+		positions = append(positions, "{{"+set.name+"_synonym_pos}}", expr)
+		args = append(args, synonymArgs...)
+	}
+	for _, name := range []string{"buy", "stop", "target"} {
+		positions = append(positions, "{{"+name+"_before_dollar}}", beforeDollarSQL(name+"_before", name+"_segment", keywordArgNames))
+	}
+	return strings.NewReplacer(positions...).Replace(query), args
+}
+
+// extractPricesSQL executes the proven price extraction logic
+func extractPricesSQL(db *DB) error {
+	log.Printf("Extracting prices using proven SQL logic...")
+
+	// Materialize the proven price extraction into a temp table, then apply it with one join-based UPDATE
+	priceQuery, args := priceExtractionQuery("trade_signals")
+	priceExtractionSQL := `
+		CREATE TEMP TABLE tmp_validated_prices AS` + priceQuery
+
+	priceUpdateSQL := `
 		UPDATE trade_signals
-		SET 
-			buy_price = (
-				SELECT buy_price 
-				FROM validated_prices 
-				WHERE validated_prices.email_id = trade_signals.email_id
-				AND validated_prices.ticker = trade_signals.ticker
-			),
-			stop_price = (
-				SELECT stop_price
-				FROM validated_prices 
-				WHERE validated_prices.email_id = trade_signals.email_id
-				AND validated_prices.ticker = trade_signals.ticker
-			),
-			target_price = (
-				SELECT target_price
-				FROM validated_prices 
-				WHERE validated_prices.email_id = trade_signals.email_id
-				AND validated_prices.ticker = trade_signals.ticker
-			)
-		WHERE EXISTS (
-			SELECT 1 
-			FROM validated_prices 
-			WHERE validated_prices.email_id = trade_signals.email_id
-			AND validated_prices.ticker = trade_signals.ticker
-		)`
-
-	if _, err := db.Exec(priceExtractionSQL); err != nil {
-		return fmt.Errorf("failed to execute price extraction: %v", err)
+		SET buy_price = vp.buy_price,
+			stop_price = vp.stop_price,
+			target_price = vp.target_price,
+			buy_source = vp.buy_source,
+			stop_source = vp.stop_source,
+			target_source = vp.target_source
+		FROM tmp_validated_prices vp
+		WHERE vp.email_id = trade_signals.email_id
+		AND vp.ticker = trade_signals.ticker`
+
+	if err := runMaterializedUpdate(db, "tmp_validated_prices", "", priceExtractionSQL, priceUpdateSQL, args...); err != nil {
+		return fmt.Errorf("failed to execute price extraction: %w", err)
 	}
 
 	// Get price extraction stats
@@ -285,7 +495,7 @@ func extractPricesSQL(db *DB) error {
 	`).Scan(&totalWithTickers, &withBuyPrice, &withStopPrice, &withTargetPrice, &completeSignals)
 
 	if err != nil {
-		return fmt.Errorf("failed to get price stats: %v", err)
+		return fmt.Errorf("failed to get price stats: %w", err)
 	}
 
 	if totalWithTickers > 0 {
@@ -313,7 +523,7 @@ func showExtractionResults(db *DB) error {
 			buy_price,
 			stop_price,
 			target_price,
-			SUBSTR(e.html, 1, 200) as sample_text
+			SUBSTR(COALESCE(e.clean_text, ''), 1, 200) as sample_text
 		FROM trade_signals ts
 		JOIN emails e ON ts.email_id = e.id
 		WHERE ts.ticker IS NOT NULL
@@ -324,7 +534,7 @@ func showExtractionResults(db *DB) error {
 		LIMIT 5
 	`)
 	if err != nil {
-		return fmt.Errorf("failed to query successful extractions: %v", err)
+		return fmt.Errorf("failed to query successful extractions: %w", err)
 	}
 	defer rows.Close()
 
@@ -339,7 +549,7 @@ func showExtractionResults(db *DB) error {
 			continue
 		}
 		
-		log.Printf("  %s: Buy=%.2f, Stop=%.2f, Target=%.2f", ticker, buyPrice, stopPrice, targetPrice)
+		log.Printf("  %s: Buy=%s, Stop=%s, Target=%s", ticker, formatPrice(buyPrice), formatPrice(stopPrice), formatPrice(targetPrice))
 	}
 
 	return nil
@@ -348,21 +558,27 @@ func showExtractionResults(db *DB) error {
 // HTTP handler for SQL-based parsing
 func sqlParseSignalsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost && r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	db, err := setupDatabase()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Database setup failed: %v", err), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Database setup failed: %v", err))
 		return
 	}
 	defer db.Close()
 
-	if err := executeSQLParsing(db); err != nil {
-		http.Error(w, fmt.Sprintf("SQL parsing failed: %v", err), http.StatusInternalServerError)
+	// SQL parsing cannot be stopped part way, so it gets the parse stage's span but not its timeout
+	ctx, endSpan := startSpan(r.Context(), "stage parse", attribute.String("stage", "parse"), attribute.String("parser", "sql"))
+	defer endSpan(nil) // finishStage records err on the span
+	start := time.Now()
+	err = executeSQLParsing(db)
+	finishStage(ctx, db, "parse", "parse_buy_stop_target", start, err)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("SQL parsing failed: %v", err))
 		return
 	}
 
-	fmt.Fprint(w, "SQL-based signal parsing completed successfully using proven extraction logic")
+	writeStageDone(w, "SQL-based signal parsing")
 }
\ No newline at end of file
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// seedSQLParserSignals stores n emails and their trade_signals rows, mixing complete alerts with ones
+// missing a ticker or a stop so both extraction steps have rows to skip
+func seedSQLParserSignals(tb testing.TB, db *DB, n int) {
+	tb.Helper()
+	tx, err := db.Begin()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	date := time.Date(2024, 6, 3, 13, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("m%05d", i)
+		ticker := string(rune('A'+i%26)) + string(rune('A'+i/26%26)) + string(rune('A'+i/676%26))
+		buy := 20 + float64(i%50)
+		text := fmt.Sprintf("Acme Holdings (NASDAQ: %s) is today's pick.\nBuy at $%.2f\nStop at $%.2f\nTarget at $%.2f", ticker, buy, buy*0.9, buy*1.2)
+		switch {
+		case i%7 == 0:
+			text = strings.Replace(text, "(NASDAQ: "+ticker+")", "", 1)
+		case i%5 == 0:
+			text = strings.Replace(text, fmt.Sprintf("\nStop at $%.2f", buy*0.9), "", 1)
+		}
+
+		if _, err := tx.Exec(`INSERT INTO emails (id, thread_id, subject, date, clean_text) VALUES (?, ?, 'Trade alert', ?, ?)`,
+			id, id, date.Format(time.RFC3339), text); err != nil {
+			tb.Fatal(err)
+		}
+		if _, err := tx.Exec(`INSERT INTO trade_signals (email_id, ticker, signal_date, entry_date, buy_price) VALUES (?, 'PARSED', ?, ?, 1)`,
+			id, date.UnixMilli(), date.UnixMilli()); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// correlatedExtractionUpdate is the update the SQL parser ran before materializing: one UPDATE whose
+// correlated subqueries evaluate the extraction query for every trade_signals row
+func correlatedExtractionUpdate(db *DB, query string, args []interface{}, columns []string, match string) error {
+	var set []string
+	for _, column := range columns {
+		set = append(set, fmt.Sprintf("%[1]s = (SELECT %[1]s FROM extraction x WHERE %[2]s)", column, match))
+	}
+	_, err := db.Exec(`WITH extraction AS (`+query+`)
+		UPDATE trade_signals
+		SET `+strings.Join(set, ",\n\t\t\t")+`
+		WHERE EXISTS (SELECT 1 FROM extraction x WHERE `+match+`)`, args...)
+	return err
+}
+
+// correlatedSQLParse runs both extraction steps through correlatedExtractionUpdate
+func correlatedSQLParse(db *DB) error {
+	tickerQuery, tickerArgs := tickerExtractionQuery("trade_signals")
+	if err := correlatedExtractionUpdate(db, tickerQuery, tickerArgs, []string{"ticker", "ticker_source"},
+		"x.email_id = trade_signals.email_id"); err != nil {
+		return fmt.Errorf("ticker extraction: %w", err)
+	}
+	priceQuery, priceArgs := priceExtractionQuery("trade_signals")
+	return correlatedExtractionUpdate(db, priceQuery, priceArgs,
+		[]string{"buy_price", "stop_price", "target_price", "buy_source", "stop_source", "target_source"},
+		"x.email_id = trade_signals.email_id AND x.ticker = trade_signals.ticker")
+}
+
+// materializedSQLParse runs both extraction steps the way executeSQLParsing does
+func materializedSQLParse(db *DB) error {
+	if err := extractTickersSQL(db); err != nil {
+		return err
+	}
+	return extractPricesSQL(db)
+}
+
+// sqlParseStats summarizes trade_signals after a SQL parse: the counts the parser logs, plus a
+// digest of every extracted value so two runs only agree when each row does
+func sqlParseStats(tb testing.TB, db *DB) string {
+	tb.Helper()
+	var tickers, buys, stops, targets, complete int
+	var digest string
+	err := db.QueryRow(`
+		SELECT
+			SUM(ticker != 'PARSED'),
+			SUM(buy_price != 1),
+			SUM(stop_price IS NOT NULL),
+			SUM(target_price IS NOT NULL),
+			SUM(buy_price != 1 AND stop_price IS NOT NULL AND target_price IS NOT NULL),
+			(SELECT GROUP_CONCAT(row, ';') FROM (
+				SELECT email_id || ticker || buy_price || COALESCE(stop_price, '') || COALESCE(target_price, '') ||
+					COALESCE(ticker_source, '') || COALESCE(buy_source, '') AS row
+				FROM trade_signals ORDER BY email_id))
+		FROM trade_signals
+	`).Scan(&tickers, &buys, &stops, &targets, &complete, &digest)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return fmt.Sprintf("tickers=%d buy=%d stop=%d target=%d complete=%d digest=%x", tickers, buys, stops, targets, complete, sha256.Sum256([]byte(digest)))
+}
+
+func TestSQLParserUpdatePathsAgree(t *testing.T) {
+	db := newTestDB(t)
+	seedSQLParserSignals(t, db, 300)
+	if err := correlatedSQLParse(db); err != nil {
+		t.Fatal(err)
+	}
+	correlated := sqlParseStats(t, db)
+
+	if _, err := db.Exec(`UPDATE trade_signals SET ticker = 'PARSED', ticker_source = NULL, buy_price = 1,
+		stop_price = NULL, target_price = NULL, buy_source = NULL, stop_source = NULL, target_source = NULL`); err != nil {
+		t.Fatal(err)
+	}
+	if err := materializedSQLParse(db); err != nil {
+		t.Fatal(err)
+	}
+	if materialized := sqlParseStats(t, db); materialized != correlated {
+		t.Errorf("materialized update gave %s, correlated update gave %s", materialized, correlated)
+	}
+	if !strings.HasPrefix(correlated, "tickers=257 ") {
+		t.Errorf("stats %s, want the 257 emails with an exchange ticker to have one", correlated)
+	}
+}
+
+// BenchmarkSQLParserUpdate compares the correlated and materialized update paths on 10k seeded signals.
+// Each path runs on its own database, and their parse stats must match.
+func BenchmarkSQLParserUpdate(b *testing.B) {
+	paths := []struct {
+		name  string
+		parse func(*DB) error
+	}{
+		{"correlated", correlatedSQLParse},
+		{"materialized", materializedSQLParse},
+	}
+
+	stats := make(map[string]string)
+	for _, path := range paths {
+		b.Run(path.name, func(b *testing.B) {
+			db := newTestDB(b)
+			seedSQLParserSignals(b, db, 10000)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := path.parse(db); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			stats[path.name] = sqlParseStats(b, db)
+		})
+	}
+	if stats["correlated"] != stats["materialized"] {
+		b.Errorf("parse stats differ: correlated %s, materialized %s", stats["correlated"], stats["materialized"])
+	}
+}
+
+// sqlExtractedTicker runs the SQL parser's ticker extraction over one email with body text
+func sqlExtractedTicker(t *testing.T, text string) string {
+	t.Helper()
+	db := newTestDB(t)
+	date := time.Date(2024, 6, 3, 13, 0, 0, 0, time.UTC)
+	if _, err := db.Exec(`INSERT INTO emails (id, thread_id, subject, date, clean_text) VALUES ('m1', 'm1', 'Trade alert', ?, ?)`,
+		date.Format(time.RFC3339), text); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO trade_signals (email_id, ticker, signal_date, entry_date, buy_price) VALUES ('m1', 'PARSED', ?, ?, 1)`,
+		date.UnixMilli(), date.UnixMilli()); err != nil {
+		t.Fatal(err)
+	}
+	if err := extractTickersSQL(db); err != nil {
+		t.Fatal(err)
+	}
+	var ticker string
+	if err := db.QueryRow(`SELECT ticker FROM trade_signals WHERE email_id = 'm1'`).Scan(&ticker); err != nil {
+		t.Fatal(err)
+	}
+	return ticker
+}
+
+func TestHTMLTargetAttributeIsNotAPrice(t *testing.T) {
+	html := `<html><head><style>.target { width: 600px; } td.target-cell { padding: 20px; }</style></head>
+		<body><a href="https://example.com/chart" target="_blank">Chart 2</a>
+		<p>Acme Holdings (NASDAQ: ACME) is today's pick.</p><p>Buy at $12.50</p><p>Stop at $11.00</p><p>Target at $15.00</p></body></html>`
+
+	signal, _, rejection, err := extractSignalFields(EmailSignal{ID: "m1", HTML: html, Date: time.Date(2024, 6, 3, 13, 0, 0, 0, time.UTC)})
+	if err != nil || rejection != nil {
+		t.Fatalf("extractSignalFields() = %v, %v", rejection, err)
+	}
+	if signal.BuyPrice != 12.5 || signal.StopPrice != 11 || signal.TargetPrice != 15 {
+		t.Errorf("Go parser: buy %g, stop %g, target %g, want 12.5, 11 and 15", signal.BuyPrice, signal.StopPrice, signal.TargetPrice)
+	}
+
+	db := newTestDB(t)
+	saveTestEmail(t, db, "m1", "Trade alert", html)
+	date := time.Date(2024, 6, 3, 13, 0, 0, 0, time.UTC)
+	if _, err := db.Exec(`INSERT INTO trade_signals (email_id, ticker, signal_date, entry_date, buy_price) VALUES ('m1', 'PARSED', ?, ?, 1)`,
+		date.UnixMilli(), date.UnixMilli()); err != nil {
+		t.Fatal(err)
+	}
+	if err := materializedSQLParse(db); err != nil {
+		t.Fatal(err)
+	}
+	var buy, stop, target float64
+	if err := db.QueryRow(`SELECT buy_price, stop_price, target_price FROM trade_signals WHERE email_id = 'm1'`).Scan(&buy, &stop, &target); err != nil {
+		t.Fatal(err)
+	}
+	if buy != 12.5 || stop != 11 || target != 15 {
+		t.Errorf("SQL parser: buy %g, stop %g, target %g, want 12.5, 11 and 15", buy, stop, target)
+	}
+}
+
+// sqlParsedPrices runs both SQL extraction steps over one email with HTML body html and returns the
+// saved buy price, or 0 when the signal was not updated, and its stop and target
+func sqlParsedPrices(t *testing.T, html string) (buy, stop, target float64) {
+	t.Helper()
+	db := newTestDB(t)
+	saveTestEmail(t, db, "m1", "Trade alert", html)
+	if _, err := db.Exec(`INSERT INTO trade_signals (email_id, ticker, signal_date, entry_date, buy_price) VALUES ('m1', 'PARSED', 1717419600000, 1717419600000, 0)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := materializedSQLParse(db); err != nil {
+		t.Fatal(err)
+	}
+	var stopPrice, targetPrice sql.NullFloat64
+	if err := db.QueryRow(`SELECT buy_price, stop_price, target_price FROM trade_signals WHERE email_id = 'm1'`).Scan(&buy, &stopPrice, &targetPrice); err != nil {
+		t.Fatal(err)
+	}
+	return buy, stopPrice.Float64, targetPrice.Float64
+}
+
+func TestSQLSegmentLength(t *testing.T) {
+	// filler keeps the buy price away from its keyword without an AT, @ or $ the segment could stop on
+	filler := func(n int) string { return strings.Repeat("x", n) }
+
+	tests := []struct {
+		name      string
+		segment   int
+		gap       int // characters between BUY and its price
+		wantFound bool
+	}{
+		{"near price, default segment", 100, 50, true},
+		{"price 150 characters away, default segment", 100, 150, false},
+		{"price 150 characters away, 200 character segment", 200, 150, true},
+	}
+
+	saved := sqlSegmentLength
+	t.Cleanup(func() { sqlSegmentLength = saved })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sqlSegmentLength = tt.segment
+			html := "<p>Acme Holdings (NASDAQ: ACME) is today's pick.</p><p>Buy " + filler(tt.gap-len("Buy ")) +
+				" at $12.50</p><p>Stop at $11.00</p><p>Target at $15.00</p>"
+			buy, stop, target := sqlParsedPrices(t, html)
+			if found := buy == 12.5 && stop == 11 && target == 15; found != tt.wantFound {
+				t.Errorf("buy %g, stop %g, target %g; found = %v, want %v", buy, stop, target, found, tt.wantFound)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stageTracker records which pipeline stages are running so overlapping requests can be rejected
+type stageTracker struct {
+	mu      sync.Mutex
+	running map[string]time.Time
+}
+
+// pipelineStages lists every stage name used by the pipeline routes
+var pipelineStages = []string{"download", "enrich", "enrich_v1_2", "parse", "process"}
+
+// runningStages guards the pipeline handlers for the lifetime of the server
+var runningStages = &stageTracker{running: make(map[string]time.Time)}
+
+// tryStart claims all the given stages, or none if any of them is already running.
+// It returns the stages that were busy.
+func (t *stageTracker) tryStart(stages ...string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var busy []string
+	for _, stage := range stages {
+		if _, ok := t.running[stage]; ok {
+			busy = append(busy, stage)
+		}
+	}
+	if len(busy) > 0 {
+		return busy
+	}
+
+	now := time.Now()
+	for _, stage := range stages {
+		t.running[stage] = now
+	}
+	return nil
+}
+
+// finish releases stages claimed by tryStart
+func (t *stageTracker) finish(stages ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, stage := range stages {
+		delete(t.running, stage)
+	}
+}
+
+// withStageGuard wraps a handler so it returns 409 Conflict while any of its stages is already running
+func withStageGuard(handler http.HandlerFunc, stages ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if busy := runningStages.tryStart(stages...); busy != nil {
+			writeJSONError(w, http.StatusConflict, fmt.Sprintf("Already running: %s; try again when it finishes", strings.Join(busy, ", ")))
+			return
+		}
+		defer runningStages.finish(stages...)
+
+		handler(w, r)
+	}
+}
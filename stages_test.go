@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStageGuardRejectsConcurrentRun(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}
+
+	tests := []struct {
+		name   string
+		stages []string
+		want   int
+	}{
+		{"same stage", []string{"parse"}, http.StatusConflict},
+		{"overlapping stages", []string{"parse", "process"}, http.StatusConflict},
+		{"other stage", []string{"download"}, http.StatusOK},
+	}
+
+	first := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		withStageGuard(slow, "parse")(first, httptest.NewRequest(http.MethodPost, "/parse-signals", nil))
+		close(done)
+	}()
+	<-started
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			withStageGuard(func(http.ResponseWriter, *http.Request) {}, tt.stages...)(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if rec.Code != http.StatusConflict {
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			var body map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body["error"] == "" {
+				t.Errorf("body = %q, want a JSON error", rec.Body.String())
+			}
+		})
+	}
+
+	close(release)
+	<-done
+	if first.Code != http.StatusOK {
+		t.Errorf("first run status = %d, want 200", first.Code)
+	}
+
+	// Once the first run finishes the stage can start again
+	rec := httptest.NewRecorder()
+	withStageGuard(func(http.ResponseWriter, *http.Request) {}, "parse")(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status after the first run = %d, want 200", rec.Code)
+	}
+}
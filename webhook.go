@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// CompletionEvent is the payload POSTed to COMPLETION_WEBHOOK_URL when a pipeline stage finishes
+type CompletionEvent struct {
+	Stage      string         `json:"stage"`
+	Status     string         `json:"status"` // ok, empty, partial, timeout or failed
+	Counts     map[string]int `json:"counts"`
+	DurationMs int64          `json:"duration_ms"`
+}
+
+// webhookAttempts is how many times a completion notification is sent before giving up
+const webhookAttempts = 2
+
+// webhookClient keeps notifications short so a slow receiver cannot hold up the server
+var webhookClient = &http.Client{Timeout: 5 * time.Second}
+
+// finishStage marks the stage's span in ctx with err, summarizes a stage run by a single-stage handler
+// and notifies the completion webhook
+func finishStage(ctx context.Context, db *DB, stage, table string, start time.Time, err error) {
+	recordSpanError(ctx, err)
+	if completionWebhookURL == "" {
+		return
+	}
+	summary, _ := summarizeStage(db, stage, table, time.Since(start), err)
+	notifyStageCompletion(summary)
+}
+
+// notifyStageCompletion posts a stage summary to the completion webhook in the background
+func notifyStageCompletion(summary StageSummary) {
+	if completionWebhookURL == "" {
+		return
+	}
+
+	event := CompletionEvent{
+		Stage:      summary.Stage,
+		Status:     summary.Status,
+		Counts:     map[string]int{"rows": summary.Rows, "failed": summary.Failed},
+		DurationMs: summary.DurationMs,
+	}
+	go sendCompletionEvent(completionWebhookURL, event)
+}
+
+// sendCompletionEvent delivers event, retrying once. Failures are logged and never reach the pipeline.
+func sendCompletionEvent(url string, event CompletionEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Completion webhook: failed to encode %s event: %v", event.Stage, err)
+		return
+	}
+
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if err = postWebhook(url, body); err == nil {
+			return
+		}
+		log.Printf("Completion webhook: %s notification attempt %d/%d failed: %v", event.Stage, attempt, webhookAttempts, err)
+	}
+}
+
+// postWebhook sends one JSON POST and treats any non-2xx response as a failure
+func postWebhook(url string, body []byte) error {
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}